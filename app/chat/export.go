@@ -38,6 +38,9 @@ type ExportOptions struct {
 	WithContent bool
 	Raw         bool
 	All         bool
+
+	// Progress is called with the number of exported messages after each message, optional
+	Progress func(count int64)
 }
 
 type Message struct {
@@ -212,6 +215,9 @@ loop:
 
 		count++
 		tracker.SetValue(count)
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
 	}
 
 	if err = iter.Err(); err != nil {
@@ -27,6 +27,9 @@ type UsersOptions struct {
 	Chat   string
 	Output string
 	Raw    bool
+
+	// Progress is called with the number of exported users of all fields after each user, optional
+	Progress func(count int64)
 }
 
 type User struct {
@@ -91,9 +94,17 @@ func Users(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Us
 		"bots":   builder().Bots(),
 	}
 
+	count := int64(0)
+	onUser := func() {
+		count++
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
+	}
+
 	for field, query := range fields {
 		iter := query.Iter()
-		if err = outputUsers(ctx, pw, peer, enc, field, iter, opts.Raw); err != nil {
+		if err = outputUsers(ctx, pw, peer, enc, field, iter, opts.Raw, onUser); err != nil {
 			// skip if we get CHAT_ADMIN_REQUIRED error, just export other fields
 			if tgerr.Is(err, tg.ErrChatAdminRequired) {
 				continue
@@ -113,6 +124,7 @@ func outputUsers(ctx context.Context,
 	field string,
 	iter *participants.Iterator,
 	raw bool,
+	onUser func(),
 ) error {
 	total, err := iter.Total(ctx)
 	if err != nil {
@@ -148,6 +160,7 @@ func outputUsers(ctx context.Context,
 		enc.Raw(buf)
 
 		tracker.Increment(1)
+		onUser()
 	}
 
 	if err = iter.Err(); err != nil {
@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/fatih/color"
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/peers"
-	"github.com/spf13/viper"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
@@ -19,10 +20,10 @@ import (
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/core/tclient"
-	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/key"
 	"github.com/iyear/tdl/pkg/prog"
 	"github.com/iyear/tdl/pkg/tmessage"
+	"github.com/iyear/tdl/pkg/transfer"
 	"github.com/iyear/tdl/pkg/utils"
 )
 
@@ -45,6 +46,16 @@ type Options struct {
 	// serve
 	Serve bool
 	Port  int
+
+	// Progress receives download events in addition to the built-in file handling.
+	// If set, the terminal progress bar is not rendered.
+	Progress downloader.Progress
+
+	Transfer transfer.Options
+
+	// Pool is used instead of creating a new pool from the client if set,
+	// and it is not closed after running.
+	Pool dcpool.Pool
 }
 
 type parser struct {
@@ -53,10 +64,15 @@ type parser struct {
 }
 
 func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
-	pool := dcpool.NewPool(c,
-		int64(viper.GetInt(consts.FlagPoolSize)),
-		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
-	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+	opts.Transfer = opts.Transfer.Normalize()
+
+	pool := opts.Pool
+	if pool == nil {
+		pool = dcpool.NewPool(c,
+			opts.Transfer.PoolSize,
+			tclient.NewDefaultMiddlewares(ctx, opts.Transfer.ReconnectTimeout)...)
+		defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+	}
 
 	parsers := []parser{
 		{Data: opts.URLs, Parser: tmessage.FromURL(ctx, pool, kvd, opts.URLs)},
@@ -70,12 +86,12 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 		zap.Any("dialogs", dialogs))
 
 	if opts.Serve {
-		return serve(ctx, kvd, pool, dialogs, opts.Port, opts.Takeout)
+		return serve(ctx, kvd, pool, dialogs, opts.Port, opts.Takeout, opts.Transfer.PartSize)
 	}
 
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
 
-	it, err := newIter(pool, manager, dialogs, opts, viper.GetDuration(consts.FlagDelay))
+	it, err := newIter(pool, manager, dialogs, opts, opts.Transfer.Delay)
 	if err != nil {
 		return err
 	}
@@ -87,6 +103,9 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 		}
 	} else {
 		color.Yellow("Restart download by 'restart' flag")
+		if err = restart(ctx, kvd, it); err != nil {
+			return err
+		}
 	}
 
 	defer func() { // save progress
@@ -99,15 +118,19 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	dlProgress := prog.New(utils.Byte.FormatBinaryBytes)
 	dlProgress.SetNumTrackersExpected(it.Total())
-	prog.EnablePS(ctx, dlProgress)
+	if opts.Progress == nil {
+		prog.EnablePS(ctx, dlProgress)
+	} else {
+		prog.SetTotal(opts.Progress, it.Total()-len(it.Finished())) // finished files are skipped when resuming
+	}
 
 	options := downloader.Options{
 		Pool:     pool,
-		Threads:  viper.GetInt(consts.FlagThreads),
+		Threads:  opts.Transfer.Threads,
 		Iter:     it,
 		Progress: newProgress(dlProgress, it, opts),
 	}
-	limit := viper.GetInt(consts.FlagLimit)
+	limit := opts.Transfer.Limit
 
 	logctx.From(ctx).Info("Start download",
 		zap.String("dir", opts.Dir),
@@ -118,8 +141,10 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	color.Green("All files will be downloaded to '%s' dir", opts.Dir)
 
-	go dlProgress.Render()
-	defer prog.Wait(ctx, dlProgress)
+	if opts.Progress == nil {
+		go dlProgress.Render()
+		defer prog.Wait(ctx, dlProgress)
+	}
 
 	return downloader.New(options).Download(ctx, limit)
 }
@@ -136,25 +161,77 @@ func collectDialogs(parsers []parser) ([][]*tmessage.Dialog, error) {
 	return dialogs, nil
 }
 
-func resume(ctx context.Context, kvd storage.Storage, iter *iter, ask bool) error {
+// resumeState is the saved progress of an interrupted download
+type resumeState struct {
+	Finished map[int]struct{}       `json:"finished"`
+	Partial  map[string]partialFile `json:"partial"` // temp file path -> file
+}
+
+// loadProgress loads the saved progress of iter, the zero state is returned if there is no progress
+func loadProgress(ctx context.Context, kvd storage.Storage, iter *iter) (resumeState, error) {
 	logctx.From(ctx).Debug("Check resume key",
 		zap.String("fingerprint", iter.Fingerprint()))
 
+	state := resumeState{}
+
 	b, err := kvd.Get(ctx, key.Resume(iter.Fingerprint()))
 	if err != nil && !errors.Is(err, storage.ErrNotFound) {
-		return err
+		return state, err
 	}
 	if len(b) == 0 { // no progress
+		return state, nil
+	}
+
+	if err = json.Unmarshal(b, &state); err != nil {
+		return state, err
+	}
+	if state.Finished == nil { // saved by older versions, only finished messages
+		if err = json.Unmarshal(b, &state.Finished); err != nil {
+			return state, err
+		}
+	}
+	return state, nil
+}
+
+// discardProgress clears the saved progress and removes the partial temp files recorded by it,
+// which would never be continued or cleaned up after the progress is gone
+func discardProgress(ctx context.Context, kvd storage.Storage, iter *iter, state resumeState) error {
+	for path := range state.Partial {
+		if filepath.Ext(path) != tempExt {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logctx.From(ctx).Warn("Remove partial temp file",
+				zap.String("path", path),
+				zap.Error(err))
+		}
+	}
+
+	return kvd.Delete(ctx, key.Resume(iter.Fingerprint()))
+}
+
+// restart starts the download over, discarding the saved progress if any
+func restart(ctx context.Context, kvd storage.Storage, iter *iter) error {
+	state, err := loadProgress(ctx, kvd, iter)
+	if err != nil {
+		return err
+	}
+	if len(state.Finished) == 0 && len(state.Partial) == 0 {
 		return nil
 	}
 
-	finished := make(map[int]struct{})
-	if err = json.Unmarshal(b, &finished); err != nil {
+	return discardProgress(ctx, kvd, iter, state)
+}
+
+func resume(ctx context.Context, kvd storage.Storage, iter *iter, ask bool) error {
+	state, err := loadProgress(ctx, kvd, iter)
+	if err != nil {
 		return err
 	}
+	finished := state.Finished
 
-	// finished is empty, no need to resume
-	if len(finished) == 0 {
+	// nothing finished or partially downloaded, no need to resume
+	if len(finished) == 0 && len(state.Partial) == 0 {
 		return nil
 	}
 
@@ -172,23 +249,31 @@ func resume(ctx context.Context, kvd storage.Storage, iter *iter, ask bool) erro
 	}
 
 	logctx.From(ctx).Debug("Resume download",
-		zap.Int("finished", len(finished)))
+		zap.Int("finished", len(finished)),
+		zap.Int("partial", len(state.Partial)))
 
 	if !confirm {
-		// clear resume key
-		return kvd.Delete(ctx, key.Resume(iter.Fingerprint()))
+		// clear resume key and start over
+		return discardProgress(ctx, kvd, iter, state)
 	}
 
 	iter.SetFinished(finished)
+	if state.Partial != nil {
+		iter.SetPartials(state.Partial)
+	}
 	return nil
 }
 
 func saveProgress(ctx context.Context, kvd storage.Storage, it *iter) error {
-	finished := it.Finished()
+	state := resumeState{
+		Finished: it.Finished(),
+		Partial:  it.Partials(),
+	}
 	logctx.From(ctx).Debug("Save progress",
-		zap.Int("finished", len(finished)))
+		zap.Int("finished", len(state.Finished)),
+		zap.Int("partial", len(state.Partial)))
 
-	b, err := json.Marshal(finished)
+	b, err := json.Marshal(state)
 	if err != nil {
 		return err
 	}
@@ -3,6 +3,7 @@ package dl
 import (
 	"io"
 	"os"
+	"sync"
 
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/tg"
@@ -11,6 +12,20 @@ import (
 	"github.com/iyear/tdl/core/tmedia"
 )
 
+// MessageElem is implemented by the elems passed to Options.Progress,
+// to tell which message the file comes from.
+type MessageElem interface {
+	From() peers.Peer
+	Msg() *tg.Message
+}
+
+// FileElem is implemented by the elems passed to Options.Progress.
+// Path returns the final path of the downloaded file, it is only set
+// after OnDone is called without error.
+type FileElem interface {
+	Path() string
+}
+
 type iterElem struct {
 	id int
 
@@ -18,7 +33,8 @@ type iterElem struct {
 	fromMsg *tg.Message
 	file    *tmedia.Media
 
-	to *os.File
+	to   *tempFile
+	path string // final path after the temp file is renamed
 
 	opts Options
 }
@@ -29,6 +45,14 @@ func (i *iterElem) To() io.WriterAt { return i.to }
 
 func (i *iterElem) AsTakeout() bool { return i.opts.Takeout }
 
+func (i *iterElem) Offset() int64 { return i.to.offset }
+
+func (i *iterElem) From() peers.Peer { return i.from }
+
+func (i *iterElem) Msg() *tg.Message { return i.fromMsg }
+
+func (i *iterElem) Path() string { return i.path }
+
 func (i *iterElem) Location() tg.InputFileLocationClass { return i.file.InputFileLoc }
 
 func (i *iterElem) Name() string { return i.file.Name }
@@ -36,3 +60,54 @@ func (i *iterElem) Name() string { return i.file.Name }
 func (i *iterElem) Size() int64 { return i.file.Size }
 
 func (i *iterElem) DC() int { return i.file.DC }
+
+// tempFile is the temp file of a download. It records how many bytes from the
+// beginning are written, so the partial file can be kept when download is interrupted.
+type tempFile struct {
+	*os.File
+
+	offset int64 // download starts from here
+
+	mu      sync.Mutex
+	written int64           // contiguous bytes written from the beginning
+	parts   map[int64]int64 // offset -> end of parts written after a gap
+}
+
+func newTempFile(f *os.File, offset int64) *tempFile {
+	return &tempFile{
+		File:    f,
+		offset:  offset,
+		written: offset,
+		parts:   make(map[int64]int64),
+	}
+}
+
+func (t *tempFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := t.File.WriteAt(p, off)
+	if n == 0 {
+		return n, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.parts[off] = off + int64(n)
+	for {
+		end, ok := t.parts[t.written]
+		if !ok {
+			break
+		}
+		delete(t.parts, t.written)
+		t.written = end
+	}
+
+	return n, err
+}
+
+// Written returns the size of contiguous data from the beginning of file
+func (t *tempFile) Written() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.written
+}
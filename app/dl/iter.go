@@ -53,6 +53,7 @@ type iter struct {
 	mu          *sync.Mutex
 	finished    map[int]struct{}
 	fingerprint string
+	partials    map[string]partialFile // temp file path -> file, partial temp files that can be continued
 	preSum      []int
 	i, j        int
 	counter     *atomic.Int64
@@ -96,6 +97,7 @@ func newIter(pool dcpool.Pool, manager *peers.Manager, dialog [][]*tmessage.Dial
 		mu:          &sync.Mutex{},
 		finished:    make(map[int]struct{}),
 		fingerprint: fingerprint(dialogs),
+		partials:    make(map[string]partialFile),
 		preSum:      preSum(dialogs),
 		i:           0,
 		j:           0,
@@ -220,7 +222,7 @@ func (i *iter) processSingle(message *tg.Message, from peers.Peer) (bool, bool)
 		return false, false
 	}
 
-	to, err := os.Create(path)
+	to, err := i.openTemp(path, item)
 	if err != nil {
 		i.err = errors.Wrap(err, "create file")
 		return false, false
@@ -255,6 +257,105 @@ func (i *iter) processGrouped(ctx context.Context, message *tg.Message, from pee
 	return true, false
 }
 
+// partialFile identifies the file a partial temp file belongs to
+type partialFile struct {
+	ID   int64 `json:"id"`
+	Size int64 `json:"size"`
+}
+
+// filePartial returns the identity of media, ok is false if the location has no file ID
+func filePartial(media *tmedia.Media) (partialFile, bool) {
+	var id int64
+	switch loc := media.InputFileLoc.(type) {
+	case *tg.InputDocumentFileLocation:
+		id = loc.ID
+	case *tg.InputPhotoFileLocation:
+		id = loc.ID
+	default:
+		return partialFile{}, false
+	}
+
+	return partialFile{ID: id, Size: media.Size}, true
+}
+
+// openTemp opens the temp file of a download. If the temp file is recorded as a partial
+// of the same file by the resume progress, the complete parts already in the file are kept
+// and download continues after them. Otherwise the file is truncated.
+//
+// It is called with i.mu held.
+func (i *iter) openTemp(path string, media *tmedia.Media) (*tempFile, error) {
+	expected, ok := i.partials[path]
+	delete(i.partials, path) // recorded again by KeepPartial if interrupted
+
+	if actual, known := filePartial(media); !ok || !known || actual != expected {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return newTempFile(f, 0), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	offset := stat.Size() / downloader.MaxPartSize * downloader.MaxPartSize
+	if offset >= media.Size { // not a partial of this file
+		offset = 0
+	}
+	if err = f.Truncate(offset); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return newTempFile(f, offset), nil
+}
+
+// KeepPartial truncates the interrupted temp file of elem to its contiguous written part
+// and records it to be continued next time, or removes it if nothing useful is written
+func (i *iter) KeepPartial(elem *iterElem) {
+	path := elem.to.Name()
+
+	written := elem.to.Written()
+	partial, ok := filePartial(elem.file)
+	if !ok || written <= 0 || os.Truncate(path, written) != nil {
+		_ = os.Remove(path) // just try to remove temp file, ignore error
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.partials[path] = partial
+}
+
+// SetPartials sets the partial temp files recorded by the resume progress
+func (i *iter) SetPartials(partials map[string]partialFile) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.partials = partials
+}
+
+// Partials returns the partial temp files that can be continued next time
+func (i *iter) Partials() map[string]partialFile {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	partials := make(map[string]partialFile, len(i.partials))
+	for path, file := range i.partials {
+		partials[path] = file
+	}
+	return partials
+}
+
 func (i *iter) Value() downloader.Elem {
 	return <-i.elem
 }
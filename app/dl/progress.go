@@ -24,6 +24,7 @@ type progress struct {
 	pw       pw.Writer
 	trackers *sync.Map // map[ID]*pw.Tracker
 	opts     Options
+	ext      downloader.Progress // external progress sink, may be nil
 
 	it *iter
 }
@@ -33,6 +34,7 @@ func newProgress(p pw.Writer, it *iter, opts Options) *progress {
 		pw:       p,
 		trackers: &sync.Map{},
 		opts:     opts,
+		ext:      opts.Progress,
 		it:       it,
 	}
 }
@@ -40,9 +42,17 @@ func newProgress(p pw.Writer, it *iter, opts Options) *progress {
 func (p *progress) OnAdd(elem downloader.Elem) {
 	tracker := prog.AppendTracker(p.pw, utils.Byte.FormatBinaryBytes, p.processMessage(elem), elem.File().Size())
 	p.trackers.Store(elem.(*iterElem).id, tracker)
+
+	if p.ext != nil {
+		p.ext.OnAdd(elem)
+	}
 }
 
 func (p *progress) OnDownload(elem downloader.Elem, state downloader.ProgressState) {
+	if p.ext != nil {
+		p.ext.OnDownload(elem, state)
+	}
+
 	tracker, ok := p.trackers.Load(elem.(*iterElem).id)
 	if !ok {
 		return
@@ -54,33 +64,43 @@ func (p *progress) OnDownload(elem downloader.Elem, state downloader.ProgressSta
 }
 
 func (p *progress) OnDone(elem downloader.Elem, err error) {
+	err = p.done(elem, err)
+
+	if p.ext != nil {
+		p.ext.OnDone(elem, err)
+	}
+}
+
+// done finishes the file of elem and returns the final error of it
+func (p *progress) done(elem downloader.Elem, err error) error {
 	e := elem.(*iterElem)
 
 	tracker, ok := p.trackers.Load(e.id)
 	if !ok {
-		return
+		return err
 	}
 	t := tracker.(*pw.Tracker)
 
 	if err := e.to.Close(); err != nil {
-		p.fail(t, elem, errors.Wrap(err, "close file"))
-		return
+		return p.fail(t, elem, errors.Wrap(err, "close file"))
 	}
 
 	if err != nil {
-		if !errors.Is(err, context.Canceled) { // don't report user cancel
-			p.fail(t, elem, errors.Wrap(err, "progress"))
+		if errors.Is(err, context.Canceled) { // don't report user cancel
+			p.it.KeepPartial(e) // keep downloaded parts to continue next time
+			return err
 		}
 		_ = os.Remove(e.to.Name()) // just try to remove temp file, ignore error
-		return
+		return p.fail(t, elem, errors.Wrap(err, "progress"))
 	}
 
 	p.it.Finish(e.id)
 
 	if err := p.donePost(e); err != nil {
-		p.fail(t, elem, errors.Wrap(err, "post file"))
-		return
+		return p.fail(t, elem, errors.Wrap(err, "post file"))
 	}
+
+	return nil
 }
 
 func (p *progress) donePost(elem *iterElem) error {
@@ -101,6 +121,7 @@ func (p *progress) donePost(elem *iterElem) error {
 	if err := os.Rename(elem.to.Name(), newpath); err != nil {
 		return errors.Wrap(err, "rename file")
 	}
+	elem.path = newpath
 
 	// Set file modification time to message date if available
 	if elem.file.Date > 0 {
@@ -113,9 +134,10 @@ func (p *progress) donePost(elem *iterElem) error {
 	return nil
 }
 
-func (p *progress) fail(t *pw.Tracker, elem downloader.Elem, err error) {
+func (p *progress) fail(t *pw.Tracker, elem downloader.Elem, err error) error {
 	p.pw.Log(color.RedString("%s error: %s", p.elemString(elem), err.Error()))
 	t.MarkAsErrored()
+	return err
 }
 
 func (p *progress) processMessage(elem downloader.Elem) string {
@@ -6,6 +6,7 @@ import (
 	_ "embed"
 	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
 	"strconv"
 	"sync"
@@ -18,18 +19,17 @@ import (
 	"github.com/gotd/contrib/tg_io"
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/tg"
-	"github.com/spf13/viper"
 
 	"github.com/iyear/tdl/core/dcpool"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/core/tmedia"
 	"github.com/iyear/tdl/core/util/tutil"
-	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/tmessage"
 )
 
-type media struct {
+// Media is a message media which can be streamed over HTTP.
+type Media struct {
 	*tmedia.Media
 	MIME string
 }
@@ -43,39 +43,29 @@ func serve(ctx context.Context,
 	dialogs [][]*tmessage.Dialog,
 	port int,
 	takeout bool,
+	partSize int,
 ) error {
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
 
 	router := mux.NewRouter()
 
-	cache := &sync.Map{} // map[string]*media
+	cache := &sync.Map{} // map[string]*Media
 	router.Handle("/{peer}/{message:[0-9]+}", handler(func(w http.ResponseWriter, r *http.Request) error {
 		vars := mux.Vars(r)
 		peer := vars["peer"]
 		messageStr := vars["message"]
 
-		var item *media
+		var item *Media
 		if t, ok := cache.Load(peer + messageStr); ok {
-			item = t.(*media)
+			item = t.(*Media)
 		} else {
 			message, err := strconv.Atoi(messageStr)
 			if err != nil {
 				return errors.Wrap(err, "invalid message id")
 			}
 
-			p, err := tutil.GetInputPeer(ctx, manager, peer)
-			if err != nil {
-				return errors.Wrap(err, "resolve peer")
-			}
-
-			msg, err := tutil.GetSingleMessage(ctx, pool.Default(ctx), p.InputPeer(), message)
-			if err != nil {
-				return errors.Wrap(err, "resolve message")
-			}
-
-			item, err = convItem(msg)
-			if err != nil {
-				return errors.Wrap(err, "convItem")
+			if item, err = ResolveMedia(ctx, manager, pool.Default(ctx), peer, message); err != nil {
+				return err
 			}
 
 			cache.Store(peer+messageStr, item)
@@ -86,16 +76,7 @@ func serve(ctx context.Context,
 			api = pool.Takeout(ctx, item.DC)
 		}
 
-		u := partio.NewStreamer(
-			tg_io.NewDownloader(api).ChunkSource(item.Size, item.InputFileLoc),
-			int64(viper.GetInt(consts.FlagPartSize)))
-
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, item.Name))
-
-		http_io.NewHandler(u, item.Size).
-			WithContentType(item.MIME).
-			WithLog(logctx.From(ctx).Named("serve")).
-			ServeHTTP(w, r)
+		ServeMedia(ctx, w, r, api, item, partSize, false)
 		return nil
 	}))
 
@@ -142,7 +123,47 @@ func handler(h func(w http.ResponseWriter, r *http.Request) error) http.Handler
 	})
 }
 
-func convItem(msg *tg.Message) (*media, error) {
+// ResolveMedia gets the message from peer and returns its media.
+func ResolveMedia(ctx context.Context, manager *peers.Manager, api *tg.Client, peer string, message int) (*Media, error) {
+	p, err := tutil.GetInputPeer(ctx, manager, peer)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve peer")
+	}
+
+	msg, err := tutil.GetSingleMessage(ctx, api, p.InputPeer(), message)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve message")
+	}
+
+	item, err := NewMedia(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "get media")
+	}
+
+	return item, nil
+}
+
+// ServeMedia streams the media to w with Range support, api should be a client of the media DC.
+// If inline is true, browsers are allowed to display the media instead of saving it.
+func ServeMedia(ctx context.Context, w http.ResponseWriter, r *http.Request, api *tg.Client, item *Media, partSize int, inline bool) {
+	u := partio.NewStreamer(
+		tg_io.NewDownloader(api).ChunkSource(item.Size, item.InputFileLoc),
+		int64(partSize))
+
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": item.Name}))
+
+	http_io.NewHandler(u, item.Size).
+		WithContentType(item.MIME).
+		WithLog(logctx.From(ctx).Named("serve")).
+		ServeHTTP(w, r)
+}
+
+// NewMedia returns the streamable media of the message with its MIME type.
+func NewMedia(msg *tg.Message) (*Media, error) {
 	md, ok := tmedia.GetMedia(msg)
 	if !ok {
 		return nil, errors.New("message is not a media")
@@ -160,7 +181,7 @@ func convItem(msg *tg.Message) (*media, error) {
 		mime = "image/jpeg"
 	}
 
-	return &media{
+	return &Media{
 		Media: md,
 		MIME:  mime,
 	}, nil
@@ -13,7 +13,6 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/peers"
 	pw "github.com/jedib0t/go-pretty/v6/progress"
-	"github.com/spf13/viper"
 	"go.uber.org/multierr"
 
 	"github.com/iyear/tdl/app/internal/tctx"
@@ -22,10 +21,10 @@ import (
 	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/core/tclient"
 	"github.com/iyear/tdl/core/util/tutil"
-	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/prog"
 	"github.com/iyear/tdl/pkg/texpr"
 	"github.com/iyear/tdl/pkg/tmessage"
+	"github.com/iyear/tdl/pkg/transfer"
 )
 
 type Options struct {
@@ -37,6 +36,16 @@ type Options struct {
 	DryRun bool
 	Single bool
 	Desc   bool
+
+	// Progress receives forward events in addition to the terminal output.
+	// If set, the terminal progress bar is not rendered.
+	Progress forwarder.Progress
+
+	Transfer transfer.Options
+
+	// Pool is used instead of creating a new pool from the client if set,
+	// and it is not closed after running.
+	Pool dcpool.Pool
 }
 
 func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
@@ -54,10 +63,15 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	ctx = tctx.WithKV(ctx, kvd)
 
-	pool := dcpool.NewPool(c,
-		int64(viper.GetInt(consts.FlagPoolSize)),
-		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
-	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+	opts.Transfer = opts.Transfer.Normalize()
+
+	pool := opts.Pool
+	if pool == nil {
+		pool = dcpool.NewPool(c,
+			opts.Transfer.PoolSize,
+			tclient.NewDefaultMiddlewares(ctx, opts.Transfer.ReconnectTimeout)...)
+		defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+	}
 
 	ctx = tctx.WithPool(ctx, pool)
 
@@ -80,7 +94,11 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	fwProgress := prog.New(pw.FormatNumber)
 	fwProgress.SetNumTrackersExpected(totalMessages(dialogs))
-	prog.EnablePS(ctx, fwProgress)
+	if opts.Progress == nil {
+		prog.EnablePS(ctx, fwProgress)
+	} else {
+		prog.SetTotal(opts.Progress, totalMessages(dialogs))
+	}
 
 	fw := forwarder.New(forwarder.Options{
 		Pool: pool,
@@ -94,14 +112,16 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 			silent:  opts.Silent,
 			dryRun:  opts.DryRun,
 			grouped: !opts.Single,
-			delay:   viper.GetDuration(consts.FlagDelay),
+			delay:   opts.Transfer.Delay,
 		}),
-		Progress: newProgress(fwProgress),
-		Threads:  viper.GetInt(consts.FlagThreads),
+		Progress: newProgress(fwProgress, opts.Progress),
+		Threads:  opts.Transfer.Threads,
 	})
 
-	go fwProgress.Render()
-	defer prog.Wait(ctx, fwProgress)
+	if opts.Progress == nil {
+		go fwProgress.Render()
+		defer prog.Wait(ctx, fwProgress)
+	}
 
 	return fw.Forward(ctx)
 }
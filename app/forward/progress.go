@@ -17,6 +17,7 @@ type progress struct {
 	pw       pw.Writer
 	trackers map[tuple]*pw.Tracker // TODO(iyear): concurrent map
 	elemName map[int64]string
+	ext      forwarder.Progress // external progress sink, may be nil
 }
 
 type tuple struct {
@@ -25,20 +26,29 @@ type tuple struct {
 	to   int64
 }
 
-func newProgress(p pw.Writer) *progress {
+func newProgress(p pw.Writer, ext forwarder.Progress) *progress {
 	return &progress{
 		pw:       p,
 		trackers: make(map[tuple]*pw.Tracker),
 		elemName: make(map[int64]string),
+		ext:      ext,
 	}
 }
 
 func (p *progress) OnAdd(elem forwarder.Elem) {
 	tracker := prog.AppendTracker(p.pw, pw.FormatNumber, p.processMessage(elem, false), 1)
 	p.trackers[p.tuple(elem)] = tracker
+
+	if p.ext != nil {
+		p.ext.OnAdd(elem)
+	}
 }
 
 func (p *progress) OnClone(elem forwarder.Elem, state forwarder.ProgressState) {
+	if p.ext != nil {
+		p.ext.OnClone(elem, state)
+	}
+
 	tracker, ok := p.trackers[p.tuple(elem)]
 	if !ok {
 		return
@@ -52,6 +62,10 @@ func (p *progress) OnClone(elem forwarder.Elem, state forwarder.ProgressState) {
 }
 
 func (p *progress) OnDone(elem forwarder.Elem, err error) {
+	if p.ext != nil {
+		p.ext.OnDone(elem, err)
+	}
+
 	tracker, ok := p.trackers[p.tuple(elem)]
 	if !ok {
 		return
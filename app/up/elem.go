@@ -10,6 +10,12 @@ import (
 	"github.com/iyear/tdl/core/uploader"
 )
 
+// FileElem is implemented by the elems passed to Options.Progress.
+// Path returns the local path of the file being uploaded.
+type FileElem interface {
+	Path() string
+}
+
 type iterElem struct {
 	file  *uploaderFile
 	thumb *uploaderFile
@@ -38,6 +44,10 @@ func (e *iterElem) AsPhoto() bool {
 	return e.asPhoto
 }
 
+func (e *iterElem) Path() string {
+	return e.file.File.Name()
+}
+
 type uploaderFile struct {
 	*os.File
 	size int64
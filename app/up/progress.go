@@ -16,7 +16,8 @@ import (
 
 type progress struct {
 	pw       pw.Writer
-	trackers *sync.Map // map[tuple]*pw.Tracker
+	trackers *sync.Map         // map[tuple]*pw.Tracker
+	ext      uploader.Progress // external progress sink, may be nil
 }
 
 type tuple struct {
@@ -24,19 +25,28 @@ type tuple struct {
 	to   int64
 }
 
-func newProgress(p pw.Writer) *progress {
+func newProgress(p pw.Writer, ext uploader.Progress) *progress {
 	return &progress{
 		pw:       p,
 		trackers: &sync.Map{},
+		ext:      ext,
 	}
 }
 
 func (p *progress) OnAdd(elem uploader.Elem) {
 	tracker := prog.AppendTracker(p.pw, utils.Byte.FormatBinaryBytes, p.processMessage(elem), elem.File().Size())
 	p.trackers.Store(p.tuple(elem), tracker)
+
+	if p.ext != nil {
+		p.ext.OnAdd(elem)
+	}
 }
 
 func (p *progress) OnUpload(elem uploader.Elem, state uploader.ProgressState) {
+	if p.ext != nil {
+		p.ext.OnUpload(elem, state)
+	}
+
 	tracker, ok := p.trackers.Load(p.tuple(elem))
 	if !ok {
 		return
@@ -48,29 +58,37 @@ func (p *progress) OnUpload(elem uploader.Elem, state uploader.ProgressState) {
 }
 
 func (p *progress) OnDone(elem uploader.Elem, err error) {
+	err = p.done(elem, err)
+
+	if p.ext != nil {
+		p.ext.OnDone(elem, err)
+	}
+}
+
+// done closes the files of elem and returns the final error of it
+func (p *progress) done(elem uploader.Elem, err error) error {
 	tracker, ok := p.trackers.Load(p.tuple(elem))
 	if !ok {
-		return
+		return err
 	}
 	t := tracker.(*pw.Tracker)
 	e := elem.(*iterElem)
 
 	if err := p.closeFile(e); err != nil {
-		p.fail(t, elem, errors.Wrap(err, "close file"))
-		return
+		return p.fail(t, elem, errors.Wrap(err, "close file"))
 	}
 
 	if err != nil {
-		p.fail(t, elem, errors.Wrap(err, "progress"))
-		return
+		return p.fail(t, elem, errors.Wrap(err, "progress"))
 	}
 
 	if e.remove {
 		if err := os.Remove(e.file.File.Name()); err != nil {
-			p.fail(t, elem, errors.Wrap(err, "remove file"))
-			return
+			return p.fail(t, elem, errors.Wrap(err, "remove file"))
 		}
 	}
+
+	return nil
 }
 
 func (p *progress) closeFile(e *iterElem) error {
@@ -87,9 +105,10 @@ func (p *progress) closeFile(e *iterElem) error {
 	return nil
 }
 
-func (p *progress) fail(t *pw.Tracker, elem uploader.Elem, err error) {
+func (p *progress) fail(t *pw.Tracker, elem uploader.Elem, err error) error {
 	p.pw.Log(color.RedString("%s error: %s", p.elemString(elem), err.Error()))
 	t.MarkAsErrored()
+	return err
 }
 
 func (p *progress) tuple(elem uploader.Elem) tuple {
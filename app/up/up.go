@@ -8,7 +8,6 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/peers"
 	"github.com/gotd/td/tg"
-	"github.com/spf13/viper"
 	"go.uber.org/multierr"
 
 	"github.com/iyear/tdl/core/dcpool"
@@ -16,8 +15,8 @@ import (
 	"github.com/iyear/tdl/core/tclient"
 	"github.com/iyear/tdl/core/uploader"
 	"github.com/iyear/tdl/core/util/tutil"
-	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/prog"
+	"github.com/iyear/tdl/pkg/transfer"
 	"github.com/iyear/tdl/pkg/utils"
 )
 
@@ -27,6 +26,16 @@ type Options struct {
 	Excludes []string
 	Remove   bool
 	Photo    bool
+
+	// Progress receives upload events in addition to the built-in file handling.
+	// If set, the terminal progress bar is not rendered.
+	Progress uploader.Progress
+
+	Transfer transfer.Options
+
+	// Pool is used instead of creating a new pool from the client if set,
+	// and it is not closed after running.
+	Pool dcpool.Pool
 }
 
 func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Options) (rerr error) {
@@ -37,10 +46,15 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	color.Blue("Files count: %d", len(files))
 
-	pool := dcpool.NewPool(c,
-		int64(viper.GetInt(consts.FlagPoolSize)),
-		tclient.NewDefaultMiddlewares(ctx, viper.GetDuration(consts.FlagReconnectTimeout))...)
-	defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+	opts.Transfer = opts.Transfer.Normalize()
+
+	pool := opts.Pool
+	if pool == nil {
+		pool = dcpool.NewPool(c,
+			opts.Transfer.PoolSize,
+			tclient.NewDefaultMiddlewares(ctx, opts.Transfer.ReconnectTimeout)...)
+		defer multierr.AppendInvoke(&rerr, multierr.Close(pool))
+	}
 
 	manager := peers.Options{Storage: storage.NewPeers(kvd)}.Build(pool.Default(ctx))
 
@@ -51,21 +65,27 @@ func Run(ctx context.Context, c *telegram.Client, kvd storage.Storage, opts Opti
 
 	upProgress := prog.New(utils.Byte.FormatBinaryBytes)
 	upProgress.SetNumTrackersExpected(len(files))
-	prog.EnablePS(ctx, upProgress)
+	if opts.Progress == nil {
+		prog.EnablePS(ctx, upProgress)
+	} else {
+		prog.SetTotal(opts.Progress, len(files))
+	}
 
 	options := uploader.Options{
 		Client:   pool.Default(ctx),
-		Threads:  viper.GetInt(consts.FlagThreads),
-		Iter:     newIter(files, to, opts.Photo, opts.Remove, viper.GetDuration(consts.FlagDelay)),
-		Progress: newProgress(upProgress),
+		Threads:  opts.Transfer.Threads,
+		Iter:     newIter(files, to, opts.Photo, opts.Remove, opts.Transfer.Delay),
+		Progress: newProgress(upProgress, opts.Progress),
 	}
 
 	up := uploader.New(options)
 
-	go upProgress.Render()
-	defer prog.Wait(ctx, upProgress)
+	if opts.Progress == nil {
+		go upProgress.Render()
+		defer prog.Wait(ctx, upProgress)
+	}
 
-	return up.Upload(ctx, viper.GetInt(consts.FlagLimit))
+	return up.Upload(ctx, opts.Transfer.Limit)
 }
 
 func resolveDestPeer(ctx context.Context, manager *peers.Manager, chat string) (peers.Peer, error) {
@@ -28,6 +28,7 @@ func NewDownload() *cobra.Command {
 			}
 
 			opts.Template = viper.GetString(consts.FlagDlTemplate)
+			opts.Transfer = tTransfer()
 
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return dl.Run(logctx.Named(ctx, "dl"), c, kvd, opts)
@@ -22,6 +22,8 @@ func NewForward() *cobra.Command {
 		Short:   "Forward messages with automatic fallback and message routing",
 		GroupID: groupTools.ID,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Transfer = tTransfer()
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return forward.Run(logctx.Named(ctx, "forward"), c, kvd, opts)
 			})
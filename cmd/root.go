@@ -28,6 +28,7 @@ import (
 	"github.com/iyear/tdl/pkg/extensions"
 	"github.com/iyear/tdl/pkg/kv"
 	"github.com/iyear/tdl/pkg/tclient"
+	"github.com/iyear/tdl/pkg/transfer"
 )
 
 var (
@@ -214,8 +215,12 @@ func completeExtFiles(ext ...string) completeFunc {
 
 func tOptions(ctx context.Context) (tclient.Options, error) {
 	// init tclient kv
-	kvd, err := kv.From(ctx).Open(viper.GetString(consts.FlagNamespace))
+	ns := viper.GetString(consts.FlagNamespace)
+	kvd, err := kv.From(ctx).Open(ns)
 	if err != nil {
+		if kv.IsLocked(err) {
+			return tclient.Options{}, errors.Errorf("namespace %q is in use by another process, e.g. tdl web", ns)
+		}
 		return tclient.Options{}, errors.Wrap(err, "open kv storage")
 	}
 	o := tclient.Options{
@@ -229,6 +234,18 @@ func tOptions(ctx context.Context) (tclient.Options, error) {
 	return o, nil
 }
 
+// tTransfer builds transfer options from global flags
+func tTransfer() transfer.Options {
+	return transfer.Options{
+		Threads:          viper.GetInt(consts.FlagThreads),
+		Limit:            viper.GetInt(consts.FlagLimit),
+		PoolSize:         int64(viper.GetInt(consts.FlagPoolSize)),
+		Delay:            viper.GetDuration(consts.FlagDelay),
+		ReconnectTimeout: viper.GetDuration(consts.FlagReconnectTimeout),
+		PartSize:         viper.GetInt(consts.FlagPartSize),
+	}
+}
+
 func tRun(ctx context.Context, f func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error, middlewares ...telegram.Middleware) error {
 	o, err := tOptions(ctx)
 	if err != nil {
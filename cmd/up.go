@@ -20,6 +20,8 @@ func NewUpload() *cobra.Command {
 		Short:   "Upload anything to Telegram",
 		GroupID: groupTools.ID,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Transfer = tTransfer()
+
 			return tRun(cmd.Context(), func(ctx context.Context, c *telegram.Client, kvd storage.Storage) error {
 				return up.Run(logctx.Named(ctx, "up"), c, kvd, opts)
 			})
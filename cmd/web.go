@@ -3,6 +3,7 @@ package cmd
 import (
 	"path/filepath"
 
+	"github.com/go-faster/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -12,10 +13,17 @@ import (
 	"github.com/iyear/tdl/pkg/consts"
 	"github.com/iyear/tdl/pkg/kv"
 	"github.com/iyear/tdl/web/backend"
+	"github.com/iyear/tdl/web/backend/service"
 )
 
 func NewWeb() *cobra.Command {
-	var port int
+	var (
+		port     int
+		password string
+		shared   bool
+		imports  []string
+		roots    service.PathRoots
+	)
 
 	cmd := &cobra.Command{
 		Use:     "web",
@@ -30,30 +38,63 @@ func NewWeb() *cobra.Command {
 			webLogger := logutil.New(level, filepath.Join(consts.LogPath, "web.log"))
 			ctx := logctx.With(cmd.Context(), webLogger)
 
-			// 创建默认的Bolt存储配置
-			webBoltStorage := map[string]string{
-				kv.DriverTypeKey: kv.DriverBolt.String(),
-				"path":           filepath.Join(consts.DataDir, "web_data"),
-			}
+			// CLI的存储（--storage），导入时按需打开，用完立即关闭以释放文件锁
+			cliStorage := viper.GetStringMapString(consts.FlagStorage)
 
-			// 创建KV存储实例
-			kvStore, err := kv.NewWithMap(webBoltStorage)
-			if err != nil {
-				return err
+			var kvStore kv.Storage
+			if shared {
+				// bolt每个命名空间一个文件，CLI和Web可以同时使用不同的命名空间；
+				// legacy和file驱动是单个文件，无法在多个进程间共享
+				if driver, err := kv.ParseDriver(cliStorage[kv.DriverTypeKey]); err != nil || driver != kv.DriverBolt {
+					return errors.New("--shared requires the bolt storage driver")
+				}
+				kvStore = kv.From(cmd.Context())
+			} else {
+				// 创建默认的Bolt存储配置
+				webBoltStorage := map[string]string{
+					kv.DriverTypeKey: kv.DriverBolt.String(),
+					"path":           filepath.Join(consts.DataDir, "web_data"),
+				}
+
+				// 创建KV存储实例
+				var err error
+				kvStore, err = kv.NewWithMap(webBoltStorage)
+				if err != nil {
+					return err
+				}
+				// 注意：不在这里关闭存储，因为服务器需要持续使用
 			}
-			// 注意：不在这里关闭存储，因为服务器需要持续使用
 
 			config := backend.Config{
-				Port:  port,
-				Debug: viper.GetBool("debug"),
+				Port:     port,
+				Debug:    viper.GetBool("debug"),
+				Password: password,
+				CLIStorage: func() (kv.Storage, error) {
+					return kv.NewWithMap(cliStorage)
+				},
+				SharedStorage: shared,
+				Import:        imports,
+				Roots:         roots,
 			}
 
-			server := backend.NewServer(ctx, kvStore, config)
+			server, err := backend.NewServer(ctx, kvStore, config)
+			if err != nil {
+				if kv.IsLocked(err) {
+					return errors.Wrap(err, "web storage is in use by another tdl web process")
+				}
+				return err
+			}
 			return server.Start()
 		},
 	}
 
 	cmd.Flags().IntVarP(&port, "port", "p", 8080, "web server port")
+	cmd.Flags().StringVar(&password, "password", "", "admin password of web interface, a random one is generated on first start if not set")
+	cmd.Flags().BoolVar(&shared, "shared", false, "use the CLI storage (--storage) instead of a separate one, web accounts are then available to CLI as namespace 'user_<id>'")
+	cmd.Flags().StringSliceVar(&roots.Download, "download-root", nil, "directories that web download tasks can save to, defaults to Downloads/tdl in the home directory. The tdl data directory is never accessible")
+	cmd.Flags().StringSliceVar(&roots.Export, "export-root", nil, "directories that web exports can write to, defaults to --download-root")
+	cmd.Flags().StringSliceVar(&roots.Upload, "upload-root", nil, "directories whose files can be uploaded from the server, defaults to --download-root")
+	cmd.Flags().StringSliceVar(&imports, "import", nil, "CLI namespaces to import as web accounts on start, sessions are copied so CLI can keep using them")
 
 	return cmd
 }
\ No newline at end of file
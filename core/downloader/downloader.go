@@ -2,9 +2,12 @@ package downloader
 
 import (
 	"context"
+	"io"
 
 	"github.com/go-faster/errors"
+	"github.com/gotd/contrib/tg_io"
 	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
@@ -40,17 +43,20 @@ func (d *Downloader) Download(ctx context.Context, limit int) error {
 	for d.opts.Iter.Next(wgctx) {
 		elem := d.opts.Iter.Value()
 
-		wg.Go(func() (rerr error) {
+		wg.Go(func() error {
 			d.opts.Progress.OnAdd(elem)
-			defer func() { d.opts.Progress.OnDone(elem, rerr) }()
+			var derr error // error of this elem, reported to progress
+			defer func() { d.opts.Progress.OnDone(elem, derr) }()
 
 			if err := d.download(wgctx, elem); err != nil {
+				derr = err
+
 				// canceled by user, so we directly return error to stop all
 				if errors.Is(err, context.Canceled) {
 					return errors.Wrap(err, "download")
 				}
 
-				// don't return error, just log it
+				// don't return error, just report it by progress
 			}
 
 			return nil
@@ -79,13 +85,48 @@ func (d *Downloader) download(ctx context.Context, elem Elem) error {
 		client = d.opts.Pool.Takeout(ctx, elem.File().DC())
 	}
 
+	w := newWriteAt(elem, d.opts.Progress, MaxPartSize)
+
+	if r, ok := elem.(Resumable); ok && r.Offset() > 0 {
+		w.downloaded.Store(r.Offset())
+		return d.downloadFrom(ctx, client, elem, r.Offset(), w)
+	}
+
 	_, err := downloader.NewDownloader().WithPartSize(MaxPartSize).
 		Download(client, elem.File().Location()).
 		WithThreads(tutil.BestThreads(elem.File().Size(), d.opts.Threads)).
-		Parallel(ctx, newWriteAt(elem, d.opts.Progress, MaxPartSize))
+		Parallel(ctx, w)
 	if err != nil {
 		return errors.Wrap(err, "download")
 	}
 
 	return nil
 }
+
+// downloadFrom downloads the rest of the file from offset in parallel
+func (d *Downloader) downloadFrom(ctx context.Context, client *tg.Client, elem Elem, offset int64, w io.WriterAt) error {
+	size := elem.File().Size()
+	source := tg_io.NewDownloader(client).ChunkSource(size, elem.File().Location())
+
+	wg, wgctx := errgroup.WithContext(ctx)
+	wg.SetLimit(tutil.BestThreads(size-offset, d.opts.Threads))
+
+	for off := offset; off < size && wgctx.Err() == nil; off += MaxPartSize {
+		off := off
+		wg.Go(func() error {
+			buf := make([]byte, MaxPartSize)
+			n, err := source.Chunk(wgctx, off, buf)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return errors.Wrapf(err, "download part at %d", off)
+			}
+
+			_, err = w.WriteAt(buf[:n], off)
+			return err
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return errors.Wrap(err, "download")
+	}
+	return ctx.Err()
+}
@@ -20,6 +20,13 @@ type Elem interface {
 	AsTakeout() bool
 }
 
+// Resumable is an optional interface of Elem. To of a resumable elem already
+// contains the first Offset() bytes of the file, so download starts from there.
+// Offset must be a multiple of MaxPartSize.
+type Resumable interface {
+	Offset() int64
+}
+
 type File interface {
 	Location() tg.InputFileLocationClass
 	Size() int64
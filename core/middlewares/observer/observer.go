@@ -0,0 +1,42 @@
+package observer
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// Observer is notified of errors handled inside the middleware chain, e.g. to collect metrics.
+type Observer interface {
+	// OnRetry is called before the retry middleware retries a request.
+	OnRetry(ctx context.Context, err error)
+	// OnFloodWait is called when a request fails with FLOOD_WAIT, before the flood waiter sleeps.
+	OnFloodWait(ctx context.Context, d time.Duration)
+}
+
+type floodWait struct {
+	o Observer
+}
+
+func (f floodWait) Handle(next tg.Invoker) telegram.InvokeFunc {
+	return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		err := next.Invoke(ctx, input, output)
+		if d, ok := tgerr.AsFloodWait(err); ok {
+			if d == 0 {
+				d = time.Second // same as floodwait.SimpleWaiter
+			}
+			f.o.OnFloodWait(ctx, d)
+		}
+		return err
+	}
+}
+
+// FloodWait returns middleware that reports flood wait errors to o.
+// It must be placed after the flood waiter so that every wait is observed.
+func FloodWait(o Observer) telegram.Middleware {
+	return floodWait{o: o}
+}
@@ -24,8 +24,9 @@ var internalErrors = []string{
 }
 
 type retry struct {
-	max    int
-	errors []string
+	max     int
+	errors  []string
+	onRetry func(ctx context.Context, err error)
 }
 
 func (r retry) Handle(next tg.Invoker) telegram.InvokeFunc {
@@ -36,6 +37,9 @@ func (r retry) Handle(next tg.Invoker) telegram.InvokeFunc {
 			if err := next.Invoke(ctx, input, output); err != nil {
 				if tgerr.Is(err, r.errors...) {
 					logctx.From(ctx).Debug("retry middleware", zap.Int("retries", retries), zap.Error(err))
+					if r.onRetry != nil {
+						r.onRetry(ctx, err)
+					}
 					retries++
 					continue
 				}
@@ -51,8 +55,14 @@ func (r retry) Handle(next tg.Invoker) telegram.InvokeFunc {
 
 // New returns middleware that retries request if it fails with one of provided errors.
 func New(max int, errors ...string) telegram.Middleware {
+	return NewWithHook(max, nil, errors...)
+}
+
+// NewWithHook is like New, but calls onRetry with the error before each retry.
+func NewWithHook(max int, onRetry func(ctx context.Context, err error), errors ...string) telegram.Middleware {
 	return retry{
-		max:    max,
-		errors: append(errors, internalErrors...), // #373
+		max:     max,
+		errors:  append(errors, internalErrors...), // #373
+		onRetry: onRetry,
 	}
 }
@@ -2,7 +2,6 @@ package tclient
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -16,6 +15,7 @@ import (
 	"golang.org/x/net/proxy"
 
 	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/middlewares/observer"
 	"github.com/iyear/tdl/core/middlewares/recovery"
 	"github.com/iyear/tdl/core/middlewares/retry"
 	"github.com/iyear/tdl/core/util/netutil"
@@ -29,6 +29,9 @@ var (
 	PublicKeys []exchange.PublicKey
 )
 
+// ErrNotAuthorized is returned by RunWithAuth if the session is not logged in.
+var ErrNotAuthorized = errors.New("not authorized. please login first")
+
 type Options struct {
 	AppID            int
 	AppHash          string
@@ -95,6 +98,16 @@ func NewDefaultMiddlewares(ctx context.Context, timeout time.Duration) []telegra
 	}
 }
 
+// NewObservedMiddlewares is like NewDefaultMiddlewares, but reports retries and flood waits to o.
+func NewObservedMiddlewares(ctx context.Context, timeout time.Duration, o observer.Observer) []telegram.Middleware {
+	return []telegram.Middleware{
+		recovery.New(ctx, newBackoff(timeout)),
+		retry.NewWithHook(5, o.OnRetry),
+		floodwait.NewSimpleWaiter(),
+		observer.FloodWait(o),
+	}
+}
+
 func newBackoff(timeout time.Duration) backoff.BackOff {
 	b := backoff.NewExponentialBackOff()
 
@@ -111,7 +124,7 @@ func RunWithAuth(ctx context.Context, client *telegram.Client, f func(ctx contex
 			return err
 		}
 		if !status.Authorized {
-			return fmt.Errorf("not authorized. please login first")
+			return ErrNotAuthorized
 		}
 
 		return f(ctx)
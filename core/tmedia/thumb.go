@@ -0,0 +1,142 @@
+package tmedia
+
+import (
+	"fmt"
+
+	"github.com/gotd/td/telegram/thumbnail"
+	"github.com/gotd/td/tg"
+)
+
+// Thumb is a small preview of a photo or document.
+type Thumb struct {
+	Media        // InputFileLoc is nil if Bytes is set
+	Key   string // unique key of the thumbnail, e.g. photo_<id>_<type>
+	Bytes []byte // JPEG which can be used without downloading, e.g. inflated stripped thumbnail
+}
+
+// GetThumb returns the smallest thumbnail whose longer side is at least minSide,
+// or the largest one if all of them are smaller. The stripped thumbnail is only
+// used if there is no other size.
+func GetThumb(m tg.MessageMediaClass, minSide int) (*Thumb, bool) {
+	switch m := m.(type) {
+	case *tg.MessageMediaPhoto:
+		p, ok := m.Photo.(*tg.Photo)
+		if !ok {
+			return nil, false
+		}
+
+		return pickThumb(p.Sizes, minSide, func(size string) *Thumb {
+			return &Thumb{
+				Media: Media{
+					InputFileLoc: &tg.InputPhotoFileLocation{
+						ID:            p.ID,
+						AccessHash:    p.AccessHash,
+						FileReference: p.FileReference,
+						ThumbSize:     size,
+					},
+					DC:   p.DCID,
+					Date: int64(p.Date),
+				},
+				Key: fmt.Sprintf("photo_%d_%s", p.ID, size),
+			}
+		})
+	case *tg.MessageMediaDocument:
+		doc, ok := m.Document.(*tg.Document)
+		if !ok {
+			return nil, false
+		}
+		thumbs, ok := doc.GetThumbs()
+		if !ok {
+			return nil, false
+		}
+
+		return pickThumb(thumbs, minSide, func(size string) *Thumb {
+			return &Thumb{
+				Media: Media{
+					InputFileLoc: &tg.InputDocumentFileLocation{
+						ID:            doc.ID,
+						AccessHash:    doc.AccessHash,
+						FileReference: doc.FileReference,
+						ThumbSize:     size,
+					},
+					DC:   doc.DCID,
+					Date: int64(doc.Date),
+				},
+				Key: fmt.Sprintf("doc_%d_%s", doc.ID, size),
+			}
+		})
+	case *tg.MessageMediaInvoice:
+		if e, ok := m.ExtendedMedia.(*tg.MessageExtendedMedia); ok {
+			return GetThumb(e.Media, minSide)
+		}
+	}
+
+	return nil, false
+}
+
+func pickThumb(sizes []tg.PhotoSizeClass, minSide int, newThumb func(size string) *Thumb) (*Thumb, bool) {
+	var (
+		best     tg.PhotoSizeClass
+		bestSide int
+		stripped *tg.PhotoStrippedSize
+	)
+
+	for _, size := range sizes {
+		var side int
+		switch s := size.(type) {
+		case *tg.PhotoSize:
+			side = max(s.W, s.H)
+		case *tg.PhotoSizeProgressive:
+			if len(s.Sizes) == 0 {
+				continue
+			}
+			side = max(s.W, s.H)
+		case *tg.PhotoCachedSize:
+			side = max(s.W, s.H)
+		case *tg.PhotoStrippedSize:
+			stripped = s
+			continue
+		default: // e.g. vector outline of stickers
+			continue
+		}
+
+		switch {
+		case best == nil,
+			bestSide < minSide && side > bestSide,                     // larger one is still small
+			bestSide >= minSide && side >= minSide && side < bestSide: // smaller one is still enough
+			best, bestSide = size, side
+		}
+	}
+
+	if best == nil {
+		if stripped == nil {
+			return nil, false
+		}
+		data, err := thumbnail.Expand(stripped.Bytes)
+		if err != nil {
+			return nil, false
+		}
+
+		t := newThumb(stripped.Type)
+		t.InputFileLoc = nil
+		t.Bytes = data
+		t.Size = int64(len(data))
+		t.Name = t.Key + ".jpg"
+		return t, true
+	}
+
+	t := newThumb(best.GetType())
+	t.Name = t.Key + ".jpg"
+	switch s := best.(type) {
+	case *tg.PhotoSize:
+		t.Size = int64(s.Size)
+	case *tg.PhotoSizeProgressive:
+		t.Size = int64(s.Sizes[len(s.Sizes)-1])
+	case *tg.PhotoCachedSize:
+		t.InputFileLoc = nil
+		t.Bytes = s.Bytes
+		t.Size = int64(len(s.Bytes))
+	}
+
+	return t, true
+}
@@ -42,17 +42,20 @@ func (u *Uploader) Upload(ctx context.Context, limit int) error {
 	for u.opts.Iter.Next(wgctx) {
 		elem := u.opts.Iter.Value()
 
-		wg.Go(func() (rerr error) {
+		wg.Go(func() error {
 			u.opts.Progress.OnAdd(elem)
-			defer func() { u.opts.Progress.OnDone(elem, rerr) }()
+			var derr error // error of this elem, reported to progress
+			defer func() { u.opts.Progress.OnDone(elem, derr) }()
 
 			if err := u.upload(wgctx, elem); err != nil {
+				derr = err
+
 				// canceled by user, so we directly return error to stop all
 				if errors.Is(err, context.Canceled) {
 					return errors.Wrap(err, "upload")
 				}
 
-				// don't return error, just log it
+				// don't return error, just report it by progress
 			}
 
 			return nil
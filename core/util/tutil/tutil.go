@@ -111,7 +111,7 @@ func GetInputPeer(ctx context.Context, manager *peers.Manager, from string) (pee
 		return p, nil
 	}
 
-	return nil, fmt.Errorf("failed to get result from %d：%v", id, err)
+	return nil, fmt.Errorf("failed to get result from %d：%w", id, err)
 }
 
 func GetPeerID(peer tg.PeerClass) int64 {
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/go-faster/errors"
+	"go.etcd.io/bbolt"
 
 	"github.com/iyear/tdl/core/storage"
 )
@@ -74,3 +75,8 @@ func From(ctx context.Context) Storage {
 func IsNotFound(err error) bool {
 	return errors.Is(err, storage.ErrNotFound)
 }
+
+// IsLocked checks if error is caused by a database file locked by another process
+func IsLocked(err error) bool {
+	return errors.Is(err, bbolt.ErrTimeout)
+}
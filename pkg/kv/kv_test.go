@@ -154,3 +154,26 @@ func TestStorage_MigrateFrom(t *testing.T) {
 		}
 	})
 }
+
+func TestIsLocked(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := New(DriverBolt, map[string]any{"path": dir})
+	require.NoError(t, err)
+	b, err := New(DriverBolt, map[string]any{"path": dir})
+	require.NoError(t, err)
+
+	_, err = a.Open("foo")
+	require.NoError(t, err)
+
+	// namespaces are separate files, only the same namespace is locked
+	_, err = b.Open("bar")
+	assert.NoError(t, err)
+	_, err = b.Open("foo")
+	assert.True(t, IsLocked(err))
+
+	require.NoError(t, a.Close())
+	_, err = b.Open("foo")
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+}
@@ -57,3 +57,16 @@ func Wait(ctx context.Context, pw progress.Writer) {
 		}
 	}
 }
+
+// Totaler is implemented by external progress sinks
+// that want to know the expected number of items.
+type Totaler interface {
+	SetTotal(total int)
+}
+
+// SetTotal reports the expected number of items to p if it implements Totaler.
+func SetTotal(p any, total int) {
+	if t, ok := p.(Totaler); ok {
+		t.SetTotal(total)
+	}
+}
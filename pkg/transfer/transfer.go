@@ -0,0 +1,51 @@
+// Package transfer defines the transfer parameters shared by download, upload and forward,
+// so that they can be passed explicitly instead of read from global flags.
+package transfer
+
+import "time"
+
+// Options are the transfer parameters of one run.
+type Options struct {
+	Threads          int           // max threads for transfer one item
+	Limit            int           // max number of concurrent tasks
+	PoolSize         int64         // size of the DC pool, zero means infinity
+	Delay            time.Duration // delay between each task, zero means no delay
+	ReconnectTimeout time.Duration // Telegram client reconnection backoff timeout, infinite if set to 0
+	PartSize         int           // part size of streaming in serve mode
+}
+
+// Default returns the same defaults as the CLI flags.
+func Default() Options {
+	return Options{
+		Threads:          4,
+		Limit:            2,
+		PoolSize:         8,
+		Delay:            0,
+		ReconnectTimeout: 5 * time.Minute,
+		PartSize:         512 * 1024,
+	}
+}
+
+// Normalize replaces the fields which can't be zero with defaults,
+// so that a zero Options is still usable.
+func (o Options) Normalize() Options {
+	d := Default()
+
+	if o.Threads <= 0 {
+		o.Threads = d.Threads
+	}
+	if o.Limit <= 0 {
+		o.Limit = d.Limit
+	}
+	if o.PoolSize < 0 {
+		o.PoolSize = 0
+	}
+	if o.Delay < 0 {
+		o.Delay = 0
+	}
+	if o.PartSize <= 0 {
+		o.PartSize = d.PartSize
+	}
+
+	return o
+}
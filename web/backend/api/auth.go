@@ -11,8 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/web/backend/middleware"
 	"github.com/iyear/tdl/web/backend/service"
-	"github.com/iyear/tdl/web/backend/util"
 	"github.com/iyear/tdl/web/backend/websocket"
 )
 
@@ -20,23 +20,37 @@ type AuthHandler struct {
 	ctx         context.Context
 	kvStore     kv.Storage
 	authService *service.AuthService
+	clients     *service.ClientManager
 	wsHub       *websocket.Hub
+	cliStorage  service.StorageOpener // 打开CLI存储，为nil时不支持导入
+	shared      bool                  // Web是否与CLI共享存储
 }
 
-func NewAuthHandler(ctx context.Context, kvStore kv.Storage, wsHub *websocket.Hub) *AuthHandler {
+func NewAuthHandler(ctx context.Context, kvStore kv.Storage, authService *service.AuthService, wsHub *websocket.Hub, clients *service.ClientManager, cliStorage service.StorageOpener, shared bool) *AuthHandler {
 	return &AuthHandler{
 		ctx:         ctx,
 		kvStore:     kvStore,
-		authService: service.NewAuthService(ctx, kvStore),
+		authService: authService,
+		clients:     clients,
 		wsHub:       wsHub,
+		cliStorage:  cliStorage,
+		shared:      shared,
 	}
 }
 
 // GetStatus 获取认证状态
 func (h *AuthHandler) GetStatus(c *gin.Context) {
-	userID := h.getUserID(c)
-	
-	authenticated, userInfo, err := h.authService.IsAuthenticated(userID)
+	telegramID, err := h.authService.GetAuthenticatedTelegramID(h.getClientID(c))
+	if err != nil {
+		// 客户端没有可用的当前账号
+		Success(c, map[string]interface{}{
+			"authenticated": false,
+			"user":          nil,
+		})
+		return
+	}
+
+	authenticated, userInfo, err := h.authService.IsAuthenticated(strconv.FormatInt(telegramID, 10))
 	if err != nil {
 		Error(c, http.StatusInternalServerError, fmt.Errorf("check authentication: %v", err))
 		return
@@ -48,19 +62,20 @@ func (h *AuthHandler) GetStatus(c *gin.Context) {
 	})
 }
 
+// QRLoginRequest 二维码登录请求
+type QRLoginRequest struct {
+	Proxy string `json:"proxy"` // 登录使用的代理，为空时直连
+}
+
 // StartQRLogin 开始二维码登录
 func (h *AuthHandler) StartQRLogin(c *gin.Context) {
-	var req struct {
-		Proxy string `json:"proxy"`
-	}
+	var req QRLoginRequest
 
 	// 绑定JSON请求，但代理是可选的，所以即使失败也继续
 	c.ShouldBindJSON(&req)
 
-	// 使用客户端IP作为sessionID，保持一致性
-	sessionID := h.getClientID(c)
-	
-	session, err := h.authService.StartQRLogin(sessionID, req.Proxy)
+	// 每次登录使用随机的会话ID，会话归属于发起登录的浏览器
+	session, err := h.authService.StartQRLogin(h.getClientID(c), req.Proxy)
 	if err != nil {
 		Error(c, http.StatusInternalServerError, fmt.Errorf("start qr login: %v", err))
 		return
@@ -70,7 +85,7 @@ func (h *AuthHandler) StartQRLogin(c *gin.Context) {
 	go h.monitorSessionStatus(session)
 
 	Success(c, map[string]interface{}{
-		"session_id": sessionID,
+		"session_id": session.ID,
 		"status":     session.Status,
 	})
 }
@@ -86,8 +101,12 @@ func (h *AuthHandler) GetQRCode(c *gin.Context) {
 	sizeStr := c.DefaultQuery("size", "256")
 	size, _ := strconv.Atoi(sizeStr)
 
-	qrData, err := h.authService.GetQRCode(sessionID, size)
+	qrData, err := h.authService.GetQRCode(h.getClientID(c), sessionID, size)
 	if err != nil {
+		if errors.Is(err, service.ErrLoginSessionNotFound) {
+			NotFoundError(c, "Session not found")
+			return
+		}
 		Error(c, http.StatusInternalServerError, fmt.Errorf("get qr code: %v", err))
 		return
 	}
@@ -105,7 +124,7 @@ func (h *AuthHandler) CheckQRStatus(c *gin.Context) {
 		return
 	}
 
-	session, err := h.authService.GetSession(sessionID)
+	session, err := h.authService.GetSession(h.getClientID(c), sessionID)
 	if err != nil {
 		Error(c, http.StatusNotFound, errors.New("session not found"))
 		return
@@ -128,22 +147,23 @@ func (h *AuthHandler) CheckQRStatus(c *gin.Context) {
 	Success(c, result)
 }
 
+// CodeLoginRequest 验证码登录请求
+type CodeLoginRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Proxy string `json:"proxy"`
+}
+
 // StartCodeLogin 开始验证码登录
 func (h *AuthHandler) StartCodeLogin(c *gin.Context) {
-	var req struct {
-		Phone string `json:"phone" binding:"required"`
-		Proxy string `json:"proxy"`
-	}
+	var req CodeLoginRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		ValidationError(c, err.Error())
 		return
 	}
 
-	// 使用客户端IP作为sessionID，保持一致性
-	sessionID := h.getClientID(c)
-
-	session, err := h.authService.StartCodeLogin(sessionID, req.Phone, req.Proxy)
+	// 每次登录使用随机的会话ID，会话归属于发起登录的浏览器
+	session, err := h.authService.StartCodeLogin(h.getClientID(c), req.Phone, req.Proxy)
 	if err != nil {
 		Error(c, http.StatusInternalServerError, fmt.Errorf("start code login: %v", err))
 		return
@@ -153,26 +173,33 @@ func (h *AuthHandler) StartCodeLogin(c *gin.Context) {
 	go h.monitorSessionStatus(session)
 
 	Success(c, map[string]interface{}{
-		"session_id": sessionID,
+		"session_id": session.ID,
 		"status":     session.Status,
 		"phone":      req.Phone,
 	})
 }
 
+// VerifyCodeRequest 提交验证码
+type VerifyCodeRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
 // VerifyCode 验证登录码
 func (h *AuthHandler) VerifyCode(c *gin.Context) {
-	var req struct {
-		SessionID string `json:"session_id" binding:"required"`
-		Code      string `json:"code" binding:"required"`
-	}
+	var req VerifyCodeRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		ValidationError(c, err.Error())
 		return
 	}
 
-	err := h.authService.VerifyCode(req.SessionID, req.Code)
+	err := h.authService.VerifyCode(h.getClientID(c), req.SessionID, req.Code)
 	if err != nil {
+		if errors.Is(err, service.ErrLoginSessionNotFound) {
+			NotFoundError(c, "Session not found")
+			return
+		}
 		Error(c, http.StatusInternalServerError, fmt.Errorf("verify code: %v", err))
 		return
 	}
@@ -180,20 +207,27 @@ func (h *AuthHandler) VerifyCode(c *gin.Context) {
 	SuccessWithMessage(c, nil, "Code verification started")
 }
 
+// VerifyPasswordRequest 提交2FA密码
+type VerifyPasswordRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+}
+
 // VerifyPassword 验证2FA密码
 func (h *AuthHandler) VerifyPassword(c *gin.Context) {
-	var req struct {
-		SessionID string `json:"session_id" binding:"required"`
-		Password  string `json:"password" binding:"required"`
-	}
+	var req VerifyPasswordRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		ValidationError(c, err.Error())
 		return
 	}
 
-	err := h.authService.VerifyPassword(req.SessionID, req.Password)
+	err := h.authService.VerifyPassword(h.getClientID(c), req.SessionID, req.Password)
 	if err != nil {
+		if errors.Is(err, service.ErrLoginSessionNotFound) {
+			NotFoundError(c, "Session not found")
+			return
+		}
 		Error(c, http.StatusInternalServerError, fmt.Errorf("verify password: %v", err))
 		return
 	}
@@ -201,52 +235,178 @@ func (h *AuthHandler) VerifyPassword(c *gin.Context) {
 	SuccessWithMessage(c, nil, "Password verification started")
 }
 
-// Logout 退出登录
+// Logout 退出当前账号，其他已登录的账号保持登录
 func (h *AuthHandler) Logout(c *gin.Context) {
-	userID := h.getUserID(c)
-	
-	err := h.authService.Logout(userID)
+	clientID := h.getClientID(c)
+
+	telegramID, err := h.authService.GetAuthenticatedTelegramID(clientID)
+	if err != nil {
+		SuccessWithMessage(c, nil, "Logged out successfully")
+		return
+	}
+
+	loggedOut, err := h.authService.RemoveAccount(clientID, telegramID)
 	if err != nil {
 		Error(c, http.StatusInternalServerError, fmt.Errorf("logout: %v", err))
 		return
 	}
+	if loggedOut {
+		h.clients.Stop(telegramID)
+	}
 
 	SuccessWithMessage(c, nil, "Logged out successfully")
 }
 
-// getUserID 获取用户ID，优先使用Telegram ID，回退到安全的客户端IP
-func (h *AuthHandler) getUserID(c *gin.Context) string {
-	// 获取安全的客户端标识符
+// AccountInfo 已登录的Telegram账号
+type AccountInfo struct {
+	service.UserInfo
+	Active bool `json:"active"` // 是否为当前账号
+}
+
+// ListAccounts 列出当前浏览器可以使用的已登录Telegram账号
+func (h *AuthHandler) ListAccounts(c *gin.Context) {
 	clientID := h.getClientID(c)
-	// 尝试获取Telegram ID
-	telegramID, err := h.authService.GetAuthenticatedTelegramID(clientID)
-	if err == nil {
-		return fmt.Sprintf("%d", telegramID)
+
+	accounts, err := h.authService.ListAccounts(clientID)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, fmt.Errorf("list accounts: %v", err))
+		return
 	}
-	
-	// 回退到使用安全的客户端IP
-	return clientID
+
+	active, _ := h.authService.GetAuthenticatedTelegramID(clientID)
+
+	infos := make([]AccountInfo, 0, len(accounts))
+	for _, account := range accounts {
+		infos = append(infos, AccountInfo{
+			UserInfo: account,
+			Active:   account.ID == active,
+		})
+	}
+
+	Success(c, map[string]interface{}{
+		"accounts": infos,
+		"active":   active,
+		"total":    len(infos),
+	})
 }
 
-// getClientID 获取客户端ID，与ChatHandler保持一致的识别机制
+// SwitchAccount 切换当前账号，之后未指定account_id的请求和任务使用该账号
+func (h *AuthHandler) SwitchAccount(c *gin.Context) {
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		ValidationError(c, "invalid account id")
+		return
+	}
+
+	if err := h.authService.SwitchAccount(h.getClientID(c), accountID); err != nil {
+		NotFoundError(c, fmt.Sprintf("Account not available: %v", err))
+		return
+	}
+
+	SuccessWithMessage(c, map[string]int64{
+		"active": accountID,
+	}, "Account switched")
+}
+
+// RemoveAccount 从当前浏览器移除账号，账号不属于其他浏览器时登出并删除其会话
+func (h *AuthHandler) RemoveAccount(c *gin.Context) {
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		ValidationError(c, "invalid account id")
+		return
+	}
+
+	loggedOut, err := h.authService.RemoveAccount(h.getClientID(c), accountID)
+	if err != nil {
+		if errors.Is(err, service.ErrNotAuthenticated) {
+			NotFoundError(c, "Account not found")
+			return
+		}
+		Error(c, http.StatusInternalServerError, fmt.Errorf("remove account: %v", err))
+		return
+	}
+	if loggedOut {
+		h.clients.Stop(accountID)
+	}
+
+	SuccessWithMessage(c, nil, "Account removed")
+}
+
+// ListCLINamespaces 列出可以导入的CLI命名空间
+func (h *AuthHandler) ListCLINamespaces(c *gin.Context) {
+	if h.cliStorage == nil {
+		Error(c, http.StatusNotImplemented, errors.New("cli storage is not available"))
+		return
+	}
+
+	namespaces, err := service.ListCLINamespaces(h.cliStorage, h.shared)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, fmt.Errorf("list cli namespaces: %v", err))
+		return
+	}
+
+	Success(c, map[string]interface{}{
+		"namespaces": namespaces,
+		"shared":     h.shared,
+	})
+}
+
+// ImportAccountRequest 导入CLI命名空间请求
+type ImportAccountRequest struct {
+	Namespace string `json:"namespace" binding:"required"`
+	Proxy     string `json:"proxy"` // 为空时使用全局设置中的代理
+}
+
+// ImportAccount 将CLI命名空间（tdl login -n <namespace>）中已登录的会话导入为Web账号
+func (h *AuthHandler) ImportAccount(c *gin.Context) {
+	var req ImportAccountRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	if h.cliStorage == nil {
+		Error(c, http.StatusNotImplemented, errors.New("cli storage is not available"))
+		return
+	}
+
+	// 未指定代理时使用全局设置中的代理
+	if req.Proxy == "" {
+		if settings, err := NewSettingsHandler(h.ctx, h.kvStore).GetCurrentSettings(); err == nil {
+			req.Proxy = settings.GlobalProxy
+		}
+	}
+
+	userInfo, err := h.authService.ImportNamespace(c.Request.Context(), h.getClientID(c), h.cliStorage, req.Namespace, req.Proxy)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNamespaceLocked):
+			Error(c, http.StatusConflict, fmt.Errorf("%v, stop the tdl command using it and try again", err))
+		case errors.Is(err, service.ErrNamespaceNotLoggedIn):
+			ValidationError(c, err.Error())
+		default:
+			Error(c, http.StatusInternalServerError, fmt.Errorf("import account: %v", err))
+		}
+		return
+	}
+	// 导入的会话替换了账号原有的会话，已有的客户端需要重新连接
+	h.clients.Stop(userInfo.ID)
+
+	SuccessWithMessage(c, map[string]interface{}{
+		"user": userInfo,
+	}, "Account imported")
+}
+
+// getClientID 获取发起请求的浏览器标识，登录会话和当前账号都以浏览器为键，与通过认证的操作员无关
 func (h *AuthHandler) getClientID(c *gin.Context) string {
-	const clientIDCookie = "tdl_client_id"
-	const clientIDHeader = "X-TDL-Client-ID"
-	
-	// 1. 优先从Cookie获取客户端ID
-	if clientID, err := c.Cookie(clientIDCookie); err == nil && clientID != "" {
-		return clientID
-	}
-	
-	// 2. 从Header获取客户端ID
-	if clientID := c.GetHeader(clientIDHeader); clientID != "" {
-		// 设置cookie以便后续请求使用
-		c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-		return clientID
-	}
-	
-	// 3. 回退到IP地址（保持向后兼容）
-	return util.SafeClientID(c.ClientIP())
+	client, _ := middleware.Client(c)
+	return client
+}
+
+// AuthenticateWebSocket 识别WebSocket连接所属的浏览器，该浏览器可以使用的账号的任务事件会推送到该连接
+func (h *AuthHandler) AuthenticateWebSocket(c *gin.Context) (string, error) {
+	return middleware.Client(c)
 }
 
 // monitorSessionStatus 监控会话状态变化并推送WebSocket消息
@@ -260,7 +420,7 @@ func (h *AuthHandler) monitorSessionStatus(session *service.LoginSession) {
 		case <-h.ctx.Done():
 			return
 		case <-ticker.C:
-			currentSession, err := h.authService.GetSession(session.ID)
+			currentSession, err := h.authService.GetSession(session.ClientID, session.ID)
 			if err != nil {
 				return // 会话不存在，停止监控
 			}
@@ -268,10 +428,15 @@ func (h *AuthHandler) monitorSessionStatus(session *service.LoginSession) {
 			// 状态发生变化时推送WebSocket消息
 			if currentSession.Status != lastStatus {
 				// 发送WebSocket更新
-				h.wsHub.BroadcastNotification(fmt.Sprintf("Login status: %s", currentSession.Status), "info")
+				h.wsHub.BroadcastNotification(session.ClientID, fmt.Sprintf("Login status: %s", currentSession.Status), "info")
 				lastStatus = currentSession.Status
 			}
 
+			// 重新登录的账号使用新会话，关闭使用旧会话的客户端
+			if currentSession.Status == service.StatusCompleted && currentSession.UserInfo != nil {
+				h.clients.Stop(currentSession.UserInfo.ID)
+			}
+
 			// 终态时停止监控
 			if currentSession.Status == service.StatusCompleted ||
 				currentSession.Status == service.StatusFailed ||
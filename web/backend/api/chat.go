@@ -2,10 +2,7 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -24,91 +21,73 @@ import (
 	"github.com/iyear/tdl/app/chat"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
-	tclientcore "github.com/iyear/tdl/core/tclient"
 	"github.com/iyear/tdl/core/util/tutil"
 	"github.com/iyear/tdl/pkg/kv"
-	"github.com/iyear/tdl/pkg/tclient"
 	"github.com/iyear/tdl/pkg/texpr"
+	"github.com/iyear/tdl/web/backend/middleware"
 	"github.com/iyear/tdl/web/backend/service"
-	"github.com/iyear/tdl/web/backend/util"
+	"github.com/iyear/tdl/web/backend/websocket"
 )
 
 type ChatHandler struct {
 	ctx         context.Context
 	kvStore     kv.Storage
 	authService *service.AuthService
+	clients     *service.ClientManager
+	dialogs     *service.DialogCache
+	paths       *service.PathPolicy
+	wsHub       *websocket.Hub
+	tasks       *service.TaskRepository
+	scheduler   *service.Scheduler
+	exports     exportTasks // taskID -> ExportTaskInfo (in-memory cache of persisted tasks)
 }
 
-func NewChatHandler(ctx context.Context, kvStore kv.Storage) *ChatHandler {
-	return &ChatHandler{
+func NewChatHandler(ctx context.Context, kvStore kv.Storage, authService *service.AuthService, wsHub *websocket.Hub, clients *service.ClientManager, dialogs *service.DialogCache, paths *service.PathPolicy, tasks *service.TaskRepository, scheduler *service.Scheduler) *ChatHandler {
+	h := &ChatHandler{
 		ctx:         ctx,
 		kvStore:     kvStore,
-		authService: service.NewAuthService(ctx, kvStore),
-	}
-}
-
-// createTelegramClientForUser 为特定用户创建Telegram客户端
-func (h *ChatHandler) createTelegramClientForUser(clientID string) (*telegram.Client, storage.Storage, error) {
-	// 获取Telegram ID
-	telegramID, err := h.authService.GetAuthenticatedTelegramID(clientID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get authenticated telegram id: %w", err)
-	}
-
-	// 使用用户命名空间
-	namespace := fmt.Sprintf("user_%d", telegramID)
-	return h.createTelegramClient(namespace)
-}
-
-// createTelegramClient 创建Telegram客户端，使用与CLI相同的配置
-func (h *ChatHandler) createTelegramClient(namespace string) (*telegram.Client, storage.Storage, error) {
-	// 通过kv.Storage获取storage.Storage实例
-	storageInstance, err := h.kvStore.Open(namespace)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open storage namespace: %w", err)
-	}
-
-	// 获取当前设置
-	settingsHandler := NewSettingsHandler(h.ctx, h.kvStore)
-	settings, err := settingsHandler.GetCurrentSettings()
-	if err != nil {
-		logctx.From(h.ctx).Warn("Failed to load settings, using defaults", zap.Error(err))
-		settings = &Settings{
-			GlobalProxy:      "",
-			ReconnectTimeout: 300,
-		}
-	}
-
-	// 使用与CLI相同的tOptions配置
-	o := tclient.Options{
-		KV:               storageInstance,
-		Proxy:            settings.GlobalProxy, // 从设置中获取代理配置
-		NTP:              "", // NTP配置暂时为空
-		ReconnectTimeout: time.Duration(settings.ReconnectTimeout) * time.Second, // 从设置中获取重连超时
-		UpdateHandler:    nil,
-	}
-
-	// 记录配置信息
-	logctx.From(h.ctx).Info("Creating Telegram client with settings",
-		zap.String("proxy", o.Proxy),
-		zap.Duration("reconnectTimeout", o.ReconnectTimeout))
-
-	// 创建客户端，使用与CLI相同的参数
-	client, err := tclient.New(h.ctx, o, false) // false表示不需要登录，使用已有session
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create telegram client: %w", err)
-	}
-
-	return client, storageInstance, nil
+		authService: authService,
+		clients:     clients,
+		dialogs:     dialogs,
+		paths:       paths,
+		wsHub:       wsHub,
+		tasks:       tasks,
+		scheduler:   scheduler,
+		exports:     exportTasks{tasks: make(map[string]ExportTaskInfo)},
+	}
+
+	h.restoreExportTasks()
+	return h
 }
 
 // ChatListRequest 聊天列表请求
 type ChatListRequest struct {
-	Output string `json:"output,omitempty"` // table 或 json
-	Filter string `json:"filter,omitempty"` // 过滤表达式
-	Page   int    `json:"page,omitempty"`   // 页码，从1开始
-	Limit  int    `json:"limit,omitempty"`  // 每页条数，默认50
-	Search string `json:"search,omitempty"` // 搜索关键词
+	Output  string `json:"output,omitempty" form:"output"`   // table 或 json
+	Filter  string `json:"filter,omitempty" form:"filter"`   // 过滤表达式
+	Page    int    `json:"page,omitempty" form:"page"`       // 页码，从1开始
+	Limit   int    `json:"limit,omitempty" form:"limit"`     // 每页条数，默认50
+	Search  string `json:"search,omitempty" form:"search"`   // 搜索关键词
+	Sort    string `json:"sort,omitempty" form:"sort"`       // 排序字段：date（默认）、type、name
+	Order   string `json:"order,omitempty" form:"order"`     // asc 或 desc，date默认desc，其他默认asc
+	Refresh bool   `json:"refresh,omitempty" form:"refresh"` // 忽略缓存，重新从Telegram获取
+	// AccountID 使用的Telegram账号，为空时使用当前账号
+	AccountID int64 `json:"account_id,omitempty" form:"account_id"`
+}
+
+// ChatListResponse 聊天列表的一页
+type ChatListResponse struct {
+	Message    string                  `json:"message"`
+	Data       []*service.CachedDialog `json:"data"`
+	Count      int                     `json:"count"`       // 本页条数
+	TotalCount int                     `json:"total_count"` // 过滤和搜索后的总数
+	Page       int                     `json:"page"`
+	Limit      int                     `json:"limit"`
+	TotalPages int                     `json:"total_pages"`
+	HasNext    bool                    `json:"has_next"`
+	HasPrev    bool                    `json:"has_prev"`
+	Sort       string                  `json:"sort"`
+	Order      string                  `json:"order"`
+	CachedAt   time.Time               `json:"cached_at"` // 对话列表从Telegram获取的时间
 }
 
 // ChatExportRequest 消息导出请求
@@ -123,6 +102,8 @@ type ChatExportRequest struct {
 	Raw         bool   `json:"raw,omitempty"`                  // 原始数据
 	All         bool   `json:"all,omitempty"`                  // 所有消息
 	OutputPath  string `json:"output_path,omitempty"`          // 自定义输出路径
+	Priority    int    `json:"priority,omitempty"`             // 排队优先级，数值越大越先执行
+	AccountID   int64  `json:"account_id,omitempty"`           // 执行任务的Telegram账号，为空时使用当前账号
 }
 
 // ChatUsersRequest 用户导出请求
@@ -130,6 +111,8 @@ type ChatUsersRequest struct {
 	Chat       string `json:"chat" binding:"required"` // 聊天ID或域名
 	Raw        bool   `json:"raw,omitempty"`           // 原始数据
 	OutputPath string `json:"output_path,omitempty"`  // 自定义输出路径
+	Priority   int    `json:"priority,omitempty"`     // 排队优先级，数值越大越先执行
+	AccountID  int64  `json:"account_id,omitempty"`   // 执行任务的Telegram账号，为空时使用当前账号
 }
 
 // Dialog 聊天对话结构（模拟数据）
@@ -170,88 +153,77 @@ func (h *ChatHandler) GetChatList(c *gin.Context) {
 	if req.Limit > 200 {
 		req.Limit = 200 // 最大200条，防止过大
 	}
+	if req.Sort == "" {
+		req.Sort = string(service.DialogSortDate)
+	}
+	if req.Order == "" {
+		req.Order = "asc"
+		if req.Sort == string(service.DialogSortDate) {
+			req.Order = "desc" // 最近的对话在前，与Telegram客户端一致
+		}
+	}
+	if req.Order != "asc" && req.Order != "desc" {
+		ValidationError(c, fmt.Sprintf("Invalid order: %s", req.Order))
+		return
+	}
+
+	// 过滤在缓存的完整列表上执行，表达式错误不需要连接Telegram
+	filter, err := expr.Compile(req.Filter, expr.AsBool())
+	if err != nil {
+		ExpressionError(c, fmt.Sprintf("Invalid filter: %v", err))
+		return
+	}
 
 	// 优化客户端识别机制：优先使用session，回退到IP
-	clientID, err := h.getOrCreateClientID(c)
+	clientID, err := middleware.Client(c)
 	if err != nil {
 		logctx.From(h.ctx).Error("Failed to get client ID", zap.Error(err))
 		InternalServerError(c, "Failed to identify client")
 		return
 	}
 	
-	client, storageInstance, err := h.createTelegramClientForUser(clientID)
+	// 确定请求使用的账号，未登录时返回401
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
 	if err != nil {
-		logctx.From(h.ctx).Error("Failed to create telegram client", zap.Error(err))
-		
-		// 检查是否是认证相关错误
-		errorMsg := err.Error()
-		if strings.Contains(strings.ToLower(errorMsg), "not authenticated") ||
-		   strings.Contains(strings.ToLower(errorMsg), "client not authenticated") ||
-		   strings.Contains(strings.ToLower(errorMsg), "telegram user not authenticated") {
-			c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"success": false,
-				"error":   "Not authorized. Please login to Telegram first",
-				"code":    "UNAUTHORIZED",
-			})
-			return
-		}
-		
-		InternalServerError(c, "Failed to connect to Telegram")
+		AccountError(c, err)
 		return
 	}
 
-	// 收集对话数据
-	var dialogs []*chat.Dialog
-
-	// 使用 RunWithAuth 确保用户已认证
-	err = tclientcore.RunWithAuth(h.ctx, client, func(ctx context.Context) error {
-		// 解析输出类型
-		var outputType chat.ListOutput
-		switch req.Output {
-		case "json":
-			outputType = chat.ListOutputJson
-		case "table":
-			outputType = chat.ListOutputTable
-		default:
-			outputType = chat.ListOutputJson
-		}
-
-		// 创建选项
-		opts := chat.ListOptions{
-			Output: outputType,
-			Filter: req.Filter,
-		}
-
-		// 收集结果而不是直接输出
-		return h.collectDialogsList(ctx, client, storageInstance, opts, &dialogs)
+	// 缓存有效时直接使用，否则使用账号的长连接客户端重新获取
+	list, err := h.dialogs.Load(c.Request.Context(), accountID, req.Refresh, func(ctx context.Context) ([]*service.CachedDialog, error) {
+		var dialogs []*service.CachedDialog
+		err := h.clients.Run(ctx, clientID, accountID, func(ctx context.Context, acc *service.AccountClient) error {
+			var err error
+			dialogs, err = h.getDialogsData(ctx, acc.Client, acc.KV)
+			return err
+		})
+		return dialogs, err
 	})
 
 	if err != nil {
-		logctx.From(h.ctx).Error("Failed to get chat list", zap.Error(err), zap.String("error_message", err.Error()))
-		
-		// 检查是否是认证相关错误 - 使用更宽泛的检查
-		errorMsg := err.Error()
-		if errorMsg == "not authorized. please login first" ||
-		   strings.Contains(strings.ToLower(errorMsg), "not authorized") ||
-		   strings.Contains(strings.ToLower(errorMsg), "unauthorized") ||
-		   strings.Contains(strings.ToLower(errorMsg), "not logged in") ||
-		   strings.Contains(strings.ToLower(errorMsg), "login") ||
-		   strings.Contains(strings.ToLower(errorMsg), "auth") {
-			logctx.From(h.ctx).Info("Authentication required for chat list", zap.String("error", errorMsg))
-			c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"success": false,
-				"error":   "Not authorized. Please login to Telegram first",
-				"code":    "UNAUTHORIZED",
-			})
+		// 未登录、限流等错误由InternalError映射为对应的错误类型
+		InternalError(c, "Failed to retrieve chat list", err)
+		return
+	}
+
+	// 应用过滤表达式和搜索
+	filteredDialogs := make([]*service.CachedDialog, 0, len(list.Dialogs))
+	for _, d := range list.Dialogs {
+		matched, err := texpr.Run(filter, &d.Dialog)
+		if err != nil {
+			ExpressionError(c, fmt.Sprintf("Failed to run filter: %v", err))
 			return
 		}
-		
-		InternalServerError(c, "Failed to retrieve chat list")
-		return
+		if matched.(bool) {
+			filteredDialogs = append(filteredDialogs, d)
+		}
 	}
+	filteredDialogs = h.applySearchFilter(filteredDialogs, req.Search)
 
-	// 应用搜索过滤
-	filteredDialogs := h.applySearchFilter(dialogs, req.Search)
+	if err = service.SortDialogs(filteredDialogs, service.DialogSort(req.Sort), req.Order == "desc"); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
 	
 	// 计算分页
 	totalCount := len(filteredDialogs)
@@ -261,7 +233,7 @@ func (h *ChatHandler) GetChatList(c *gin.Context) {
 	start := (req.Page - 1) * req.Limit
 	end := start + req.Limit
 	if start >= totalCount {
-		filteredDialogs = []*chat.Dialog{}
+		filteredDialogs = []*service.CachedDialog{}
 	} else {
 		if end > totalCount {
 			end = totalCount
@@ -269,41 +241,28 @@ func (h *ChatHandler) GetChatList(c *gin.Context) {
 		filteredDialogs = filteredDialogs[start:end]
 	}
 
-	Success(c, map[string]interface{}{
-		"message":     "Chat list retrieved successfully",
-		"data":        filteredDialogs,
-		"count":       len(filteredDialogs),
-		"total_count": totalCount,
-		"page":        req.Page,
-		"limit":       req.Limit,
-		"total_pages": totalPages,
-		"has_next":    req.Page < totalPages,
-		"has_prev":    req.Page > 1,
+	Success(c, ChatListResponse{
+		Message:    "Chat list retrieved successfully",
+		Data:       filteredDialogs,
+		Count:      len(filteredDialogs),
+		TotalCount: totalCount,
+		Page:       req.Page,
+		Limit:      req.Limit,
+		TotalPages: totalPages,
+		HasNext:    req.Page < totalPages,
+		HasPrev:    req.Page > 1,
+		Sort:       req.Sort,
+		Order:      req.Order,
+		CachedAt:   list.UpdatedAt,
 	})
 }
 
-// collectDialogsList 收集对话列表数据，基于app/chat.List的实现但返回数据而不是输出
-func (h *ChatHandler) collectDialogsList(ctx context.Context, c *telegram.Client, storageInstance storage.Storage, opts chat.ListOptions, result *[]*chat.Dialog) error {
-	// 创建临时的结果收集器，而不是直接输出
-	dialogs, err := h.getDialogsData(ctx, c, storageInstance, opts)
-	if err != nil {
-		return err
-	}
-
-	*result = dialogs
-	return nil
-}
-
-// getDialogsData 获取对话数据，基于 app/chat/ls.go 的核心实现
-func (h *ChatHandler) getDialogsData(ctx context.Context, c *telegram.Client, storageInstance storage.Storage, opts chat.ListOptions) ([]*chat.Dialog, error) {
+// getDialogsData 获取完整的对话列表，基于 app/chat/ls.go 的核心实现
+//
+// 过滤表达式不在这里执行，结果会被缓存并用于不同的过滤条件
+func (h *ChatHandler) getDialogsData(ctx context.Context, c *telegram.Client, storageInstance storage.Storage) ([]*service.CachedDialog, error) {
 	log := logctx.From(ctx)
 
-	// compile filter
-	filter, err := expr.Compile(opts.Filter, expr.AsBool())
-	if err != nil {
-		return nil, fmt.Errorf("failed to compile filter: %w", err)
-	}
-
 	dialogs, err := query.GetDialogs(c.API()).BatchSize(100).Collect(ctx)
 	if err != nil {
 		return nil, err
@@ -315,7 +274,7 @@ func (h *ChatHandler) getDialogsData(ctx context.Context, c *telegram.Client, st
 	}
 
 	manager := peers.Options{Storage: storage.NewPeers(storageInstance)}.Build(c.API())
-	result := make([]*chat.Dialog, 0, len(dialogs))
+	result := make([]*service.CachedDialog, 0, len(dialogs))
 	
 	for _, d := range dialogs {
 		id := tutil.GetInputPeerID(d.Peer)
@@ -330,14 +289,17 @@ func (h *ChatHandler) getDialogsData(ctx context.Context, c *telegram.Client, st
 			continue
 		}
 
-		var r *chat.Dialog
+		var (
+			r    *chat.Dialog
+			kind string
+		)
 		switch t := d.Peer.(type) {
 		case *tg.InputPeerUser:
-			r = h.processUser(t.UserID, d.Entities)
+			r, kind = h.processUser(t.UserID, d.Entities), service.DialogKindUser
 		case *tg.InputPeerChannel:
-			r = h.processChannel(ctx, c.API(), t.ChannelID, d.Entities)
+			r, kind = h.processChannel(ctx, c.API(), t.ChannelID, d.Entities), service.DialogKindChannel
 		case *tg.InputPeerChat:
-			r = h.processChat(t.ChatID, d.Entities)
+			r, kind = h.processChat(t.ChatID, d.Entities), service.DialogKindChat
 		}
 
 		// skip unsupported types
@@ -345,16 +307,11 @@ func (h *ChatHandler) getDialogsData(ctx context.Context, c *telegram.Client, st
 			continue
 		}
 
-		// filter
-		b, err := texpr.Run(filter, r)
-		if err != nil {
-			return nil, fmt.Errorf("failed to run filter: %w", err)
-		}
-		if !b.(bool) {
-			continue
+		cd := &service.CachedDialog{Dialog: *r, Kind: kind}
+		if d.Last != nil {
+			cd.TopMessage, cd.LastMessageDate = d.Last.GetID(), d.Last.GetDate()
 		}
-
-		result = append(result, r)
+		result = append(result, cd)
 	}
 
 	return result, nil
@@ -536,37 +493,30 @@ func (h *ChatHandler) ExportChatMessages(c *gin.Context) {
 	}
 
 	// 优化客户端识别机制：优先使用session，回退到IP
-	clientID, err := h.getOrCreateClientID(c)
+	clientID, err := middleware.Client(c)
 	if err != nil {
 		logctx.From(h.ctx).Error("Failed to get client ID", zap.Error(err))
 		InternalServerError(c, "Failed to identify client")
 		return
 	}
 	
-	client, storageInstance, err := h.createTelegramClientForUser(clientID)
+	// 提前确定账号，任务执行时使用同一个账号的客户端
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
 	if err != nil {
-		logctx.From(h.ctx).Error("Failed to create telegram client", zap.Error(err))
-		
-		// 检查是否是认证相关错误
-		errorMsg := err.Error()
-		if strings.Contains(strings.ToLower(errorMsg), "not authenticated") ||
-		   strings.Contains(strings.ToLower(errorMsg), "client not authenticated") ||
-		   strings.Contains(strings.ToLower(errorMsg), "telegram user not authenticated") {
-			c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"success": false,
-				"error":   "Not authorized. Please login to Telegram first",
-				"code":    "UNAUTHORIZED",
-			})
-			return
-		}
-		
-		InternalServerError(c, "Failed to connect to Telegram")
+		AccountError(c, err)
+		return
+	}
+
+	// 输出目录必须在允许的导出根目录中，未指定时使用默认目录
+	outputDir, ok := resolvePath(c, h.paths.Export, req.OutputPath)
+	if !ok {
 		return
 	}
 
 	// 生成输出文件路径
-	defaultFilename := fmt.Sprintf("tdl-export-%d.json", time.Now().Unix())
-	outputFile, err := h.createOutputPath(req.OutputPath, defaultFilename)
+	taskID := newTaskID(exportTypeMessages)
+	defaultFilename := fmt.Sprintf("tdl-%s.json", taskID)
+	outputFile, err := h.createOutputPath(outputDir, defaultFilename)
 	if err != nil {
 		logctx.From(h.ctx).Error("Failed to create output path", zap.Error(err))
 		InternalServerError(c, "Failed to create output directory")
@@ -603,24 +553,27 @@ func (h *ChatHandler) ExportChatMessages(c *gin.Context) {
 		All:         req.All,
 	}
 
-	// 异步执行导出任务
-	taskID := fmt.Sprintf("export_%d", time.Now().Unix())
-	go func() {
-		err := tclientcore.RunWithAuth(h.ctx, client, func(ctx context.Context) error {
-			return chat.Export(ctx, client, storageInstance, exportOpts)
-		})
-		if err != nil {
-			logctx.From(h.ctx).Error("Export task failed", 
-				zap.String("task_id", taskID), 
-				zap.Error(err))
-			// TODO: 通过WebSocket通知前端任务失败
-		} else {
-			logctx.From(h.ctx).Info("Export task completed", 
-				zap.String("task_id", taskID),
-				zap.String("output_file", outputFile))
-			// TODO: 通过WebSocket通知前端任务完成
-		}
-	}()
+	// 提交到调度器排队执行，进度和结果通过任务接口和WebSocket获取
+	if err := h.runExport(ExportTaskInfo{
+		ID:         taskID,
+		Type:       exportTypeMessages,
+		Name:       fmt.Sprintf("导出消息: %s", chatName(req.Chat)),
+		Status:     "queued",
+		Chat:       req.Chat,
+		OutputFile: outputFile,
+		CreatedAt:  time.Now(),
+		Config: map[string]interface{}{
+			"export_config": req,
+		},
+		ClientID:  clientID,
+		AccountID: accountID,
+	}, req.Priority, func(ctx context.Context, acc *service.AccountClient, progress func(count int64)) error {
+		exportOpts.Progress = progress
+		return chat.Export(ctx, acc.Client, acc.KV, exportOpts)
+	}); err != nil {
+		taskError(c, "Failed to queue task", err)
+		return
+	}
 
 	Success(c, map[string]interface{}{
 		"message":     "Export job submitted successfully",
@@ -628,7 +581,7 @@ func (h *ChatHandler) ExportChatMessages(c *gin.Context) {
 		"output_file": outputFile,
 		"type":        req.Type,
 		"chat":        req.Chat,
-		"status":      "pending",
+		"status":      "queued",
 	})
 }
 
@@ -641,37 +594,30 @@ func (h *ChatHandler) ExportChatUsers(c *gin.Context) {
 	}
 
 	// 优化客户端识别机制：优先使用session，回退到IP
-	clientID, err := h.getOrCreateClientID(c)
+	clientID, err := middleware.Client(c)
 	if err != nil {
 		logctx.From(h.ctx).Error("Failed to get client ID", zap.Error(err))
 		InternalServerError(c, "Failed to identify client")
 		return
 	}
 	
-	client, storageInstance, err := h.createTelegramClientForUser(clientID)
+	// 提前确定账号，任务执行时使用同一个账号的客户端
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
 	if err != nil {
-		logctx.From(h.ctx).Error("Failed to create telegram client", zap.Error(err))
-		
-		// 检查是否是认证相关错误
-		errorMsg := err.Error()
-		if strings.Contains(strings.ToLower(errorMsg), "not authenticated") ||
-		   strings.Contains(strings.ToLower(errorMsg), "client not authenticated") ||
-		   strings.Contains(strings.ToLower(errorMsg), "telegram user not authenticated") {
-			c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"success": false,
-				"error":   "Not authorized. Please login to Telegram first",
-				"code":    "UNAUTHORIZED",
-			})
-			return
-		}
-		
-		InternalServerError(c, "Failed to connect to Telegram")
+		AccountError(c, err)
+		return
+	}
+
+	// 输出目录必须在允许的导出根目录中，未指定时使用默认目录
+	outputDir, ok := resolvePath(c, h.paths.Export, req.OutputPath)
+	if !ok {
 		return
 	}
 
 	// 生成输出文件路径
-	defaultFilename := fmt.Sprintf("tdl-users-%d.json", time.Now().Unix())
-	outputFile, err := h.createOutputPath(req.OutputPath, defaultFilename)
+	taskID := newTaskID(exportTypeUsers)
+	defaultFilename := fmt.Sprintf("tdl-%s.json", taskID)
+	outputFile, err := h.createOutputPath(outputDir, defaultFilename)
 	if err != nil {
 		logctx.From(h.ctx).Error("Failed to create output path", zap.Error(err))
 		InternalServerError(c, "Failed to create output directory")
@@ -685,42 +631,45 @@ func (h *ChatHandler) ExportChatUsers(c *gin.Context) {
 		Raw:    req.Raw,
 	}
 
-	// 异步执行导出任务
-	taskID := fmt.Sprintf("users_%d", time.Now().Unix())
-	go func() {
-		err := tclientcore.RunWithAuth(h.ctx, client, func(ctx context.Context) error {
-			return chat.Users(ctx, client, storageInstance, usersOpts)
-		})
-		if err != nil {
-			logctx.From(h.ctx).Error("Users export task failed", 
-				zap.String("task_id", taskID), 
-				zap.Error(err))
-			// TODO: 通过WebSocket通知前端任务失败
-		} else {
-			logctx.From(h.ctx).Info("Users export task completed", 
-				zap.String("task_id", taskID),
-				zap.String("output_file", outputFile))
-			// TODO: 通过WebSocket通知前端任务完成
-		}
-	}()
+	// 提交到调度器排队执行，进度和结果通过任务接口和WebSocket获取
+	if err := h.runExport(ExportTaskInfo{
+		ID:         taskID,
+		Type:       exportTypeUsers,
+		Name:       fmt.Sprintf("导出成员: %s", req.Chat),
+		Status:     "queued",
+		Chat:       req.Chat,
+		OutputFile: outputFile,
+		CreatedAt:  time.Now(),
+		Config: map[string]interface{}{
+			"users_config": req,
+		},
+		ClientID:  clientID,
+		AccountID: accountID,
+	}, req.Priority, func(ctx context.Context, acc *service.AccountClient, progress func(count int64)) error {
+		usersOpts.Progress = progress
+		return chat.Users(ctx, acc.Client, acc.KV, usersOpts)
+	}); err != nil {
+		taskError(c, "Failed to queue task", err)
+		return
+	}
 
 	Success(c, map[string]interface{}{
 		"message":     "Users export job submitted successfully",
 		"task_id":     taskID,
 		"output_file": outputFile,
 		"chat":        req.Chat,
-		"status":      "pending",
+		"status":      "queued",
 	})
 }
 
 // applySearchFilter 应用搜索过滤
-func (h *ChatHandler) applySearchFilter(dialogs []*chat.Dialog, search string) []*chat.Dialog {
+func (h *ChatHandler) applySearchFilter(dialogs []*service.CachedDialog, search string) []*service.CachedDialog {
 	if search == "" {
 		return dialogs
 	}
 	
 	search = strings.ToLower(strings.TrimSpace(search))
-	filtered := make([]*chat.Dialog, 0)
+	filtered := make([]*service.CachedDialog, 0)
 	
 	for _, dialog := range dialogs {
 		// 搜索名称、用户名、类型
@@ -746,99 +695,25 @@ func (h *ChatHandler) applySearchFilter(dialogs []*chat.Dialog, search string) [
 	return filtered
 }
 
-// getDefaultDownloadPath 获取默认下载路径
-func (h *ChatHandler) getDefaultDownloadPath() string {
-	if runtime.GOOS == "windows" {
-		// Windows系统，尝试优先使用Download文件夹
-		if userProfile := os.Getenv("USERPROFILE"); userProfile != "" {
-			downloadPath := filepath.Join(userProfile, "Downloads")
-			if info, err := os.Stat(downloadPath); err == nil && info.IsDir() {
-				return downloadPath
-			}
-		}
-		// 如果找不到Download文件夹，使用用户主目录
-		if userProfile := os.Getenv("USERPROFILE"); userProfile != "" {
-			return userProfile
-		}
-	} else {
-		// Linux/macOS系统，尝试优先使用Download文件夹
-		if homeDir := os.Getenv("HOME"); homeDir != "" {
-			downloadPath := filepath.Join(homeDir, "Downloads")
-			if info, err := os.Stat(downloadPath); err == nil && info.IsDir() {
-				return downloadPath
-			}
-			// 如果找不到Download文件夹，使用用户主目录
-			return homeDir
-		}
-	}
-	// 如果都找不到，使用系统临时目录
-	return os.TempDir()
-}
-
-// createOutputPath 创建输出路径，如果路径不存在则创建
-func (h *ChatHandler) createOutputPath(customPath, defaultFilename string) (string, error) {
-	var basePath string
-	if customPath != "" {
-		// 使用用户指定的路径
-		basePath = customPath
-	} else {
-		// 使用默认路径
-		basePath = h.getDefaultDownloadPath()
-	}
-
+// createOutputPath 创建输出目录，dir应为沙箱解析后的路径
+func (h *ChatHandler) createOutputPath(dir, defaultFilename string) (string, error) {
 	// 确保目录存在
-	if err := os.MkdirAll(basePath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory %s: %w", basePath, err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
 	// 生成完整的文件路径
-	return filepath.Join(basePath, defaultFilename), nil
-}
-
-// getOrCreateClientID 优化的客户端识别机制
-func (h *ChatHandler) getOrCreateClientID(c *gin.Context) (string, error) {
-	const clientIDCookie = "tdl_client_id"
-	const clientIDHeader = "X-TDL-Client-ID"
-	
-	// 1. 优先从Cookie获取客户端ID
-	if clientID, err := c.Cookie(clientIDCookie); err == nil && clientID != "" {
-		return clientID, nil
-	}
-	
-	// 2. 从Header获取客户端ID
-	if clientID := c.GetHeader(clientIDHeader); clientID != "" {
-		// 设置cookie以便后续请求使用
-		c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-		return clientID, nil
-	}
-	
-	// 3. 生成新的客户端ID
-	clientID, err := h.generateClientID()
-	if err != nil {
-		// 如果生成失败，回退到IP地址
-		logctx.From(h.ctx).Warn("Failed to generate client ID, fallback to IP", zap.Error(err))
-		return util.SafeClientID(c.ClientIP()), nil
-	}
-	
-	// 4. 设置cookie并返回
-	c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-	return clientID, nil
-}
-
-// generateClientID 生成唯一的客户端ID
-func (h *ChatHandler) generateClientID() (string, error) {
-	bytes := make([]byte, 16)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", fmt.Errorf("generate random bytes: %w", err)
-	}
-	return "client_" + hex.EncodeToString(bytes), nil
+	return filepath.Join(dir, defaultFilename), nil
 }
 
 // GetDefaultDownloadPath 获取默认下载路径
 func (h *ChatHandler) GetDefaultDownloadPath(c *gin.Context) {
-	defaultPath := h.getDefaultDownloadPath()
 	Success(c, map[string]interface{}{
-		"default_path": defaultPath,
+		"default_path": h.paths.Download.Default(),
 		"platform":    runtime.GOOS,
+		// 客户端可以使用的根目录，其他路径会被拒绝
+		"download_roots": h.paths.Download.Roots(),
+		"export_roots":   h.paths.Export.Roots(),
+		"upload_roots":   h.paths.Upload.Roots(),
 	})
 }
\ No newline at end of file
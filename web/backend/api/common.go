@@ -1,12 +1,20 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/expr-lang/expr/file"
 	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tgerr"
 	"go.uber.org/zap"
 
 	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/tclient"
+	"github.com/iyear/tdl/web/backend/service"
 )
 
 // 统一API响应格式
@@ -15,6 +23,162 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 	Message string      `json:"message,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	// Code 错误类型，客户端根据它区分错误，而不是解析错误信息
+	Code ErrorCode `json:"code,omitempty"`
+	// RetryAfter 触发Telegram限流时需要等待的秒数，同时设置Retry-After响应头
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// ErrorCode API错误类型
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"       // 参数错误
+	CodeInvalidExpression    ErrorCode = "invalid_expression"    // 过滤表达式无法编译或执行
+	CodeOperatorUnauthorized ErrorCode = "operator_unauthorized" // 未登录Web服务，见middleware.RequireAuth
+	CodeUnauthorized         ErrorCode = "unauthorized"          // 未登录Telegram或会话已失效
+	CodeForbidden            ErrorCode = "forbidden"
+	CodePathForbidden        ErrorCode = "path_forbidden" // 路径不在允许的根目录中
+	CodeChatForbidden        ErrorCode = "chat_forbidden" // 账号在聊天中没有相应的权限
+	CodeNotFound             ErrorCode = "not_found"
+	CodePeerNotFound         ErrorCode = "peer_not_found" // 聊天或用户不存在，或账号无法访问
+	CodeMessageNotFound      ErrorCode = "message_not_found"
+	CodeConflict             ErrorCode = "conflict"
+	CodeFloodWait            ErrorCode = "flood_wait"     // Telegram限流，retry_after秒后重试
+	CodeTelegram             ErrorCode = "telegram_error" // 其他Telegram RPC错误
+	CodeUnavailable          ErrorCode = "unavailable"    // 功能不可用或服务正在关闭
+	CodeTimeout              ErrorCode = "timeout"
+	CodeInternal             ErrorCode = "internal"
+)
+
+// ErrorCodes 所有错误类型，用于生成OpenAPI文档
+var ErrorCodes = []ErrorCode{
+	CodeInvalidRequest, CodeInvalidExpression, CodeOperatorUnauthorized, CodeUnauthorized,
+	CodeForbidden, CodePathForbidden, CodeChatForbidden, CodeNotFound, CodePeerNotFound,
+	CodeMessageNotFound, CodeConflict, CodeFloodWait, CodeTelegram, CodeUnavailable,
+	CodeTimeout, CodeInternal,
+}
+
+// APIError 带类型的错误，handler可以直接返回给Error或InternalError
+type APIError struct {
+	Status     int
+	Code       ErrorCode
+	Message    string
+	RetryAfter int
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Telegram RPC错误类型到API错误类型的映射
+var (
+	tgUnauthorized = []string{
+		"AUTH_KEY_UNREGISTERED", "AUTH_KEY_INVALID", "AUTH_KEY_PERM_EMPTY",
+		"SESSION_REVOKED", "SESSION_EXPIRED", "USER_DEACTIVATED", "USER_DEACTIVATED_BAN",
+	}
+	tgPeerNotFound = []string{
+		"PEER_ID_INVALID", "USERNAME_NOT_OCCUPIED", "USERNAME_INVALID", "CHANNEL_INVALID",
+		"CHANNEL_PRIVATE", "CHAT_ID_INVALID", "USER_ID_INVALID", "INVITE_HASH_INVALID",
+		"INVITE_HASH_EXPIRED",
+	}
+	tgMessageNotFound = []string{
+		"MSG_ID_INVALID", "MESSAGE_ID_INVALID", "MESSAGE_IDS_EMPTY",
+	}
+	tgChatForbidden = []string{
+		"CHAT_ADMIN_REQUIRED", "CHAT_WRITE_FORBIDDEN", "CHAT_FORBIDDEN", "CHAT_RESTRICTED",
+		"USER_BANNED_IN_CHANNEL", "CHAT_SEND_MEDIA_FORBIDDEN", "CHAT_FORWARDS_RESTRICTED",
+	}
+)
+
+// classifyError 将已知的错误映射为API错误，未知错误返回nil
+//
+// Telegram RPC错误只在这里处理，handler不需要解析错误信息
+func classifyError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	if d, ok := tgerr.AsFloodWait(err); ok {
+		seconds := int(d.Seconds())
+		return &APIError{
+			Status:     http.StatusTooManyRequests,
+			Code:       CodeFloodWait,
+			Message:    fmt.Sprintf("Too many requests to Telegram, retry after %d seconds", seconds),
+			RetryAfter: seconds,
+		}
+	}
+
+	if rpcErr, ok := tgerr.As(err); ok {
+		switch {
+		case rpcErr.IsOneOf(tgUnauthorized...):
+			return &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "Telegram session is no longer valid, please login again"}
+		case rpcErr.IsOneOf(tgPeerNotFound...):
+			return &APIError{Status: http.StatusNotFound, Code: CodePeerNotFound, Message: fmt.Sprintf("Chat not found or not accessible (%s)", rpcErr.Type)}
+		case rpcErr.IsOneOf(tgMessageNotFound...):
+			return &APIError{Status: http.StatusNotFound, Code: CodeMessageNotFound, Message: fmt.Sprintf("Message not found (%s)", rpcErr.Type)}
+		case rpcErr.IsOneOf(tgChatForbidden...):
+			return &APIError{Status: http.StatusForbidden, Code: CodeChatForbidden, Message: fmt.Sprintf("Not allowed in this chat (%s)", rpcErr.Type)}
+		default:
+			return &APIError{Status: http.StatusBadGateway, Code: CodeTelegram, Message: fmt.Sprintf("Telegram error: %s", rpcErr.Type)}
+		}
+	}
+
+	var exprErr *file.Error
+	switch {
+	case errors.Is(err, service.ErrNotAuthenticated), errors.Is(err, tclient.ErrNotAuthorized):
+		return &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "Not authorized. Please login to Telegram first"}
+	case errors.Is(err, service.ErrPathForbidden):
+		return &APIError{Status: http.StatusForbidden, Code: CodePathForbidden, Message: err.Error()}
+	case errors.Is(err, service.ErrClientManagerClosed):
+		return &APIError{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: "Server is shutting down"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Status: http.StatusGatewayTimeout, Code: CodeTimeout, Message: "Request to Telegram timed out"}
+	case errors.As(err, &exprErr):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidExpression, Message: exprErr.Error()}
+	}
+
+	return nil
+}
+
+// statusCode HTTP状态码对应的默认错误类型
+func statusCode(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusNotImplemented, http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	default:
+		return CodeInternal
+	}
+}
+
+// writeError 返回带类型的错误，message为空时使用错误本身的描述
+func writeError(c *gin.Context, e *APIError, message string) {
+	if message == "" {
+		message = e.Message
+	}
+	if e.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+
+	c.JSON(e.Status, Response{
+		Success:    false,
+		Error:      message,
+		Code:       e.Code,
+		RetryAfter: e.RetryAfter,
+	})
 }
 
 // 成功响应
@@ -34,51 +198,73 @@ func SuccessWithMessage(c *gin.Context, data interface{}, message string) {
 	})
 }
 
-// 错误响应
+// 错误响应，已知类型的错误（如Telegram限流）使用其对应的状态码
 func Error(c *gin.Context, code int, err error) {
 	logctx.From(c.Request.Context()).Error("API Error", 
 		zap.Error(err))
 	
-	c.JSON(code, Response{
-		Success: false,
-		Error:   err.Error(),
-	})
+	if e := classifyError(err); e != nil {
+		writeError(c, e, "")
+		return
+	}
+	writeError(c, &APIError{Status: code, Code: statusCode(code)}, err.Error())
 }
 
 // 参数验证错误
 func ValidationError(c *gin.Context, message string) {
-	c.JSON(http.StatusBadRequest, Response{
-		Success: false,
-		Error:   message,
-	})
+	writeError(c, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest}, message)
+}
+
+// 表达式错误，用于过滤等texpr表达式
+func ExpressionError(c *gin.Context, message string) {
+	writeError(c, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidExpression}, message)
+}
+
+// 未登录Telegram
+func UnauthorizedError(c *gin.Context) {
+	writeError(c, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized}, "Not authorized. Please login to Telegram first")
+}
+
+// 解析请求使用的账号失败，未登录时返回401
+func AccountError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrNotAuthenticated) {
+		UnauthorizedError(c)
+		return
+	}
+	InternalError(c, "Failed to resolve Telegram account", err)
 }
 
 // 内部服务器错误响应
 func InternalServerError(c *gin.Context, message string) {
-	c.JSON(http.StatusInternalServerError, Response{
-		Success: false,
-		Error:   message,
-	})
+	writeError(c, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal}, message)
 }
 
-// 内部服务器错误响应带详细错误信息
+// 内部服务器错误响应带详细错误信息，已知类型的错误使用其对应的状态码和类型
 func InternalError(c *gin.Context, message string, err error) {
 	logctx.From(c.Request.Context()).Error("API Error", 
 		zap.String("message", message),
 		zap.Error(err))
 	
-	c.JSON(http.StatusInternalServerError, Response{
-		Success: false,
-		Error:   message + ": " + err.Error(),
-	})
+	if e := classifyError(err); e != nil {
+		writeError(c, e, message+": "+e.Message)
+		return
+	}
+	writeError(c, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal}, message+": "+err.Error())
 }
 
 // 未找到错误响应
 func NotFoundError(c *gin.Context, message string) {
-	c.JSON(http.StatusNotFound, Response{
-		Success: false,
-		Error:   message,
-	})
+	writeError(c, &APIError{Status: http.StatusNotFound, Code: CodeNotFound}, message)
+}
+
+// 禁止访问错误响应
+func ForbiddenError(c *gin.Context, message string) {
+	writeError(c, &APIError{Status: http.StatusForbidden, Code: CodeForbidden}, message)
+}
+
+// 路径不在允许的根目录中
+func PathForbiddenError(c *gin.Context, message string) {
+	writeError(c, &APIError{Status: http.StatusForbidden, Code: CodePathForbidden}, message)
 }
 
 // 获取分页参数
@@ -2,8 +2,6 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -12,15 +10,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/expr-lang/expr"
 	"github.com/gin-gonic/gin"
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram"
+	"go.uber.org/zap"
 
+	"github.com/iyear/tdl/app/chat"
 	"github.com/iyear/tdl/app/dl"
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/pkg/kv"
-	tclientpkg "github.com/iyear/tdl/pkg/tclient"
+	"github.com/iyear/tdl/web/backend/middleware"
 	"github.com/iyear/tdl/web/backend/service"
 	"github.com/iyear/tdl/web/backend/websocket"
 )
@@ -30,19 +31,30 @@ type DownloadHandler struct {
 	kvd         kv.Storage
 	wsHub       *websocket.Hub
 	authService *service.AuthService
-	activeTasks sync.Map // taskID -> context.CancelFunc
-	taskStore   sync.Map // taskID -> TaskInfo (in-memory storage)
+	clients     *service.ClientManager
+	paths       *service.PathPolicy
+	tasks       *service.TaskRepository
+	scheduler   *service.Scheduler
+	metrics     *service.Metrics
+	taskStore   sync.Map // taskID -> TaskInfo (in-memory cache of persisted tasks)
 }
 
-func NewDownloadHandler(ctx context.Context, kvd kv.Storage, wsHub *websocket.Hub) *DownloadHandler {
-	return &DownloadHandler{
+func NewDownloadHandler(ctx context.Context, kvd kv.Storage, authService *service.AuthService, wsHub *websocket.Hub, clients *service.ClientManager, paths *service.PathPolicy, tasks *service.TaskRepository, scheduler *service.Scheduler, metrics *service.Metrics) *DownloadHandler {
+	h := &DownloadHandler{
 		ctx:         ctx,
 		kvd:         kvd,
 		wsHub:       wsHub,
-		authService: service.NewAuthService(ctx, kvd),
-		activeTasks: sync.Map{},
+		authService: authService,
+		clients:     clients,
+		paths:       paths,
+		tasks:       tasks,
+		scheduler:   scheduler,
+		metrics:     metrics,
 		taskStore:   sync.Map{},
 	}
+
+	h.restoreTasks()
+	return h
 }
 
 // DownloadRequest represents a download request
@@ -59,6 +71,9 @@ type DownloadRequest struct {
 	Continue     bool     `json:"continue"`
 	Desc         bool     `json:"desc"`
 	TaskID       string   `json:"task_id"`
+	Priority     int      `json:"priority"` // 排队优先级，数值越大越先执行
+	ExportUntil  int64    `json:"export_until,omitempty"` // 导出聊天消息的截止时间，恢复任务时保持消息范围不变
+	AccountID    int64    `json:"account_id,omitempty"`   // 执行任务的Telegram账号，为空时使用当前账号
 }
 
 // ImportRequest represents a JSON import request
@@ -68,7 +83,10 @@ type ImportRequest struct {
 	Template           string   `json:"template"`
 	JsonData           any      `json:"json_data" binding:"required"`
 	SelectedMessageIds []int    `json:"selected_message_ids"`
-	TaskID             string   `json:"task_id" binding:"required"`
+	Restart            bool     `json:"restart"` // 忽略上次的断点重新下载，默认继续
+	TaskID             string   `json:"task_id"` // 为空时自动生成
+	Priority           int      `json:"priority"` // 排队优先级，数值越大越先执行
+	AccountID          int64    `json:"account_id,omitempty"` // 执行任务的Telegram账号，为空时使用当前账号
 }
 
 // TaskInfo represents the task information
@@ -85,8 +103,19 @@ type TaskInfo struct {
 	CreatedAt   time.Time              `json:"created_at"`
 	Error       string                 `json:"error,omitempty"`
 	Config      map[string]interface{} `json:"config,omitempty"`
+	ClientID    string                 `json:"client_id,omitempty"` // 创建任务的操作员
+	AccountID   int64                  `json:"account_id,omitempty"` // 执行任务的Telegram账号
+	Resumable   bool                   `json:"resumable"`           // 中断后是否可以通过resume继续
+	FailedItems []FailedItem           `json:"failed_items,omitempty"` // 上次执行中下载失败的文件
+	Files       []string               `json:"files,omitempty"`        // 所有执行中下载完成的文件
 }
 
+// FailedItem 下载失败的文件所在的消息
+type FailedItem struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Error     string `json:"error,omitempty"`
+}
 
 // StartDownload 开始下载任务
 func (h *DownloadHandler) StartDownload(c *gin.Context) {
@@ -108,18 +137,58 @@ func (h *DownloadHandler) StartDownload(c *gin.Context) {
 		return
 	}
 
+	// 下载目录必须在允许的根目录中
+	dir, ok := resolvePath(c, h.paths.Download, req.DownloadPath)
+	if !ok {
+		return
+	}
+	req.DownloadPath = dir
+
+	// 过滤表达式只作用于chat_id模式，提前编译以便返回明确的参数错误
+	if req.Filter != "" {
+		if _, err := expr.Compile(req.Filter, expr.AsBool()); err != nil {
+			ExpressionError(c, fmt.Sprintf("Invalid filter expression: %v", err))
+			return
+		}
+	}
+
+	// 在请求上下文中识别客户端，协程中不能再访问gin.Context
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		InternalError(c, "Failed to identify client", err)
+		return
+	}
+
+	// 确定任务使用的账号，未指定时使用当前账号
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
+	if err != nil {
+		AccountError(c, err)
+		return
+	}
+	req.AccountID = accountID
+
 	// 生成任务ID
 	taskID := req.TaskID
 	if taskID == "" {
-		taskID = fmt.Sprintf("download-%d-%s", time.Now().Unix(), req.ChatID)
+		taskID = newTaskID("download")
+	}
+
+	// 固定聊天导出的范围，否则恢复时新消息会改变断点续传的指纹
+	if req.ChatID != "" && req.ExportUntil == 0 {
+		req.ExportUntil = time.Now().Unix()
+	}
+
+	name := fmt.Sprintf("下载任务: %d 个链接", len(req.URLs))
+	if req.ChatID != "" {
+		name = fmt.Sprintf("下载任务: Chat %s", req.ChatID)
 	}
 
 	// 保存任务信息到内存存储
 	taskInfo := TaskInfo{
 		ID:        taskID,
 		Type:      "download",
-		Name:      fmt.Sprintf("下载任务 %s", taskID),
-		Status:    "pending",
+		Name:      name,
+		Status:    "queued",
 		Progress:  0,
 		Speed:     "0 B/s",
 		ETA:       "--",
@@ -128,87 +197,264 @@ func (h *DownloadHandler) StartDownload(c *gin.Context) {
 		Config: map[string]interface{}{
 			"download_config": req,
 		},
+		ClientID:  clientID,
+		AccountID: accountID,
+		Resumable: true,
 	}
-	
-	h.taskStore.Store(taskID, taskInfo)
 
-	// 创建带取消功能的上下文
-	taskCtx, cancel := context.WithCancel(h.ctx)
-	h.activeTasks.Store(taskID, cancel)
+	if err := h.createTask(taskInfo); err != nil {
+		taskError(c, "Failed to create task", err)
+		return
+	}
 
 	// 启动下载任务
-	go func() {
-		defer func() {
-			h.activeTasks.Delete(taskID)
-		}()
-
-		// 更新任务状态为运行中
-		h.updateTaskStatus(taskID, "running", "", 0)
-
-		// 发送任务开始通知
-		h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskStart, websocket.TaskData{
-			TaskID:   taskID,
-			TaskType: "download",
-			Status:   "running",
-			Message:  "Download task started",
-		})
+	if err := h.runTask(taskID, req.Priority, "Download task started", "Download completed successfully", func(ctx context.Context) error {
+		return h.executeDownload(ctx, req, taskID, clientID)
+	}); err != nil {
+		taskError(c, "Failed to queue task", err)
+		return
+	}
 
-		// 模拟下载进度
-		for i := 0; i <= 100; i += 10 {
-			select {
-			case <-taskCtx.Done():
-				return
-			default:
-				time.Sleep(1 * time.Second)
-				
-				h.wsHub.BroadcastProgress(websocket.ProgressData{
-					TaskID:      taskID,
-					Progress:    float64(i),
-					Speed:       "2.5 MB/s",
-					ETA:         fmt.Sprintf("%ds", (100-i)/10),
-					Transferred: int64(i * 1024 * 1024),
-					Total:       100 * 1024 * 1024,
-				})
-				
-				h.updateTaskStatus(taskID, "running", "", float64(i))
+	SuccessWithMessage(c, map[string]string{
+		"task_id": taskID,
+	}, "Download task queued")
+}
+
+// executeDownload 执行链接或聊天的下载任务，使用CLI的dl.Run
+func (h *DownloadHandler) executeDownload(ctx context.Context, req DownloadRequest, taskID string, clientID string) error {
+	// 服务重启前保存的任务可能使用了根目录以外的路径
+	if _, err := h.paths.Download.Resolve(req.DownloadPath); err != nil {
+		return err
+	}
+
+	return h.clients.Run(ctx, clientID, req.AccountID, func(ctx context.Context, acc *service.AccountClient) error {
+		opts := h.downloadOptions(req, taskID, acc.ID)
+		opts.URLs = req.URLs
+		opts.Pool = acc.Pool
+
+		// 指定了chat_id时，先导出该聊天的媒体消息，再作为JSON文件交给dl.Run
+		if req.ChatID != "" {
+			exportFile, err := h.exportChatMedia(ctx, acc.Client, acc.KV, req, taskID)
+			if err != nil {
+				return errors.Wrap(err, "export chat media")
 			}
+			defer os.Remove(exportFile)
+
+			opts.Files = []string{exportFile}
 		}
-		
-		// 检查是否被取消
-		err := taskCtx.Err()
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				// 任务被取消
-				h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
+
+		logctx.From(ctx).Info("Start web download",
+			zap.String("task_id", taskID),
+			zap.Int("urls", len(opts.URLs)),
+			zap.String("chat", req.ChatID),
+			zap.Strings("include", opts.Include),
+			zap.Strings("exclude", opts.Exclude))
+
+		return dl.Run(logctx.Named(ctx, "dl"), acc.Client, acc.KV, opts)
+	})
+}
+
+// executeDownloadFiles 下载tdl JSON文件中的消息，用于只重试失败的文件
+func (h *DownloadHandler) executeDownloadFiles(ctx context.Context, req DownloadRequest, files []string, taskID string, clientID string) error {
+	// 服务重启前保存的任务可能使用了根目录以外的路径
+	if _, err := h.paths.Download.Resolve(req.DownloadPath); err != nil {
+		return err
+	}
+
+	return h.clients.Run(ctx, clientID, req.AccountID, func(ctx context.Context, acc *service.AccountClient) error {
+		opts := h.downloadOptions(req, taskID, acc.ID)
+		opts.Files = files
+		opts.Pool = acc.Pool
+
+		logctx.From(ctx).Info("Start web download from files",
+			zap.String("task_id", taskID),
+			zap.Int("files", len(files)))
+
+		return dl.Run(logctx.Named(ctx, "dl"), acc.Client, acc.KV, opts)
+	})
+}
+
+// downloadOptions 根据下载请求生成dl.Run的选项，不包含下载来源
+func (h *DownloadHandler) downloadOptions(req DownloadRequest, taskID string, accountID int64) dl.Options {
+	return dl.Options{
+		Dir:      req.DownloadPath,
+		Template: h.convertTemplateFormat(req.Template),
+		Include:  h.expandFileTypes(req.Include, req.FileTypes),
+		Exclude:  req.Exclude,
+		Desc:     req.Desc,
+		Takeout:  req.Takeout,
+		// Web端无法交互确认，continue=false时直接重新开始
+		Continue: req.Continue,
+		Restart:  !req.Continue,
+		Progress: newDownloadProgress(h, taskID, accountID),
+		Transfer: currentTransfer(h.ctx, h.kvd),
+	}
+}
+
+// exportChatMedia 将聊天中的全部媒体消息导出为tdl JSON，返回临时文件路径
+func (h *DownloadHandler) exportChatMedia(ctx context.Context, client *telegram.Client, kvd storage.Storage, req DownloadRequest, taskID string) (string, error) {
+	output := filepath.Join(os.TempDir(), fmt.Sprintf("download_%d.json", time.Now().UnixNano()))
+
+	filter := req.Filter
+	if filter == "" {
+		filter = "true"
+	}
+
+	until := req.ExportUntil
+	if until == 0 {
+		until = time.Now().Unix()
+	}
+
+	err := chat.Export(logctx.Named(ctx, "export"), client, kvd, chat.ExportOptions{
+		Type:   chat.ExportTypeTime,
+		Chat:   req.ChatID,
+		Input:  []int{0, int(until)},
+		Output: output,
+		Filter: filter,
+	})
+	if err != nil {
+		_ = os.Remove(output)
+		return "", err
+	}
+
+	return output, nil
+}
+
+// fileTypeExtensions 前端文件类型到扩展名的映射
+var fileTypeExtensions = map[string][]string{
+	"photo":    {"jpg", "jpeg", "png", "gif", "webp", "heic"},
+	"video":    {"mp4", "mkv", "mov", "avi", "webm", "flv", "m4v"},
+	"audio":    {"mp3", "m4a", "flac", "ogg", "opus", "wav", "aac"},
+	"document": {"pdf", "txt", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "epub"},
+	"archive":  {"zip", "rar", "7z", "tar", "gz"},
+}
+
+// expandFileTypes 合并include和file_types，未知的文件类型按扩展名处理
+func (h *DownloadHandler) expandFileTypes(include, fileTypes []string) []string {
+	exts := make([]string, 0, len(include))
+	exts = append(exts, include...)
+
+	for _, t := range fileTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || t == "all" {
+			continue
+		}
+		if mapped, ok := fileTypeExtensions[t]; ok {
+			exts = append(exts, mapped...)
+			continue
+		}
+		exts = append(exts, strings.TrimPrefix(t, "."))
+	}
+
+	return exts
+}
+
+// runTask 将下载任务提交到调度器排队执行，并统一维护任务状态和WebSocket通知
+func (h *DownloadHandler) runTask(taskID string, priority int, startMsg, doneMsg string, run func(ctx context.Context) error) error {
+	h.updateTaskStatus(taskID, "queued", "", 0)
+	task, _ := h.getTaskInfo(taskID)
+
+	_, err := h.scheduler.Submit(service.Job{
+		ID:        taskID,
+		Type:      "download",
+		Priority:  priority,
+		ClientID:  task.ClientID,
+		AccountID: task.AccountID,
+		Run: func(ctx context.Context) error {
+			// 更新任务状态为运行中
+			h.updateTaskStatus(taskID, "running", "", 0)
+			account := h.taskAccount(taskID)
+
+			// 发送任务开始通知
+			h.wsHub.BroadcastTaskStatus(account, websocket.MessageTypeTaskStart, websocket.TaskData{
+				TaskID:   taskID,
+				TaskType: "download",
+				Status:   "running",
+				Message:  startMsg,
+			})
+
+			err := run(ctx)
+			switch {
+			case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+				// 任务被取消，状态已由CancelTask/PauseTask更新
+				logctx.From(h.ctx).Info("Download task cancelled", zap.String("task_id", taskID))
+			case err != nil:
+				logctx.From(h.ctx).Error("Download task failed",
+					zap.String("task_id", taskID),
+					zap.Error(err))
+				h.updateTaskStatus(taskID, "error", err.Error(), 0)
+				h.wsHub.BroadcastTaskStatus(account, websocket.MessageTypeTaskError, websocket.TaskData{
 					TaskID:   taskID,
 					TaskType: "download",
-					Status:   "cancelled",
-					Message:  "Task cancelled by user",
+					Status:   "error",
+					Message:  err.Error(),
 				})
-			} else {
-				// 任务出错
-				h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
+			default:
+				h.updateTaskStatus(taskID, "completed", "", 100)
+				h.wsHub.BroadcastTaskStatus(account, websocket.MessageTypeTaskEnd, websocket.TaskData{
 					TaskID:   taskID,
 					TaskType: "download",
-					Status:   "error",
-					Message:  err.Error(),
+					Status:   "completed",
+					Message:  doneMsg,
 				})
 			}
-		} else {
-			// 任务完成
-			h.updateTaskStatus(taskID, "completed", "", 100)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
-				TaskID:   taskID,
-				TaskType: "download",
-				Status:   "completed",
-				Message:  "Download completed successfully",
-			})
+			return err
+		},
+	})
+	return err
+}
+
+// restoreTasks 从任务仓库加载历史任务，未完成的任务标记为中断
+func (h *DownloadHandler) restoreTasks() {
+	loadPersistedTasks(h.ctx, h.tasks, "download", func(data []byte) error {
+		var task TaskInfo
+		if err := json.Unmarshal(data, &task); err != nil {
+			return err
 		}
-	}()
 
-	SuccessWithMessage(c, map[string]string{
-		"task_id": taskID,
-	}, "Download task started")
+		if isUnfinishedStatus(task.Status) {
+			task.Status = TaskStatusInterrupted
+			task.Error = taskInterruptedMessage
+			task.Speed = "0 B/s"
+			task.ETA = "--"
+			task.Resumable = h.hasResumableConfig(task)
+			h.saveTask(task)
+			return nil
+		}
+
+		h.taskStore.Store(task.ID, task)
+		return nil
+	})
+}
+
+// hasResumableConfig 任务是否保存了可以重新执行的配置
+func (h *DownloadHandler) hasResumableConfig(task TaskInfo) bool {
+	if task.ClientID == "" {
+		return false
+	}
+
+	var dlReq DownloadRequest
+	if decodeTaskConfig(task.Config, "download_config", &dlReq) {
+		return true
+	}
+
+	var importReq ImportRequest
+	return decodeTaskConfig(task.Config, "import_config", &importReq)
+}
+
+// createTask 保存新任务，ID已被使用时返回service.ErrTaskExists
+func (h *DownloadHandler) createTask(task TaskInfo) error {
+	if err := createTask(h.ctx, h.tasks, h.scheduler, task.ID, task.Type, task.Status, task.CreatedAt, task); err != nil {
+		return err
+	}
+	h.taskStore.Store(task.ID, task)
+	return nil
+}
+
+// saveTask 保存任务到内存缓存和任务仓库
+func (h *DownloadHandler) saveTask(task TaskInfo) {
+	h.taskStore.Store(task.ID, task)
+	persistTask(h.ctx, h.tasks, task.ID, task.Type, task.Status, task.CreatedAt, task)
 }
 
 // GetTasks 获取下载任务列表
@@ -217,16 +463,7 @@ func (h *DownloadHandler) GetTasks(c *gin.Context) {
 	
 	// 从内存存储获取任务
 	h.taskStore.Range(func(key, value interface{}) bool {
-		if task, ok := value.(TaskInfo); ok {
-			// 检查任务是否仍在运行
-			if task.Status == "running" {
-				if _, exists := h.activeTasks.Load(task.ID); !exists {
-					// 任务不在活动列表中，可能已经停止
-					task.Status = "error"
-					task.Error = "Task was interrupted"
-					h.taskStore.Store(task.ID, task)
-				}
-			}
+		if task, ok := value.(TaskInfo); ok && canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
 			tasks = append(tasks, task)
 		}
 		return true
@@ -246,19 +483,28 @@ func (h *DownloadHandler) CancelTask(c *gin.Context) {
 		return
 	}
 
-	// 取消活动任务
-	if cancelFunc, exists := h.activeTasks.Load(taskID); exists {
-		if cancel, ok := cancelFunc.(context.CancelFunc); ok {
-			cancel()
+	task, ok := h.accessibleTask(c, taskID)
+	if !ok {
+		return
+	}
+
+	// 取消排队或运行中的任务，已结束的任务直接删除记录
+	if _, found := h.scheduler.Cancel(taskID); !found {
+		if !isUnfinishedStatus(task.Status) {
+			h.taskStore.Delete(taskID)
+			deletePersistedTask(h.ctx, h.tasks, taskID)
+
+			SuccessWithMessage(c, nil, "Task deleted successfully")
+			return
 		}
-		h.activeTasks.Delete(taskID)
 	}
 
 	// 更新任务状态
 	h.updateTaskStatus(taskID, "cancelled", "", 0)
+	account := h.taskAccount(taskID)
 
 	// 发送WebSocket通知
-	h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
+	h.wsHub.BroadcastTaskStatus(account, websocket.MessageTypeTaskEnd, websocket.TaskData{
 		TaskID:   taskID,
 		TaskType: "download",
 		Status:   "cancelled",
@@ -276,10 +522,70 @@ func (h *DownloadHandler) updateTaskStatus(taskID, status, errorMsg string, prog
 			task.Progress = progress
 			if errorMsg != "" {
 				task.Error = errorMsg
+			} else if status == "running" {
+				task.Error = ""
 			}
-			h.taskStore.Store(taskID, task)
+			h.saveTask(task)
+		}
+	}
+}
+
+// updateTaskProgress 更新任务的传输进度、失败的文件和完成的文件，persist为false时只更新内存缓存
+func (h *DownloadHandler) updateTaskProgress(taskID string, snap transferSnapshot, failed []FailedItem, files []string, persist bool) {
+	task, ok := h.getTaskInfo(taskID)
+	if !ok || task.Status != "running" {
+		return
+	}
+
+	task.Progress = snap.Progress
+	task.Speed = snap.Speed
+	task.ETA = snap.ETA
+	task.Transferred = snap.Transferred
+	task.Total = snap.Total
+	task.FailedItems = failed
+	task.Files = mergeFiles(task.Files, files)
+
+	if persist {
+		h.saveTask(task)
+		return
+	}
+	h.taskStore.Store(taskID, task)
+}
+
+// mergeFiles 将本次执行下载完成的文件合并到任务的文件列表，重试和恢复时保留之前的文件
+func mergeFiles(existing, files []string) []string {
+	seen := make(map[string]bool, len(existing))
+	for _, file := range existing {
+		seen[file] = true
+	}
+
+	merged := append([]string(nil), existing...)
+	for _, file := range files {
+		if !seen[file] {
+			seen[file] = true
+			merged = append(merged, file)
 		}
 	}
+	return merged
+}
+
+// taskAccount 返回任务使用的账号，WebSocket事件推送给可以访问该账号的连接
+func (h *DownloadHandler) taskAccount(taskID string) int64 {
+	task, ok := h.getTaskInfo(taskID)
+	if !ok {
+		return 0
+	}
+	return task.AccountID
+}
+
+// accessibleTask 获取发起请求的客户端可以访问的任务，不存在或无权访问时返回404
+func (h *DownloadHandler) accessibleTask(c *gin.Context, taskID string) (TaskInfo, bool) {
+	task, ok := h.getTaskInfo(taskID)
+	if !ok || !canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
+		NotFoundError(c, "Task not found")
+		return TaskInfo{}, false
+	}
+	return task, true
 }
 
 // getTaskInfo 获取任务信息
@@ -293,6 +599,9 @@ func (h *DownloadHandler) getTaskInfo(taskID string) (TaskInfo, bool) {
 }
 
 // PauseTask 暂停下载任务
+//
+// 暂停会取消任务的上下文，dl.Run退出时保存已完成的文件到断点续传记录，
+// 未完成文件中已下载的部分保留在.tmp文件中，恢复时从中断处继续
 func (h *DownloadHandler) PauseTask(c *gin.Context) {
 	taskID := c.Param("id")
 	if taskID == "" {
@@ -300,19 +609,28 @@ func (h *DownloadHandler) PauseTask(c *gin.Context) {
 		return
 	}
 
-	// 取消活动任务
-	if cancelFunc, exists := h.activeTasks.Load(taskID); exists {
-		if cancel, ok := cancelFunc.(context.CancelFunc); ok {
-			cancel()
-		}
-		h.activeTasks.Delete(taskID)
+	task, exists := h.accessibleTask(c, taskID)
+	if !exists {
+		return
+	}
+
+	if !isUnfinishedStatus(task.Status) {
+		ValidationError(c, "Task is not running")
+		return
 	}
 
-	// 更新任务状态为暂停
-	h.updateTaskStatus(taskID, "paused", "", 0)
+	// 取消排队或运行中的任务
+	h.scheduler.Cancel(taskID)
+
+	// 更新任务状态为暂停，保留当前进度
+	task.Status = "paused"
+	task.Speed = "0 B/s"
+	task.ETA = "--"
+	task.Resumable = h.hasResumableConfig(task)
+	h.saveTask(task)
 
 	// 发送WebSocket通知
-	h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
+	h.wsHub.BroadcastTaskStatus(task.AccountID, websocket.MessageTypeTaskEnd, websocket.TaskData{
 		TaskID:   taskID,
 		TaskType: "download",
 		Status:   "paused",
@@ -322,7 +640,7 @@ func (h *DownloadHandler) PauseTask(c *gin.Context) {
 	SuccessWithMessage(c, nil, "Task paused successfully")
 }
 
-// ResumeTask 恢复下载任务
+// ResumeTask 恢复暂停或因服务重启中断的下载任务
 func (h *DownloadHandler) ResumeTask(c *gin.Context) {
 	taskID := c.Param("id")
 	if taskID == "" {
@@ -331,31 +649,115 @@ func (h *DownloadHandler) ResumeTask(c *gin.Context) {
 	}
 
 	// 获取任务信息
-	task, exists := h.getTaskInfo(taskID)
+	task, exists := h.accessibleTask(c, taskID)
 	if !exists {
-		NotFoundError(c, "Task not found")
 		return
 	}
 
-	if task.Status != "paused" {
-		ValidationError(c, "Task is not in paused state")
+	if task.Status != "paused" && task.Status != TaskStatusInterrupted {
+		ValidationError(c, "Task is not paused")
 		return
 	}
 
-	// 重启下载任务
-	h.updateTaskStatus(taskID, "running", "", task.Progress)
-	
-	h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskStart, websocket.TaskData{
-		TaskID:   taskID,
-		TaskType: "download",
-		Status:   "running",
-		Message:  "Task resumed",
-	})
+	if !task.Resumable {
+		ValidationError(c, "Task cannot be resumed")
+		return
+	}
+
+	// 暂停后任务可能还在保存断点，结束前不能重新执行
+	if h.scheduler.Active(taskID) {
+		ValidationError(c, "Task is still stopping, please try again later")
+		return
+	}
+
+	// 使用保存的配置重新执行任务
+	if err := h.rerunTask(task, true, "Task resumed"); err != nil {
+		taskError(c, "Failed to resume task", err)
+		return
+	}
 
 	SuccessWithMessage(c, nil, "Task resumed successfully")
 }
 
-// RetryTask 重试下载任务
+// rerunTask 根据保存的配置重新执行任务
+//
+// resume为true时从断点继续，已下载的部分由dl.Run的断点续传跳过
+func (h *DownloadHandler) rerunTask(task TaskInfo, resume bool, startMsg string) error {
+	var dlReq DownloadRequest
+	if decodeTaskConfig(task.Config, "download_config", &dlReq) {
+		// 恢复暂停的任务总是从断点继续，重试沿用创建任务时的选择
+		if resume {
+			dlReq.Continue = true
+		}
+
+		return h.runTask(task.ID, dlReq.Priority, startMsg, "Download completed successfully", func(ctx context.Context) error {
+			return h.executeDownload(ctx, dlReq, task.ID, task.ClientID)
+		})
+	}
+
+	var importReq ImportRequest
+	if decodeTaskConfig(task.Config, "import_config", &importReq) {
+		if resume {
+			importReq.Restart = false
+		}
+
+		tempFile, err := h.writeImportFile(importReq)
+		if err != nil {
+			return err
+		}
+
+		if err = h.runTask(task.ID, importReq.Priority, startMsg, "Import download completed successfully", func(ctx context.Context) error {
+			defer os.Remove(tempFile)
+			return h.executeRealDownload(ctx, importReq, tempFile, task.ClientID, h.convertTemplateFormat(importReq.Template))
+		}); err != nil {
+			os.Remove(tempFile)
+			return err
+		}
+		return nil
+	}
+
+	return errors.New("task has no resumable config")
+}
+
+// retryFailed 只重新下载上次执行中失败的文件，下载目录和模板沿用任务的配置
+func (h *DownloadHandler) retryFailed(task TaskInfo) error {
+	var req DownloadRequest
+	if !decodeTaskConfig(task.Config, "download_config", &req) {
+		var importReq ImportRequest
+		if !decodeTaskConfig(task.Config, "import_config", &importReq) {
+			return errors.New("task has no resumable config")
+		}
+
+		req = DownloadRequest{
+			DownloadPath: importReq.DownloadPath,
+			Template:     importReq.Template,
+			Priority:     importReq.Priority,
+			AccountID:    importReq.AccountID,
+			Continue:     !importReq.Restart,
+		}
+	}
+
+	messages := make(map[int64][]int)
+	for _, item := range task.FailedItems {
+		messages[item.ChatID] = append(messages[item.ChatID], item.MessageID)
+	}
+
+	files, err := writeMessageFiles("retry_"+task.ID, messages)
+	if err != nil {
+		return err
+	}
+
+	if err = h.runTask(task.ID, req.Priority, "Retrying failed files", "Download completed successfully", func(ctx context.Context) error {
+		defer removeFiles(files)
+		return h.executeDownloadFiles(ctx, req, files, task.ID, task.ClientID)
+	}); err != nil {
+		removeFiles(files)
+		return err
+	}
+	return nil
+}
+
+// RetryTask 使用保存的配置重新执行下载任务，failed_only时只下载上次失败的文件
 func (h *DownloadHandler) RetryTask(c *gin.Context) {
 	taskID := c.Param("id")
 	if taskID == "" {
@@ -363,18 +765,44 @@ func (h *DownloadHandler) RetryTask(c *gin.Context) {
 		return
 	}
 
-	// 重置任务状态
-	h.updateTaskStatus(taskID, "pending", "", 0)
+	req, ok := bindRetryRequest(c)
+	if !ok {
+		return
+	}
 
-	// 发送WebSocket通知
-	h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskStart, websocket.TaskData{
-		TaskID:   taskID,
-		TaskType: "download",
-		Status:   "pending",
-		Message:  "Task queued for retry",
-	})
+	task, exists := h.accessibleTask(c, taskID)
+	if !exists {
+		return
+	}
+
+	if isUnfinishedStatus(task.Status) || h.scheduler.Active(taskID) {
+		ValidationError(c, "Task is still running")
+		return
+	}
+
+	if !h.hasResumableConfig(task) {
+		ValidationError(c, "Task cannot be retried")
+		return
+	}
+
+	var err error
+	if req.FailedOnly {
+		if len(task.FailedItems) == 0 {
+			ValidationError(c, "Task has no failed files")
+			return
+		}
+		err = h.retryFailed(task)
+	} else {
+		err = h.rerunTask(task, false, "Task retried")
+	}
+	if err != nil {
+		taskError(c, "Failed to retry task", err)
+		return
+	}
 
-	SuccessWithMessage(c, nil, "Task queued for retry")
+	SuccessWithMessage(c, map[string]string{
+		"task_id": taskID,
+	}, "Task queued for retry")
 }
 
 // GetTaskDetails 获取任务详细信息
@@ -385,57 +813,41 @@ func (h *DownloadHandler) GetTaskDetails(c *gin.Context) {
 		return
 	}
 
-	task, exists := h.getTaskInfo(taskID)
+	task, exists := h.accessibleTask(c, taskID)
 	if !exists {
-		NotFoundError(c, "Task not found")
 		return
 	}
 
 	Success(c, task)
 }
 
-// getClientID 获取客户端ID，与ChatHandler保持完全一致的识别机制  
-func (h *DownloadHandler) getClientID(c *gin.Context) (string, error) {
-	// 复制ChatHandler的完整 getOrCreateClientID 逻辑
-	const clientIDCookie = "tdl_client_id"
-	const clientIDHeader = "X-TDL-Client-ID"
-	
-	// 1. 优先从Cookie获取客户端ID
-	if clientID, err := c.Cookie(clientIDCookie); err == nil && clientID != "" {
-		return clientID, nil
+// DownloadTaskFiles 将任务下载完成的文件打包为zip流式返回
+func (h *DownloadHandler) DownloadTaskFiles(c *gin.Context) {
+	task, exists := h.accessibleTask(c, c.Param("id"))
+	if !exists {
+		return
 	}
-	
-	// 2. 从Header获取客户端ID
-	if clientID := c.GetHeader(clientIDHeader); clientID != "" {
-		// 设置cookie以便后续请求使用
-		c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-		return clientID, nil
+	if isUnfinishedStatus(task.Status) {
+		ValidationError(c, "Task is still running")
+		return
 	}
-	
-	// 3. 生成新的客户端ID
-	clientID, err := h.generateClientID()
-	if err != nil {
-		// 如果生成失败，回退到IP地址
-		fmt.Printf("Failed to generate client ID, fallback to IP: %v\n", err)
-		clientIP := c.ClientIP()
-		// 使用与util.SafeClientID相同的逻辑
-		safeIP := strings.ReplaceAll(clientIP, ":", "_")
-		safeIP = strings.ReplaceAll(safeIP, ".", "_")
-		return fmt.Sprintf("client_%s", safeIP), nil
+	if len(task.Files) == 0 {
+		NotFoundError(c, "Task has no downloaded files")
+		return
 	}
-	
-	// 4. 设置cookie并返回
-	c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-	return clientID, nil
-}
 
-// generateClientID 生成唯一的客户端ID - 复制ChatHandler的实现
-func (h *DownloadHandler) generateClientID() (string, error) {
-	bytes := make([]byte, 16)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", fmt.Errorf("generate random bytes: %w", err)
+	// zip中的路径相对于任务的下载目录
+	var base string
+	var dlReq DownloadRequest
+	var importReq ImportRequest
+	switch {
+	case decodeTaskConfig(task.Config, "download_config", &dlReq):
+		base = dlReq.DownloadPath
+	case decodeTaskConfig(task.Config, "import_config", &importReq):
+		base = importReq.DownloadPath
 	}
-	return "client_" + hex.EncodeToString(bytes), nil
+
+	writeZip(h.ctx, c, h.paths.Download, service.SanitizeFilename(task.ID)+".zip", base, task.Files)
 }
 
 // convertTemplateFormat 将前端模板格式转换为Go template格式
@@ -459,65 +871,9 @@ func (h *DownloadHandler) convertTemplateFormat(template string) string {
 	converted = strings.ReplaceAll(converted, "{MessageDate}", "{{ .MessageDate }}")
 	converted = strings.ReplaceAll(converted, "{DownloadDate}", "{{ .DownloadDate }}")
 	converted = strings.ReplaceAll(converted, "{FileCaption}", "{{ .FileCaption }}")
-	
-	fmt.Printf("Template conversion: '%s' -> '%s'\n", template, converted)
 	return converted
 }
 
-// createTelegramClientForUser 为特定用户创建Telegram客户端，复制ChatHandler的逻辑
-func (h *DownloadHandler) createTelegramClientForUser(clientID string) (*telegram.Client, storage.Storage, error) {
-	// 获取Telegram ID
-	telegramID, err := h.authService.GetAuthenticatedTelegramID(clientID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get authenticated telegram id: %w", err)
-	}
-
-	// 使用用户命名空间
-	namespace := fmt.Sprintf("user_%d", telegramID)
-	return h.createTelegramClient(namespace)
-}
-
-// createTelegramClient 创建Telegram客户端，使用与CLI相同的配置
-func (h *DownloadHandler) createTelegramClient(namespace string) (*telegram.Client, storage.Storage, error) {
-	// 通过kv.Storage获取storage.Storage实例
-	storageInstance, err := h.kvd.Open(namespace)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open storage namespace: %w", err)
-	}
-
-	// 获取当前设置
-	settingsHandler := NewSettingsHandler(h.ctx, h.kvd)
-	settings, err := settingsHandler.GetCurrentSettings()
-	if err != nil {
-		fmt.Printf("Failed to load settings, using defaults: %v\n", err)
-		settings = &Settings{
-			GlobalProxy:      "",
-			ReconnectTimeout: 300,
-		}
-	}
-
-	// 使用与CLI相同的tOptions配置
-	o := tclientpkg.Options{
-		KV:               storageInstance,
-		Proxy:            settings.GlobalProxy, // 从设置中获取代理配置
-		NTP:              "", // NTP配置暂时为空
-		ReconnectTimeout: time.Duration(settings.ReconnectTimeout) * time.Second, // 从设置中获取重连超时
-		UpdateHandler:    nil,
-	}
-
-	// 记录配置信息
-	fmt.Printf("Creating Telegram client with settings: proxy=%s, reconnectTimeout=%v\n", 
-		o.Proxy, o.ReconnectTimeout)
-
-	// 创建客户端，使用与CLI相同的参数
-	client, err := tclientpkg.New(h.ctx, o, false) // false表示不需要登录，使用已有session
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create telegram client: %w", err)
-	}
-
-	return client, storageInstance, nil
-}
-
 // ImportFromJson 从JSON文件导入并开始下载 - 使用CLI的完整功能
 func (h *DownloadHandler) ImportFromJson(c *gin.Context) {
 	var req ImportRequest
@@ -546,70 +902,49 @@ func (h *DownloadHandler) ImportFromJson(c *gin.Context) {
 		return
 	}
 
-	messagesArray, ok := messages.([]interface{})
-	if !ok {
+	if _, ok := messages.([]interface{}); !ok {
 		ValidationError(c, "Invalid messages format in JSON")
 		return
 	}
 
-	// 创建临时JSON文件供后端处理
-	tempDir := os.TempDir()
-	tempFile := filepath.Join(tempDir, fmt.Sprintf("import_%s_%d.json", req.TaskID, time.Now().Unix()))
-	
-	// 如果指定了选中的消息ID，过滤JSON数据
-	if len(req.SelectedMessageIds) > 0 {
-		selectedIdMap := make(map[int]bool)
-		for _, id := range req.SelectedMessageIds {
-			selectedIdMap[id] = true
-		}
-		
-		filteredMessages := []interface{}{}
-		for _, msg := range messagesArray {
-			if msgMap, ok := msg.(map[string]interface{}); ok {
-				if idFloat, hasId := msgMap["id"]; hasId {
-					if id, ok := idFloat.(float64); ok {
-						if selectedIdMap[int(id)] {
-							filteredMessages = append(filteredMessages, msg)
-						}
-					}
-				}
-			}
-		}
-		
-		// 更新JSON数据为过滤后的消息
-		jsonMap["messages"] = filteredMessages
+	// 下载目录必须在允许的根目录中
+	dir, ok := resolvePath(c, h.paths.Download, req.DownloadPath)
+	if !ok {
+		return
 	}
+	req.DownloadPath = dir
 
-	// 写入临时文件
-	jsonBytes, err := json.Marshal(jsonMap)
+	// 在请求上下文中识别客户端，协程中不能再访问gin.Context
+	clientID, err := middleware.Client(c)
 	if err != nil {
-		InternalError(c, "Failed to serialize JSON data", err)
+		InternalError(c, "Failed to identify client", err)
 		return
 	}
 
-	if err := os.WriteFile(tempFile, jsonBytes, 0644); err != nil {
+	// 确定任务使用的账号，未指定时使用当前账号
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
+	if err != nil {
+		AccountError(c, err)
+		return
+	}
+	req.AccountID = accountID
+
+	if req.TaskID == "" {
+		req.TaskID = newTaskID("download")
+	}
+
+	tempFile, err := h.writeImportFile(req)
+	if err != nil {
 		InternalError(c, "Failed to create temporary file", err)
 		return
 	}
-	
-	// 调试：输出临时文件内容
-	fmt.Printf("Created temp file: %s\n", tempFile)
-	fmt.Printf("JSON content: %s\n", string(jsonBytes))
-
-	// 确保在任务完成后清理临时文件
-	defer func() {
-		go func() {
-			time.Sleep(1 * time.Hour) // 1小时后清理
-			os.Remove(tempFile)
-		}()
-	}()
 
 	// 保存任务信息
 	taskInfo := TaskInfo{
 		ID:        req.TaskID,
 		Type:      "download",
 		Name:      fmt.Sprintf("导入下载: Chat %s (%d个文件)", req.ChatID, len(req.SelectedMessageIds)),
-		Status:    "pending",
+		Status:    "queued",
 		Progress:  0,
 		Speed:     "0 B/s",
 		ETA:       "--",
@@ -617,135 +952,105 @@ func (h *DownloadHandler) ImportFromJson(c *gin.Context) {
 		CreatedAt: time.Now(),
 		Config: map[string]interface{}{
 			"import_config": req,
-			"temp_file":     tempFile,
 		},
+		ClientID:  clientID,
+		AccountID: accountID,
+		Resumable: true,
 	}
-	
-	h.taskStore.Store(req.TaskID, taskInfo)
 
-	// 创建带取消功能的上下文
-	taskCtx, cancel := context.WithCancel(h.ctx)
-	h.activeTasks.Store(req.TaskID, cancel)
+	if err = h.createTask(taskInfo); err != nil {
+		os.Remove(tempFile)
+		taskError(c, "Failed to create task", err)
+		return
+	}
 
 	// 启动导入下载任务
-	go func() {
-		defer func() {
-			h.activeTasks.Delete(req.TaskID)
-		}()
-
-		// 更新任务状态为运行中
-		h.updateTaskStatus(req.TaskID, "running", "", 0)
-
-		// 发送任务开始通知
-		h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskStart, websocket.TaskData{
-			TaskID:   req.TaskID,
-			TaskType: "download",
-			Status:   "running",
-			Message:  "Import download task started",
-		})
+	if err = h.runTask(req.TaskID, req.Priority, "Import download task started", "Import download completed successfully", func(ctx context.Context) error {
+		defer os.Remove(tempFile)
 
-		// 调用真实的tdl CLI下载功能
-		fmt.Printf("Starting download with temp file: %s\n", tempFile)
-		fmt.Printf("Download path: %s\n", req.DownloadPath)
-		fmt.Printf("Original Template: %s\n", req.Template)
-		
 		// 自动转换模板格式：从 {xxx} 转换为 {{ .xxx }}
 		template := h.convertTemplateFormat(req.Template)
-		fmt.Printf("Converted Template: %s\n", template)
-		
-		// 获取客户端ID
-		clientID, err := h.getClientID(c)
-		if err != nil {
-			fmt.Printf("Failed to get client ID: %v\n", err)
-			h.updateTaskStatus(req.TaskID, "error", fmt.Sprintf("Failed to get client ID: %v", err), 0)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
-				TaskID:   req.TaskID,
-				TaskType: "download",
-				Status:   "error",
-				Message:  fmt.Sprintf("Failed to get client ID: %v", err),
-			})
-			return
-		}
-		fmt.Printf("Using clientID: %s\n", clientID)
-		
-		err = h.executeRealDownload(taskCtx, req, tempFile, clientID, template)
-		if err != nil {
-			fmt.Printf("Download error: %v\n", err)
-			h.updateTaskStatus(req.TaskID, "error", err.Error(), 0)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
-				TaskID:   req.TaskID,
-				TaskType: "download",
-				Status:   "error",
-				Message:  err.Error(),
-			})
-			return
+
+		return h.executeRealDownload(ctx, req, tempFile, clientID, template)
+	}); err != nil {
+		os.Remove(tempFile)
+		taskError(c, "Failed to queue task", err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]string{
+		"task_id": req.TaskID,
+	}, "Import download task queued")
+}
+
+// writeImportFile 将导入的JSON（按选中的消息过滤后）写入临时文件供dl.Run使用
+func (h *DownloadHandler) writeImportFile(req ImportRequest) (string, error) {
+	jsonMap, ok := req.JsonData.(map[string]interface{})
+	if !ok {
+		return "", errors.New("invalid JSON data format")
+	}
+
+	// 如果指定了选中的消息ID，过滤JSON数据
+	if len(req.SelectedMessageIds) > 0 {
+		messagesArray, _ := jsonMap["messages"].([]interface{})
+
+		selectedIdMap := make(map[int]bool)
+		for _, id := range req.SelectedMessageIds {
+			selectedIdMap[id] = true
 		}
-		
-		fmt.Printf("Download completed successfully\n")
-		
-		// 检查是否被取消
-		err = taskCtx.Err()
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
-					TaskID:   req.TaskID,
-					TaskType: "download",
-					Status:   "cancelled",
-					Message:  "Import task cancelled by user",
-				})
-			} else {
-				h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
-					TaskID:   req.TaskID,
-					TaskType: "download",
-					Status:   "error",
-					Message:  err.Error(),
-				})
+
+		filteredMessages := []interface{}{}
+		for _, msg := range messagesArray {
+			if msgMap, ok := msg.(map[string]interface{}); ok {
+				if idFloat, hasId := msgMap["id"]; hasId {
+					if id, ok := idFloat.(float64); ok {
+						if selectedIdMap[int(id)] {
+							filteredMessages = append(filteredMessages, msg)
+						}
+					}
+				}
 			}
-		} else {
-			// 任务完成
-			h.updateTaskStatus(req.TaskID, "completed", "", 100)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
-				TaskID:   req.TaskID,
-				TaskType: "download",
-				Status:   "completed",
-				Message:  "Import download completed successfully",
-			})
 		}
-	}()
 
-	SuccessWithMessage(c, map[string]string{
-		"task_id": req.TaskID,
-	}, "Import download task started")
+		// 复制一份，避免修改任务配置中保存的原始数据
+		filtered := make(map[string]interface{}, len(jsonMap))
+		for k, v := range jsonMap {
+			filtered[k] = v
+		}
+		filtered["messages"] = filteredMessages
+		jsonMap = filtered
+	}
+
+	// 写入临时文件
+	jsonBytes, err := json.Marshal(jsonMap)
+	if err != nil {
+		return "", errors.Wrap(err, "serialize JSON data")
+	}
+
+	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("import_%s_%d.json", service.SanitizeFilename(req.TaskID), time.Now().Unix()))
+	if err = os.WriteFile(tempFile, jsonBytes, 0644); err != nil {
+		return "", errors.Wrap(err, "write temporary file")
+	}
+
+	return tempFile, nil
 }
 
 // executeRealDownload 执行真实的下载任务，使用CLI的完整功能
 func (h *DownloadHandler) executeRealDownload(ctx context.Context, req ImportRequest, tempFile string, clientID string, template string) error {
-	fmt.Printf("executeRealDownload: Starting real CLI download for clientID: %s\n", clientID)
-	
 	// 使用与Chat页面相同的认证机制
 	return h.tRunWithFiles(ctx, req, tempFile, clientID, template)
 }
 
 // tRunWithFiles 使用与Chat页面相同的认证机制来执行下载
 func (h *DownloadHandler) tRunWithFiles(ctx context.Context, req ImportRequest, tempFile string, clientID string, template string) error {
-	fmt.Printf("tRunWithFiles: Creating authenticated client for user\n")
-	
-	// 使用与Chat页面完全相同的客户端创建逻辑
-	client, storageInstance, err := h.createTelegramClientForUser(clientID)
-	if err != nil {
-		fmt.Printf("tRunWithFiles: Failed to create client for user: %v\n", err)
-		return errors.Wrap(err, "create telegram client for user")
+	// 服务重启前保存的任务可能使用了根目录以外的路径
+	if _, err := h.paths.Download.Resolve(req.DownloadPath); err != nil {
+		return err
 	}
-	
-	fmt.Printf("tRunWithFiles: Client created successfully for authenticated user\n")
-	
-	fmt.Printf("tRunWithFiles: Starting download with authenticated client\n")
-	
-	// 直接运行下载，因为客户端已经是认证用户的了
-	err = client.Run(ctx, func(ctx context.Context) error {
-		fmt.Printf("tRunWithFiles: Inside client.Run, starting download\n")
-		
-		// === 关键：直接使用CLI的dl.Run函数，但设置Continue=true避免交互 ===
+
+	// 使用账号的长连接客户端运行下载
+	err := h.clients.Run(ctx, clientID, req.AccountID, func(ctx context.Context, acc *service.AccountClient) error {
+		// === 关键：直接使用CLI的dl.Run函数，Continue和Restart必须设置其一以避免交互 ===
 		opts := dl.Options{
 			Dir:         req.DownloadPath,
 			RewriteExt:  false,
@@ -758,91 +1063,22 @@ func (h *DownloadHandler) tRunWithFiles(ctx context.Context, req ImportRequest,
 			Desc:        false,
 			Takeout:     false,
 			Group:       false,
-			Continue:    true,  // 关键：避免交互式确认
-			Restart:     false,
+			Continue:    !req.Restart, // 关键：避免交互式确认
+			Restart:     req.Restart,
 			Serve:       false,
 			Port:        0,
+			Progress:    newDownloadProgress(h, req.TaskID, acc.ID),
+			Transfer:    currentTransfer(h.ctx, h.kvd),
+			Pool:        acc.Pool,
 		}
-		
-		fmt.Printf("tRunWithFiles: Calling CLI dl.Run with options: %+v\n", opts)
-		
-		// 创建进度监控
-		go h.monitorRealDownloadProgress(ctx, req.TaskID, req.DownloadPath)
-		
+
+
 		// 调用真实的CLI下载函数，使用用户特定的存储
-		dlErr := dl.Run(logctx.Named(ctx, "dl"), client, storageInstance, opts)
-		fmt.Printf("tRunWithFiles: dl.Run completed with error: %v\n", dlErr)
-		return dlErr
+		return dl.Run(logctx.Named(ctx, "dl"), acc.Client, acc.KV, opts)
 	})
-	
 	if err != nil {
-		fmt.Printf("tRunWithFiles: client.Run failed with error: %v\n", err)
 		return errors.Wrap(err, "run telegram client")
 	}
-	
-	fmt.Printf("tRunWithFiles: Successfully completed\n")
-	return nil
-}
 
-// monitorRealDownloadProgress 监控真实的下载进度
-func (h *DownloadHandler) monitorRealDownloadProgress(ctx context.Context, taskID string, downloadDir string) {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-	
-	startTime := time.Now()
-	lastSize := int64(0)
-	
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// 扫描下载目录获取进度
-			fileCount, totalSize := h.scanDownloadDirectory(downloadDir)
-			
-			// 计算速度
-			elapsed := time.Since(startTime).Seconds()
-			var speed string
-			if elapsed > 0 {
-				bytesPerSec := float64(totalSize-lastSize) / elapsed
-				if bytesPerSec > 1024*1024 {
-					speed = fmt.Sprintf("%.1f MB/s", bytesPerSec/(1024*1024))
-				} else if bytesPerSec > 1024 {
-					speed = fmt.Sprintf("%.1f KB/s", bytesPerSec/1024)
-				} else {
-					speed = fmt.Sprintf("%.1f B/s", bytesPerSec)
-				}
-			} else {
-				speed = "计算中..."
-			}
-			
-			// 发送进度更新
-			h.wsHub.BroadcastProgress(websocket.ProgressData{
-				TaskID:      taskID,
-				Progress:    float64(fileCount * 5), // 简单的进度估算
-				Speed:       speed,
-				ETA:         "计算中...",
-				Transferred: totalSize,
-				Total:       totalSize + 10*1024*1024, // 估算总大小
-			})
-			
-			lastSize = totalSize
-		}
-	}
-}
-
-// scanDownloadDirectory 扫描下载目录统计文件数量和大小
-func (h *DownloadHandler) scanDownloadDirectory(dir string) (fileCount int, totalSize int64) {
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // 忽略错误，继续扫描
-		}
-		if !info.IsDir() && filepath.Ext(info.Name()) != ".tmp" {
-			fileCount++
-			totalSize += info.Size()
-		}
-		return nil
-	})
-	return
+	return nil
 }
-
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/web/backend/service"
+	"github.com/iyear/tdl/web/backend/websocket"
+)
+
+// 导出任务的类型，同时用作调度器和任务仓库中的任务类型
+const (
+	exportTypeMessages = "export"
+	exportTypeUsers    = "users"
+)
+
+// ExportTaskInfo 聊天消息导出或成员导出任务
+type ExportTaskInfo struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"` // export, users
+	Name       string                 `json:"name"`
+	Status     string                 `json:"status"`
+	Chat       string                 `json:"chat"`
+	Count      int64                  `json:"count"` // 已导出的消息数或成员数
+	Speed      string                 `json:"speed"`
+	OutputFile string                 `json:"output_file"`
+	CreatedAt  time.Time              `json:"created_at"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Config     map[string]interface{} `json:"config,omitempty"`
+	ClientID   string                 `json:"client_id,omitempty"`  // 创建任务的操作员
+	AccountID  int64                  `json:"account_id,omitempty"` // 执行任务的Telegram账号
+}
+
+// exportRunner 使用账号的客户端执行导出，progress接收已导出的数量
+type exportRunner func(ctx context.Context, acc *service.AccountClient, progress func(count int64)) error
+
+// exportTasks 导出任务的内存缓存
+type exportTasks struct {
+	mu    sync.Mutex
+	tasks map[string]ExportTaskInfo
+}
+
+// runExport 将导出任务提交到调度器排队执行，并统一维护任务状态和WebSocket通知
+func (h *ChatHandler) runExport(task ExportTaskInfo, priority int, run exportRunner) error {
+	if err := createTask(h.ctx, h.tasks, h.scheduler, task.ID, task.Type, task.Status, task.CreatedAt, task); err != nil {
+		return err
+	}
+	h.exports.mu.Lock()
+	h.exports.tasks[task.ID] = task
+	h.exports.mu.Unlock()
+
+	_, err := h.scheduler.Submit(service.Job{
+		ID:        task.ID,
+		Type:      task.Type,
+		Priority:  priority,
+		ClientID:  task.ClientID,
+		AccountID: task.AccountID,
+		Run: func(taskCtx context.Context) error {
+			h.updateExportTask(task.ID, true, func(t *ExportTaskInfo) {
+				t.Status = "running"
+				t.Error = ""
+			})
+			h.wsHub.BroadcastTaskStatus(task.AccountID, websocket.MessageTypeTaskStart, websocket.TaskData{
+				TaskID:   task.ID,
+				TaskType: task.Type,
+				Status:   "running",
+				Message:  "Export task started",
+			})
+
+			progress := newExportProgress(h, task.ID, task.Type, task.AccountID)
+			err := h.clients.Run(taskCtx, task.ClientID, task.AccountID, func(ctx context.Context, acc *service.AccountClient) error {
+				return run(ctx, acc, progress.update)
+			})
+
+			switch {
+			case taskCtx.Err() != nil:
+				// 任务被取消，状态已由CancelExportTask更新，不保留不完整的结果
+				_ = os.Remove(task.OutputFile)
+				logctx.From(h.ctx).Info("Export task cancelled", zap.String("task_id", task.ID))
+			case err != nil:
+				_ = os.Remove(task.OutputFile)
+				logctx.From(h.ctx).Error("Export task failed",
+					zap.String("task_id", task.ID),
+					zap.Error(err))
+				h.finishExportTask(task.ID, progress.count(), "error", err.Error())
+				h.wsHub.BroadcastTaskStatus(task.AccountID, websocket.MessageTypeTaskError, websocket.TaskData{
+					TaskID:   task.ID,
+					TaskType: task.Type,
+					Status:   "error",
+					Message:  err.Error(),
+				})
+			default:
+				logctx.From(h.ctx).Info("Export task completed",
+					zap.String("task_id", task.ID),
+					zap.String("output_file", task.OutputFile))
+				h.finishExportTask(task.ID, progress.count(), "completed", "")
+				h.wsHub.BroadcastTaskStatus(task.AccountID, websocket.MessageTypeTaskEnd, websocket.TaskData{
+					TaskID:   task.ID,
+					TaskType: task.Type,
+					Status:   "completed",
+					Message:  fmt.Sprintf("Exported %d items", progress.count()),
+				})
+			}
+			return err
+		},
+	})
+	return err
+}
+
+// GetExportTasks 获取导出任务列表，按创建时间倒序
+func (h *ChatHandler) GetExportTasks(c *gin.Context) {
+	h.exports.mu.Lock()
+	tasks := make([]ExportTaskInfo, 0, len(h.exports.tasks))
+	for _, task := range h.exports.tasks {
+		if canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
+			tasks = append(tasks, task)
+		}
+	}
+	h.exports.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].CreatedAt.After(tasks[j].CreatedAt) })
+
+	Success(c, map[string]interface{}{
+		"tasks": tasks,
+		"total": len(tasks),
+	})
+}
+
+// GetExportTaskDetails 获取导出任务详情
+func (h *ChatHandler) GetExportTaskDetails(c *gin.Context) {
+	task, ok := h.accessibleExportTask(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	Success(c, task)
+}
+
+// CancelExportTask 取消排队或运行中的导出任务，已结束的任务删除记录，导出的文件保留
+func (h *ChatHandler) CancelExportTask(c *gin.Context) {
+	taskID := c.Param("id")
+	task, ok := h.accessibleExportTask(c, taskID)
+	if !ok {
+		return
+	}
+
+	if _, found := h.scheduler.Cancel(taskID); !found && !isUnfinishedStatus(task.Status) {
+		h.exports.mu.Lock()
+		delete(h.exports.tasks, taskID)
+		h.exports.mu.Unlock()
+		deletePersistedTask(h.ctx, h.tasks, taskID)
+
+		SuccessWithMessage(c, nil, "Export task deleted successfully")
+		return
+	}
+
+	h.finishExportTask(taskID, task.Count, "cancelled", "")
+	h.wsHub.BroadcastTaskStatus(task.AccountID, websocket.MessageTypeTaskEnd, websocket.TaskData{
+		TaskID:   taskID,
+		TaskType: task.Type,
+		Status:   "cancelled",
+		Message:  "Task cancelled by user",
+	})
+
+	SuccessWithMessage(c, nil, "Export task cancelled successfully")
+}
+
+// GetExportResult 返回已完成导出任务的JSON文件，消息导出的结果可以直接用于/download/import
+func (h *ChatHandler) GetExportResult(c *gin.Context) {
+	task, ok := h.accessibleExportTask(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if task.Status != "completed" {
+		ValidationError(c, fmt.Sprintf("Task is %s, result is not available", task.Status))
+		return
+	}
+
+	if _, err := os.Stat(task.OutputFile); err != nil {
+		NotFoundError(c, "Export file not found")
+		return
+	}
+
+	c.FileAttachment(task.OutputFile, filepath.Base(task.OutputFile))
+}
+
+// exportProgress 将导出的数量同步到任务和WebSocket
+type exportProgress struct {
+	h        *ChatHandler
+	taskID   string
+	taskType string
+	account  int64 // 执行导出的账号，用于路由WebSocket事件
+
+	mu          sync.Mutex
+	start       time.Time
+	exported    int64
+	lastEmit    time.Time
+	lastPersist time.Time
+}
+
+func newExportProgress(h *ChatHandler, taskID, taskType string, account int64) *exportProgress {
+	return &exportProgress{
+		h:        h,
+		taskID:   taskID,
+		taskType: taskType,
+		account:  account,
+		start:    time.Now(),
+	}
+}
+
+func (p *exportProgress) update(count int64) {
+	p.mu.Lock()
+	p.exported = count
+
+	now := time.Now()
+	if now.Sub(p.lastEmit) < progressEmitInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastEmit = now
+
+	persist := false
+	if now.Sub(p.lastPersist) >= progressPersistInterval {
+		p.lastPersist = now
+		persist = true
+	}
+
+	speed := fmt.Sprintf("%.1f msg/s", float64(count)/now.Sub(p.start).Seconds())
+	if p.taskType == exportTypeUsers {
+		speed = fmt.Sprintf("%.1f users/s", float64(count)/now.Sub(p.start).Seconds())
+	}
+	p.mu.Unlock()
+
+	p.h.wsHub.BroadcastProgress(p.account, websocket.ProgressData{
+		TaskID:      p.taskID,
+		TaskType:    p.taskType,
+		Speed:       speed,
+		ETA:         "--",
+		Transferred: count,
+	})
+	p.h.updateExportTask(p.taskID, persist, func(t *ExportTaskInfo) {
+		if t.Status == "running" {
+			t.Count = count
+			t.Speed = speed
+		}
+	})
+}
+
+func (p *exportProgress) count() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exported
+}
+
+// finishExportTask 将任务标记为结束状态
+func (h *ChatHandler) finishExportTask(taskID string, count int64, status, errorMsg string) {
+	now := time.Now()
+	h.updateExportTask(taskID, true, func(t *ExportTaskInfo) {
+		t.Status = status
+		t.Count = count
+		t.Speed = ""
+		t.Error = errorMsg
+		t.FinishedAt = &now
+	})
+}
+
+// updateExportTask 修改任务，persist为false时只更新内存缓存
+func (h *ChatHandler) updateExportTask(taskID string, persist bool, fn func(t *ExportTaskInfo)) {
+	h.exports.mu.Lock()
+	task, ok := h.exports.tasks[taskID]
+	if !ok {
+		h.exports.mu.Unlock()
+		return
+	}
+	fn(&task)
+	h.exports.tasks[taskID] = task
+	h.exports.mu.Unlock()
+
+	if persist {
+		persistTask(h.ctx, h.tasks, task.ID, task.Type, task.Status, task.CreatedAt, task)
+	}
+}
+
+// saveExportTask 保存任务到内存缓存和任务仓库
+func (h *ChatHandler) saveExportTask(task ExportTaskInfo) {
+	h.exports.mu.Lock()
+	h.exports.tasks[task.ID] = task
+	h.exports.mu.Unlock()
+
+	persistTask(h.ctx, h.tasks, task.ID, task.Type, task.Status, task.CreatedAt, task)
+}
+
+// getExportTask 获取导出任务信息
+func (h *ChatHandler) getExportTask(taskID string) (ExportTaskInfo, bool) {
+	h.exports.mu.Lock()
+	defer h.exports.mu.Unlock()
+
+	task, ok := h.exports.tasks[taskID]
+	return task, ok
+}
+
+// accessibleExportTask 获取发起请求的客户端可以访问的导出任务，不存在或无权访问时返回404
+func (h *ChatHandler) accessibleExportTask(c *gin.Context, taskID string) (ExportTaskInfo, bool) {
+	task, ok := h.getExportTask(taskID)
+	if !ok || !canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
+		NotFoundError(c, "Task not found")
+		return ExportTaskInfo{}, false
+	}
+	return task, true
+}
+
+// restoreExportTasks 从任务仓库加载历史导出任务，未完成的任务标记为中断
+func (h *ChatHandler) restoreExportTasks() {
+	for _, taskType := range []string{exportTypeMessages, exportTypeUsers} {
+		loadPersistedTasks(h.ctx, h.tasks, taskType, func(data []byte) error {
+			var task ExportTaskInfo
+			if err := json.Unmarshal(data, &task); err != nil {
+				return err
+			}
+
+			if isUnfinishedStatus(task.Status) {
+				task.Status = TaskStatusInterrupted
+				task.Error = taskInterruptedMessage
+				task.Speed = ""
+				h.saveExportTask(task)
+				return nil
+			}
+
+			h.exports.mu.Lock()
+			h.exports.tasks[task.ID] = task
+			h.exports.mu.Unlock()
+			return nil
+		})
+	}
+}
+
+// chatName 任务名称中显示的聊天，为空时为收藏夹
+func chatName(chat string) string {
+	if chat == "" {
+		return "Saved Messages"
+	}
+	return chat
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+// FilesHandler 浏览和管理下载根目录中的文件，用户无法登录运行tdl web的机器时使用
+type FilesHandler struct {
+	ctx   context.Context
+	paths *service.PathPolicy
+}
+
+func NewFilesHandler(ctx context.Context, paths *service.PathPolicy) *FilesHandler {
+	return &FilesHandler{
+		ctx:   ctx,
+		paths: paths,
+	}
+}
+
+// FileEntry 目录中的文件或子目录
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // 绝对路径，用于后续的文件操作
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// MkdirRequest 创建目录请求
+type MkdirRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// RenameRequest 重命名请求，只修改名称，不移动到其他目录
+type RenameRequest struct {
+	Path string `json:"path" binding:"required"`
+	Name string `json:"name" binding:"required"`
+}
+
+// ListFiles 列出目录中的文件，未指定path时列出所有根目录
+//
+// scope=upload时浏览上传根目录，用于选择服务器本地的上传文件，其余操作只允许在下载根目录中进行
+func (h *FilesHandler) ListFiles(c *gin.Context) {
+	sandbox := h.paths.Download
+	if c.Query("scope") == "upload" {
+		sandbox = h.paths.Upload
+	}
+
+	path := c.Query("path")
+	if path == "" {
+		roots := sandbox.Roots()
+		entries := make([]FileEntry, 0, len(roots))
+		for _, root := range roots {
+			info, err := os.Stat(root)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, FileEntry{
+				Name:    root,
+				Path:    root,
+				IsDir:   true,
+				ModTime: info.ModTime(),
+			})
+		}
+
+		Success(c, map[string]interface{}{
+			"path":    "",
+			"entries": entries,
+			"total":   len(entries),
+		})
+		return
+	}
+
+	dir, ok := resolvePath(c, sandbox, path)
+	if !ok {
+		return
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			NotFoundError(c, "Directory not found")
+			return
+		}
+		InternalError(c, "Failed to read directory", err)
+		return
+	}
+
+	entries := make([]FileEntry, 0, len(des))
+	for _, de := range des {
+		path := filepath.Join(dir, de.Name())
+		if _, err := sandbox.Resolve(path); err != nil {
+			continue // 不允许访问的条目，如数据目录
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue // 读取期间被删除
+		}
+		entries = append(entries, FileEntry{
+			Name:    de.Name(),
+			Path:    path,
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	// 目录在前，其余按名称排序
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+
+	var parent string
+	if !sandbox.IsRoot(dir) {
+		parent = filepath.Dir(dir)
+	}
+
+	Success(c, map[string]interface{}{
+		"path":    dir,
+		"parent":  parent, // 为空表示已在根目录
+		"entries": entries,
+		"total":   len(entries),
+	})
+}
+
+// Mkdir 创建目录，上级目录不存在时一并创建
+func (h *FilesHandler) Mkdir(c *gin.Context) {
+	var req MkdirRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	dir, ok := resolvePath(c, h.paths.Download, req.Path)
+	if !ok {
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		InternalError(c, "Failed to create directory", err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]string{
+		"path": dir,
+	}, "Directory created")
+}
+
+// RenameFile 重命名文件或目录，新名称会被清理，不能移出当前目录
+func (h *FilesHandler) RenameFile(c *gin.Context) {
+	var req RenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	path, ok := h.resolveExisting(c, req.Path)
+	if !ok {
+		return
+	}
+
+	target, err := h.paths.Download.Join(filepath.Dir(path), req.Name)
+	if err != nil {
+		PathForbiddenError(c, fmt.Sprintf("Invalid name %q: %v", req.Name, err))
+		return
+	}
+	if _, err = os.Lstat(target); err == nil {
+		ValidationError(c, fmt.Sprintf("%s already exists", filepath.Base(target)))
+		return
+	}
+
+	if err = os.Rename(path, target); err != nil {
+		InternalError(c, "Failed to rename file", err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]string{
+		"path": target,
+	}, "File renamed")
+}
+
+// DeleteFile 删除文件，删除非空目录需要recursive=true
+func (h *FilesHandler) DeleteFile(c *gin.Context) {
+	path, ok := h.resolveExisting(c, c.Query("path"))
+	if !ok {
+		return
+	}
+
+	var err error
+	if c.Query("recursive") == "true" {
+		err = os.RemoveAll(path)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		InternalError(c, "Failed to delete file", err)
+		return
+	}
+
+	SuccessWithMessage(c, nil, "File deleted")
+}
+
+// DownloadFile 将文件发送给浏览器
+func (h *FilesHandler) DownloadFile(c *gin.Context) {
+	path, ok := resolvePath(c, h.paths.Download, c.Query("path"))
+	if !ok {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		NotFoundError(c, "File not found")
+		return
+	}
+	if !info.Mode().IsRegular() {
+		ValidationError(c, "Only regular files can be downloaded")
+		return
+	}
+
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// resolveExisting 解析需要修改的已存在路径，根目录本身不能被修改
+func (h *FilesHandler) resolveExisting(c *gin.Context, path string) (string, bool) {
+	if path == "" {
+		ValidationError(c, "path is required")
+		return "", false
+	}
+
+	resolved, ok := resolvePath(c, h.paths.Download, path)
+	if !ok {
+		return "", false
+	}
+	if h.paths.Download.IsRoot(resolved) {
+		PathForbiddenError(c, "Root directories can't be modified")
+		return "", false
+	}
+	if _, err := os.Lstat(resolved); err != nil {
+		NotFoundError(c, "File not found")
+		return "", false
+	}
+
+	return resolved, true
+}
+
+// writeZip 将文件以流的方式打包为zip写入响应，base为zip中路径的相对起点
+//
+// 媒体文件大多已经压缩，使用Store避免无意义的CPU消耗。不在根目录中或已被删除的文件会被跳过
+func writeZip(ctx context.Context, c *gin.Context, sandbox *service.Sandbox, name, base string, files []string) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	zw := zip.NewWriter(c.Writer)
+	defer func() {
+		if err := zw.Close(); err != nil {
+			logctx.From(ctx).Warn("Failed to finish zip", zap.String("name", name), zap.Error(err))
+		}
+	}()
+
+	used := make(map[string]bool, len(files))
+	for _, file := range files {
+		if c.Request.Context().Err() != nil {
+			return // 客户端已断开
+		}
+
+		path, err := sandbox.Resolve(file)
+		if err != nil {
+			continue
+		}
+
+		// 任务目录中的文件保留相对路径，其他文件只保留文件名，重名时加上序号
+		rel, err := filepath.Rel(base, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = filepath.Base(path)
+		}
+		rel = filepath.ToSlash(rel)
+		entry := rel
+		for i := 1; used[entry]; i++ {
+			ext := filepath.Ext(rel)
+			entry = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(rel, ext), i, ext)
+		}
+
+		if err = addZipFile(zw, path, entry); err != nil {
+			logctx.From(ctx).Warn("Failed to add file to zip",
+				zap.String("file", path),
+				zap.Error(err))
+			if os.IsNotExist(err) {
+				continue
+			}
+			return // 响应已经开始，无法再返回错误
+		}
+		used[entry] = true
+	}
+}
+
+func addZipFile(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return os.ErrNotExist
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Store
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -2,8 +2,7 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -11,14 +10,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-faster/errors"
-	"github.com/gotd/td/telegram"
+	"go.uber.org/zap"
 
 	"github.com/iyear/tdl/app/forward"
 	"github.com/iyear/tdl/core/forwarder"
 	"github.com/iyear/tdl/core/logctx"
-	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/pkg/kv"
-	tclientpkg "github.com/iyear/tdl/pkg/tclient"
+	"github.com/iyear/tdl/web/backend/middleware"
 	"github.com/iyear/tdl/web/backend/service"
 	"github.com/iyear/tdl/web/backend/websocket"
 )
@@ -28,24 +26,33 @@ type ForwardHandler struct {
 	kvd         kv.Storage
 	wsHub       *websocket.Hub
 	authService *service.AuthService
-	activeTasks sync.Map // taskID -> context.CancelFunc
-	taskStore   sync.Map // taskID -> TaskInfo (in-memory storage)
+	clients     *service.ClientManager
+	paths       *service.PathPolicy
+	tasks       *service.TaskRepository
+	scheduler   *service.Scheduler
+	taskStore   sync.Map // taskID -> ForwardTaskInfo (in-memory cache of persisted tasks)
 }
 
-func NewForwardHandler(ctx context.Context, kvd kv.Storage, wsHub *websocket.Hub) *ForwardHandler {
-	return &ForwardHandler{
+func NewForwardHandler(ctx context.Context, kvd kv.Storage, authService *service.AuthService, wsHub *websocket.Hub, clients *service.ClientManager, paths *service.PathPolicy, tasks *service.TaskRepository, scheduler *service.Scheduler) *ForwardHandler {
+	h := &ForwardHandler{
 		ctx:         ctx,
 		kvd:         kvd,
 		wsHub:       wsHub,
-		authService: service.NewAuthService(ctx, kvd),
-		activeTasks: sync.Map{},
+		authService: authService,
+		clients:     clients,
+		paths:       paths,
+		tasks:       tasks,
+		scheduler:   scheduler,
 		taskStore:   sync.Map{},
 	}
+
+	h.restoreTasks()
+	return h
 }
 
 // ForwardRequest represents a forward request from web interface
 type ForwardRequest struct {
-	FromSources []string `json:"from_sources" binding:"required"` // 消息来源：文件路径或URL
+	FromSources []string `json:"from_sources" binding:"required"` // 消息来源：导出根目录中的文件路径或URL
 	ToChat      string   `json:"to_chat"`                         // 目标聊天ID或用户名（空字符串表示Saved Messages）
 	EditText    string   `json:"edit_text"`                       // 编辑消息文本（可选）
 	Mode        string   `json:"mode"`                            // 转发模式：direct, clone
@@ -54,6 +61,8 @@ type ForwardRequest struct {
 	Single      bool     `json:"single"`                          // 逐个转发而不是分组
 	Desc        bool     `json:"desc"`                            // 降序转发
 	TaskID      string   `json:"task_id"`                         // 任务ID
+	Priority    int      `json:"priority"`                        // 排队优先级，数值越大越先执行
+	AccountID   int64    `json:"account_id,omitempty"`            // 执行任务的Telegram账号，为空时使用当前账号
 }
 
 // ForwardTaskInfo represents forward task information
@@ -74,11 +83,14 @@ type ForwardTaskInfo struct {
 	FromSources   []string               `json:"from_sources"`  // 消息来源
 	ToChat        string                 `json:"to_chat"`       // 目标聊天
 	MessageStats  []MessageStat          `json:"message_stats"` // 消息统计
+	ClientID      string                 `json:"client_id,omitempty"` // 创建任务的操作员
+	AccountID     int64                  `json:"account_id,omitempty"` // 执行任务的Telegram账号
 }
 
 // MessageStat represents single message forward statistics
 type MessageStat struct {
 	FromChat    string `json:"from_chat"`
+	FromID      int64  `json:"from_id"` // 来源聊天ID，只重试失败消息时使用
 	MessageID   int    `json:"message_id"`
 	ToChat      string `json:"to_chat"`
 	Status      string `json:"status"` // pending, success, failed
@@ -106,30 +118,46 @@ func (h *ForwardHandler) StartForward(c *gin.Context) {
 		return
 	}
 
+	sources, ok := h.resolveSources(c, req.FromSources)
+	if !ok {
+		return
+	}
+	req.FromSources = sources
+
 	// 生成任务ID
 	taskID := req.TaskID
 	if taskID == "" {
-		taskID = fmt.Sprintf("forward-%d-%s", time.Now().Unix(), h.generateShortID())
+		taskID = newTaskID("forward")
 	}
 
 	// 解析转发模式
-	mode := forwarder.ModeDirect
-	switch strings.ToLower(req.Mode) {
-	case "clone":
-		mode = forwarder.ModeClone
-	case "direct", "":
-		mode = forwarder.ModeDirect
-	default:
-		ValidationError(c, "Invalid forward mode. Use 'direct' or 'clone'")
+	mode, err := parseForwardMode(req.Mode)
+	if err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	// 在请求上下文中识别客户端，协程中不能再访问gin.Context
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		InternalError(c, "Failed to identify client", err)
+		return
+	}
+
+	// 确定任务使用的账号，未指定时使用当前账号
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
+	if err != nil {
+		AccountError(c, err)
 		return
 	}
+	req.AccountID = accountID
 
 	// 保存任务信息
 	taskInfo := ForwardTaskInfo{
 		ID:          taskID,
 		Type:        "forward",
 		Name:        fmt.Sprintf("转发任务: %s -> %s", strings.Join(req.FromSources, ", "), req.ToChat),
-		Status:      "pending",
+		Status:      "queued",
 		Progress:    0,
 		Speed:       "0 msg/s",
 		ETA:         "--",
@@ -143,96 +171,187 @@ func (h *ForwardHandler) StartForward(c *gin.Context) {
 			"forward_config": req,
 		},
 		MessageStats: []MessageStat{},
+		ClientID:     clientID,
+		AccountID:    accountID,
 	}
 
-	h.taskStore.Store(taskID, taskInfo)
-
-	// 创建带取消功能的上下文
-	taskCtx, cancel := context.WithCancel(h.ctx)
-	h.activeTasks.Store(taskID, cancel)
-
-	// 启动转发任务
-	go func() {
-		defer func() {
-			h.activeTasks.Delete(taskID)
-		}()
-
-		// 更新任务状态为运行中
-		h.updateForwardTaskStatus(taskID, "running", "", 0, 0, 0)
-
-		// 发送任务开始通知
-		h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskStart, websocket.TaskData{
-			TaskID:   taskID,
-			TaskType: "forward",
-			Status:   "running",
-			Message:  "Forward task started",
-		})
+	if err := createTask(h.ctx, h.tasks, h.scheduler, taskInfo.ID, taskInfo.Type, taskInfo.Status, taskInfo.CreatedAt, taskInfo); err != nil {
+		taskError(c, "Failed to create task", err)
+		return
+	}
+	h.taskStore.Store(taskInfo.ID, taskInfo)
 
-		// 获取客户端ID
-		clientID, err := h.getClientID(c)
-		if err != nil {
-			fmt.Printf("Forward: Failed to get client ID: %v\n", err)
-			h.updateForwardTaskStatus(taskID, "error", fmt.Sprintf("Failed to get client ID: %v", err), 0, 0, 0)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
-				TaskID:   taskID,
-				TaskType: "forward",
-				Status:   "error",
-				Message:  fmt.Sprintf("Failed to get client ID: %v", err),
-			})
-			return
-		}
+	if err := h.runForward(taskID, req, clientID, mode, "Forward task started", nil); err != nil {
+		taskError(c, "Failed to queue task", err)
+		return
+	}
 
-		fmt.Printf("Forward: Using clientID: %s\n", clientID)
+	SuccessWithMessage(c, map[string]string{
+		"task_id": taskID,
+	}, "Forward task queued")
+}
 
-		// 执行真实的转发任务
-		err = h.executeRealForward(taskCtx, req, taskID, clientID, mode)
-		if err != nil {
-			fmt.Printf("Forward error: %v\n", err)
-			h.updateForwardTaskStatus(taskID, "error", err.Error(), 0, 0, 0)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
+// runForward 将转发任务提交到调度器排队执行，并统一维护任务状态和WebSocket通知
+//
+// tempFiles为执行结束后需要删除的临时文件
+func (h *ForwardHandler) runForward(taskID string, req ForwardRequest, clientID string, mode forwarder.Mode, startMsg string, tempFiles []string) error {
+	_, err := h.scheduler.Submit(service.Job{
+		ID:        taskID,
+		Type:      "forward",
+		Priority:  req.Priority,
+		ClientID:  clientID,
+		AccountID: req.AccountID,
+		Run: func(taskCtx context.Context) error {
+			defer removeFiles(tempFiles)
+
+			// 更新任务状态为运行中
+			h.updateForwardTaskStatus(taskID, "running", "", 0)
+
+			// 发送任务开始通知
+			h.wsHub.BroadcastTaskStatus(req.AccountID, websocket.MessageTypeTaskStart, websocket.TaskData{
 				TaskID:   taskID,
 				TaskType: "forward",
-				Status:   "error",
-				Message:  err.Error(),
+				Status:   "running",
+				Message:  startMsg,
 			})
-			return
-		}
-
-		fmt.Printf("Forward completed successfully\n")
 
-		// 检查是否被取消
-		err = taskCtx.Err()
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
+			// 执行真实的转发任务
+			err := h.executeRealForward(taskCtx, req, taskID, clientID, mode)
+			switch {
+			case taskCtx.Err() != nil:
+				// 任务被取消，状态已由CancelForwardTask更新
+				logctx.From(h.ctx).Info("Forward task cancelled", zap.String("task_id", taskID))
+			case err != nil:
+				logctx.From(h.ctx).Error("Forward task failed", zap.String("task_id", taskID), zap.Error(err))
+				h.updateForwardTaskStatus(taskID, "error", err.Error(), 0)
+				h.wsHub.BroadcastTaskStatus(req.AccountID, websocket.MessageTypeTaskError, websocket.TaskData{
 					TaskID:   taskID,
 					TaskType: "forward",
-					Status:   "cancelled",
-					Message:  "Forward task cancelled by user",
+					Status:   "error",
+					Message:  err.Error(),
 				})
-			} else {
-				h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskError, websocket.TaskData{
+			default:
+				// 任务完成
+				h.updateForwardTaskStatus(taskID, "completed", "", 100)
+				h.wsHub.BroadcastTaskStatus(req.AccountID, websocket.MessageTypeTaskEnd, websocket.TaskData{
 					TaskID:   taskID,
 					TaskType: "forward",
-					Status:   "error",
-					Message:  err.Error(),
+					Status:   "completed",
+					Message:  "Forward task completed successfully",
 				})
 			}
-		} else {
-			// 任务完成
-			h.updateForwardTaskStatus(taskID, "completed", "", 100, 0, 0)
-			h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
-				TaskID:   taskID,
-				TaskType: "forward",
-				Status:   "completed",
-				Message:  "Forward task completed successfully",
-			})
+			return err
+		},
+	})
+	return err
+}
+
+// resolveSources 将本地文件来源解析为导出根目录中的路径，URL来源保持不变，失败时写入响应并返回false
+//
+// 与forward.Run一致，以http开头的来源视为消息链接
+func (h *ForwardHandler) resolveSources(c *gin.Context, sources []string) ([]string, bool) {
+	resolved := make([]string, 0, len(sources))
+	for _, source := range sources {
+		switch {
+		case source == "":
+			ValidationError(c, "Empty source")
+			return nil, false
+		case strings.HasPrefix(source, "http"):
+			resolved = append(resolved, source)
+		default:
+			path, ok := resolvePath(c, h.paths.Export, source)
+			if !ok {
+				return nil, false
+			}
+			resolved = append(resolved, path)
+		}
+	}
+
+	return resolved, true
+}
+
+// parseForwardMode 解析转发模式
+func parseForwardMode(mode string) (forwarder.Mode, error) {
+	switch strings.ToLower(mode) {
+	case "clone":
+		return forwarder.ModeClone, nil
+	case "direct", "":
+		return forwarder.ModeDirect, nil
+	default:
+		return 0, errors.New("Invalid forward mode. Use 'direct' or 'clone'")
+	}
+}
+
+// RetryForwardTask 使用保存的配置重新执行转发任务，failed_only时只转发上次失败的消息
+func (h *ForwardHandler) RetryForwardTask(c *gin.Context) {
+	taskID := c.Param("id")
+	if taskID == "" {
+		ValidationError(c, "task ID is required")
+		return
+	}
+
+	retry, ok := bindRetryRequest(c)
+	if !ok {
+		return
+	}
+
+	task, exists := h.accessibleTask(c, taskID)
+	if !exists {
+		return
+	}
+
+	if isUnfinishedStatus(task.Status) || h.scheduler.Active(taskID) {
+		ValidationError(c, "Task is still running")
+		return
+	}
+
+	var req ForwardRequest
+	if task.ClientID == "" || !decodeTaskConfig(task.Config, "forward_config", &req) {
+		ValidationError(c, "Task cannot be retried")
+		return
+	}
+
+	mode, err := parseForwardMode(req.Mode)
+	if err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	// 根目录配置可能在任务创建后发生变化，重试时重新校验
+	if req.FromSources, ok = h.resolveSources(c, req.FromSources); !ok {
+		return
+	}
+
+	var tempFiles []string
+	if retry.FailedOnly {
+		messages := make(map[int64][]int)
+		for _, stat := range task.MessageStats {
+			if stat.Status == "failed" && stat.FromID != 0 {
+				messages[stat.FromID] = append(messages[stat.FromID], stat.MessageID)
+			}
+		}
+		if len(messages) == 0 {
+			ValidationError(c, "Task has no failed messages")
+			return
 		}
-	}()
+
+		if tempFiles, err = writeMessageFiles("retry_"+taskID, messages); err != nil {
+			InternalError(c, "Failed to retry task", err)
+			return
+		}
+		req.FromSources = tempFiles
+	}
+
+	h.updateForwardTaskStatus(taskID, "queued", "", 0)
+	if err = h.runForward(taskID, req, task.ClientID, mode, "Forward task retried", tempFiles); err != nil {
+		removeFiles(tempFiles)
+		taskError(c, "Failed to retry task", err)
+		return
+	}
 
 	SuccessWithMessage(c, map[string]string{
 		"task_id": taskID,
-	}, "Forward task started")
+	}, "Task queued for retry")
 }
 
 // GetForwardTasks 获取转发任务列表
@@ -241,16 +360,7 @@ func (h *ForwardHandler) GetForwardTasks(c *gin.Context) {
 
 	// 从内存存储获取任务
 	h.taskStore.Range(func(key, value interface{}) bool {
-		if task, ok := value.(ForwardTaskInfo); ok {
-			// 检查任务是否仍在运行
-			if task.Status == "running" {
-				if _, exists := h.activeTasks.Load(task.ID); !exists {
-					// 任务不在活动列表中，可能已经停止
-					task.Status = "error"
-					task.Error = "Task was interrupted"
-					h.taskStore.Store(task.ID, task)
-				}
-			}
+		if task, ok := value.(ForwardTaskInfo); ok && canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
 			tasks = append(tasks, task)
 		}
 		return true
@@ -270,19 +380,28 @@ func (h *ForwardHandler) CancelForwardTask(c *gin.Context) {
 		return
 	}
 
-	// 取消活动任务
-	if cancelFunc, exists := h.activeTasks.Load(taskID); exists {
-		if cancel, ok := cancelFunc.(context.CancelFunc); ok {
-			cancel()
+	task, ok := h.accessibleTask(c, taskID)
+	if !ok {
+		return
+	}
+
+	// 取消排队或运行中的任务，已结束的任务直接删除记录
+	if _, found := h.scheduler.Cancel(taskID); !found {
+		if !isUnfinishedStatus(task.Status) {
+			h.taskStore.Delete(taskID)
+			deletePersistedTask(h.ctx, h.tasks, taskID)
+
+			SuccessWithMessage(c, nil, "Forward task deleted successfully")
+			return
 		}
-		h.activeTasks.Delete(taskID)
 	}
 
 	// 更新任务状态
-	h.updateForwardTaskStatus(taskID, "cancelled", "", 0, 0, 0)
+	h.updateForwardTaskStatus(taskID, "cancelled", "", 0)
+	account := h.taskAccount(taskID)
 
 	// 发送WebSocket通知
-	h.wsHub.BroadcastTaskStatus(websocket.MessageTypeTaskEnd, websocket.TaskData{
+	h.wsHub.BroadcastTaskStatus(account, websocket.MessageTypeTaskEnd, websocket.TaskData{
 		TaskID:   taskID,
 		TaskType: "forward",
 		Status:   "cancelled",
@@ -300,145 +419,24 @@ func (h *ForwardHandler) GetForwardTaskDetails(c *gin.Context) {
 		return
 	}
 
-	task, exists := h.getForwardTaskInfo(taskID)
+	task, exists := h.accessibleTask(c, taskID)
 	if !exists {
-		NotFoundError(c, "Task not found")
 		return
 	}
 
 	Success(c, task)
 }
 
-// getClientID 获取客户端ID，与ChatHandler和DownloadHandler保持完全一致的识别机制
-func (h *ForwardHandler) getClientID(c *gin.Context) (string, error) {
-	const clientIDCookie = "tdl_client_id"
-	const clientIDHeader = "X-TDL-Client-ID"
-
-	// 1. 优先从Cookie获取客户端ID
-	if clientID, err := c.Cookie(clientIDCookie); err == nil && clientID != "" {
-		return clientID, nil
-	}
-
-	// 2. 从Header获取客户端ID
-	if clientID := c.GetHeader(clientIDHeader); clientID != "" {
-		// 设置cookie以便后续请求使用
-		c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-		return clientID, nil
-	}
-
-	// 3. 生成新的客户端ID
-	clientID, err := h.generateClientID()
-	if err != nil {
-		// 如果生成失败，回退到IP地址
-		fmt.Printf("Failed to generate client ID, fallback to IP: %v\n", err)
-		clientIP := c.ClientIP()
-		// 使用与util.SafeClientID相同的逻辑
-		safeIP := strings.ReplaceAll(clientIP, ":", "_")
-		safeIP = strings.ReplaceAll(safeIP, ".", "_")
-		return fmt.Sprintf("client_%s", safeIP), nil
-	}
-
-	// 4. 设置cookie并返回
-	c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-	return clientID, nil
-}
-
-// generateClientID 生成唯一的客户端ID
-func (h *ForwardHandler) generateClientID() (string, error) {
-	bytes := make([]byte, 16)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", fmt.Errorf("generate random bytes: %w", err)
-	}
-	return "client_" + hex.EncodeToString(bytes), nil
-}
-
-// generateShortID 生成短ID
-func (h *ForwardHandler) generateShortID() string {
-	bytes := make([]byte, 4)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
-}
-
-// createTelegramClientForUser 为特定用户创建Telegram客户端，复制其他Handler的逻辑
-func (h *ForwardHandler) createTelegramClientForUser(clientID string) (*telegram.Client, storage.Storage, error) {
-	// 获取Telegram ID
-	telegramID, err := h.authService.GetAuthenticatedTelegramID(clientID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get authenticated telegram id: %w", err)
-	}
-
-	// 使用用户命名空间
-	namespace := fmt.Sprintf("user_%d", telegramID)
-	return h.createTelegramClient(namespace)
-}
-
-// createTelegramClient 创建Telegram客户端，使用与CLI相同的配置
-func (h *ForwardHandler) createTelegramClient(namespace string) (*telegram.Client, storage.Storage, error) {
-	// 通过kv.Storage获取storage.Storage实例
-	storageInstance, err := h.kvd.Open(namespace)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open storage namespace: %w", err)
-	}
-
-	// 获取当前设置
-	settingsHandler := NewSettingsHandler(h.ctx, h.kvd)
-	settings, err := settingsHandler.GetCurrentSettings()
-	if err != nil {
-		fmt.Printf("Failed to load settings, using defaults: %v\n", err)
-		settings = &Settings{
-			GlobalProxy:      "",
-			ReconnectTimeout: 300,
-		}
-	}
-
-	// 使用与CLI相同的tOptions配置
-	o := tclientpkg.Options{
-		KV:               storageInstance,
-		Proxy:            settings.GlobalProxy,
-		NTP:              "",
-		ReconnectTimeout: time.Duration(settings.ReconnectTimeout) * time.Second,
-		UpdateHandler:    nil,
-	}
-
-	// 记录配置信息
-	fmt.Printf("Creating Telegram client for forward with settings: proxy=%s, reconnectTimeout=%v\n",
-		o.Proxy, o.ReconnectTimeout)
-
-	// 创建客户端
-	client, err := tclientpkg.New(h.ctx, o, false) // false表示不需要登录，使用已有session
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create telegram client: %w", err)
-	}
-
-	return client, storageInstance, nil
-}
-
 // executeRealForward 执行真实的转发任务，使用CLI的完整功能
 func (h *ForwardHandler) executeRealForward(ctx context.Context, req ForwardRequest, taskID string, clientID string, mode forwarder.Mode) error {
-	fmt.Printf("executeRealForward: Starting real CLI forward for clientID: %s\n", clientID)
-
 	// 使用与Chat页面相同的认证机制
 	return h.tRunWithForward(ctx, req, taskID, clientID, mode)
 }
 
 // tRunWithForward 使用与Chat页面相同的认证机制来执行转发
 func (h *ForwardHandler) tRunWithForward(ctx context.Context, req ForwardRequest, taskID string, clientID string, mode forwarder.Mode) error {
-	fmt.Printf("tRunWithForward: Creating authenticated client for user\n")
-
-	// 使用与Chat页面完全相同的客户端创建逻辑
-	client, storageInstance, err := h.createTelegramClientForUser(clientID)
-	if err != nil {
-		fmt.Printf("tRunWithForward: Failed to create client for user: %v\n", err)
-		return errors.Wrap(err, "create telegram client for user")
-	}
-
-	fmt.Printf("tRunWithForward: Client created successfully for authenticated user\n")
-	fmt.Printf("tRunWithForward: Starting forward with authenticated client\n")
-
-	// 直接运行转发，因为客户端已经是认证用户的了
-	err = client.Run(ctx, func(ctx context.Context) error {
-		fmt.Printf("tRunWithForward: Inside client.Run, starting forward\n")
-
+	// 使用账号的长连接客户端运行转发
+	err := h.clients.Run(ctx, clientID, req.AccountID, func(ctx context.Context, acc *service.AccountClient) error {
 		// 使用CLI的forward.Run函数
 		opts := forward.Options{
 			From:   req.FromSources,
@@ -449,90 +447,105 @@ func (h *ForwardHandler) tRunWithForward(ctx context.Context, req ForwardRequest
 			DryRun: req.DryRun,
 			Single: req.Single,
 			Desc:   req.Desc,
-		}
-
-		fmt.Printf("tRunWithForward: Calling CLI forward.Run with options: %+v\n", opts)
 
-		// 创建进度监控
-		go h.monitorRealForwardProgress(ctx, taskID, req.FromSources)
+			Progress: newForwardProgress(h, taskID, acc.ID),
+			Transfer: currentTransfer(h.ctx, h.kvd),
+			Pool:     acc.Pool,
+		}
 
 		// 调用真实的CLI转发函数
-		forwardErr := forward.Run(logctx.Named(ctx, "forward"), client, storageInstance, opts)
-		fmt.Printf("tRunWithForward: forward.Run completed with error: %v\n", forwardErr)
-		return forwardErr
+		return forward.Run(logctx.Named(ctx, "forward"), acc.Client, acc.KV, opts)
 	})
-
 	if err != nil {
-		fmt.Printf("tRunWithForward: client.Run failed with error: %v\n", err)
 		return errors.Wrap(err, "run telegram client")
 	}
 
-	fmt.Printf("tRunWithForward: Successfully completed\n")
 	return nil
 }
 
-// monitorRealForwardProgress 监控真实的转发进度
-func (h *ForwardHandler) monitorRealForwardProgress(ctx context.Context, taskID string, sources []string) {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+// updateForwardTaskStatus 更新转发任务状态
+func (h *ForwardHandler) updateForwardTaskStatus(taskID, status, errorMsg string, progress float64) {
+	if value, exists := h.taskStore.Load(taskID); exists {
+		if task, ok := value.(ForwardTaskInfo); ok {
+			task.Status = status
+			task.Progress = progress
+			if errorMsg != "" {
+				task.Error = errorMsg
+			} else if status == "running" {
+				task.Error = ""
+			}
+			h.saveTask(task)
+		}
+	}
+}
 
-	startTime := time.Now()
-	lastForwarded := 0
+// updateForwardProgress 更新转发任务的进度和消息统计，persist为false时只更新内存缓存
+func (h *ForwardHandler) updateForwardProgress(taskID string, snap transferSnapshot, messages []MessageStat, persist bool) {
+	task, ok := h.getForwardTaskInfo(taskID)
+	if !ok || task.Status != "running" {
+		return
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// 模拟进度监控（实际实现中应该集成到forward进度回调）
-			elapsed := time.Since(startTime).Seconds()
-			forwarded := int(elapsed / 2) // 简单的进度模拟
-
-			// 计算速度
-			var speed string
-			if elapsed > 0 {
-				msgPerSec := float64(forwarded-lastForwarded) / 2.0 // 每2秒的消息数
-				speed = fmt.Sprintf("%.1f msg/s", msgPerSec)
-			} else {
-				speed = "计算中..."
-			}
+	task.Progress = snap.Progress
+	task.Speed = snap.Speed
+	task.ETA = snap.ETA
+	task.Forwarded = snap.Done
+	task.Failed = snap.Failed
+	task.Total = int(snap.Total)
+	task.MessageStats = messages
 
-			// 发送进度更新
-			h.wsHub.BroadcastProgress(websocket.ProgressData{
-				TaskID:      taskID,
-				Progress:    float64(forwarded * 5), // 简单的进度估算
-				Speed:       speed,
-				ETA:         "计算中...",
-				Transferred: int64(forwarded),
-				Total:       100, // 估算总数
-			})
+	if persist {
+		h.saveTask(task)
+		return
+	}
+	h.taskStore.Store(taskID, task)
+}
 
-			h.updateForwardTaskStatus(taskID, "running", "", float64(forwarded*5), forwarded, 0)
+// saveTask 保存任务到内存缓存和任务仓库
+func (h *ForwardHandler) saveTask(task ForwardTaskInfo) {
+	h.taskStore.Store(task.ID, task)
+	persistTask(h.ctx, h.tasks, task.ID, task.Type, task.Status, task.CreatedAt, task)
+}
 
-			lastForwarded = forwarded
+// restoreTasks 从任务仓库加载历史任务，未完成的任务标记为中断
+func (h *ForwardHandler) restoreTasks() {
+	loadPersistedTasks(h.ctx, h.tasks, "forward", func(data []byte) error {
+		var task ForwardTaskInfo
+		if err := json.Unmarshal(data, &task); err != nil {
+			return err
+		}
 
-			// 简单的完成条件
-			if forwarded >= 20 {
-				return
-			}
+		if isUnfinishedStatus(task.Status) {
+			task.Status = TaskStatusInterrupted
+			task.Error = taskInterruptedMessage
+			task.Speed = "0 msg/s"
+			task.ETA = "--"
+			h.saveTask(task)
+			return nil
 		}
+
+		h.taskStore.Store(task.ID, task)
+		return nil
+	})
+}
+
+// taskAccount 返回任务使用的账号，WebSocket事件推送给可以访问该账号的连接
+func (h *ForwardHandler) taskAccount(taskID string) int64 {
+	task, ok := h.getForwardTaskInfo(taskID)
+	if !ok {
+		return 0
 	}
+	return task.AccountID
 }
 
-// updateForwardTaskStatus 更新转发任务状态
-func (h *ForwardHandler) updateForwardTaskStatus(taskID, status, errorMsg string, progress float64, forwarded, failed int) {
-	if value, exists := h.taskStore.Load(taskID); exists {
-		if task, ok := value.(ForwardTaskInfo); ok {
-			task.Status = status
-			task.Progress = progress
-			task.Forwarded = forwarded
-			task.Failed = failed
-			if errorMsg != "" {
-				task.Error = errorMsg
-			}
-			h.taskStore.Store(taskID, task)
-		}
+// accessibleTask 获取发起请求的客户端可以访问的任务，不存在或无权访问时返回404
+func (h *ForwardHandler) accessibleTask(c *gin.Context, taskID string) (ForwardTaskInfo, bool) {
+	task, ok := h.getForwardTaskInfo(taskID)
+	if !ok || !canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
+		NotFoundError(c, "Task not found")
+		return ForwardTaskInfo{}, false
 	}
+	return task, true
 }
 
 // getForwardTaskInfo 获取转发任务信息
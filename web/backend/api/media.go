@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/telegram/peers"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/app/dl"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/tmedia"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/web/backend/middleware"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+const (
+	// mediaCacheTTL 解析结果的有效期，文件引用过期后需要重新获取消息
+	mediaCacheTTL = 30 * time.Minute
+	// mediaCacheSize 缓存的消息数量上限
+	mediaCacheSize = 1024
+
+	// thumbKeyTTL 消息对应的缩略图不会变化，只有编辑媒体时才需要重新获取
+	thumbKeyTTL = 24 * time.Hour
+	// defaultThumbSize 默认缩略图的最短长边，足够消息和下载列表显示
+	defaultThumbSize = 320
+	// maxThumbBytes 下载缩略图的大小上限，超过时说明选中的不是缩略图
+	maxThumbBytes = 1 << 20
+)
+
+// MediaHandler 直接从Telegram流式读取消息中的媒体，用于在下载前预览
+type MediaHandler struct {
+	ctx         context.Context
+	kvd         kv.Storage
+	authService *service.AuthService
+	clients     *service.ClientManager
+	cache       *mediaCache[*dl.Media]
+	thumbs      *service.ThumbCache
+	thumbKeys   *mediaCache[string] // 消息 -> 缩略图缓存key，命中时不需要获取消息
+}
+
+func NewMediaHandler(ctx context.Context, kvd kv.Storage, authService *service.AuthService, clients *service.ClientManager, thumbs *service.ThumbCache) *MediaHandler {
+	return &MediaHandler{
+		ctx:         ctx,
+		kvd:         kvd,
+		authService: authService,
+		clients:     clients,
+		cache:       newMediaCache[*dl.Media](mediaCacheTTL, mediaCacheSize),
+		thumbs:      thumbs,
+		thumbKeys:   newMediaCache[string](thumbKeyTTL, mediaCacheSize),
+	}
+}
+
+// StreamMedia 流式返回消息中的媒体，支持Range请求，可直接用于<video>和<img>
+//
+// 默认inline以便浏览器直接播放，download=true时作为附件下载
+func (h *MediaHandler) StreamMedia(c *gin.Context) {
+	peer := c.Param("peer")
+	msgID, err := strconv.Atoi(c.Param("msg"))
+	if err != nil || msgID <= 0 {
+		ValidationError(c, "Invalid message id")
+		return
+	}
+
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		InternalServerError(c, "Failed to identify client")
+		return
+	}
+
+	accountID, _ := strconv.ParseInt(c.Query("account_id"), 10, 64)
+	if accountID, err = h.authService.ResolveAccount(clientID, accountID); err != nil {
+		AccountError(c, err)
+		return
+	}
+
+	inline := c.Query("download") != "true"
+	partSize := currentTransfer(h.ctx, h.kvd).PartSize
+	key := fmt.Sprintf("%d/%s/%d", accountID, peer, msgID)
+
+	// 客户端断开时停止读取
+	err = h.clients.Run(c.Request.Context(), clientID, accountID, func(ctx context.Context, acc *service.AccountClient) error {
+		item, ok := h.cache.get(key)
+		if !ok {
+			manager := peers.Options{Storage: storage.NewPeers(acc.KV)}.Build(acc.Pool.Default(ctx))
+
+			var err error
+			if item, err = dl.ResolveMedia(ctx, manager, acc.Pool.Default(ctx), peer, msgID); err != nil {
+				return err
+			}
+			h.cache.set(key, item)
+		}
+
+		dl.ServeMedia(ctx, c.Writer, c.Request, acc.Pool.Client(ctx, item.DC), item, partSize, inline)
+		return nil
+	})
+	if err != nil {
+		logctx.From(h.ctx).Warn("Failed to stream media",
+			zap.String("peer", peer),
+			zap.Int("msg", msgID),
+			zap.Error(err))
+		NotFoundError(c, fmt.Sprintf("Media not available: %v", err))
+	}
+}
+
+// GetThumbnail 返回照片或文件的缩略图JPEG，size为期望的最短长边
+//
+// 优先选择不小于size的最小尺寸，没有可下载的尺寸时展开内嵌的模糊缩略图。
+// 结果按照片或文件ID缓存在磁盘中
+func (h *MediaHandler) GetThumbnail(c *gin.Context) {
+	peer := c.Param("peer")
+	msgID, err := strconv.Atoi(c.Param("msg"))
+	if err != nil || msgID <= 0 {
+		ValidationError(c, "Invalid message id")
+		return
+	}
+
+	size, _ := strconv.Atoi(c.Query("size"))
+	if size <= 0 {
+		size = defaultThumbSize
+	}
+
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		InternalServerError(c, "Failed to identify client")
+		return
+	}
+
+	accountID, _ := strconv.ParseInt(c.Query("account_id"), 10, 64)
+	if accountID, err = h.authService.ResolveAccount(clientID, accountID); err != nil {
+		AccountError(c, err)
+		return
+	}
+
+	msgKey := fmt.Sprintf("%d/%s/%d/%d", accountID, peer, msgID, size)
+	if key, ok := h.thumbKeys.get(msgKey); ok {
+		if data, ok := h.thumbs.Get(key); ok {
+			writeThumb(c, data)
+			return
+		}
+	}
+
+	var data []byte
+	err = h.clients.Run(c.Request.Context(), clientID, accountID, func(ctx context.Context, acc *service.AccountClient) error {
+		api := acc.Pool.Default(ctx)
+		manager := peers.Options{Storage: storage.NewPeers(acc.KV)}.Build(api)
+
+		p, err := resolveChatPeer(ctx, manager, peer)
+		if err != nil {
+			return errors.Wrap(err, "resolve peer")
+		}
+		msg, err := tutil.GetSingleMessage(ctx, api, p.InputPeer(), msgID)
+		if err != nil {
+			return errors.Wrap(err, "resolve message")
+		}
+
+		thumb, ok := tmedia.GetThumb(msg.Media, size)
+		if !ok {
+			return errNoThumbnail
+		}
+		h.thumbKeys.set(msgKey, thumb.Key)
+
+		if cached, ok := h.thumbs.Get(thumb.Key); ok {
+			data = cached
+			return nil
+		}
+
+		if data = thumb.Bytes; data == nil {
+			var buf bytes.Buffer
+			if _, err = downloader.NewDownloader().
+				Download(acc.Pool.Client(ctx, thumb.DC), thumb.InputFileLoc).
+				Stream(ctx, &limitWriter{w: &buf, n: maxThumbBytes}); err != nil {
+				return errors.Wrap(err, "download thumbnail")
+			}
+			data = buf.Bytes()
+		}
+
+		if err = h.thumbs.Put(thumb.Key, data); err != nil {
+			logctx.From(h.ctx).Warn("Failed to cache thumbnail",
+				zap.String("key", thumb.Key),
+				zap.Error(err))
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errNoThumbnail) {
+			NotFoundError(c, "Message has no thumbnail")
+			return
+		}
+		logctx.From(h.ctx).Warn("Failed to get thumbnail",
+			zap.String("peer", peer),
+			zap.Int("msg", msgID),
+			zap.Error(err))
+		NotFoundError(c, fmt.Sprintf("Thumbnail not available: %v", err))
+		return
+	}
+
+	writeThumb(c, data)
+}
+
+var errNoThumbnail = errors.New("no thumbnail")
+
+// writeThumb 返回缩略图，缩略图内容不会变化，允许浏览器缓存
+func writeThumb(c *gin.Context, data []byte) {
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
+// limitWriter 写入超过n字节时返回错误
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errors.New("thumbnail is too large")
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// mediaCache 按账号和消息缓存解析结果，避免每个Range请求都重新获取消息
+type mediaCache[T any] struct {
+	ttl  time.Duration
+	size int
+
+	mu    sync.Mutex
+	items map[string]mediaCacheItem[T]
+}
+
+type mediaCacheItem[T any] struct {
+	value   T
+	expires time.Time
+}
+
+func newMediaCache[T any](ttl time.Duration, size int) *mediaCache[T] {
+	return &mediaCache[T]{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[string]mediaCacheItem[T]),
+	}
+}
+
+func (m *mediaCache[T]) get(key string) (T, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[key]
+	if !ok || time.Now().After(item.expires) {
+		delete(m.items, key)
+		var zero T
+		return zero, false
+	}
+	return item.value, true
+}
+
+func (m *mediaCache[T]) set(key string, value T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if len(m.items) >= m.size {
+		// 先清理过期的条目，仍然已满时清空，预览场景下重新解析的代价可以接受
+		for k, item := range m.items {
+			if now.After(item.expires) {
+				delete(m.items, k)
+			}
+		}
+		if len(m.items) >= m.size {
+			m.items = make(map[string]mediaCacheItem[T])
+		}
+	}
+
+	m.items[key] = mediaCacheItem[T]{value: value, expires: now.Add(m.ttl)}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/peers"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/messages"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/app/dl"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/core/tmedia"
+	"github.com/iyear/tdl/core/util/tutil"
+	"github.com/iyear/tdl/pkg/texpr"
+	"github.com/iyear/tdl/web/backend/middleware"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+	// messagesScanFactor 过滤时每页最多扫描limit的多少倍消息，避免很少匹配的表达式遍历整个聊天
+	messagesScanFactor = 10
+)
+
+// ChatMessagesRequest 消息浏览请求，按消息ID倒序分页
+type ChatMessagesRequest struct {
+	OffsetID   int    `form:"offset_id"`   // 游标，只返回ID小于它的消息，0表示从最新消息开始
+	OffsetDate int    `form:"offset_date"` // 只返回该时间之前的消息，Unix时间戳
+	Limit      int    `form:"limit"`       // 每页条数，默认50，最大100
+	Thread     int    `form:"thread"`      // 主题ID（论坛）或消息ID（评论、回复）
+	Filter     string `form:"filter"`      // texpr过滤表达式，与导出相同
+	OnlyMedia  bool   `form:"only_media"`  // 只返回带媒体的消息
+	AccountID  int64  `form:"account_id"`  // 使用的Telegram账号，为空时使用当前账号
+}
+
+// ChatMessage 消息及其媒体信息，字段与过滤表达式中的Message对应
+type ChatMessage struct {
+	ID            int           `json:"id"`
+	FromID        int64         `json:"from_id"`
+	Date          int           `json:"date"`
+	Message       string        `json:"message"`
+	Mentioned     bool          `json:"mentioned"`
+	Silent        bool          `json:"silent"`
+	FromScheduled bool          `json:"from_scheduled"`
+	Pinned        bool          `json:"pinned"`
+	Views         int           `json:"views"`
+	Forwards      int           `json:"forwards"`
+	GroupedID     int64         `json:"grouped_id,omitempty"` // 相册中的消息共享同一个ID
+	Media         *MessageMedia `json:"media,omitempty"`
+	Link          string        `json:"link"` // 消息链接，可直接用于下载和转发任务
+}
+
+// ChatMessagesResponse 一页消息
+type ChatMessagesResponse struct {
+	ChatID       int64         `json:"chat_id"` // 消息所在聊天，频道评论时为讨论组
+	Messages     []ChatMessage `json:"messages"`
+	NextOffsetID int           `json:"next_offset_id"` // 下一页的offset_id，0表示没有更多消息
+	HasMore      bool          `json:"has_more"`
+}
+
+// MessageMedia 消息中的媒体文件
+type MessageMedia struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	DC   int    `json:"dc"`
+	MIME string `json:"mime"`
+	Date int64  `json:"date"`
+}
+
+// GetChatMessages 分页浏览聊天中的消息，peer为me时浏览收藏夹
+//
+// 返回的next_offset_id作为下一页的offset_id，为0表示没有更多消息
+func (h *ChatHandler) GetChatMessages(c *gin.Context) {
+	var req ChatMessagesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultMessagesLimit
+	}
+	if req.Limit > maxMessagesLimit {
+		req.Limit = maxMessagesLimit
+	}
+	if req.Filter == "" {
+		req.Filter = "true"
+	}
+
+	filter, err := expr.Compile(req.Filter, expr.AsBool())
+	if err != nil {
+		ExpressionError(c, fmt.Sprintf("Invalid filter: %v", err))
+		return
+	}
+
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		InternalServerError(c, "Failed to identify client")
+		return
+	}
+
+	accountID, err := h.authService.ResolveAccount(clientID, req.AccountID)
+	if err != nil {
+		AccountError(c, err)
+		return
+	}
+
+	var (
+		chatID  int64
+		items   []ChatMessage
+		nextID  int
+		peerArg = c.Param("peer")
+	)
+	err = h.clients.Run(c.Request.Context(), clientID, accountID, func(ctx context.Context, acc *service.AccountClient) error {
+		api := acc.Pool.Default(ctx)
+		manager := peers.Options{Storage: storage.NewPeers(acc.KV)}.Build(api)
+
+		peer, err := resolveChatPeer(ctx, manager, peerArg)
+		if err != nil {
+			return errors.Wrap(err, "resolve peer")
+		}
+
+		if chatID, err = threadChatID(ctx, peer, req.Thread); err != nil {
+			return err
+		}
+
+		var q messages.Query
+		if req.Thread != 0 {
+			q = query.NewQuery(api).Messages().GetReplies(peer.InputPeer()).MsgID(req.Thread)
+		} else {
+			q = query.NewQuery(api).Messages().GetHistory(peer.InputPeer())
+		}
+
+		iter := messages.NewIterator(q, req.Limit)
+		if req.OffsetID > 0 {
+			iter = iter.OffsetID(req.OffsetID)
+		}
+		if req.OffsetDate > 0 {
+			iter = iter.OffsetDate(req.OffsetDate)
+		}
+
+		items, nextID, err = collectMessages(ctx, iter, filter, req, chatID)
+		return err
+	})
+	if err != nil {
+		logctx.From(h.ctx).Error("Failed to get chat messages",
+			zap.String("peer", peerArg),
+			zap.Error(err))
+		InternalError(c, "Failed to get chat messages", err)
+		return
+	}
+
+	Success(c, ChatMessagesResponse{
+		ChatID:       chatID,
+		Messages:     items,
+		NextOffsetID: nextID,
+		HasMore:      nextID != 0,
+	})
+}
+
+// collectMessages 读取一页匹配过滤条件的消息，返回下一页的游标
+//
+// 扫描的消息数达到上限时即使不满一页也返回，客户端使用游标继续读取
+func collectMessages(ctx context.Context, iter *messages.Iterator, filter *vm.Program, req ChatMessagesRequest, chatID int64) ([]ChatMessage, int, error) {
+	items := make([]ChatMessage, 0, req.Limit)
+	scanned, lastID := 0, 0
+
+	for len(items) < req.Limit && scanned < req.Limit*messagesScanFactor && iter.Next(ctx) {
+		scanned++
+		lastID = iter.Value().Msg.GetID()
+
+		m, ok := iter.Value().Msg.(*tg.Message)
+		if !ok {
+			continue // 服务消息
+		}
+		if _, ok = tmedia.GetMedia(m); !ok && req.OnlyMedia {
+			continue
+		}
+
+		matched, err := texpr.Run(filter, texpr.ConvertEnvMessage(m))
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "run filter")
+		}
+		if !matched.(bool) {
+			continue
+		}
+
+		items = append(items, newChatMessage(m, chatID))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "iterate messages")
+	}
+
+	// 迭代器已结束时没有下一页
+	if lastID <= 1 || (len(items) < req.Limit && scanned < req.Limit*messagesScanFactor) {
+		lastID = 0
+	}
+	return items, lastID, nil
+}
+
+// resolveChatPeer 解析聊天，空字符串或me表示收藏夹
+func resolveChatPeer(ctx context.Context, manager *peers.Manager, peer string) (peers.Peer, error) {
+	if peer == "" || peer == "me" {
+		return manager.Self(ctx)
+	}
+	return tutil.GetInputPeer(ctx, manager, peer)
+}
+
+// threadChatID 消息所在聊天的ID，频道的评论在关联的讨论组中
+func threadChatID(ctx context.Context, peer peers.Peer, thread int) (int64, error) {
+	p, ok := peer.(peers.Channel)
+	if thread == 0 || !ok || !p.IsBroadcast() {
+		return peer.ID(), nil
+	}
+
+	bc, _ := p.ToBroadcast()
+	raw, err := bc.FullRaw(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "get broadcast full raw")
+	}
+
+	linked, ok := raw.GetLinkedChatID()
+	if !ok {
+		return 0, errors.New("no linked group")
+	}
+	return linked, nil
+}
+
+// newChatMessage 转换消息，字段来自texpr.EnvMessage，媒体额外包含MIME和相册ID
+func newChatMessage(m *tg.Message, chatID int64) ChatMessage {
+	env := texpr.ConvertEnvMessage(m)
+	msg := ChatMessage{
+		ID:            env.ID,
+		FromID:        env.FromID,
+		Date:          env.Date,
+		Message:       env.Message,
+		Mentioned:     env.Mentioned,
+		Silent:        env.Silent,
+		FromScheduled: env.FromScheduled,
+		Pinned:        env.Pinned,
+		Views:         env.Views,
+		Forwards:      env.Forwards,
+		Link:          fmt.Sprintf("https://t.me/c/%d/%d", chatID, m.ID),
+	}
+	msg.GroupedID, _ = m.GetGroupedID()
+
+	if media, err := dl.NewMedia(m); err == nil {
+		msg.Media = &MessageMedia{
+			Name: media.Name,
+			Size: media.Size,
+			DC:   media.DC,
+			MIME: media.MIME,
+			Date: media.Date,
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+// metricsContentType Prometheus文本格式的Content-Type
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsHandler 导出Prometheus指标和进程状态，用于监控长期运行的Web服务
+type MetricsHandler struct {
+	ctx       context.Context
+	metrics   *service.Metrics
+	scheduler *service.Scheduler
+	started   time.Time
+}
+
+func NewMetricsHandler(ctx context.Context, metrics *service.Metrics, scheduler *service.Scheduler) *MetricsHandler {
+	return &MetricsHandler{
+		ctx:       ctx,
+		metrics:   metrics,
+		scheduler: scheduler,
+		started:   time.Now(),
+	}
+}
+
+// SystemStats 进程资源占用和任务队列的概况
+type SystemStats struct {
+	service.ProcessStats
+	Uptime  int64 `json:"uptime"` // 服务运行的秒数
+	Running int   `json:"running"`
+	Queued  int   `json:"queued"`
+}
+
+// GetMetrics 以Prometheus文本格式返回指标
+func (h *MetricsHandler) GetMetrics(c *gin.Context) {
+	c.Header("Content-Type", metricsContentType)
+	if err := h.metrics.Write(c.Request.Context(), c.Writer); err != nil {
+		logctx.From(h.ctx).Warn("Failed to write metrics", zap.Error(err))
+	}
+}
+
+// GetSystemStats 返回进程资源占用和任务队列的概况
+func (h *MetricsHandler) GetSystemStats(c *gin.Context) {
+	stats := SystemStats{
+		ProcessStats: service.ReadProcessStats(c.Request.Context()),
+		Uptime:       int64(time.Since(h.started).Seconds()),
+	}
+	for _, job := range h.scheduler.List() {
+		if job.Running {
+			stats.Running++
+		} else {
+			stats.Queued++
+		}
+	}
+
+	Success(c, stats)
+}
@@ -0,0 +1,509 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/iyear/tdl/pkg/consts"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+// OpenAPIPrefix 文档描述的路由前缀
+const OpenAPIPrefix = "/api/v1"
+
+// apiOperation 路由的文档信息，请求和响应的schema由结构体反射生成
+type apiOperation struct {
+	Summary string
+	Public  bool       // 不需要操作员认证
+	Query   any        // 查询参数结构体，使用form标签
+	Params  []apiParam // 没有对应结构体的查询参数或请求头
+	Body    any        // JSON请求体
+	Form    []apiParam // multipart/form-data请求体
+	Raw     string     // 二进制请求体的Content-Type
+	Data    any        // 成功响应中data字段的类型，nil时不描述
+	File    string     // 响应为文件时的Content-Type
+}
+
+// apiParam 参数，Type为string、integer、boolean、file或files
+type apiParam struct {
+	Name        string
+	In          string // query（默认）或header
+	Type        string
+	Description string
+}
+
+// apiOperations 按"METHOD 路径"索引，路径不含OpenAPIPrefix，未列出的路由只生成基本信息
+var apiOperations = map[string]apiOperation{
+	"GET /openapi.json":           {Summary: "This document", Public: true, File: "application/json"},
+	"GET /operator/status":        {Summary: "Whether the request carries a valid operator session", Public: true},
+	"POST /operator/login":        {Summary: "Login with the admin password and set the session cookie", Public: true, Body: OperatorLoginRequest{}},
+	"POST /operator/logout":       {Summary: "Clear the operator session", Public: true},
+	"GET /operator/tokens":        {Summary: "List API tokens"},
+	"POST /operator/tokens":       {Summary: "Create an API token, the token is only returned once", Body: CreateTokenRequest{}},
+	"DELETE /operator/tokens/:id": {Summary: "Revoke an API token"},
+
+	"GET /auth/status":               {Summary: "Telegram login status of the current account"},
+	"POST /auth/qr/start":            {Summary: "Start QR code login", Body: QRLoginRequest{}},
+	"GET /auth/qr/code/:sessionId":   {Summary: "QR code image of a login session", Params: []apiParam{{Name: "size", Type: "integer", Description: "Image size in pixels, default 256"}}, File: "image/png"},
+	"GET /auth/qr/status/:sessionId": {Summary: "Status of a QR code login session"},
+	"POST /auth/code/start":          {Summary: "Start phone code login", Body: CodeLoginRequest{}},
+	"POST /auth/code/verify":         {Summary: "Submit the login code", Body: VerifyCodeRequest{}},
+	"POST /auth/password/verify":     {Summary: "Submit the 2FA password", Body: VerifyPasswordRequest{}},
+	"POST /auth/logout":              {Summary: "Logout the current account"},
+	"GET /auth/accounts":             {Summary: "List logged in accounts"},
+	"POST /auth/accounts/:id/switch": {Summary: "Switch the current account"},
+	"DELETE /auth/accounts/:id":      {Summary: "Logout and remove an account"},
+	"GET /auth/cli/namespaces":       {Summary: "List logged in namespaces of the CLI storage"},
+	"POST /auth/accounts/import":     {Summary: "Import a logged in CLI namespace as an account", Body: ImportAccountRequest{}},
+
+	"GET /chat/list":             {Summary: "List dialogs from the per-account cache", Query: ChatListRequest{}, Data: ChatListResponse{}},
+	"GET /chat/:peer/messages":   {Summary: "Browse messages of a chat, newest first", Query: ChatMessagesRequest{}, Data: ChatMessagesResponse{}},
+	"GET /chat/default-path":     {Summary: "Default download directory"},
+	"POST /chat/export":          {Summary: "Export messages to a JSON file", Body: ChatExportRequest{}},
+	"POST /chat/users":           {Summary: "Export users of a chat to a JSON file", Body: ChatUsersRequest{}},
+	"GET /chat/tasks":            {Summary: "List export tasks"},
+	"GET /chat/tasks/:id":        {Summary: "Export task details", Data: ExportTaskInfo{}},
+	"GET /chat/tasks/:id/result": {Summary: "Download the exported JSON file", File: "application/json"},
+	"DELETE /chat/tasks/:id":     {Summary: "Cancel or delete an export task"},
+
+	"GET /settings/":       {Summary: "Get settings", Data: Settings{}},
+	"PUT /settings/":       {Summary: "Update settings", Body: Settings{}, Data: Settings{}},
+	"POST /settings/reset": {Summary: "Reset settings to defaults", Data: Settings{}},
+
+	"POST /download/start":            {Summary: "Start a download task", Body: DownloadRequest{}},
+	"POST /download/import":           {Summary: "Start a download task from exported JSON", Body: ImportRequest{}},
+	"GET /download/tasks":             {Summary: "List download tasks"},
+	"GET /download/tasks/:id":         {Summary: "Download task details", Data: TaskInfo{}},
+	"GET /download/tasks/:id/files":   {Summary: "Downloaded files of a finished task as a ZIP archive", File: "application/zip"},
+	"POST /download/tasks/:id/pause":  {Summary: "Pause a download task"},
+	"POST /download/tasks/:id/resume": {Summary: "Resume a paused download task"},
+	"POST /download/tasks/:id/retry":  {Summary: "Retry a failed download task", Body: RetryRequest{}},
+	"DELETE /download/tasks/:id":      {Summary: "Cancel or delete a download task"},
+
+	"POST /forward/start":           {Summary: "Start a forward task", Body: ForwardRequest{}},
+	"GET /forward/tasks":            {Summary: "List forward tasks"},
+	"GET /forward/tasks/:id":        {Summary: "Forward task details", Data: ForwardTaskInfo{}},
+	"POST /forward/tasks/:id/retry": {Summary: "Retry a failed forward task", Body: RetryRequest{}},
+	"DELETE /forward/tasks/:id":     {Summary: "Cancel or delete a forward task"},
+
+	"POST /upload/start": {Summary: "Upload files sent in the request", Form: []apiParam{
+		{Name: "files", Type: "files"},
+		{Name: "to_chat", Type: "string", Description: "Target chat, empty for Saved Messages"},
+		{Name: "excludes", Type: "string", Description: "Comma separated extensions to skip"},
+		{Name: "remove", Type: "boolean"},
+		{Name: "photo", Type: "boolean", Description: "Send images as photos"},
+		{Name: "task_id", Type: "string"},
+		{Name: "priority", Type: "integer"},
+		{Name: "account_id", Type: "integer"},
+	}},
+	"POST /upload/local":           {Summary: "Upload server-local files or directories from the upload roots", Body: LocalUploadRequest{}},
+	"GET /upload/tasks":            {Summary: "List upload tasks"},
+	"GET /upload/tasks/:id":        {Summary: "Upload task details"},
+	"POST /upload/tasks/:id/retry": {Summary: "Retry a failed upload task", Body: RetryRequest{}},
+	"DELETE /upload/tasks/:id":     {Summary: "Cancel or delete an upload task"},
+	"POST /upload/sessions":        {Summary: "Create a resumable chunked upload", Body: CreateUploadSessionRequest{}, Data: service.UploadSession{}},
+	"GET /upload/sessions/:id":     {Summary: "Offset of a chunked upload", Data: service.UploadSession{}},
+	"HEAD /upload/sessions/:id":    {Summary: "Offset of a chunked upload in the Upload-Offset header"},
+	"PATCH /upload/sessions/:id": {Summary: "Write a chunk at Upload-Offset, the task starts after the last chunk", Raw: "application/offset+octet-stream", Params: []apiParam{
+		{Name: headerUploadOffset, In: "header", Type: "integer", Description: "Offset of the chunk, must match the current offset"},
+	}},
+	"DELETE /upload/sessions/:id": {Summary: "Abort a chunked upload"},
+
+	"GET /files": {Summary: "List a directory, or the roots if path is empty", Params: []apiParam{
+		{Name: "path", Type: "string"},
+		{Name: "scope", Type: "string", Description: "upload to browse the upload roots"},
+	}},
+	"POST /files/mkdir":  {Summary: "Create a directory", Body: MkdirRequest{}},
+	"POST /files/rename": {Summary: "Rename a file or directory", Body: RenameRequest{}},
+	"DELETE /files": {Summary: "Delete a file or directory", Params: []apiParam{
+		{Name: "path", Type: "string"},
+		{Name: "recursive", Type: "boolean", Description: "Required to delete non-empty directories"},
+	}},
+	"GET /files/download": {Summary: "Download a file", Params: []apiParam{{Name: "path", Type: "string"}}, File: "application/octet-stream"},
+
+	"GET /media/:peer/:msg": {Summary: "Stream media of a message, supports Range requests", Params: []apiParam{
+		{Name: "account_id", Type: "integer"},
+		{Name: "download", Type: "boolean", Description: "Send as attachment instead of inline"},
+	}, File: "application/octet-stream"},
+	"GET /media/:peer/:msg/thumb": {Summary: "JPEG thumbnail of a photo or document", Params: []apiParam{
+		{Name: "account_id", Type: "integer"},
+		{Name: "size", Type: "integer", Description: "Minimum longer side in pixels, default 320"},
+	}, File: "image/jpeg"},
+
+	"GET /queue":               {Summary: "Running and queued tasks"},
+	"POST /queue/:id/priority": {Summary: "Change the priority of a queued task", Body: PriorityRequest{}},
+	"POST /queue/:id/move":     {Summary: "Move a queued task", Body: MoveRequest{}},
+	"POST /queue/:id/bump":     {Summary: "Move a queued task to the front"},
+
+	"GET /system/stats": {Summary: "Process resource usage and task queue summary", Data: SystemStats{}},
+}
+
+// OpenAPIHandler 根据注册的路由生成OpenAPI 3文档，用于生成类型化的客户端
+type OpenAPIHandler struct {
+	routes func() gin.RoutesInfo
+
+	once sync.Once
+	doc  []byte
+	err  error
+}
+
+// NewOpenAPIHandler 创建文档handler，routes在第一次请求时读取，此时所有路由都已注册
+func NewOpenAPIHandler(routes func() gin.RoutesInfo) *OpenAPIHandler {
+	return &OpenAPIHandler{routes: routes}
+}
+
+// GetDocument 返回/api/v1的OpenAPI文档
+func (h *OpenAPIHandler) GetDocument(c *gin.Context) {
+	h.once.Do(func() {
+		h.doc, h.err = json.MarshalIndent(buildOpenAPI(h.routes()), "", "  ")
+	})
+	if h.err != nil {
+		InternalError(c, "Failed to generate OpenAPI document", h.err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", h.doc)
+}
+
+// buildOpenAPI 生成文档，路由来自gin，请求和响应的schema来自apiOperations
+func buildOpenAPI(routes gin.RoutesInfo) map[string]any {
+	s := &schemaBuilder{schemas: make(map[string]any), types: make(map[string]reflect.Type)}
+
+	paths := make(map[string]map[string]any)
+	operationIDs := make(map[string]bool)
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	for _, r := range routes {
+		rel, ok := strings.CutPrefix(r.Path, OpenAPIPrefix)
+		if !ok {
+			continue
+		}
+		info := apiOperations[r.Method+" "+rel]
+
+		path, params := openAPIPath(rel)
+		for _, p := range info.Params {
+			params = append(params, s.param(p))
+		}
+		if info.Query != nil {
+			params = append(params, s.queryParams(reflect.TypeOf(info.Query))...)
+		}
+
+		op := map[string]any{
+			"operationId": operationID(r.Handler, r.Method, operationIDs),
+			"tags":        []string{openAPITag(rel)},
+			"responses":   s.responses(info),
+		}
+		if info.Summary != "" {
+			op["summary"] = info.Summary
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if body := s.requestBody(info); body != nil {
+			op["requestBody"] = body
+		}
+		if info.Public {
+			op["security"] = []any{}
+		}
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		paths[path][strings.ToLower(r.Method)] = op
+	}
+
+	codes := make([]string, 0, len(ErrorCodes))
+	for _, code := range ErrorCodes {
+		codes = append(codes, string(code))
+	}
+	s.schemas["Error"] = map[string]any{
+		"type":     "object",
+		"required": []string{"success", "error", "code"},
+		"properties": map[string]any{
+			"success":     map[string]any{"type": "boolean"},
+			"error":       map[string]any{"type": "string", "description": "Human readable message"},
+			"code":        map[string]any{"type": "string", "enum": codes},
+			"retry_after": map[string]any{"type": "integer", "description": "Seconds to wait, only for flood_wait"},
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "tdl web API",
+			"version": consts.Version,
+		},
+		"servers": []any{map[string]any{"url": OpenAPIPrefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]any{
+				"token":   map[string]any{"type": "http", "scheme": "bearer", "description": "API token created by POST /operator/tokens"},
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "tdl_session"},
+			},
+		},
+		"security": []any{
+			map[string]any{"token": []string{}},
+			map[string]any{"session": []string{}},
+		},
+	}
+}
+
+// openAPIPath 将gin路径转换为OpenAPI路径，并返回路径参数
+func openAPIPath(path string) (string, []any) {
+	var params []any
+
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if len(seg) < 2 || (seg[0] != ':' && seg[0] != '*') {
+			continue
+		}
+		segments[i] = "{" + seg[1:] + "}"
+		params = append(params, map[string]any{
+			"name":     seg[1:],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+// openAPITag 使用路径的第一段作为分组
+func openAPITag(path string) string {
+	tag, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	tag, _, _ = strings.Cut(tag, ".")
+	return tag
+}
+
+// operationID 使用handler的方法名，如api.(*ChatHandler).GetChatList-fm -> GetChatList
+func operationID(handler, method string, seen map[string]bool) string {
+	name := strings.TrimSuffix(handler[strings.LastIndex(handler, ".")+1:], "-fm")
+	if seen[name] {
+		name += strings.ToUpper(method[:1]) + strings.ToLower(method[1:]) // 同一handler注册了多个方法
+	}
+	seen[name] = true
+	return name
+}
+
+// schemaBuilder 通过反射生成JSON schema，具名结构体放在components中引用
+type schemaBuilder struct {
+	schemas map[string]any
+	types   map[string]reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func (s *schemaBuilder) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + s.component(t)}
+	default: // interface{}
+		return map[string]any{}
+	}
+}
+
+// component 注册具名结构体，不同包中的同名类型加上包名区分
+func (s *schemaBuilder) component(t reflect.Type) string {
+	name := t.Name()
+	if other, ok := s.types[name]; ok && other != t {
+		name = t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:] + "." + name
+	}
+	if _, ok := s.types[name]; ok {
+		return name
+	}
+
+	s.types[name] = t
+	s.schemas[name] = map[string]any{} // 占位，避免递归类型无限展开
+	s.schemas[name] = s.object(t)
+	return name
+}
+
+// object 结构体的schema，匿名嵌入的字段展开到同一层
+func (s *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			props[name] = s.schema(f.Type)
+			if strings.Contains(f.Tag.Get("binding"), "required") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	obj := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// queryParams 使用form标签的结构体字段作为查询参数
+func (s *schemaBuilder) queryParams(t reflect.Type) []any {
+	var params []any
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("form"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "query",
+			"required": strings.Contains(f.Tag.Get("binding"), "required"),
+			"schema":   s.schema(f.Type),
+		})
+	}
+	return params
+}
+
+func (s *schemaBuilder) param(p apiParam) map[string]any {
+	in := p.In
+	if in == "" {
+		in = "query"
+	}
+	param := map[string]any{
+		"name":   p.Name,
+		"in":     in,
+		"schema": paramSchema(p.Type),
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	return param
+}
+
+func paramSchema(typ string) map[string]any {
+	switch typ {
+	case "file":
+		return map[string]any{"type": "string", "format": "binary"}
+	case "files":
+		return map[string]any{"type": "array", "items": paramSchema("file")}
+	case "integer":
+		return map[string]any{"type": "integer", "format": "int64"}
+	default:
+		return map[string]any{"type": typ}
+	}
+}
+
+func (s *schemaBuilder) requestBody(info apiOperation) map[string]any {
+	var content map[string]any
+	switch {
+	case info.Body != nil:
+		content = map[string]any{"application/json": map[string]any{"schema": s.schema(reflect.TypeOf(info.Body))}}
+	case info.Form != nil:
+		props := make(map[string]any, len(info.Form))
+		for _, p := range info.Form {
+			prop := paramSchema(p.Type)
+			if p.Description != "" {
+				prop["description"] = p.Description
+			}
+			props[p.Name] = prop
+		}
+		content = map[string]any{"multipart/form-data": map[string]any{"schema": map[string]any{"type": "object", "properties": props}}}
+	case info.Raw != "":
+		content = map[string]any{info.Raw: map[string]any{"schema": paramSchema("file")}}
+	default:
+		return nil
+	}
+
+	return map[string]any{"required": true, "content": content}
+}
+
+func (s *schemaBuilder) responses(info apiOperation) map[string]any {
+	var ok map[string]any
+	switch {
+	case info.File != "":
+		ok = map[string]any{
+			"description": "File content",
+			"content":     map[string]any{info.File: map[string]any{"schema": paramSchema("file")}},
+		}
+	default:
+		data := map[string]any{}
+		if info.Data != nil {
+			data = s.schema(reflect.TypeOf(info.Data))
+		}
+		ok = map[string]any{
+			"description": "Success",
+			"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+				"type":     "object",
+				"required": []string{"success"},
+				"properties": map[string]any{
+					"success": map[string]any{"type": "boolean"},
+					"message": map[string]any{"type": "string"},
+					"data":    data,
+				},
+			}}},
+		}
+	}
+
+	return map[string]any{
+		"200": ok,
+		"default": map[string]any{
+			"description": "Error, see code for the type",
+			"content": map[string]any{"application/json": map[string]any{
+				"schema": map[string]any{"$ref": "#/components/schemas/Error"},
+			}},
+		},
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/web/backend/middleware"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+// OperatorHandler 处理Web服务自身的登录、登出和API令牌管理
+type OperatorHandler struct {
+	ctx       context.Context
+	operators *service.OperatorService
+}
+
+func NewOperatorHandler(ctx context.Context, operators *service.OperatorService) *OperatorHandler {
+	return &OperatorHandler{
+		ctx:       ctx,
+		operators: operators,
+	}
+}
+
+// OperatorLoginRequest 操作员登录请求
+type OperatorLoginRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// CreateTokenRequest 创建API令牌的请求
+type CreateTokenRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// TokenInfo 返回给前端的API令牌信息，不包含令牌本身
+type TokenInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// GetStatus 获取当前请求的操作员认证状态
+func (h *OperatorHandler) GetStatus(c *gin.Context) {
+	identity, err := middleware.Authenticate(c, h.operators)
+	if err != nil {
+		Success(c, map[string]interface{}{
+			"authenticated": false,
+		})
+		return
+	}
+
+	Success(c, map[string]interface{}{
+		"authenticated": true,
+		"operator":      identity.Operator,
+	})
+}
+
+// Login 使用管理员密码登录，成功后设置会话Cookie
+func (h *OperatorHandler) Login(c *gin.Context) {
+	var req OperatorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	client, _ := c.Cookie(middleware.ClientCookie)
+	sessionID, client, err := h.operators.Login(c.Request.Context(), req.Password, client)
+	if err != nil {
+		if errors.Is(err, service.ErrOperatorUnauthorized) {
+			logctx.From(h.ctx).Warn("Operator login failed", zap.String("ip", c.ClientIP()))
+			time.Sleep(time.Second) // 减缓密码猜测
+			writeError(c, &APIError{Status: http.StatusUnauthorized, Code: CodeOperatorUnauthorized}, "Invalid password")
+			return
+		}
+		InternalError(c, "Failed to login", err)
+		return
+	}
+
+	h.setCookie(c, middleware.SessionCookie, sessionID, int(service.OperatorSessionTTL.Seconds()))
+	h.setCookie(c, middleware.ClientCookie, client, int(service.ClientTTL.Seconds()))
+
+	SuccessWithMessage(c, map[string]string{
+		"operator": service.AdminOperator,
+	}, "Logged in successfully")
+}
+
+// Logout 删除当前会话
+func (h *OperatorHandler) Logout(c *gin.Context) {
+	if sessionID, err := c.Cookie(middleware.SessionCookie); err == nil && sessionID != "" {
+		if err := h.operators.Logout(c.Request.Context(), sessionID); err != nil {
+			InternalError(c, "Failed to logout", err)
+			return
+		}
+	}
+
+	h.setCookie(c, middleware.SessionCookie, "", -1)
+
+	SuccessWithMessage(c, nil, "Logged out successfully")
+}
+
+// ListTokens 列出当前操作员的API令牌
+func (h *OperatorHandler) ListTokens(c *gin.Context) {
+	operator, err := middleware.Operator(c)
+	if err != nil {
+		InternalError(c, "Failed to identify operator", err)
+		return
+	}
+
+	tokens, err := h.operators.ListTokens(c.Request.Context(), operator)
+	if err != nil {
+		InternalError(c, "Failed to list tokens", err)
+		return
+	}
+
+	infos := make([]TokenInfo, 0, len(tokens))
+	for _, t := range tokens {
+		infos = append(infos, tokenInfo(t))
+	}
+
+	Success(c, map[string]interface{}{
+		"tokens": infos,
+		"total":  len(infos),
+	})
+}
+
+// CreateToken 创建API令牌，令牌明文只在此时返回
+func (h *OperatorHandler) CreateToken(c *gin.Context) {
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	operator, err := middleware.Operator(c)
+	if err != nil {
+		InternalError(c, "Failed to identify operator", err)
+		return
+	}
+	client, err := middleware.Client(c)
+	if err != nil {
+		InternalError(c, "Failed to identify client", err)
+		return
+	}
+
+	creator := service.Identity{Operator: operator, Client: client}
+	t, token, err := h.operators.CreateToken(c.Request.Context(), creator, req.Name)
+	if err != nil {
+		InternalError(c, "Failed to create token", err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]interface{}{
+		"token": token,
+		"info":  tokenInfo(*t),
+	}, "Token created, it will not be shown again")
+}
+
+// RevokeToken 删除API令牌
+func (h *OperatorHandler) RevokeToken(c *gin.Context) {
+	operator, err := middleware.Operator(c)
+	if err != nil {
+		InternalError(c, "Failed to identify operator", err)
+		return
+	}
+
+	if err := h.operators.RevokeToken(c.Request.Context(), operator, c.Param("id")); err != nil {
+		if errors.Is(err, service.ErrTokenNotFound) {
+			NotFoundError(c, "Token not found")
+			return
+		}
+		InternalError(c, "Failed to revoke token", err)
+		return
+	}
+
+	SuccessWithMessage(c, nil, "Token revoked")
+}
+
+func (h *OperatorHandler) setCookie(c *gin.Context, name, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", "", c.Request.TLS != nil, true)
+}
+
+func tokenInfo(t service.APIToken) TokenInfo {
+	return TokenInfo{
+		ID:         t.ID,
+		Name:       t.Name,
+		CreatedAt:  t.CreatedAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
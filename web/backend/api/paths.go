@@ -0,0 +1,27 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+// resolvePath 将客户端传入的路径解析为沙箱中的路径，失败时写入响应并返回false
+//
+// 不在允许的根目录中时返回403，并列出允许的根目录
+func resolvePath(c *gin.Context, sandbox *service.Sandbox, path string) (string, bool) {
+	resolved, err := sandbox.Resolve(path)
+	if err != nil {
+		if errors.Is(err, service.ErrPathForbidden) {
+			PathForbiddenError(c, fmt.Sprintf("Path %q is not allowed, allowed roots: %v", path, sandbox.Roots()))
+			return "", false
+		}
+		ValidationError(c, fmt.Sprintf("Invalid path: %v", err))
+		return "", false
+	}
+
+	return resolved, true
+}
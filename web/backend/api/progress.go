@@ -0,0 +1,495 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/app/dl"
+	"github.com/iyear/tdl/app/up"
+	"github.com/iyear/tdl/core/downloader"
+	"github.com/iyear/tdl/core/forwarder"
+	"github.com/iyear/tdl/core/uploader"
+	"github.com/iyear/tdl/pkg/utils"
+	"github.com/iyear/tdl/web/backend/service"
+	"github.com/iyear/tdl/web/backend/websocket"
+)
+
+const (
+	progressEmitInterval    = 500 * time.Millisecond // WebSocket进度推送的最小间隔
+	progressPersistInterval = 2 * time.Second        // 任务进度写入存储的最小间隔
+	progressSpeedWindow     = time.Second            // 速度统计窗口
+)
+
+// progressUnit 任务进度的计量单位
+type progressUnit int
+
+const (
+	unitBytes progressUnit = iota // 按字节统计，用于下载和上传
+	unitItems                     // 按条目统计，用于转发
+)
+
+type itemState struct {
+	transferred int64
+	total       int64
+}
+
+// transferStats 统计一个任务内所有条目的传输进度
+type transferStats struct {
+	mu   sync.Mutex
+	unit progressUnit
+
+	start      time.Time
+	totalItems int // 预期条目数，未知时为0
+	done       int
+	failed     int
+	items      map[interface{}]*itemState // elem -> 状态
+
+	finishedBytes int64 // 已结束条目的字节数
+	finishedTotal int64
+
+	speed       float64 // 每秒字节数或条目数
+	lastAmount  int64
+	lastSample  time.Time
+	lastEmit    time.Time
+	lastPersist time.Time
+}
+
+// transferSnapshot 某一时刻的任务进度
+type transferSnapshot struct {
+	Progress    float64
+	Speed       string
+	ETA         string
+	Transferred int64
+	Total       int64
+	Done        int
+	Failed      int
+	Items       int // 预期条目数，未知时为0
+}
+
+func newTransferStats(unit progressUnit) *transferStats {
+	now := time.Now()
+	return &transferStats{
+		unit:       unit,
+		start:      now,
+		items:      make(map[interface{}]*itemState),
+		lastSample: now,
+	}
+}
+
+func (s *transferStats) setTotal(total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totalItems = total
+}
+
+// add 添加条目，offset为续传时已存在的字节数，计入进度但不计入传输量的增加值
+func (s *transferStats) add(key interface{}, offset, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = &itemState{transferred: offset, total: total}
+	if s.unit == unitBytes {
+		s.lastAmount += offset // 已存在的字节数也不计入速度
+	}
+}
+
+// update 更新条目的进度，返回传输量的增加值
+func (s *transferStats) update(key interface{}, transferred, total int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok {
+		item = &itemState{}
+		s.items[key] = item
+	}
+	delta := transferred - item.transferred
+	item.transferred, item.total = transferred, total
+	return delta
+}
+
+func (s *transferStats) finish(key interface{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, ok := s.items[key]; ok {
+		if err == nil {
+			item.transferred = item.total
+		}
+		s.finishedBytes += item.transferred
+		s.finishedTotal += item.total
+		delete(s.items, key)
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// 被取消的条目不计入完成或失败
+	case err != nil:
+		s.failed++
+	default:
+		s.done++
+	}
+}
+
+// tick 判断是否需要推送和持久化进度，force表示忽略推送间隔
+func (s *transferStats) tick(force bool) (emit, persist bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if !force && now.Sub(s.lastEmit) < progressEmitInterval {
+		return false, false
+	}
+	s.lastEmit = now
+
+	if now.Sub(s.lastPersist) >= progressPersistInterval {
+		s.lastPersist = now
+		persist = true
+	}
+
+	return true, persist
+}
+
+func (s *transferStats) snapshot() transferSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transferred, total := s.finishedBytes, s.finishedTotal
+	fraction := 0.0 // 进行中条目完成的比例之和
+	for _, item := range s.items {
+		transferred += item.transferred
+		total += item.total
+		if item.total > 0 {
+			fraction += float64(item.transferred) / float64(item.total)
+		}
+	}
+
+	finished := s.done + s.failed
+	snap := transferSnapshot{
+		Transferred: transferred,
+		Total:       total,
+		Done:        s.done,
+		Failed:      s.failed,
+		Items:       s.totalItems,
+	}
+
+	switch {
+	case s.totalItems > 0:
+		snap.Progress = (float64(finished) + fraction) / float64(s.totalItems) * 100
+	case total > 0:
+		snap.Progress = float64(transferred) / float64(total) * 100
+	}
+	if snap.Progress > 100 {
+		snap.Progress = 100
+	}
+
+	// 按条目统计时，传输量为已完成的条目数
+	amount := transferred
+	if s.unit == unitItems {
+		amount = int64(finished)
+		snap.Transferred, snap.Total = amount, int64(s.totalItems)
+	}
+
+	now := time.Now()
+	if dt := now.Sub(s.lastSample); dt >= progressSpeedWindow {
+		s.speed = float64(amount-s.lastAmount) / dt.Seconds()
+		s.lastAmount, s.lastSample = amount, now
+	}
+
+	snap.Speed = s.formatSpeed()
+	snap.ETA = "--"
+	if snap.Progress > 0 && snap.Progress < 100 {
+		elapsed := now.Sub(s.start)
+		remaining := time.Duration(float64(elapsed) * (100 - snap.Progress) / snap.Progress)
+		snap.ETA = remaining.Round(time.Second).String()
+	}
+
+	return snap
+}
+
+func (s *transferStats) formatSpeed() string {
+	if s.unit == unitItems {
+		return fmt.Sprintf("%.1f msg/s", s.speed)
+	}
+	return utils.Byte.FormatBinaryBytes(int64(s.speed)) + "/s"
+}
+
+// downloadProgress 将dl.Run的下载进度同步到任务和WebSocket，并记录下载失败的文件
+type downloadProgress struct {
+	h       *DownloadHandler
+	taskID  string
+	account int64 // 执行下载的账号，用于统计传输量和路由WebSocket事件
+	stats   *transferStats
+
+	mu     sync.Mutex
+	failed []FailedItem
+	files  []string // 下载完成的文件路径
+}
+
+var _ downloader.Progress = (*downloadProgress)(nil)
+
+func newDownloadProgress(h *DownloadHandler, taskID string, account int64) *downloadProgress {
+	return &downloadProgress{
+		h:       h,
+		taskID:  taskID,
+		account: account,
+		stats:   newTransferStats(unitBytes),
+		failed:  []FailedItem{},
+	}
+}
+
+func (p *downloadProgress) SetTotal(total int) {
+	p.stats.setTotal(total)
+	p.emit(true)
+}
+
+func (p *downloadProgress) OnAdd(elem downloader.Elem) {
+	var offset int64
+	if r, ok := elem.(downloader.Resumable); ok {
+		offset = r.Offset()
+	}
+	p.stats.add(elem, offset, elem.File().Size())
+	p.emit(false)
+}
+
+func (p *downloadProgress) OnDownload(elem downloader.Elem, state downloader.ProgressState) {
+	n := p.stats.update(elem, state.Downloaded, state.Total)
+	p.h.metrics.AddTransfer(p.account, service.TransferDownload, n)
+	p.emit(false)
+}
+
+func (p *downloadProgress) OnDone(elem downloader.Elem, err error) {
+	p.stats.finish(elem, err)
+
+	if m, ok := elem.(dl.MessageElem); ok && err != nil && !errors.Is(err, context.Canceled) {
+		p.mu.Lock()
+		p.failed = append(p.failed, FailedItem{
+			ChatID:    m.From().ID(),
+			MessageID: m.Msg().ID,
+			Error:     err.Error(),
+		})
+		p.mu.Unlock()
+	}
+
+	if f, ok := elem.(dl.FileElem); ok && err == nil && f.Path() != "" {
+		p.mu.Lock()
+		p.files = append(p.files, f.Path())
+		p.mu.Unlock()
+	}
+
+	p.emit(true)
+}
+
+func (p *downloadProgress) emit(force bool) {
+	emit, persist := p.stats.tick(force)
+	if !emit {
+		return
+	}
+
+	p.mu.Lock()
+	failed := make([]FailedItem, len(p.failed))
+	copy(failed, p.failed)
+	files := make([]string, len(p.files))
+	copy(files, p.files)
+	p.mu.Unlock()
+
+	snap := p.stats.snapshot()
+	p.h.wsHub.BroadcastProgress(p.account, progressData(p.taskID, "download", snap))
+	p.h.updateTaskProgress(p.taskID, snap, failed, files, persist)
+}
+
+// forwardProgress 将forward.Run的转发进度同步到任务和WebSocket，并记录每条消息的结果
+type forwardProgress struct {
+	h       *ForwardHandler
+	taskID  string
+	account int64 // 执行转发的账号，用于路由WebSocket事件
+	stats   *transferStats
+
+	mu       sync.Mutex
+	messages []MessageStat
+	index    map[forwarder.Elem]int // elem -> messages下标
+}
+
+var _ forwarder.Progress = (*forwardProgress)(nil)
+
+func newForwardProgress(h *ForwardHandler, taskID string, account int64) *forwardProgress {
+	return &forwardProgress{
+		h:        h,
+		taskID:   taskID,
+		account:  account,
+		stats:    newTransferStats(unitItems),
+		messages: []MessageStat{},
+		index:    make(map[forwarder.Elem]int),
+	}
+}
+
+func (p *forwardProgress) SetTotal(total int) {
+	p.stats.setTotal(total)
+	p.emit(true)
+}
+
+func (p *forwardProgress) OnAdd(elem forwarder.Elem) {
+	p.stats.add(elem, 0, 0)
+
+	p.mu.Lock()
+	p.index[elem] = len(p.messages)
+	p.messages = append(p.messages, MessageStat{
+		FromChat:  elem.From().VisibleName(),
+		FromID:    elem.From().ID(),
+		MessageID: elem.Msg().ID,
+		ToChat:    elem.To().VisibleName(),
+		Status:    "pending",
+	})
+	p.mu.Unlock()
+
+	p.emit(false)
+}
+
+func (p *forwardProgress) OnClone(elem forwarder.Elem, state forwarder.ProgressState) {
+	p.stats.update(elem, state.Done, state.Total)
+	p.emit(false)
+}
+
+func (p *forwardProgress) OnDone(elem forwarder.Elem, err error) {
+	p.stats.finish(elem, err)
+
+	p.mu.Lock()
+	if i, ok := p.index[elem]; ok {
+		now := time.Now()
+		stat := &p.messages[i]
+		if err != nil {
+			stat.Status = "failed"
+			stat.Error = err.Error()
+		} else {
+			stat.Status = "success"
+			stat.ForwardedAt = &now
+		}
+		delete(p.index, elem)
+	}
+	p.mu.Unlock()
+
+	p.emit(true)
+}
+
+func (p *forwardProgress) emit(force bool) {
+	emit, persist := p.stats.tick(force)
+	if !emit {
+		return
+	}
+
+	p.mu.Lock()
+	messages := make([]MessageStat, len(p.messages))
+	copy(messages, p.messages)
+	p.mu.Unlock()
+
+	snap := p.stats.snapshot()
+	p.h.wsHub.BroadcastProgress(p.account, progressData(p.taskID, "forward", snap))
+	p.h.updateForwardProgress(p.taskID, snap, messages, persist)
+}
+
+// uploadProgress 将up.Run的上传进度同步到任务和WebSocket，并记录每个文件的结果
+type uploadProgress struct {
+	h       *UploadHandler
+	taskID  string
+	account int64 // 执行上传的账号，用于统计传输量和路由WebSocket事件
+	stats   *transferStats
+
+	mu    sync.Mutex
+	files []FileUploadInfo
+	index map[uploader.Elem]int // elem -> files下标
+}
+
+var _ uploader.Progress = (*uploadProgress)(nil)
+
+func newUploadProgress(h *UploadHandler, taskID string, account int64) *uploadProgress {
+	return &uploadProgress{
+		h:       h,
+		taskID:  taskID,
+		account: account,
+		stats:   newTransferStats(unitBytes),
+		files:   []FileUploadInfo{},
+		index:   make(map[uploader.Elem]int),
+	}
+}
+
+func (p *uploadProgress) SetTotal(total int) {
+	p.stats.setTotal(total)
+	p.emit(true)
+}
+
+func (p *uploadProgress) OnAdd(elem uploader.Elem) {
+	p.stats.add(elem, 0, elem.File().Size())
+
+	// 目录中的文件由up.Run遍历得到，记录完整路径以便只重试失败的文件
+	path := elem.File().Name()
+	if fe, ok := elem.(up.FileElem); ok {
+		path = fe.Path()
+	}
+
+	p.mu.Lock()
+	p.index[elem] = len(p.files)
+	p.files = append(p.files, FileUploadInfo{
+		FilePath: path,
+		Status:   "uploading",
+	})
+	p.mu.Unlock()
+
+	p.emit(false)
+}
+
+func (p *uploadProgress) OnUpload(elem uploader.Elem, state uploader.ProgressState) {
+	n := p.stats.update(elem, state.Uploaded, state.Total)
+	p.h.metrics.AddTransfer(p.account, service.TransferUpload, n)
+	p.emit(false)
+}
+
+func (p *uploadProgress) OnDone(elem uploader.Elem, err error) {
+	p.stats.finish(elem, err)
+
+	p.mu.Lock()
+	if i, ok := p.index[elem]; ok {
+		file := &p.files[i]
+		if err != nil {
+			file.Status = "failed"
+			file.Error = err.Error()
+		} else {
+			file.Status = "success"
+			file.UploadedAt = time.Now()
+		}
+		delete(p.index, elem)
+	}
+	p.mu.Unlock()
+
+	p.emit(true)
+}
+
+func (p *uploadProgress) emit(force bool) {
+	emit, persist := p.stats.tick(force)
+	if !emit {
+		return
+	}
+
+	p.mu.Lock()
+	files := make([]FileUploadInfo, len(p.files))
+	copy(files, p.files)
+	p.mu.Unlock()
+
+	snap := p.stats.snapshot()
+	p.h.wsHub.BroadcastProgress(p.account, progressData(p.taskID, "upload", snap))
+	p.h.updateUploadProgress(p.taskID, snap, files, persist)
+}
+
+func progressData(taskID, taskType string, snap transferSnapshot) websocket.ProgressData {
+	return websocket.ProgressData{
+		TaskID:      taskID,
+		TaskType:    taskType,
+		Progress:    snap.Progress,
+		Speed:       snap.Speed,
+		ETA:         snap.ETA,
+		Transferred: snap.Transferred,
+		Total:       snap.Total,
+	}
+}
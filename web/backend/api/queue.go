@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+type QueueHandler struct {
+	ctx         context.Context
+	scheduler   *service.Scheduler
+	authService *service.AuthService
+}
+
+func NewQueueHandler(ctx context.Context, scheduler *service.Scheduler, authService *service.AuthService) *QueueHandler {
+	return &QueueHandler{
+		ctx:         ctx,
+		scheduler:   scheduler,
+		authService: authService,
+	}
+}
+
+// PriorityRequest 修改排队任务优先级的请求
+type PriorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+// MoveRequest 调整排队任务位置的请求
+type MoveRequest struct {
+	Position int `json:"position"` // 目标位置，0表示队首
+}
+
+// GetQueue 获取运行中和排队中的任务，只包含发起请求的客户端可以访问的任务
+func (h *QueueHandler) GetQueue(c *gin.Context) {
+	jobs := make([]service.JobInfo, 0)
+	for _, job := range h.scheduler.List() {
+		if canAccessTask(c, h.authService, job.ClientID, job.AccountID) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	Success(c, map[string]interface{}{
+		"jobs":  jobs,
+		"total": len(jobs),
+	})
+}
+
+// SetPriority 修改排队任务的优先级
+func (h *QueueHandler) SetPriority(c *gin.Context) {
+	var req PriorityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	if !h.accessibleJob(c, c.Param("id")) {
+		return
+	}
+
+	position, err := h.scheduler.SetPriority(c.Param("id"), req.Priority)
+	if err != nil {
+		h.queueError(c, err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]int{
+		"position": position,
+	}, "Task priority updated")
+}
+
+// MoveTask 将排队任务移动到指定位置
+func (h *QueueHandler) MoveTask(c *gin.Context) {
+	var req MoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	if !h.accessibleJob(c, c.Param("id")) {
+		return
+	}
+
+	position, err := h.scheduler.Move(c.Param("id"), req.Position)
+	if err != nil {
+		h.queueError(c, err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]int{
+		"position": position,
+	}, "Task moved")
+}
+
+// BumpTask 将排队任务移到队首
+func (h *QueueHandler) BumpTask(c *gin.Context) {
+	if !h.accessibleJob(c, c.Param("id")) {
+		return
+	}
+
+	if err := h.scheduler.Bump(c.Param("id")); err != nil {
+		h.queueError(c, err)
+		return
+	}
+
+	SuccessWithMessage(c, map[string]int{
+		"position": 0,
+	}, "Task moved to the front of the queue")
+}
+
+// accessibleJob 任务是否在队列中且发起请求的客户端可以访问，否则返回404
+func (h *QueueHandler) accessibleJob(c *gin.Context, id string) bool {
+	job, ok := h.scheduler.Get(id)
+	if !ok || !canAccessTask(c, h.authService, job.ClientID, job.AccountID) {
+		NotFoundError(c, "Task is not queued")
+		return false
+	}
+	return true
+}
+
+func (h *QueueHandler) queueError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrJobNotQueued) {
+		NotFoundError(c, "Task is not queued")
+		return
+	}
+
+	InternalError(c, "Failed to update queue", err)
+}
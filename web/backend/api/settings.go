@@ -3,17 +3,20 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/pkg/transfer"
 )
 
 type SettingsHandler struct {
-	ctx     context.Context
-	kvStore kv.Storage
+	ctx       context.Context
+	kvStore   kv.Storage
+	listeners []func(*Settings)
 }
 
 func NewSettingsHandler(ctx context.Context, kvStore kv.Storage) *SettingsHandler {
@@ -23,6 +26,18 @@ func NewSettingsHandler(ctx context.Context, kvStore kv.Storage) *SettingsHandle
 	}
 }
 
+// OnChange 注册设置变更回调，在设置更新或重置后调用
+func (h *SettingsHandler) OnChange(fn func(*Settings)) {
+	h.listeners = append(h.listeners, fn)
+}
+
+// notify 通知所有设置变更回调
+func (h *SettingsHandler) notify(settings *Settings) {
+	for _, fn := range h.listeners {
+		fn(settings)
+	}
+}
+
 // Settings 设置数据结构
 type Settings struct {
 	GlobalProxy       string `json:"globalProxy"`
@@ -30,6 +45,35 @@ type Settings struct {
 	MaxThreads        int    `json:"maxThreads"`
 	MaxTasks          int    `json:"maxTasks"`
 	PartSize          int    `json:"partSize"`
+	Limit             int    `json:"limit"`    // 单个任务内同时传输的文件数
+	PoolSize          int    `json:"poolSize"` // DC连接池大小，0表示不限制
+	Delay             int    `json:"delay"`    // 每个文件之间的延迟，毫秒
+}
+
+// defaultSettings 默认设置，与CLI参数的默认值一致
+func defaultSettings() Settings {
+	return Settings{
+		GlobalProxy:      "",
+		ReconnectTimeout: 300,
+		MaxThreads:       4,
+		MaxTasks:         2,
+		PartSize:         512,
+		Limit:            2,
+		PoolSize:         8,
+		Delay:            0,
+	}
+}
+
+// Transfer 将设置转换为dl/up/forward的传输参数
+func (s *Settings) Transfer() transfer.Options {
+	return transfer.Options{
+		Threads:          s.MaxThreads,
+		Limit:            s.Limit,
+		PoolSize:         int64(s.PoolSize),
+		Delay:            time.Duration(s.Delay) * time.Millisecond,
+		ReconnectTimeout: time.Duration(s.ReconnectTimeout) * time.Second,
+		PartSize:         s.PartSize * 1024,
+	}
 }
 
 // GetSettings 获取设置
@@ -51,13 +95,7 @@ func (h *SettingsHandler) GetSettings(c *gin.Context) {
 	}
 
 	// 默认设置
-	settings := Settings{
-		GlobalProxy:      "",
-		ReconnectTimeout: 300,
-		MaxThreads:       4,
-		MaxTasks:         2,
-		PartSize:         512,
-	}
+	settings := defaultSettings()
 
 	// 如果存在保存的设置，解析JSON
 	if data != nil {
@@ -71,7 +109,7 @@ func (h *SettingsHandler) GetSettings(c *gin.Context) {
 
 // UpdateSettings 更新设置
 func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
-	var settings Settings
+	settings := defaultSettings() // 请求中未包含的字段使用默认值
 	if err := c.ShouldBindJSON(&settings); err != nil {
 		ValidationError(c, err.Error())
 		return
@@ -94,6 +132,18 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 		ValidationError(c, "Part size must be between 64 and 2048 KB")
 		return
 	}
+	if settings.Limit < 1 || settings.Limit > 16 {
+		ValidationError(c, "Limit must be between 1 and 16")
+		return
+	}
+	if settings.PoolSize < 0 || settings.PoolSize > 64 {
+		ValidationError(c, "Pool size must be between 0 and 64")
+		return
+	}
+	if settings.Delay < 0 || settings.Delay > 60000 {
+		ValidationError(c, "Delay must be between 0 and 60000 ms")
+		return
+	}
 
 	// 打开设置存储命名空间
 	settingsStorage, err := h.kvStore.Open("settings")
@@ -123,7 +173,12 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 		zap.Int("reconnectTimeout", settings.ReconnectTimeout),
 		zap.Int("maxThreads", settings.MaxThreads),
 		zap.Int("maxTasks", settings.MaxTasks),
-		zap.Int("partSize", settings.PartSize))
+		zap.Int("partSize", settings.PartSize),
+		zap.Int("limit", settings.Limit),
+		zap.Int("poolSize", settings.PoolSize),
+		zap.Int("delay", settings.Delay))
+
+	h.notify(&settings)
 
 	SuccessWithMessage(c, settings, "Settings updated successfully")
 }
@@ -146,16 +201,12 @@ func (h *SettingsHandler) ResetSettings(c *gin.Context) {
 	}
 
 	// 默认设置
-	settings := Settings{
-		GlobalProxy:      "",
-		ReconnectTimeout: 300,
-		MaxThreads:       4,
-		MaxTasks:         2,
-		PartSize:         512,
-	}
+	settings := defaultSettings()
 
 	logctx.From(h.ctx).Info("Settings reset to defaults")
 
+	h.notify(&settings)
+
 	SuccessWithMessage(c, settings, "Settings reset to defaults successfully")
 }
 
@@ -174,20 +225,24 @@ func (h *SettingsHandler) GetCurrentSettings() (*Settings, error) {
 	}
 
 	// 默认设置
-	settings := &Settings{
-		GlobalProxy:      "",
-		ReconnectTimeout: 300,
-		MaxThreads:       4,
-		MaxTasks:         2,
-		PartSize:         512,
-	}
+	settings := defaultSettings()
 
 	// 如果存在保存的设置，解析JSON
 	if data != nil {
-		if err := json.Unmarshal(data, settings); err != nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
 			logctx.From(h.ctx).Warn("Failed to parse settings JSON, using defaults", zap.Error(err))
 		}
 	}
 
-	return settings, nil
-}
\ No newline at end of file
+	return &settings, nil
+}
+// currentTransfer 读取任务使用的传输参数，读取设置失败时使用默认设置
+func currentTransfer(ctx context.Context, kvd kv.Storage) transfer.Options {
+	settings, err := NewSettingsHandler(ctx, kvd).GetCurrentSettings()
+	if err != nil {
+		logctx.From(ctx).Warn("Failed to load settings, using default transfer options", zap.Error(err))
+		defaults := defaultSettings()
+		settings = &defaults
+	}
+	return settings.Transfer()
+}
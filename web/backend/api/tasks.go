@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/web/backend/middleware"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+const (
+	// TaskStatusInterrupted 服务重启时仍未完成的任务状态
+	TaskStatusInterrupted = "interrupted"
+
+	taskInterruptedMessage = "Task was interrupted by server restart"
+)
+
+// isUnfinishedStatus 任务是否处于未结束的状态
+func isUnfinishedStatus(status string) bool {
+	return status == "pending" || status == "queued" || status == "running"
+}
+
+// newTaskID 生成任务ID，包含随机部分，同一秒内创建的任务不会重复
+func newTaskID(taskType string) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", taskType, time.Now().Unix(), hex.EncodeToString(b))
+}
+
+// createTask 持久化新任务，ID已被使用或同ID的任务仍在队列中时返回service.ErrTaskExists
+//
+// 客户端可以指定任务ID，覆盖已有的任务会丢失其记录和取消函数
+func createTask(ctx context.Context, tasks *service.TaskRepository, scheduler *service.Scheduler, id, taskType, status string, createdAt time.Time, data interface{}) error {
+	if scheduler.Active(id) {
+		return errors.Wrapf(service.ErrTaskExists, "task %s", id)
+	}
+	if tasks == nil {
+		return nil
+	}
+	return tasks.Create(ctx, id, taskType, status, createdAt, data)
+}
+
+// taskIDInUse 任务ID是否已被任务记录或队列中的任务使用，用于在产生临时文件等副作用前提前检查
+func taskIDInUse(ctx context.Context, tasks *service.TaskRepository, scheduler *service.Scheduler, id string) bool {
+	if scheduler.Active(id) {
+		return true
+	}
+	if tasks == nil {
+		return false
+	}
+	_, err := tasks.Get(ctx, id)
+	return err == nil
+}
+
+// taskError 任务ID冲突时返回409，其他错误返回500
+func taskError(c *gin.Context, message string, err error) {
+	if errors.Is(err, service.ErrTaskExists) || errors.Is(err, service.ErrJobExists) {
+		Error(c, http.StatusConflict, err)
+		return
+	}
+	InternalError(c, message, err)
+}
+
+// canAccessTask 发起请求的客户端是否可以查看和操作任务，无权访问的任务按不存在处理
+func canAccessTask(c *gin.Context, auth *service.AuthService, taskClient string, accountID int64) bool {
+	clientID, err := middleware.Client(c)
+	return err == nil && auth.CanAccessTask(clientID, taskClient, accountID)
+}
+
+// persistTask 将任务写入任务仓库，失败只记录日志，不影响任务本身
+func persistTask(ctx context.Context, tasks *service.TaskRepository, id, taskType, status string, createdAt time.Time, data interface{}) {
+	if tasks == nil {
+		return
+	}
+
+	if err := tasks.Save(ctx, id, taskType, status, createdAt, data); err != nil {
+		logctx.From(ctx).Warn("Failed to persist task",
+			zap.String("task_id", id),
+			zap.Error(err))
+	}
+}
+
+// deletePersistedTask 从任务仓库中删除任务
+func deletePersistedTask(ctx context.Context, tasks *service.TaskRepository, id string) {
+	if tasks == nil {
+		return
+	}
+
+	if err := tasks.Delete(ctx, id); err != nil {
+		logctx.From(ctx).Warn("Failed to delete persisted task",
+			zap.String("task_id", id),
+			zap.Error(err))
+	}
+}
+
+// loadPersistedTasks 加载指定类型的任务记录，逐条交给fn解码
+func loadPersistedTasks(ctx context.Context, tasks *service.TaskRepository, taskType string, fn func(data []byte) error) {
+	if tasks == nil {
+		return
+	}
+
+	records, err := tasks.List(ctx, taskType)
+	if err != nil {
+		logctx.From(ctx).Error("Failed to load persisted tasks",
+			zap.String("type", taskType),
+			zap.Error(err))
+		return
+	}
+
+	for _, record := range records {
+		if err := fn(record.Data); err != nil {
+			logctx.From(ctx).Warn("Skip broken persisted task",
+				zap.String("task_id", record.ID),
+				zap.Error(err))
+		}
+	}
+
+	logctx.From(ctx).Info("Loaded persisted tasks",
+		zap.String("type", taskType),
+		zap.Int("count", len(records)))
+}
+
+// decodeTaskConfig 从任务Config中解码请求结构
+//
+// 新建的任务中存放的是请求结构体，从存储加载的任务则是map，统一通过JSON转换
+func decodeTaskConfig(config map[string]interface{}, key string, v interface{}) bool {
+	raw, ok := config[key]
+	if !ok || raw == nil {
+		return false
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, v) == nil
+}
+
+// RetryRequest 重试任务的请求
+type RetryRequest struct {
+	FailedOnly bool `json:"failed_only"` // 只重试上次执行中失败的消息或文件
+}
+
+// bindRetryRequest 解析重试请求，请求体可以为空
+func bindRetryRequest(c *gin.Context) (RetryRequest, bool) {
+	var req RetryRequest
+	if c.Request.ContentLength == 0 {
+		return req, true
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// writeMessageFiles 将每个聊天的消息写入一个tdl JSON文件，供dl.Run和forward.Run读取
+func writeMessageFiles(prefix string, messages map[int64][]int) ([]string, error) {
+	chats := make([]int64, 0, len(messages))
+	for chat := range messages {
+		chats = append(chats, chat)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+
+	files := make([]string, 0, len(chats))
+	for _, chat := range chats {
+		msgs := make([]map[string]interface{}, 0, len(messages[chat]))
+		for _, id := range messages[chat] {
+			// dl.Run只读取带有file字段的消息
+			msgs = append(msgs, map[string]interface{}{
+				"id":   id,
+				"type": "message",
+				"file": "retry",
+			})
+		}
+
+		data, err := json.Marshal(map[string]interface{}{
+			"id":       chat,
+			"messages": msgs,
+		})
+		if err != nil {
+			removeFiles(files)
+			return nil, errors.Wrap(err, "serialize messages")
+		}
+
+		file := filepath.Join(os.TempDir(), fmt.Sprintf("%s_%d_%d.json", prefix, chat, time.Now().UnixNano()))
+		if err = os.WriteFile(file, data, 0644); err != nil {
+			removeFiles(files)
+			return nil, errors.Wrap(err, "write messages file")
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// removeFiles 删除临时文件，忽略错误
+func removeFiles(files []string) {
+	for _, file := range files {
+		_ = os.Remove(file)
+	}
+}
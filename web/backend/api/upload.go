@@ -2,30 +2,26 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-faster/errors"
-	"github.com/gotd/td/telegram"
 	"go.uber.org/zap"
 
 	"github.com/iyear/tdl/app/up"
 	"github.com/iyear/tdl/core/logctx"
-	tclientcore "github.com/iyear/tdl/core/tclient"
-	"github.com/iyear/tdl/pkg/tclient"
-	"github.com/iyear/tdl/core/storage"
 	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/web/backend/middleware"
 	"github.com/iyear/tdl/web/backend/service"
-	"github.com/iyear/tdl/web/backend/util"
 	"github.com/iyear/tdl/web/backend/websocket"
 )
 
@@ -34,19 +30,39 @@ type UploadHandler struct {
 	kvd         kv.Storage
 	wsHub       *websocket.Hub
 	authService *service.AuthService
-	activeTasks sync.Map // taskID -> context.CancelFunc
-	taskStore   sync.Map // taskID -> UploadTaskInfo (in-memory storage)
+	clients     *service.ClientManager
+	paths       *service.PathPolicy
+	tasks       *service.TaskRepository
+	scheduler   *service.Scheduler
+	metrics     *service.Metrics
+	taskStore   sync.Map // taskID -> *UploadTaskInfo (in-memory cache of persisted tasks)
+
+	sessions      *service.UploadSessionStore // 分块上传会话
+	sessionLocks  uploadSessionLocks
+	stagingPolicy service.StagingPolicy
 }
 
-func NewUploadHandler(ctx context.Context, kvd kv.Storage, wsHub *websocket.Hub) *UploadHandler {
-	return &UploadHandler{
+func NewUploadHandler(ctx context.Context, kvd kv.Storage, authService *service.AuthService, wsHub *websocket.Hub, clients *service.ClientManager, paths *service.PathPolicy, tasks *service.TaskRepository, sessions *service.UploadSessionStore, scheduler *service.Scheduler, metrics *service.Metrics) *UploadHandler {
+	h := &UploadHandler{
 		ctx:         ctx,
 		kvd:         kvd,
 		wsHub:       wsHub,
-		authService: service.NewAuthService(ctx, kvd),
-		activeTasks: sync.Map{},
+		authService: authService,
+		clients:     clients,
+		paths:       paths,
+		tasks:       tasks,
+		scheduler:   scheduler,
+		metrics:     metrics,
 		taskStore:   sync.Map{},
+
+		sessions:      sessions,
+		sessionLocks:  uploadSessionLocks{locks: make(map[string]bool)},
+		stagingPolicy: service.DefaultStagingPolicy,
 	}
+
+	h.restoreTasks()
+	go h.sweepStaging()
+	return h
 }
 
 // UploadRequest represents an upload request from web interface
@@ -56,6 +72,8 @@ type UploadRequest struct {
 	Remove     bool     `json:"remove"`                          // 上传后删除文件
 	Photo      bool     `json:"photo"`                           // 作为照片上传而不是文件
 	TaskID     string   `json:"task_id"`                         // 任务ID
+	Priority   int      `json:"priority"`                        // 排队优先级，数值越大越先执行
+	AccountID  int64    `json:"account_id,omitempty"`            // 执行任务的Telegram账号，为空时使用当前账号
 }
 
 // UploadTaskInfo represents upload task information
@@ -75,6 +93,9 @@ type UploadTaskInfo struct {
 	Config        map[string]interface{} `json:"config,omitempty"`
 	ToChat        string                 `json:"to_chat"`       // 目标聊天
 	FilePaths     []string               `json:"file_paths"`    // 文件路径列表
+	ClientID      string                 `json:"client_id,omitempty"` // 创建任务的客户端
+	AccountID     int64                  `json:"account_id,omitempty"` // 执行任务的Telegram账号
+	Files         []FileUploadInfo       `json:"files,omitempty"` // 每个文件的上传结果
 }
 
 // FileUploadInfo represents single file upload statistics
@@ -100,6 +121,8 @@ func (h *UploadHandler) StartUpload(c *gin.Context) {
 	remove := c.PostForm("remove") == "true"
 	photo := c.PostForm("photo") == "true"
 	taskID := c.PostForm("task_id")
+	priority, _ := strconv.Atoi(c.PostForm("priority"))
+	accountID, _ := strconv.ParseInt(c.PostForm("account_id"), 10, 64)
 
 	var excludes []string
 	if excludesStr != "" {
@@ -109,9 +132,27 @@ func (h *UploadHandler) StartUpload(c *gin.Context) {
 		}
 	}
 
-	// 生成任务ID
+	// 获取客户端ID
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		logctx.From(h.ctx).Error("Failed to get client ID", zap.Error(err))
+		InternalServerError(c, "Failed to identify client")
+		return
+	}
+
+	// 确定任务使用的账号，未指定时使用当前账号
+	accountID, err = h.authService.ResolveAccount(clientID, accountID)
+	if err != nil {
+		AccountError(c, err)
+		return
+	}
+
+	// 生成任务ID，指定的ID已被使用时不能写入其临时目录
 	if taskID == "" {
-		taskID = fmt.Sprintf("upload-%d-%s", time.Now().Unix(), h.generateShortID())
+		taskID = newTaskID("upload")
+	} else if taskIDInUse(h.ctx, h.tasks, h.scheduler, taskID) {
+		taskError(c, "Failed to create task", errors.Wrapf(service.ErrTaskExists, "task %s", taskID))
+		return
 	}
 
 	// 获取上传的文件
@@ -132,8 +173,13 @@ func (h *UploadHandler) StartUpload(c *gin.Context) {
 
 	var filePaths []string
 	for _, fileHeader := range files {
-		filePath := filepath.Join(tempDir, fileHeader.Filename)
-		
+		// 浏览器提供的文件名可能包含路径，只保留清理后的文件名
+		filePath, err := h.paths.Staging.Join(tempDir, fileHeader.Filename)
+		if err != nil {
+			PathForbiddenError(c, fmt.Sprintf("Invalid file name %q: %v", fileHeader.Filename, err))
+			return
+		}
+
 		if err := h.saveUploadedFile(fileHeader, filePath); err != nil {
 			logctx.From(h.ctx).Error("Failed to save uploaded file", 
 				zap.String("filename", fileHeader.Filename), 
@@ -150,7 +196,7 @@ func (h *UploadHandler) StartUpload(c *gin.Context) {
 		ID:        taskID,
 		Type:      "upload",
 		Name:      fmt.Sprintf("上传 %d 个文件", len(filePaths)),
-		Status:    "pending",
+		Status:    "queued",
 		Progress:  0,
 		Speed:     "0 B/s",
 		ETA:       "计算中...",
@@ -161,76 +207,296 @@ func (h *UploadHandler) StartUpload(c *gin.Context) {
 		ToChat:    toChat,
 		FilePaths: filePaths,
 		Config: map[string]interface{}{
-			"excludes": excludes,
-			"remove":   remove,
-			"photo":    photo,
+			"excludes":   excludes,
+			"remove":     remove,
+			"photo":      photo,
+			"priority":   priority,
+			"account_id": accountID,
 		},
+		ClientID:  clientID,
+		AccountID: accountID,
 	}
 
 	// 存储任务信息
-	h.taskStore.Store(taskID, taskInfo)
+	if err := h.createTask(taskInfo); err != nil {
+		taskError(c, "Failed to create task", err)
+		return
+	}
 
-	// 获取客户端ID
-	clientID, err := h.getOrCreateClientID(c)
+	// 提交到调度器排队执行上传任务
+	if err := h.runUpload(taskID, UploadRequest{
+		ToChat:    toChat,
+		Excludes:  excludes,
+		Remove:    remove,
+		Photo:     photo,
+		Priority:  priority,
+		AccountID: accountID,
+	}, clientID, filePaths); err != nil {
+		taskError(c, "Failed to queue task", err)
+		return
+	}
+
+	Success(c, map[string]interface{}{
+		"message":     "Upload task submitted successfully",
+		"task_id":     taskID,
+		"file_count":  len(filePaths),
+		"to_chat":     toChat,
+		"status":      "queued",
+	})
+}
+
+// LocalUploadRequest 上传服务器上已有的文件或目录，目录会被递归遍历
+type LocalUploadRequest struct {
+	Paths     []string `json:"paths" binding:"required,min=1"` // 上传根目录中的文件或目录
+	ToChat    string   `json:"to_chat"`                        // 目标聊天ID或用户名（空字符串表示Saved Messages）
+	Excludes  []string `json:"excludes"`                       // 排除的文件扩展名
+	Remove    bool     `json:"remove"`                         // 上传后删除原文件
+	Photo     bool     `json:"photo"`                          // 作为照片上传而不是文件
+	TaskID    string   `json:"task_id"`                        // 任务ID
+	Priority  int      `json:"priority"`                       // 排队优先级，数值越大越先执行
+	AccountID int64    `json:"account_id,omitempty"`           // 执行任务的Telegram账号，为空时使用当前账号
+}
+
+// StartLocalUpload 上传服务器本地的文件和目录，不经过浏览器中转
+//
+// 路径必须位于上传根目录中，目录的遍历、排除扩展名和.thumb缩略图与命令行的tdl up相同
+func (h *UploadHandler) StartLocalUpload(c *gin.Context) {
+	var req LocalUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	clientID, err := middleware.Client(c)
 	if err != nil {
 		logctx.From(h.ctx).Error("Failed to get client ID", zap.Error(err))
 		InternalServerError(c, "Failed to identify client")
 		return
 	}
 
-	// 异步执行上传任务
-	ctx, cancel := context.WithCancel(h.ctx)
-	h.activeTasks.Store(taskID, cancel)
-
-	go func() {
-		defer func() {
-			h.activeTasks.Delete(taskID)
-			// 清理临时文件
-			os.RemoveAll(tempDir)
-		}()
-
-		err := h.executeUpload(ctx, clientID, taskID, filePaths, up.Options{
-			Chat:     toChat,
-			Paths:    filePaths,
-			Excludes: excludes,
-			Remove:   remove,
-			Photo:    photo,
-		})
-
-		// 更新任务状态
-		if taskInfoRaw, ok := h.taskStore.Load(taskID); ok {
-			taskInfo := taskInfoRaw.(*UploadTaskInfo)
+	// 确定任务使用的账号，未指定时使用当前账号
+	if req.AccountID, err = h.authService.ResolveAccount(clientID, req.AccountID); err != nil {
+		AccountError(c, err)
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Paths))
+	filePaths := make([]string, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		path, ok := resolvePath(c, h.paths.Upload, p)
+		if !ok {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			NotFoundError(c, fmt.Sprintf("Path not found: %s", p))
+			return
+		}
+		if !seen[path] {
+			seen[path] = true
+			filePaths = append(filePaths, path)
+		}
+	}
+
+	if req.TaskID == "" {
+		req.TaskID = newTaskID("upload")
+	}
+
+	name := fmt.Sprintf("上传 %d 个本地路径", len(filePaths))
+	if len(filePaths) == 1 {
+		name = fmt.Sprintf("上传 %s", filepath.Base(filePaths[0]))
+	}
+
+	if err := h.createTask(&UploadTaskInfo{
+		ID:        req.TaskID,
+		Type:      "upload",
+		Name:      name,
+		Status:    "queued",
+		Speed:     "0 B/s",
+		ETA:       "计算中...",
+		Total:     len(filePaths), // 包含目录时在遍历后更新
+		CreatedAt: time.Now(),
+		ToChat:    req.ToChat,
+		FilePaths: filePaths,
+		Config: map[string]interface{}{
+			"excludes":   req.Excludes,
+			"remove":     req.Remove,
+			"photo":      req.Photo,
+			"priority":   req.Priority,
+			"account_id": req.AccountID,
+			"local":      true,
+		},
+		ClientID:  clientID,
+		AccountID: req.AccountID,
+	}); err != nil {
+		taskError(c, "Failed to create task", err)
+		return
+	}
+
+	if err := h.runUpload(req.TaskID, UploadRequest{
+		ToChat:    req.ToChat,
+		Excludes:  req.Excludes,
+		Remove:    req.Remove,
+		Photo:     req.Photo,
+		TaskID:    req.TaskID,
+		Priority:  req.Priority,
+		AccountID: req.AccountID,
+	}, clientID, filePaths); err != nil {
+		taskError(c, "Failed to queue task", err)
+		return
+	}
+
+	Success(c, map[string]interface{}{
+		"message": "Upload task submitted successfully",
+		"task_id": req.TaskID,
+		"paths":   filePaths,
+		"to_chat": req.ToChat,
+		"status":  "queued",
+	})
+}
+
+// runUpload 将上传任务提交到调度器排队执行
+//
+// 全部文件上传成功后立即清理临时目录，有失败的文件时保留以便重试，
+// 之后由sweepStaging按StagingPolicy清理
+func (h *UploadHandler) runUpload(taskID string, req UploadRequest, clientID string, paths []string) error {
+	_, err := h.scheduler.Submit(service.Job{
+		ID:        taskID,
+		Type:      "upload",
+		Priority:  req.Priority,
+		ClientID:  clientID,
+		AccountID: req.AccountID,
+		Run: func(ctx context.Context) error {
+			h.updateTask(taskID, func(task *UploadTaskInfo) {
+				task.Status = "running"
+				task.Error = ""
+			})
+
+			err := h.executeUpload(ctx, clientID, req.AccountID, taskID, paths, up.Options{
+				Chat:     req.ToChat,
+				Paths:    paths,
+				Excludes: req.Excludes,
+				Remove:   req.Remove,
+				Photo:    req.Photo,
+				Transfer: currentTransfer(h.ctx, h.kvd),
+			})
+
+			// 更新任务状态，取消的任务状态已由CancelUploadTask更新
+			if ctx.Err() != nil {
+				return err
+			}
+
+			failed := 0
+			h.updateTask(taskID, func(task *UploadTaskInfo) {
+				if err != nil {
+					task.Status = "error"
+					task.Error = err.Error()
+				} else {
+					task.Status = "completed"
+					task.Progress = 100
+				}
+				failed = task.Failed
+			})
 			if err != nil {
-				taskInfo.Status = "error"
-				taskInfo.Error = err.Error()
 				logctx.From(h.ctx).Error("Upload task failed", 
 					zap.String("task_id", taskID), 
 					zap.Error(err))
 			} else {
-				taskInfo.Status = "completed"
-				taskInfo.Progress = 100
 				logctx.From(h.ctx).Info("Upload task completed", 
-					zap.String("task_id", taskID))
+					zap.String("task_id", taskID),
+					zap.Int("failed", failed))
+			}
+
+			if err == nil && failed == 0 {
+				os.RemoveAll(h.tempDir(taskID))
+			}
+			return err
+		},
+	})
+	return err
+}
+
+// RetryUploadTask 使用保存的文件和选项重新执行上传任务，failed_only时只上传上次失败的文件
+func (h *UploadHandler) RetryUploadTask(c *gin.Context) {
+	taskID := c.Param("id")
+
+	retry, ok := bindRetryRequest(c)
+	if !ok {
+		return
+	}
+
+	task, exists := h.accessibleTask(c, taskID)
+	if !exists {
+		return
+	}
+
+	if isUnfinishedStatus(task.Status) || h.scheduler.Active(taskID) {
+		ValidationError(c, "Task is still running")
+		return
+	}
+
+	req, ok := h.uploadConfig(task)
+	if !ok || task.ClientID == "" {
+		ValidationError(c, "Task cannot be retried")
+		return
+	}
+
+	paths := task.FilePaths
+	if retry.FailedOnly {
+		paths = nil
+		for _, file := range task.Files {
+			if file.Status == "failed" {
+				paths = append(paths, file.FilePath)
 			}
-			h.taskStore.Store(taskID, taskInfo)
 		}
-	}()
+		if len(paths) == 0 {
+			ValidationError(c, "Task has no failed files")
+			return
+		}
+	}
+
+	// 临时文件在任务全部成功或被删除后清理，此时只能重新提交
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			ValidationError(c, "Uploaded files are no longer available, please upload them again")
+			return
+		}
+	}
+
+	h.updateTask(taskID, func(task *UploadTaskInfo) {
+		task.Status = "queued"
+	})
+	if err := h.runUpload(taskID, req, task.ClientID, paths); err != nil {
+		taskError(c, "Failed to retry task", err)
+		return
+	}
 
 	Success(c, map[string]interface{}{
-		"message":     "Upload task submitted successfully",
-		"task_id":     taskID,
-		"file_count":  len(filePaths),
-		"to_chat":     toChat,
-		"status":      "pending",
+		"message":    "Upload task queued for retry",
+		"task_id":    taskID,
+		"file_count": len(paths),
 	})
 }
 
+// uploadConfig 从任务Config中解码上传选项
+func (h *UploadHandler) uploadConfig(task *UploadTaskInfo) (UploadRequest, bool) {
+	var req UploadRequest
+
+	data, err := json.Marshal(task.Config)
+	if err != nil || json.Unmarshal(data, &req) != nil {
+		return req, false
+	}
+
+	req.ToChat = task.ToChat
+	req.TaskID = task.ID
+	return req, true
+}
+
 // GetUploadTasks 获取上传任务列表
 func (h *UploadHandler) GetUploadTasks(c *gin.Context) {
 	var tasks []*UploadTaskInfo
 	
 	h.taskStore.Range(func(key, value interface{}) bool {
-		if task, ok := value.(*UploadTaskInfo); ok {
+		if task, ok := value.(*UploadTaskInfo); ok && canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
 			tasks = append(tasks, task)
 		}
 		return true
@@ -246,13 +512,11 @@ func (h *UploadHandler) GetUploadTasks(c *gin.Context) {
 func (h *UploadHandler) GetUploadTaskDetails(c *gin.Context) {
 	taskID := c.Param("id")
 	
-	taskInfoRaw, exists := h.taskStore.Load(taskID)
+	taskInfo, exists := h.accessibleTask(c, taskID)
 	if !exists {
-		NotFoundError(c, "Upload task not found")
 		return
 	}
 
-	taskInfo := taskInfoRaw.(*UploadTaskInfo)
 	Success(c, map[string]interface{}{
 		"message": "Upload task details retrieved successfully",
 		"task":    taskInfo,
@@ -263,142 +527,159 @@ func (h *UploadHandler) GetUploadTaskDetails(c *gin.Context) {
 func (h *UploadHandler) CancelUploadTask(c *gin.Context) {
 	taskID := c.Param("id")
 	
-	cancelFunc, exists := h.activeTasks.Load(taskID)
+	task, exists := h.accessibleTask(c, taskID)
 	if !exists {
-		NotFoundError(c, "Upload task not found or already completed")
 		return
 	}
 
-	// 取消任务
-	if cancel, ok := cancelFunc.(context.CancelFunc); ok {
-		cancel()
-		h.activeTasks.Delete(taskID)
-		
-		// 更新任务状态
-		if taskInfoRaw, ok := h.taskStore.Load(taskID); ok {
-			taskInfo := taskInfoRaw.(*UploadTaskInfo)
-			taskInfo.Status = "cancelled"
-			h.taskStore.Store(taskID, taskInfo)
+	// 取消的任务保留临时文件以便重试，删除任务时再清理
+	_, found := h.scheduler.Cancel(taskID)
+	if !found {
+		// 已结束的任务直接删除记录
+		if !isUnfinishedStatus(task.Status) {
+			h.taskStore.Delete(taskID)
+			deletePersistedTask(h.ctx, h.tasks, taskID)
+			os.RemoveAll(h.tempDir(taskID))
+
+			Success(c, map[string]interface{}{
+				"message": "Upload task deleted successfully",
+				"task_id": taskID,
+			})
+			return
 		}
+
+		NotFoundError(c, "Upload task not found or already completed")
+		return
 	}
 
+	// 更新任务状态
+	h.updateTask(taskID, func(task *UploadTaskInfo) {
+		task.Status = "cancelled"
+	})
+
 	Success(c, map[string]interface{}{
 		"message": "Upload task cancelled successfully",
 		"task_id": taskID,
 	})
 }
 
-// executeUpload 执行真实的上传逻辑
-func (h *UploadHandler) executeUpload(ctx context.Context, clientID, taskID string, filePaths []string, opts up.Options) error {
-	logctx.From(ctx).Info("Starting upload task", 
-		zap.String("task_id", taskID),
-		zap.Int("file_count", len(filePaths)),
-		zap.String("to_chat", opts.Chat))
+// accessibleTask 获取发起请求的客户端可以访问的任务，不存在或无权访问时返回404
+func (h *UploadHandler) accessibleTask(c *gin.Context, taskID string) (*UploadTaskInfo, bool) {
+	value, ok := h.taskStore.Load(taskID)
+	if !ok {
+		NotFoundError(c, "Upload task not found")
+		return nil, false
+	}
 
-	// 创建Telegram客户端
-	client, storageInstance, err := h.createTelegramClientForUser(clientID)
-	if err != nil {
-		return errors.Wrap(err, "create telegram client for user")
+	task := value.(*UploadTaskInfo)
+	if !canAccessTask(c, h.authService, task.ClientID, task.AccountID) {
+		NotFoundError(c, "Upload task not found")
+		return nil, false
 	}
+	return task, true
+}
 
-	// 使用 RunWithAuth 确保用户已认证
-	return tclientcore.RunWithAuth(ctx, client, func(ctx context.Context) error {
-		return up.Run(logctx.Named(ctx, "upload"), client, storageInstance, opts)
-	})
+// createTask 保存新任务，ID已被使用时返回service.ErrTaskExists
+func (h *UploadHandler) createTask(task *UploadTaskInfo) error {
+	if err := createTask(h.ctx, h.tasks, h.scheduler, task.ID, task.Type, task.Status, task.CreatedAt, task); err != nil {
+		return err
+	}
+	h.taskStore.Store(task.ID, task)
+	return nil
 }
 
-// createTelegramClientForUser 为特定用户创建Telegram客户端，复制其他Handler的逻辑
-func (h *UploadHandler) createTelegramClientForUser(clientID string) (*telegram.Client, storage.Storage, error) {
-	// 获取Telegram ID
-	telegramID, err := h.authService.GetAuthenticatedTelegramID(clientID)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "get authenticated telegram id")
+// saveTask 保存任务到内存缓存和任务仓库
+func (h *UploadHandler) saveTask(task *UploadTaskInfo) {
+	h.taskStore.Store(task.ID, task)
+	persistTask(h.ctx, h.tasks, task.ID, task.Type, task.Status, task.CreatedAt, task)
+}
+
+// updateTask 修改任务的副本后保存，避免修改正在被读取的任务
+func (h *UploadHandler) updateTask(taskID string, fn func(task *UploadTaskInfo)) {
+	value, ok := h.taskStore.Load(taskID)
+	if !ok {
+		return
 	}
 
-	// 使用用户命名空间
-	namespace := fmt.Sprintf("user_%d", telegramID)
-	return h.createTelegramClient(namespace)
+	task := *value.(*UploadTaskInfo)
+	fn(&task)
+	h.saveTask(&task)
 }
 
-// createTelegramClient 创建Telegram客户端，使用与CLI相同的配置
-func (h *UploadHandler) createTelegramClient(namespace string) (*telegram.Client, storage.Storage, error) {
-	// 通过kv.Storage获取storage.Storage实例
-	storageInstance, err := h.kvd.Open(namespace)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "open storage")
+// updateUploadProgress 更新上传任务的进度和文件统计，persist为false时只更新内存缓存
+func (h *UploadHandler) updateUploadProgress(taskID string, snap transferSnapshot, files []FileUploadInfo, persist bool) {
+	value, ok := h.taskStore.Load(taskID)
+	if !ok || value.(*UploadTaskInfo).Status != "running" {
+		return
 	}
 
-	// 获取当前设置
-	settingsHandler := NewSettingsHandler(h.ctx, h.kvd)
-	settings, err := settingsHandler.GetCurrentSettings()
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "get current settings")
+	task := *value.(*UploadTaskInfo)
+	task.Progress = snap.Progress
+	task.Speed = snap.Speed
+	task.ETA = snap.ETA
+	task.Uploaded = snap.Done
+	task.Failed = snap.Failed
+	task.Files = files
+	if snap.Items > 0 {
+		task.Total = snap.Items // 目录遍历后才知道文件数量
 	}
 
-	// 使用与CLI相同的tOptions配置
-	o := tclient.Options{
-		KV:               storageInstance,
-		Proxy:            settings.GlobalProxy, // 从设置中获取代理配置
-		NTP:              "", // NTP配置暂时为空
-		ReconnectTimeout: time.Duration(settings.ReconnectTimeout) * time.Second, // 从设置中获取重连超时
-		UpdateHandler:    nil,
+	if persist {
+		h.saveTask(&task)
+		return
 	}
+	h.taskStore.Store(taskID, &task)
+}
 
-	// 记录配置信息
-	logctx.From(h.ctx).Info("Creating Telegram client for upload with settings",
-		zap.String("proxy", o.Proxy),
-		zap.Duration("reconnectTimeout", o.ReconnectTimeout))
+// restoreTasks 从任务仓库加载历史任务，未完成的任务标记为中断
+//
+// 中断的上传任务在临时文件仍然存在时可以通过retry重新执行
+func (h *UploadHandler) restoreTasks() {
+	loadPersistedTasks(h.ctx, h.tasks, "upload", func(data []byte) error {
+		task := &UploadTaskInfo{}
+		if err := json.Unmarshal(data, task); err != nil {
+			return err
+		}
 
-	// 创建客户端，使用与CLI相同的参数
-	client, err := tclient.New(h.ctx, o, false) // false表示不需要登录，使用已有session
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "create telegram client")
-	}
+		if isUnfinishedStatus(task.Status) {
+			task.Status = TaskStatusInterrupted
+			task.Error = taskInterruptedMessage
+			task.Speed = "0 B/s"
+			task.ETA = "--"
+			h.saveTask(task)
+			return nil
+		}
 
-	return client, storageInstance, nil
+		h.taskStore.Store(task.ID, task)
+		return nil
+	})
 }
 
-// getOrCreateClientID 获取客户端ID，与AuthHandler保持一致的识别机制
-func (h *UploadHandler) getOrCreateClientID(c *gin.Context) (string, error) {
-	const clientIDCookie = "tdl_client_id"
-	const clientIDHeader = "X-TDL-Client-ID"
-	
-	// 1. 优先从Cookie获取客户端ID
-	if clientID, err := c.Cookie(clientIDCookie); err == nil && clientID != "" {
-		return clientID, nil
-	}
-	
-	// 2. 从Header获取客户端ID
-	if clientID := c.GetHeader(clientIDHeader); clientID != "" {
-		// 设置cookie以便后续请求使用
-		c.SetCookie(clientIDCookie, clientID, 30*24*3600, "/", "", false, true) // 30天
-		return clientID, nil
-	}
-	
-	// 3. 从旧Header获取（向后兼容）
-	if clientID := c.GetHeader("X-Session-ID"); clientID != "" {
-		return clientID, nil
-	}
-	
-	// 4. 回退到IP地址（与Auth处理保持一致）
-	clientIP := c.ClientIP()
-	if clientIP == "" {
-		return "", errors.New("cannot identify client")
-	}
-	return util.SafeClientID(clientIP), nil
-}
+// executeUpload 执行真实的上传逻辑
+func (h *UploadHandler) executeUpload(ctx context.Context, clientID string, accountID int64, taskID string, filePaths []string, opts up.Options) error {
+	logctx.From(ctx).Info("Starting upload task", 
+		zap.String("task_id", taskID),
+		zap.Int("file_count", len(filePaths)),
+		zap.String("to_chat", opts.Chat))
 
-func (h *UploadHandler) generateShortID() string {
-	bytes := make([]byte, 3)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+	// 使用账号的长连接客户端
+	return h.clients.Run(ctx, clientID, accountID, func(ctx context.Context, acc *service.AccountClient) error {
+		opts.Progress = newUploadProgress(h, taskID, acc.ID)
+		opts.Pool = acc.Pool
+		return up.Run(logctx.Named(ctx, "upload"), acc.Client, acc.KV, opts)
+	})
 }
 
 func (h *UploadHandler) createTempDir(taskID string) (string, error) {
-	tempDir := filepath.Join(os.TempDir(), "tdl_upload", taskID)
+	tempDir := h.tempDir(taskID)
 	return tempDir, os.MkdirAll(tempDir, 0755)
 }
 
+// tempDir 任务上传文件的临时目录，任务ID由客户端提供，需要清理后使用
+func (h *UploadHandler) tempDir(taskID string) string {
+	return filepath.Join(h.paths.Staging.Default(), service.SanitizeFilename(taskID))
+}
+
 func (h *UploadHandler) saveUploadedFile(fileHeader *multipart.FileHeader, dst string) error {
 	src, err := fileHeader.Open()
 	if err != nil {
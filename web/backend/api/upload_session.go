@@ -0,0 +1,460 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/web/backend/middleware"
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+// 分块上传协议的请求头，与tus协议相同
+const (
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+)
+
+// stagingSweepInterval 检查暂存目录生命周期的间隔
+const stagingSweepInterval = 10 * time.Minute
+
+// CreateUploadSessionRequest 创建分块上传会话，文件的所有分块写入后立即开始上传到Telegram
+type CreateUploadSessionRequest struct {
+	Filename  string `json:"filename" binding:"required"`
+	Size      int64  `json:"size" binding:"required,gt=0"`
+	ToChat    string `json:"to_chat"`              // 目标聊天ID或用户名（空字符串表示Saved Messages）
+	Remove    bool   `json:"remove"`               // 上传后删除暂存文件
+	Photo     bool   `json:"photo"`                // 作为照片上传而不是文件
+	Priority  int    `json:"priority"`             // 排队优先级，数值越大越先执行
+	AccountID int64  `json:"account_id,omitempty"` // 执行任务的Telegram账号，为空时使用当前账号
+}
+
+// uploadSessionLocks 同一会话同时只能有一个PATCH请求写入
+type uploadSessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]bool
+}
+
+func (l *uploadSessionLocks) tryLock(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.locks[id] {
+		return false
+	}
+	l.locks[id] = true
+	return true
+}
+
+func (l *uploadSessionLocks) unlock(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.locks, id)
+}
+
+// CreateUploadSession 创建上传会话和暂存文件，返回会话ID，会话ID同时也是之后的上传任务ID
+func (h *UploadHandler) CreateUploadSession(c *gin.Context) {
+	var req CreateUploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ValidationError(c, err.Error())
+		return
+	}
+
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		logctx.From(h.ctx).Error("Failed to get client ID", zap.Error(err))
+		InternalServerError(c, "Failed to identify client")
+		return
+	}
+
+	// 确定任务使用的账号，未指定时使用当前账号
+	if req.AccountID, err = h.authService.ResolveAccount(clientID, req.AccountID); err != nil {
+		AccountError(c, err)
+		return
+	}
+
+	id := newTaskID("upload")
+	dir, err := h.createTempDir(id)
+	if err != nil {
+		logctx.From(h.ctx).Error("Failed to create temp directory", zap.Error(err))
+		InternalServerError(c, "Failed to create temporary directory")
+		return
+	}
+
+	path, err := h.paths.Staging.Join(dir, req.Filename)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		PathForbiddenError(c, fmt.Sprintf("Invalid file name %q: %v", req.Filename, err))
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		InternalError(c, "Failed to create staging file", err)
+		return
+	}
+	_ = f.Close()
+
+	options, err := json.Marshal(UploadRequest{
+		ToChat:    req.ToChat,
+		Remove:    req.Remove,
+		Photo:     req.Photo,
+		TaskID:    id,
+		Priority:  req.Priority,
+		AccountID: req.AccountID,
+	})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		InternalError(c, "Failed to encode upload options", err)
+		return
+	}
+
+	session := &service.UploadSession{
+		ID:        id,
+		Filename:  filepath.Base(path),
+		Path:      path,
+		Size:      req.Size,
+		Options:   options,
+		ClientID:  clientID,
+		CreatedAt: time.Now(),
+	}
+	if err = h.sessions.Save(h.ctx, session); err != nil {
+		_ = os.RemoveAll(dir)
+		InternalError(c, "Failed to save upload session", err)
+		return
+	}
+
+	c.Header(headerUploadOffset, "0")
+	c.Header(headerUploadLength, strconv.FormatInt(session.Size, 10))
+	Success(c, session)
+}
+
+// GetUploadSession 查询会话已接收的字节数，客户端中断后从Offset继续上传，也支持HEAD请求
+func (h *UploadHandler) GetUploadSession(c *gin.Context) {
+	session, ok := h.getUploadSession(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header(headerUploadOffset, strconv.FormatInt(session.Offset, 10))
+	c.Header(headerUploadLength, strconv.FormatInt(session.Size, 10))
+	Success(c, session)
+}
+
+// PatchUploadSession 从Upload-Offset位置写入一个分块，请求体直接流式写入暂存文件
+//
+// Upload-Offset必须等于已接收的字节数，否则返回409和当前的Offset。
+// 连接中断时已经收到的数据仍然保留，客户端查询Offset后继续发送
+func (h *UploadHandler) PatchUploadSession(c *gin.Context) {
+	session, ok := h.getUploadSession(c)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		ValidationError(c, "Invalid Upload-Offset header")
+		return
+	}
+
+	if !h.sessionLocks.tryLock(session.ID) {
+		Error(c, http.StatusConflict, errors.New("another chunk of this upload is being written"))
+		return
+	}
+	defer h.sessionLocks.unlock(session.ID)
+
+	// 加锁后重新读取，避免使用并发请求写入前的Offset
+	if session, err = h.sessions.Get(h.ctx, session.ID); err != nil {
+		NotFoundError(c, "Upload session not found")
+		return
+	}
+	if offset != session.Offset {
+		c.Header(headerUploadOffset, strconv.FormatInt(session.Offset, 10))
+		Error(c, http.StatusConflict, errors.Errorf("Upload-Offset mismatch, expected %d", session.Offset))
+		return
+	}
+	if session.Completed() {
+		ValidationError(c, "Upload is already completed")
+		return
+	}
+
+	n, writeErr := h.writeChunk(session, c.Request.Body)
+	session.Offset += n
+	if err = h.sessions.Save(h.ctx, session); err != nil {
+		InternalError(c, "Failed to save upload session", err)
+		return
+	}
+	c.Header(headerUploadOffset, strconv.FormatInt(session.Offset, 10))
+
+	if writeErr != nil {
+		logctx.From(h.ctx).Warn("Upload chunk interrupted",
+			zap.String("session_id", session.ID),
+			zap.Int64("offset", session.Offset),
+			zap.Error(writeErr))
+		ValidationError(c, fmt.Sprintf("Chunk interrupted at offset %d: %v", session.Offset, writeErr))
+		return
+	}
+
+	if !session.Completed() {
+		Success(c, map[string]interface{}{
+			"offset":    session.Offset,
+			"size":      session.Size,
+			"completed": false,
+		})
+		return
+	}
+
+	if err = h.completeUploadSession(session); err != nil {
+		taskError(c, "Failed to start upload task", err)
+		return
+	}
+
+	Success(c, map[string]interface{}{
+		"offset":    session.Offset,
+		"size":      session.Size,
+		"completed": true,
+		"task_id":   session.ID,
+	})
+}
+
+// DeleteUploadSession 放弃未完成的上传，删除会话和暂存文件
+func (h *UploadHandler) DeleteUploadSession(c *gin.Context) {
+	session, ok := h.getUploadSession(c)
+	if !ok {
+		return
+	}
+
+	if !h.sessionLocks.tryLock(session.ID) {
+		Error(c, http.StatusConflict, errors.New("a chunk of this upload is being written"))
+		return
+	}
+	defer h.sessionLocks.unlock(session.ID)
+
+	h.removeUploadSession(session.ID)
+
+	SuccessWithMessage(c, nil, "Upload session deleted")
+}
+
+// writeChunk 从offset开始写入请求体，超过文件大小的数据视为错误，返回实际写入的字节数
+func (h *UploadHandler) writeChunk(session *service.UploadSession, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(session.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(session.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	remaining := session.Size - session.Offset
+	n, err := io.Copy(f, io.LimitReader(body, remaining))
+	if err != nil {
+		return n, err
+	}
+	if n == remaining {
+		// 超出文件大小的分块整体拒绝，Offset不变
+		if extra, _ := body.Read(make([]byte, 1)); extra > 0 {
+			if err = f.Truncate(session.Offset); err != nil {
+				return 0, errors.Wrap(err, "truncate staging file")
+			}
+			return 0, errors.Errorf("chunk exceeds upload size %d", session.Size)
+		}
+		// 服务重启前可能写入过更长的数据，以会话大小为准
+		if err = f.Truncate(session.Size); err != nil {
+			return n, err
+		}
+	}
+
+	return n, f.Sync()
+}
+
+// completeUploadSession 文件接收完成，以会话ID创建上传任务并提交到调度器
+func (h *UploadHandler) completeUploadSession(session *service.UploadSession) error {
+	var req UploadRequest
+	if err := json.Unmarshal(session.Options, &req); err != nil {
+		return err
+	}
+
+	task := &UploadTaskInfo{
+		ID:        session.ID,
+		Type:      "upload",
+		Name:      fmt.Sprintf("上传 %s", session.Filename),
+		Status:    "queued",
+		Speed:     "0 B/s",
+		ETA:       "计算中...",
+		Total:     1,
+		CreatedAt: time.Now(),
+		ToChat:    req.ToChat,
+		FilePaths: []string{session.Path},
+		Config: map[string]interface{}{
+			"remove":     req.Remove,
+			"photo":      req.Photo,
+			"priority":   req.Priority,
+			"account_id": req.AccountID,
+		},
+		ClientID:  session.ClientID,
+		AccountID: req.AccountID,
+	}
+	if err := h.createTask(task); err != nil {
+		return err
+	}
+
+	// 暂存文件此后由任务管理，会话记录不再需要
+	if err := h.sessions.Delete(h.ctx, session.ID); err != nil {
+		logctx.From(h.ctx).Warn("Failed to delete upload session",
+			zap.String("session_id", session.ID),
+			zap.Error(err))
+	}
+
+	return h.runUpload(task.ID, req, session.ClientID, task.FilePaths)
+}
+
+// getUploadSession 获取当前操作员的会话，其他操作员的会话视为不存在
+func (h *UploadHandler) getUploadSession(c *gin.Context) (*service.UploadSession, bool) {
+	clientID, err := middleware.Client(c)
+	if err != nil {
+		InternalServerError(c, "Failed to identify client")
+		return nil, false
+	}
+
+	session, err := h.sessions.Get(h.ctx, c.Param("id"))
+	if err != nil {
+		if !kv.IsNotFound(err) {
+			InternalError(c, "Failed to load upload session", err)
+			return nil, false
+		}
+		NotFoundError(c, "Upload session not found")
+		return nil, false
+	}
+	if session.ClientID != clientID {
+		NotFoundError(c, "Upload session not found")
+		return nil, false
+	}
+
+	return session, true
+}
+
+// removeUploadSession 删除会话记录和暂存目录
+func (h *UploadHandler) removeUploadSession(id string) {
+	if err := h.sessions.Delete(h.ctx, id); err != nil {
+		logctx.From(h.ctx).Warn("Failed to delete upload session",
+			zap.String("session_id", id),
+			zap.Error(err))
+	}
+	_ = os.RemoveAll(h.tempDir(id))
+}
+
+// sweepStaging 按生命周期策略定期清理暂存目录，直到服务退出
+func (h *UploadHandler) sweepStaging() {
+	ticker := time.NewTicker(stagingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		h.cleanupStaging(time.Now())
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanupStaging 清理过期的上传会话，以及不再需要的任务暂存目录
+func (h *UploadHandler) cleanupStaging(now time.Time) {
+	log := logctx.From(h.ctx)
+
+	sessions, err := h.sessions.List(h.ctx)
+	if err != nil {
+		log.Warn("Failed to list upload sessions", zap.Error(err))
+		return
+	}
+
+	owners := make(map[string]service.StagingOwner, len(sessions))
+	for _, session := range sessions {
+		if h.stagingPolicy.Expired(service.StagingSession, now.Sub(session.UpdatedAt)) {
+			log.Info("Remove expired upload session", zap.String("session_id", session.ID))
+			h.removeUploadSession(session.ID)
+			continue
+		}
+		owners[service.SanitizeFilename(session.ID)] = service.StagingSession
+	}
+
+	h.taskStore.Range(func(key, value interface{}) bool {
+		task := value.(*UploadTaskInfo)
+		owner := service.StagingFailed
+		switch {
+		case isUnfinishedStatus(task.Status) || h.scheduler.Active(task.ID):
+			owner = service.StagingActive
+		case task.Status == "completed" && task.Failed == 0:
+			owner = service.StagingFinished
+		}
+		owners[service.SanitizeFilename(task.ID)] = owner
+		return true
+	})
+
+	staging := h.paths.Staging.Default()
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		log.Warn("Failed to read staging directory", zap.Error(err))
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		owner, ok := owners[entry.Name()]
+		if !ok {
+			owner = service.StagingOrphan
+		}
+		if owner == service.StagingSession {
+			continue // 会话已按最后写入时间检查
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if !h.stagingPolicy.Expired(owner, now.Sub(info.ModTime())) {
+			continue
+		}
+
+		dir := filepath.Join(staging, entry.Name())
+		if err = os.RemoveAll(dir); err != nil {
+			log.Warn("Failed to remove staging directory", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		log.Info("Removed staging directory",
+			zap.String("dir", dir),
+			zap.String("reason", stagingReason(owner)))
+	}
+}
+
+// stagingReason 日志中记录的清理原因
+func stagingReason(owner service.StagingOwner) string {
+	switch owner {
+	case service.StagingFinished:
+		return "task completed"
+	case service.StagingFailed:
+		return "retention expired"
+	default:
+		return "orphaned"
+	}
+}
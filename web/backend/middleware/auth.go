@@ -1,16 +1,77 @@
 package middleware
 
 import (
+	"net/http"
+	"strings"
+
 	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/web/backend/service"
+)
+
+const (
+	// SessionCookie 操作员会话Cookie
+	SessionCookie = "tdl_session"
+	// ClientCookie 浏览器标识Cookie，操作员登出后保留，重新登录时沿用
+	ClientCookie = "tdl_client"
 
-	"github.com/iyear/tdl/core/storage"
+	operatorKey = "operator"
+	clientKey   = "client"
 )
 
-// RequireAuth 检查用户是否已认证
-func RequireAuth(kvd storage.Storage) gin.HandlerFunc {
+// RequireAuth 要求请求携带操作员凭据：Authorization: Bearer <API令牌>或会话Cookie
+func RequireAuth(operators *service.OperatorService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 简化实现 - 暂时跳过认证检查
-		c.Set("user_id", "default")
+		identity, err := Authenticate(c, operators)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "Operator authentication required",
+				"code":    "operator_unauthorized", // api.CodeOperatorUnauthorized
+			})
+			return
+		}
+
+		c.Set(operatorKey, identity.Operator)
+		c.Set(clientKey, identity.Client)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// Operator 返回通过RequireAuth认证的操作员
+func Operator(c *gin.Context) (string, error) {
+	operator := c.GetString(operatorKey)
+	if operator == "" {
+		return "", errors.New("operator not authenticated")
+	}
+	return operator, nil
+}
+
+// Client 返回通过RequireAuth认证的浏览器标识，登录会话和Telegram账号的访问权限都以此为键
+func Client(c *gin.Context) (string, error) {
+	client := c.GetString(clientKey)
+	if client == "" {
+		return "", errors.New("client not authenticated")
+	}
+	return client, nil
+}
+
+// Authenticate 校验请求携带的操作员凭据，返回请求的身份
+func Authenticate(c *gin.Context, operators *service.OperatorService) (service.Identity, error) {
+	ctx := c.Request.Context()
+
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return service.Identity{}, service.ErrOperatorUnauthorized
+		}
+		return operators.Token(ctx, strings.TrimSpace(token))
+	}
+
+	sessionID, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return service.Identity{}, service.ErrOperatorUnauthorized
+	}
+	return operators.Session(ctx, sessionID)
+}
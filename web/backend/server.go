@@ -6,31 +6,65 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/fatih/color"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-faster/errors"
 	"go.uber.org/zap"
 
 	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/pkg/transfer"
 	"github.com/iyear/tdl/web/backend/api"
 	"github.com/iyear/tdl/web/backend/middleware"
+	"github.com/iyear/tdl/web/backend/service"
 	"github.com/iyear/tdl/web/backend/websocket"
 )
 
 type Server struct {
-	router *gin.Engine
-	port   int
-	ctx    context.Context
-	kvd    kv.Storage
-	wsHub  *websocket.Hub
+	router    *gin.Engine
+	port      int
+	ctx       context.Context
+	kvd       kv.Storage
+	wsHub     *websocket.Hub
+	tasks     *service.TaskRepository
+	uploads   *service.UploadSessionStore
+	thumbs    *service.ThumbCache
+	dialogs   *service.DialogCache
+	sched     *service.Scheduler
+	operators *service.OperatorService
+	auth      *service.AuthService
+	settings  *api.SettingsHandler
+	clients   *service.ClientManager
+	paths     *service.PathPolicy
+	metrics   *service.Metrics
+
+	cliStorage service.StorageOpener
+	shared     bool
 }
 
+// thumbCacheSize 缩略图磁盘缓存的大小上限
+const thumbCacheSize = 256 << 20
+
 type Config struct {
-	Port  int
-	Debug bool
+	Port     int
+	Debug    bool
+	Password string // 管理员密码，为空时沿用已保存的密码
+
+	// CLIStorage 打开CLI使用的存储（--storage），用于导入CLI命名空间，为nil时不支持导入
+	CLIStorage service.StorageOpener
+	// SharedStorage Web是否直接使用CLI的存储，此时Web账号也是CLI的user_<id>命名空间
+	SharedStorage bool
+	// Import 启动时导入为Web账号的CLI命名空间
+	Import []string
+	// Roots Web文件操作允许访问的根目录，客户端传入的路径必须在其中
+	Roots service.PathRoots
 }
 
-func NewServer(ctx context.Context, kvd kv.Storage, config Config) *Server {
+// allowedOrigins 允许跨域访问API和WebSocket的来源，即前端开发服务器
+var allowedOrigins = []string{"http://localhost:3000", "http://localhost:5173"}
+
+func NewServer(ctx context.Context, kvd kv.Storage, config Config) (*Server, error) {
 	if !config.Debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -40,15 +74,104 @@ func NewServer(ctx context.Context, kvd kv.Storage, config Config) *Server {
 
 	// CORS配置
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5173"},
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		AllowCredentials: true,
-		MaxAge:          12 * time.Hour,
+		MaxAge:           12 * time.Hour,
 	}))
 
-	// 创建WebSocket Hub
-	wsHub := websocket.NewHub()
+	// 创建操作员认证服务，首次启动且未指定密码时生成随机密码
+	operators, generated, err := service.NewOperatorService(ctx, kvd, config.Password)
+	if err != nil {
+		return nil, errors.Wrap(err, "create operator service")
+	}
+	if generated != "" {
+		logctx.From(ctx).Info("Generated admin password for web interface")
+		color.Yellow("Generated admin password for web interface: %s", generated)
+		color.Yellow("Use --password to set your own password")
+	}
+
+	// 客户端传入的下载、导出和上传路径都限制在这些根目录中
+	paths, err := service.NewPathPolicy(config.Roots)
+	if err != nil {
+		return nil, errors.Wrap(err, "create path policy")
+	}
+	logctx.From(ctx).Info("Allowed file roots",
+		zap.Strings("download", paths.Download.Roots()),
+		zap.Strings("export", paths.Export.Roots()),
+		zap.Strings("upload", paths.Upload.Roots()))
+
+	// 创建任务仓库，任务状态在服务重启后保留
+	tasks, err := service.NewTaskRepository(kvd)
+	if err != nil {
+		return nil, errors.Wrap(err, "create task repository")
+	}
+
+	// 分块上传会话，中断的上传在服务重启后可以继续
+	uploads, err := service.NewUploadSessionStore(kvd)
+	if err != nil {
+		return nil, errors.Wrap(err, "create upload session store")
+	}
+
+	// 缩略图磁盘缓存，超过上限时淘汰最久未使用的文件
+	thumbs, err := service.NewThumbCache(service.DefaultThumbCacheDir(), thumbCacheSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "create thumbnail cache")
+	}
+
+	// 设置在调度器、客户端管理器和设置接口之间共用，更新后通知OnChange回调
+	settingsHandler := api.NewSettingsHandler(ctx, kvd)
+
+	// 创建全局任务调度器，并发数取自设置中的MaxTasks
+	sched := service.NewScheduler(ctx, func() int {
+		settings, err := settingsHandler.GetCurrentSettings()
+		if err != nil {
+			logctx.From(ctx).Warn("Failed to load settings for scheduler", zap.Error(err))
+			return 1
+		}
+		return settings.MaxTasks
+	})
+
+	// 运行期间累计的指标，由/metrics导出
+	metrics := service.NewMetrics()
+	sched.SetMetrics(metrics)
+
+	// 对话列表缓存，客户端运行期间根据收到的更新增量维护
+	dialogs, err := service.NewDialogCache(ctx, kvd, service.DefaultDialogTTL)
+	if err != nil {
+		return nil, errors.Wrap(err, "create dialog cache")
+	}
+
+	// 账号的登录会话和访问权限，客户端管理器、WebSocket Hub和所有接口共用
+	auth := service.NewAuthService(ctx, kvd)
+
+	// 每个账号共享一个长期运行的Telegram客户端，代理等设置变化后重新连接
+	clients := service.NewClientManager(ctx, kvd, auth, func() service.ClientConfig {
+		settings, err := settingsHandler.GetCurrentSettings()
+		if err != nil {
+			logctx.From(ctx).Warn("Failed to load settings for telegram client", zap.Error(err))
+			d := transfer.Default()
+			return service.ClientConfig{ReconnectTimeout: d.ReconnectTimeout, PoolSize: d.PoolSize}
+		}
+		o := settings.Transfer()
+		return service.ClientConfig{
+			Proxy:            settings.GlobalProxy,
+			ReconnectTimeout: o.ReconnectTimeout,
+			PoolSize:         o.PoolSize,
+		}
+	}, dialogs.UpdateHandler, metrics.Observer)
+	metrics.Register(sched.Collect, clients.Collect, service.CollectProcess)
+
+	settingsHandler.OnChange(func(*api.Settings) {
+		// MaxTasks可能变大，立即启动排队中的任务
+		sched.Dispatch()
+		// 代理、重连超时或连接池大小变化后，使用旧配置的客户端在空闲后重新连接
+		clients.Reconfigure()
+	})
+
+	// 创建WebSocket Hub，任务事件只推送给可以访问任务账号的连接
+	wsHub := websocket.NewHub(auth.CanAccess)
 	go wsHub.Run()
 
 	server := &Server{
@@ -57,10 +180,26 @@ func NewServer(ctx context.Context, kvd kv.Storage, config Config) *Server {
 		ctx:    ctx,
 		kvd:    kvd,
 		wsHub:  wsHub,
+		tasks:  tasks,
+		sched:  sched,
+
+		uploads:   uploads,
+		thumbs:    thumbs,
+		dialogs:   dialogs,
+		operators: operators,
+		auth:      auth,
+		settings:  settingsHandler,
+		clients:   clients,
+		paths:     paths,
+		metrics:   metrics,
+
+		cliStorage: config.CLIStorage,
+		shared:     config.SharedStorage,
 	}
 
+	server.importNamespaces(config.Import)
 	server.setupRoutes()
-	return server
+	return server, nil
 }
 
 func (s *Server) setupRoutes() {
@@ -71,91 +210,200 @@ func (s *Server) setupRoutes() {
 	s.router.Static("/assets", "./web/frontend/dist/assets")
 	s.router.StaticFile("/", "./web/frontend/dist/index.html")
 
-	// API路由组
+	authHandler := api.NewAuthHandler(s.ctx, s.kvd, s.auth, s.wsHub, s.clients, s.cliStorage, s.shared)
+	operatorHandler := api.NewOperatorHandler(s.ctx, s.operators)
+
+	// API文档，根据注册的路由生成，无需认证
+	openAPIHandler := api.NewOpenAPIHandler(s.router.Routes)
+	s.router.GET(api.OpenAPIPrefix+"/openapi.json", openAPIHandler.GetDocument)
+
+	// Prometheus指标，使用API令牌抓取
+	metricsHandler := api.NewMetricsHandler(s.ctx, s.metrics, s.sched)
+	s.router.GET("/metrics", middleware.RequireAuth(s.operators), metricsHandler.GetMetrics)
+
+	// 操作员登录，无需认证
+	operatorPublic := s.router.Group("/api/v1/operator")
+	{
+		operatorPublic.GET("/status", operatorHandler.GetStatus)
+		operatorPublic.POST("/login", operatorHandler.Login)
+		operatorPublic.POST("/logout", operatorHandler.Logout)
+	}
+
+	// API路由组，需要操作员会话或API令牌
 	apiV1 := s.router.Group("/api/v1")
+	apiV1.Use(middleware.RequireAuth(s.operators))
 	{
+		// API令牌管理
+		tokenGroup := apiV1.Group("/operator/tokens")
+		{
+			tokenGroup.GET("", operatorHandler.ListTokens)
+			tokenGroup.POST("", operatorHandler.CreateToken)
+			tokenGroup.DELETE("/:id", operatorHandler.RevokeToken)
+		}
+
 		// 认证相关
 		auth := apiV1.Group("/auth")
 		{
-			authHandler := api.NewAuthHandler(s.ctx, s.kvd, s.wsHub)
 			auth.GET("/status", authHandler.GetStatus)
-			
+
 			// QR登录
 			auth.POST("/qr/start", authHandler.StartQRLogin)
 			auth.GET("/qr/code/:sessionId", authHandler.GetQRCode)
 			auth.GET("/qr/status/:sessionId", authHandler.CheckQRStatus)
-			
+
 			// 验证码登录
 			auth.POST("/code/start", authHandler.StartCodeLogin)
 			auth.POST("/code/verify", authHandler.VerifyCode)
-			
+
 			// 2FA验证
 			auth.POST("/password/verify", authHandler.VerifyPassword)
-			
+
 			// 登出
 			auth.POST("/logout", authHandler.Logout)
+
+			// 多账号管理，登录新账号后自动切换为当前账号
+			auth.GET("/accounts", authHandler.ListAccounts)
+			auth.POST("/accounts/:id/switch", authHandler.SwitchAccount)
+			auth.DELETE("/accounts/:id", authHandler.RemoveAccount)
+
+			// 导入CLI命名空间中已登录的账号
+			auth.GET("/cli/namespaces", authHandler.ListCLINamespaces)
+			auth.POST("/accounts/import", authHandler.ImportAccount)
 		}
 
 		// 聊天管理相关
 		chatGroup := apiV1.Group("/chat")
 		{
-			chatHandler := api.NewChatHandler(s.ctx, s.kvd)
-			chatGroup.GET("/list", chatHandler.GetChatList)           // 获取聊天列表
+			chatHandler := api.NewChatHandler(s.ctx, s.kvd, s.auth, s.wsHub, s.clients, s.dialogs, s.paths, s.tasks, s.sched)
+			chatGroup.GET("/list", chatHandler.GetChatList)                    // 获取聊天列表
+			chatGroup.GET("/:peer/messages", chatHandler.GetChatMessages)      // 分页浏览聊天消息
 			chatGroup.GET("/default-path", chatHandler.GetDefaultDownloadPath) // 获取默认下载路径
-			chatGroup.POST("/export", chatHandler.ExportChatMessages) // 导出聊天消息
-			chatGroup.POST("/users", chatHandler.ExportChatUsers)     // 导出聊天用户
+			chatGroup.POST("/export", chatHandler.ExportChatMessages)          // 导出聊天消息
+			chatGroup.POST("/users", chatHandler.ExportChatUsers)              // 导出聊天用户
+			chatGroup.GET("/tasks", chatHandler.GetExportTasks)                // 获取导出任务列表
+			chatGroup.GET("/tasks/:id", chatHandler.GetExportTaskDetails)      // 获取导出任务详情
+			chatGroup.GET("/tasks/:id/result", chatHandler.GetExportResult)    // 下载导出结果JSON
+			chatGroup.DELETE("/tasks/:id", chatHandler.CancelExportTask)       // 取消/删除导出任务
 		}
 
 		// 设置相关
 		settingsGroup := apiV1.Group("/settings")
 		{
-			settingsHandler := api.NewSettingsHandler(s.ctx, s.kvd)
-			settingsGroup.GET("/", settingsHandler.GetSettings)       // 获取设置
-			settingsGroup.PUT("/", settingsHandler.UpdateSettings)    // 更新设置
-			settingsGroup.POST("/reset", settingsHandler.ResetSettings) // 重置设置
+			settingsGroup.GET("/", s.settings.GetSettings)         // 获取设置
+			settingsGroup.PUT("/", s.settings.UpdateSettings)      // 更新设置
+			settingsGroup.POST("/reset", s.settings.ResetSettings) // 重置设置
 		}
 
 		// 下载管理相关
 		downloadGroup := apiV1.Group("/download")
 		{
-			downloadHandler := api.NewDownloadHandler(s.ctx, s.kvd, s.wsHub)
-			downloadGroup.POST("/start", downloadHandler.StartDownload)     // 开始下载任务
-			downloadGroup.POST("/import", downloadHandler.ImportFromJson)   // 从JSON文件导入下载
-			downloadGroup.GET("/tasks", downloadHandler.GetTasks)          // 获取下载任务列表
-			downloadGroup.GET("/tasks/:id", downloadHandler.GetTaskDetails) // 获取任务详情
-			downloadGroup.POST("/tasks/:id/pause", downloadHandler.PauseTask)   // 暂停任务
-			downloadGroup.POST("/tasks/:id/resume", downloadHandler.ResumeTask) // 恢复任务
-			downloadGroup.POST("/tasks/:id/retry", downloadHandler.RetryTask)   // 重试任务
-			downloadGroup.DELETE("/tasks/:id", downloadHandler.CancelTask)     // 取消/删除任务
+			downloadHandler := api.NewDownloadHandler(s.ctx, s.kvd, s.auth, s.wsHub, s.clients, s.paths, s.tasks, s.sched, s.metrics)
+			downloadGroup.POST("/start", downloadHandler.StartDownload)              // 开始下载任务
+			downloadGroup.POST("/import", downloadHandler.ImportFromJson)            // 从JSON文件导入下载
+			downloadGroup.GET("/tasks", downloadHandler.GetTasks)                    // 获取下载任务列表
+			downloadGroup.GET("/tasks/:id", downloadHandler.GetTaskDetails)          // 获取任务详情
+			downloadGroup.GET("/tasks/:id/files", downloadHandler.DownloadTaskFiles) // 打包下载任务的文件
+			downloadGroup.POST("/tasks/:id/pause", downloadHandler.PauseTask)        // 暂停任务
+			downloadGroup.POST("/tasks/:id/resume", downloadHandler.ResumeTask)      // 恢复任务
+			downloadGroup.POST("/tasks/:id/retry", downloadHandler.RetryTask)        // 重试任务
+			downloadGroup.DELETE("/tasks/:id", downloadHandler.CancelTask)           // 取消/删除任务
 		}
 
 		// 转发管理相关
 		forwardGroup := apiV1.Group("/forward")
 		{
-			forwardHandler := api.NewForwardHandler(s.ctx, s.kvd, s.wsHub)
-			forwardGroup.POST("/start", forwardHandler.StartForward)           // 开始转发任务
-			forwardGroup.GET("/tasks", forwardHandler.GetForwardTasks)         // 获取转发任务列表
-			forwardGroup.GET("/tasks/:id", forwardHandler.GetForwardTaskDetails) // 获取转发任务详情
-			forwardGroup.DELETE("/tasks/:id", forwardHandler.CancelForwardTask)  // 取消转发任务
+			forwardHandler := api.NewForwardHandler(s.ctx, s.kvd, s.auth, s.wsHub, s.clients, s.paths, s.tasks, s.sched)
+			forwardGroup.POST("/start", forwardHandler.StartForward)               // 开始转发任务
+			forwardGroup.GET("/tasks", forwardHandler.GetForwardTasks)             // 获取转发任务列表
+			forwardGroup.GET("/tasks/:id", forwardHandler.GetForwardTaskDetails)   // 获取转发任务详情
+			forwardGroup.POST("/tasks/:id/retry", forwardHandler.RetryForwardTask) // 重试转发任务
+			forwardGroup.DELETE("/tasks/:id", forwardHandler.CancelForwardTask)    // 取消转发任务
 		}
 
 		// 上传管理相关
 		uploadGroup := apiV1.Group("/upload")
 		{
-			uploadHandler := api.NewUploadHandler(s.ctx, s.kvd, s.wsHub)
-			uploadGroup.POST("/start", uploadHandler.StartUpload)              // 开始上传任务
-			uploadGroup.GET("/tasks", uploadHandler.GetUploadTasks)            // 获取上传任务列表
-			uploadGroup.GET("/tasks/:id", uploadHandler.GetUploadTaskDetails)  // 获取上传任务详情
-			uploadGroup.DELETE("/tasks/:id", uploadHandler.CancelUploadTask)   // 取消上传任务
+			uploadHandler := api.NewUploadHandler(s.ctx, s.kvd, s.auth, s.wsHub, s.clients, s.paths, s.tasks, s.uploads, s.sched, s.metrics)
+			uploadGroup.POST("/start", uploadHandler.StartUpload)                  // 开始上传任务
+			uploadGroup.POST("/local", uploadHandler.StartLocalUpload)             // 上传服务器本地的文件或目录
+			uploadGroup.GET("/tasks", uploadHandler.GetUploadTasks)                // 获取上传任务列表
+			uploadGroup.GET("/tasks/:id", uploadHandler.GetUploadTaskDetails)      // 获取上传任务详情
+			uploadGroup.POST("/tasks/:id/retry", uploadHandler.RetryUploadTask)    // 重试上传任务
+			uploadGroup.DELETE("/tasks/:id", uploadHandler.CancelUploadTask)       // 取消上传任务
+			uploadGroup.POST("/sessions", uploadHandler.CreateUploadSession)       // 创建分块上传会话
+			uploadGroup.GET("/sessions/:id", uploadHandler.GetUploadSession)       // 查询已上传的偏移
+			uploadGroup.HEAD("/sessions/:id", uploadHandler.GetUploadSession)      // 同上，兼容tus客户端
+			uploadGroup.PATCH("/sessions/:id", uploadHandler.PatchUploadSession)   // 从偏移处写入分块
+			uploadGroup.DELETE("/sessions/:id", uploadHandler.DeleteUploadSession) // 放弃上传
+		}
+
+		// 下载根目录中的文件管理
+		filesGroup := apiV1.Group("/files")
+		{
+			filesHandler := api.NewFilesHandler(s.ctx, s.paths)
+			filesGroup.GET("", filesHandler.ListFiles)             // 列出目录，未指定path时列出根目录
+			filesGroup.POST("/mkdir", filesHandler.Mkdir)          // 创建目录
+			filesGroup.POST("/rename", filesHandler.RenameFile)    // 重命名文件或目录
+			filesGroup.DELETE("", filesHandler.DeleteFile)         // 删除文件或目录
+			filesGroup.GET("/download", filesHandler.DownloadFile) // 下载文件
+		}
+
+		// 媒体预览，直接从Telegram流式读取
+		mediaGroup := apiV1.Group("/media")
+		{
+			mediaHandler := api.NewMediaHandler(s.ctx, s.kvd, s.auth, s.clients, s.thumbs)
+			mediaGroup.GET("/:peer/:msg", mediaHandler.StreamMedia)        // 流式返回消息中的媒体，支持Range
+			mediaGroup.GET("/:peer/:msg/thumb", mediaHandler.GetThumbnail) // 照片或文件的缩略图
 		}
+
+		// 任务队列相关
+		queueGroup := apiV1.Group("/queue")
+		{
+			queueHandler := api.NewQueueHandler(s.ctx, s.sched, s.auth)
+			queueGroup.GET("", queueHandler.GetQueue)                  // 获取运行中和排队中的任务
+			queueGroup.POST("/:id/priority", queueHandler.SetPriority) // 修改排队任务优先级
+			queueGroup.POST("/:id/move", queueHandler.MoveTask)        // 调整排队任务位置
+			queueGroup.POST("/:id/bump", queueHandler.BumpTask)        // 排队任务移到队首
+		}
+
+		// 进程资源占用和任务队列概况
+		apiV1.GET("/system/stats", metricsHandler.GetSystemStats)
+	}
+
+	// WebSocket端点，需要操作员认证，按客户端可访问的账号推送事件
+	s.router.GET("/ws", middleware.RequireAuth(s.operators), websocket.HandleWebSocket(s.wsHub, authHandler.AuthenticateWebSocket, allowedOrigins))
+}
+
+// importNamespaces 启动时将指定的CLI命名空间导入为Web账号，导入失败不影响启动
+func (s *Server) importNamespaces(namespaces []string) {
+	if len(namespaces) == 0 || s.cliStorage == nil {
+		return
 	}
 
-	// WebSocket端点
-	s.router.GET("/ws", websocket.HandleWebSocket(s.wsHub))
+	var proxy string
+	if settings, err := s.settings.GetCurrentSettings(); err == nil {
+		proxy = settings.GlobalProxy
+	}
+
+	for _, ns := range namespaces {
+		userInfo, err := s.auth.ImportNamespace(s.ctx, service.StartupClient, s.cliStorage, ns, proxy)
+		if err != nil {
+			logctx.From(s.ctx).Warn("Failed to import namespace",
+				zap.String("namespace", ns),
+				zap.Error(err))
+			color.Red("Failed to import namespace %s: %v", ns, err)
+			continue
+		}
+
+		logctx.From(s.ctx).Info("Imported namespace",
+			zap.String("namespace", ns),
+			zap.Int64("user_id", userInfo.ID))
+		color.Green("Imported namespace %s as account %d", ns, userInfo.ID)
+	}
 }
 
 func (s *Server) Start() error {
-	logctx.From(s.ctx).Info("Starting web server", 
+	logctx.From(s.ctx).Info("Starting web server",
 		zap.Int("port", s.port))
 
 	srv := &http.Server{
@@ -167,14 +415,16 @@ func (s *Server) Start() error {
 	go func() {
 		<-s.ctx.Done()
 		logctx.From(s.ctx).Info("Shutting down web server")
-		
+
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		
+
 		if err := srv.Shutdown(ctx); err != nil {
 			logctx.From(s.ctx).Error("Server forced to shutdown", zap.Error(err))
 		}
 	}()
 
-	return srv.ListenAndServe()
-}
\ No newline at end of file
+	err := srv.ListenAndServe()
+	s.clients.Close()
+	return err
+}
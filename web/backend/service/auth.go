@@ -4,34 +4,48 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/telegram/auth/qrlogin"
 	"github.com/gotd/td/tg"
 	"github.com/gotd/td/tgerr"
 	"github.com/skip2/go-qrcode"
-	"go.uber.org/zap"
 
-	"github.com/iyear/tdl/core/logctx"
 	"github.com/iyear/tdl/pkg/key"
 	"github.com/iyear/tdl/pkg/kv"
 	"github.com/iyear/tdl/pkg/tclient"
 )
 
+var (
+	// ErrNotAuthenticated 客户端没有当前账号，或请求的账号未登录Telegram、不属于该客户端
+	ErrNotAuthenticated = errors.New("not authenticated")
+	// ErrLoginSessionNotFound 登录会话不存在、已被清理或不属于该客户端
+	ErrLoginSessionNotFound = errors.New("session not found")
+)
+
 // AuthService 认证服务
 type AuthService struct {
 	ctx      context.Context
 	kvStore  kv.Storage
 	sessions map[string]*LoginSession
 	mu       sync.RWMutex
+	loginMu  sync.Mutex // 串行化登录流程的启动，保证每个客户端同时只有一个登录流程
 }
 
 // LoginSession 登录会话
+//
+// ID为随机生成的会话ID，ClientID为发起登录的浏览器，只有该浏览器可以查询和提交验证码
 type LoginSession struct {
 	ID           string
+	ClientID     string
 	Type         LoginType
 	Status       LoginStatus
 	Client       *telegram.Client
@@ -43,8 +57,12 @@ type LoginSession struct {
 	Error        string
 	UserInfo     *UserInfo
 	NeedPassword bool
+	CodeChan     chan string // 用于验证码传递的通道
 	PasswordChan chan string // 用于2FA密码传递的通道
 	ProxyURL     string      // 会话级别的代理配置
+
+	cancel context.CancelFunc // 取消登录流程
+	done   chan struct{}      // 登录流程退出后关闭
 }
 
 // LoginType 登录类型
@@ -75,6 +93,7 @@ type UserInfo struct {
 	Phone     string `json:"phone"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	Source    string `json:"source,omitempty"` // 从CLI导入的账号的来源命名空间
 }
 
 // NewAuthService 创建认证服务
@@ -135,7 +154,7 @@ func (s *AuthService) GetAuthenticatedTelegramID(clientID string) (int64, error)
 	telegramIDData, err := mappingNS.Get(context.Background(), clientID)
 	if err != nil {
 		if kv.IsNotFound(err) {
-			return 0, errors.New("client not authenticated")
+			return 0, errors.Wrap(ErrNotAuthenticated, "client")
 		}
 		return 0, errors.Wrap(err, "get telegram id")
 	}
@@ -151,24 +170,218 @@ func (s *AuthService) GetAuthenticatedTelegramID(clientID string) (int64, error)
 		return 0, errors.Wrap(err, "check authentication status")
 	}
 
-	if !authenticated {
-		return 0, errors.New("telegram user not authenticated")
+	if !authenticated || !s.CanAccess(clientID, telegramID) {
+		return 0, errors.Wrapf(ErrNotAuthenticated, "telegram user %d", telegramID)
 	}
 
 	return telegramID, nil
 }
 
-// StartQRLogin 开始二维码登录
-func (s *AuthService) StartQRLogin(sessionID, proxyURL string) (*LoginSession, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// CanAccess 判断客户端是否可以使用已登录的账号
+//
+// 账号属于登录或导入它的浏览器；没有归属记录的账号（旧版本登录的账号和启动时导入的账号）所有浏览器都可以使用
+func (s *AuthService) CanAccess(clientID string, accountID int64) bool {
+	authenticated, _, err := s.IsAuthenticated(strconv.FormatInt(accountID, 10))
+	if err != nil || !authenticated {
+		return false
+	}
+
+	owners, err := s.accountOwners(accountID)
+	if err != nil {
+		return false
+	}
+	return owners == nil || slices.Contains(owners, clientID)
+}
+
+// CanAccessTask 判断客户端是否可以查看和操作任务
+//
+// 任务属于创建它的客户端和可以使用任务账号的客户端，未记录账号的旧任务只属于创建它的客户端
+func (s *AuthService) CanAccessTask(clientID, taskClient string, accountID int64) bool {
+	if taskClient != "" && taskClient == clientID {
+		return true
+	}
+	return accountID != 0 && s.CanAccess(clientID, accountID)
+}
+
+// accountOwners 返回可以使用账号的客户端，没有归属记录时返回nil
+func (s *AuthService) accountOwners(accountID int64) ([]string, error) {
+	ns, err := s.kvStore.Open(fmt.Sprintf("user_%d", accountID))
+	if err != nil {
+		return nil, errors.Wrap(err, "open storage")
+	}
+
+	data, err := ns.Get(context.Background(), "owners")
+	if err != nil {
+		if kv.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get owners")
+	}
+
+	owners := make([]string, 0)
+	if err := json.Unmarshal(data, &owners); err != nil {
+		return nil, errors.Wrap(err, "unmarshal owners")
+	}
+	return owners, nil
+}
+
+// saveAccountOwners 保存可以使用账号的客户端
+func (s *AuthService) saveAccountOwners(accountID int64, owners []string) error {
+	ns, err := s.kvStore.Open(fmt.Sprintf("user_%d", accountID))
+	if err != nil {
+		return errors.Wrap(err, "open storage")
+	}
+
+	data, err := json.Marshal(owners)
+	if err != nil {
+		return errors.Wrap(err, "marshal owners")
+	}
+	return ns.Set(context.Background(), "owners", data)
+}
+
+// addAccountOwner 允许客户端使用登录或导入的账号
+func (s *AuthService) addAccountOwner(clientID string, accountID int64) error {
+	// 启动时导入不改变归属：新账号共享，已有账号保持原有归属
+	if clientID == StartupClient {
+		return nil
+	}
+
+	owners, err := s.accountOwners(accountID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(owners, clientID) {
+		return nil
+	}
+
+	// 已登录且没有归属记录的共享账号保持共享
+	if owners == nil {
+		authenticated, _, err := s.IsAuthenticated(strconv.FormatInt(accountID, 10))
+		if err != nil {
+			return errors.Wrap(err, "check authentication status")
+		}
+		if authenticated {
+			return nil
+		}
+	}
+
+	return s.saveAccountOwners(accountID, append(owners, clientID))
+}
+
+// ListAccounts 列出客户端可以使用的已登录Telegram账号，按ID排序
+func (s *AuthService) ListAccounts(clientID string) ([]UserInfo, error) {
+	namespaces, err := s.kvStore.Namespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "list namespaces")
+	}
 
-	if oldSession, exists := s.sessions[sessionID]; exists && oldSession.Client != nil {
-		// 简化处理：忽略客户端清理
+	accounts := make([]UserInfo, 0)
+	for _, ns := range namespaces {
+		if !strings.HasPrefix(ns, "user_") {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(ns, "user_"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		authenticated, userInfo, err := s.IsAuthenticated(strconv.FormatInt(id, 10))
+		if err != nil {
+			return nil, errors.Wrapf(err, "check account %d", id)
+		}
+		if !authenticated || !s.CanAccess(clientID, id) {
+			continue
+		}
+
+		if userInfo == nil {
+			userInfo = &UserInfo{ID: id}
+		}
+		accounts = append(accounts, *userInfo)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID < accounts[j].ID
+	})
+	return accounts, nil
+}
+
+// ResolveAccount 返回任务使用的账号，accountID为0时使用客户端的当前账号
+func (s *AuthService) ResolveAccount(clientID string, accountID int64) (int64, error) {
+	if accountID == 0 {
+		return s.GetAuthenticatedTelegramID(clientID)
+	}
+
+	authenticated, _, err := s.IsAuthenticated(strconv.FormatInt(accountID, 10))
+	if err != nil {
+		return 0, errors.Wrap(err, "check authentication status")
+	}
+	if !authenticated || !s.CanAccess(clientID, accountID) {
+		return 0, errors.Wrapf(ErrNotAuthenticated, "account %d", accountID)
+	}
+
+	return accountID, nil
+}
+
+// SwitchAccount 将客户端的当前账号切换为已登录的accountID
+func (s *AuthService) SwitchAccount(clientID string, accountID int64) error {
+	if _, err := s.ResolveAccount(clientID, accountID); err != nil {
+		return err
+	}
+
+	return s.saveClientMapping(clientID, accountID)
+}
+
+// RemoveAccount 从客户端移除账号，如果是客户端的当前账号，则切换到其他已登录的账号
+//
+// 账号还属于其他客户端时只移除该客户端的访问权限，否则登出账号。loggedOut表示账号已登出，
+// 调用方需要关闭账号的Telegram客户端
+func (s *AuthService) RemoveAccount(clientID string, accountID int64) (loggedOut bool, _ error) {
+	if _, err := s.ResolveAccount(clientID, accountID); err != nil {
+		return false, err
+	}
+	current, _ := s.GetAuthenticatedTelegramID(clientID)
+
+	owners, err := s.accountOwners(accountID)
+	if err != nil {
+		return false, err
+	}
+	if len(owners) > 1 {
+		owners = slices.DeleteFunc(owners, func(owner string) bool { return owner == clientID })
+		if err := s.saveAccountOwners(accountID, owners); err != nil {
+			return false, errors.Wrap(err, "save owners")
+		}
+	} else {
+		if err := s.Logout(strconv.FormatInt(accountID, 10)); err != nil {
+			return false, err
+		}
+		loggedOut = true
+	}
+
+	if current != accountID {
+		return loggedOut, nil
+	}
+
+	accounts, err := s.ListAccounts(clientID)
+	if err != nil {
+		return loggedOut, err
+	}
+	if len(accounts) == 0 {
+		return loggedOut, nil // 没有其他账号，映射指向的账号已不可用
+	}
+
+	return loggedOut, s.saveClientMapping(clientID, accounts[0].ID)
+}
+
+// StartQRLogin 开始二维码登录
+func (s *AuthService) StartQRLogin(clientID, proxyURL string) (*LoginSession, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return nil, err
 	}
 
 	session := &LoginSession{
 		ID:           sessionID,
+		ClientID:     clientID,
 		Type:         LoginTypeQR,
 		Status:       StatusInitializing,
 		CreatedAt:    time.Now(),
@@ -177,65 +390,109 @@ func (s *AuthService) StartQRLogin(sessionID, proxyURL string) (*LoginSession, e
 		ProxyURL:     proxyURL,             // 存储代理配置
 	}
 
-	s.sessions[sessionID] = session
-	go s.processQRLogin(session)
-	return session, nil
+	return s.startLogin(session, s.processQRLogin), nil
 }
 
 // StartCodeLogin 开始验证码登录
-func (s *AuthService) StartCodeLogin(sessionID, phone, proxyURL string) (*LoginSession, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if oldSession, exists := s.sessions[sessionID]; exists && oldSession.Client != nil {
-		// 简化处理：忽略客户端清理
+func (s *AuthService) StartCodeLogin(clientID, phone, proxyURL string) (*LoginSession, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return nil, err
 	}
 
 	session := &LoginSession{
 		ID:           sessionID,
+		ClientID:     clientID,
 		Type:         LoginTypeCode,
 		Status:       StatusInitializing,
 		Phone:        phone,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
+		CodeChan:     make(chan string, 1), // 初始化验证码通道
 		PasswordChan: make(chan string, 1), // 初始化密码通道
 		ProxyURL:     proxyURL,             // 存储代理配置
 	}
 
-	s.sessions[sessionID] = session
-	go s.processCodeLogin(session)
-	return session, nil
+	return s.startLogin(session, s.processCodeLogin), nil
+}
+
+// startLogin 停止客户端之前的登录流程后启动新的登录流程，返回会话的快照
+//
+// 同一客户端的登录使用同一个临时命名空间，必须等待之前的client.Run退出后才能开始新的登录
+func (s *AuthService) startLogin(session *LoginSession, process func(ctx context.Context, session *LoginSession)) *LoginSession {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+
+	s.mu.Lock()
+	previous := make([]*LoginSession, 0)
+	for id, old := range s.sessions {
+		if old.ClientID == session.ClientID {
+			previous = append(previous, old)
+			delete(s.sessions, id)
+		}
+	}
+	s.mu.Unlock()
+
+	// 等待时不能持有s.mu，登录流程退出前需要更新会话状态
+	for _, old := range previous {
+		old.cancel()
+		<-old.done
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	session.cancel = cancel
+	session.done = make(chan struct{})
+
+	s.mu.Lock()
+	s.sessions[session.ID] = session
+	snapshot := *session
+	s.mu.Unlock()
+
+	go func() {
+		defer close(session.done)
+		defer cancel()
+		process(ctx, session)
+	}()
+
+	return &snapshot
 }
 
 // VerifyCode 验证码验证
-func (s *AuthService) VerifyCode(sessionID, code string) error {
+func (s *AuthService) VerifyCode(clientID, sessionID, code string) error {
 	s.mu.Lock()
-	session, exists := s.sessions[sessionID]
+	session, exists := s.session(clientID, sessionID)
+	var status LoginStatus
+	if exists {
+		status = session.Status
+	}
 	s.mu.Unlock()
 
 	if !exists {
-		return errors.New("session not found")
+		return ErrLoginSessionNotFound
 	}
 
-	if session.Status != StatusWaitingCode {
+	if status != StatusWaitingCode {
 		return errors.New("not waiting for code")
 	}
 
-	go s.verifyCode(session, code)
-	return nil
+	return s.verifyCode(session, code)
 }
 
 // VerifyPassword 2FA密码验证
-func (s *AuthService) VerifyPassword(sessionID, password string) error {
+func (s *AuthService) VerifyPassword(clientID, sessionID, password string) error {
 	s.mu.Lock()
-	session, exists := s.sessions[sessionID]
+	session, exists := s.session(clientID, sessionID)
+	var status LoginStatus
+	if exists {
+		status = session.Status
+	}
 	s.mu.Unlock()
 
 	if !exists {
-		return errors.New("session not found")
+		return ErrLoginSessionNotFound
 	}
 
-	if session.Status != StatusWaitingPassword {
+	if status != StatusWaitingPassword {
 		return errors.New("not waiting for password")
 	}
 
@@ -243,21 +500,42 @@ func (s *AuthService) VerifyPassword(sessionID, password string) error {
 	return nil
 }
 
-// GetSession 获取登录会话
-func (s *AuthService) GetSession(sessionID string) (*LoginSession, error) {
+// GetSession 获取客户端的登录会话快照，登录流程会继续更新会话，调用方不能持有会话本身
+func (s *AuthService) GetSession(clientID, sessionID string) (*LoginSession, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	session, exists := s.sessions[sessionID]
+	session, exists := s.session(clientID, sessionID)
 	if !exists {
-		return nil, errors.New("session not found")
+		return nil, ErrLoginSessionNotFound
 	}
 
-	return session, nil
+	snapshot := *session
+	return &snapshot, nil
+}
+
+// session 查找属于客户端的登录会话，调用方需持有锁
+func (s *AuthService) session(clientID, sessionID string) (*LoginSession, bool) {
+	session, exists := s.sessions[sessionID]
+	if !exists || session.ClientID != clientID {
+		return nil, false
+	}
+	return session, true
+}
+
+// pendingLogin 返回客户端进行中的登录会话，调用方需持有锁
+func (s *AuthService) pendingLogin(clientID string) *LoginSession {
+	for _, session := range s.sessions {
+		if session.ClientID == clientID && session.Status != StatusCompleted &&
+			session.Status != StatusFailed && session.Status != StatusExpired {
+			return session
+		}
+	}
+	return nil
 }
 
 // processQRLogin 处理QR登录流程
-func (s *AuthService) processQRLogin(session *LoginSession) {
+func (s *AuthService) processQRLogin(ctx context.Context, session *LoginSession) {
 	defer func() {
 		if r := recover(); r != nil {
 			s.mu.Lock()
@@ -268,8 +546,8 @@ func (s *AuthService) processQRLogin(session *LoginSession) {
 		}
 	}()
 
-	// 创建会话专用的KV存储
-	ns, err := s.kvStore.Open(fmt.Sprintf("session_%s", session.ID))
+	// 创建客户端登录专用的KV存储
+	ns, err := s.kvStore.Open(fmt.Sprintf("session_%s", session.ClientID))
 	if err != nil {
 		s.mu.Lock()
 		session.Status = StatusFailed
@@ -279,6 +557,12 @@ func (s *AuthService) processQRLogin(session *LoginSession) {
 		return
 	}
 
+	// 清除上次登录的会话，登录新账号时不能复用已登录账号的授权
+	if err = ns.Delete(context.Background(), "session"); err != nil && !kv.IsNotFound(err) {
+		s.setStatus(session, StatusFailed, fmt.Sprintf("reset session storage: %v", err))
+		return
+	}
+
 	// 设置App类型为Desktop（QR登录需要）
 	if err = ns.Set(context.Background(), key.App(), []byte(tclient.AppDesktop)); err != nil {
 		s.mu.Lock()
@@ -293,7 +577,7 @@ func (s *AuthService) processQRLogin(session *LoginSession) {
 	d := tg.NewUpdateDispatcher()
 
 	// 创建Telegram客户端
-	ctx := kv.With(s.ctx, s.kvStore)
+	ctx = kv.With(ctx, s.kvStore)
 	
 	client, err := tclient.New(ctx, tclient.Options{
 		KV:            ns,
@@ -378,7 +662,7 @@ func (s *AuthService) processQRLogin(session *LoginSession) {
 }
 
 // processCodeLogin 处理验证码登录流程  
-func (s *AuthService) processCodeLogin(session *LoginSession) {
+func (s *AuthService) processCodeLogin(ctx context.Context, session *LoginSession) {
 	defer func() {
 		if r := recover(); r != nil {
 			s.mu.Lock()
@@ -389,18 +673,157 @@ func (s *AuthService) processCodeLogin(session *LoginSession) {
 		}
 	}()
 
-	// 简化处理：跳过客户端创建，直接设置状态
+	// 创建客户端登录专用的KV存储
+	ns, err := s.kvStore.Open(fmt.Sprintf("session_%s", session.ClientID))
+	if err != nil {
+		s.setStatus(session, StatusFailed, fmt.Sprintf("open session storage: %v", err))
+		return
+	}
+
+	// 清除上次登录的会话，登录新账号时不能复用已登录账号的授权
+	if err = ns.Delete(context.Background(), "session"); err != nil && !kv.IsNotFound(err) {
+		s.setStatus(session, StatusFailed, fmt.Sprintf("reset session storage: %v", err))
+		return
+	}
+
+	if err = ns.Set(context.Background(), key.App(), []byte(tclient.AppDesktop)); err != nil {
+		s.setStatus(session, StatusFailed, fmt.Sprintf("set app: %v", err))
+		return
+	}
+
+	ctx = kv.With(ctx, s.kvStore)
+
+	client, err := tclient.New(ctx, tclient.Options{
+		KV:    ns,
+		Proxy: session.ProxyURL, // 使用会话中的代理配置
+	}, true) // 登录模式
+	if err != nil {
+		s.setStatus(session, StatusFailed, fmt.Sprintf("create client: %v", err))
+		return
+	}
+
 	s.mu.Lock()
-	session.Status = StatusWaitingCode
+	session.Client = client
 	session.UpdatedAt = time.Now()
 	s.mu.Unlock()
+
+	// 整个登录流程在同一个client.Run中完成，验证码和密码通过通道传入
+	err = client.Run(ctx, func(ctx context.Context) error {
+		sent, err := client.Auth().SendCode(ctx, session.Phone, auth.SendCodeOptions{})
+		if err != nil {
+			return errors.Wrap(err, "send code")
+		}
+
+		sentCode, ok := sent.(*tg.AuthSentCode)
+		if !ok {
+			return errors.Errorf("unexpected sent code type %T", sent)
+		}
+
+		s.mu.Lock()
+		session.CodeHash = sentCode.PhoneCodeHash
+		s.mu.Unlock()
+		s.setStatus(session, StatusWaitingCode, "")
+
+		for {
+			code, err := waitInput(ctx, session.CodeChan)
+			if err != nil {
+				return errors.Wrap(err, "wait code")
+			}
+
+			_, err = client.Auth().SignIn(ctx, session.Phone, code, sentCode.PhoneCodeHash)
+			var signUp *auth.SignUpRequired
+			switch {
+			case err == nil:
+				return s.completeLoginInClient(ctx, session, client)
+			case errors.Is(err, auth.ErrPasswordAuthNeeded):
+				return s.passwordLogin(ctx, session, client)
+			case tgerr.Is(err, "PHONE_CODE_INVALID"):
+				// 验证码错误，允许重新输入
+				s.setStatus(session, StatusWaitingCode, "invalid code, please try again")
+			case errors.As(err, &signUp):
+				return errors.New("phone number is not registered, sign up is not supported")
+			default:
+				return errors.Wrap(err, "sign in")
+			}
+		}
+	})
+
+	if err != nil {
+		s.setStatus(session, StatusFailed, fmt.Sprintf("login process: %v", err))
+	}
+}
+
+// passwordLogin 等待并验证2FA密码，密码错误时允许重新输入
+func (s *AuthService) passwordLogin(ctx context.Context, session *LoginSession, client *telegram.Client) error {
+	s.mu.Lock()
+	session.NeedPassword = true
+	s.mu.Unlock()
+	s.setStatus(session, StatusWaitingPassword, "")
+
+	for {
+		password, err := waitInput(ctx, session.PasswordChan)
+		if err != nil {
+			return errors.Wrap(err, "wait password")
+		}
+
+		_, err = client.Auth().Password(ctx, password)
+		switch {
+		case err == nil:
+			return s.completeLoginInClient(ctx, session, client)
+		case errors.Is(err, auth.ErrPasswordInvalid):
+			s.setStatus(session, StatusWaitingPassword, "invalid password, please try again")
+		default:
+			return errors.Wrap(err, "password auth")
+		}
+	}
+}
+
+// waitInput 等待用户通过通道提交验证码或密码
+func waitInput(ctx context.Context, ch <-chan string) (string, error) {
+	select {
+	case input, ok := <-ch:
+		if !ok {
+			return "", errors.New("session closed")
+		}
+		return input, nil
+	case <-time.After(5 * time.Minute): // 5分钟超时
+		return "", errors.New("timeout")
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// setStatus 更新会话状态和错误信息
+func (s *AuthService) setStatus(session *LoginSession, status LoginStatus, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.Status = status
+	session.Error = errMsg
+	session.UpdatedAt = time.Now()
 }
 
 // completeLoginInClient 在客户端上下文中完成登录
 func (s *AuthService) completeLoginInClient(ctx context.Context, session *LoginSession, client *telegram.Client) error {
+	userInfo, err := s.saveAccount(ctx, session.ClientID, client, "")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	session.Status = StatusCompleted
+	session.UserInfo = userInfo
+	session.UpdatedAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// saveAccount 将客户端登录命名空间中已授权的会话保存为账号，source为导入来源的CLI命名空间
+func (s *AuthService) saveAccount(ctx context.Context, clientID string, client *telegram.Client, source string) (*UserInfo, error) {
 	user, err := client.Self(ctx)
 	if err != nil {
-		return errors.Wrap(err, "get self")
+		return nil, errors.Wrap(err, "get self")
 	}
 
 	userInfo := &UserInfo{
@@ -409,50 +832,39 @@ func (s *AuthService) completeLoginInClient(ctx context.Context, session *LoginS
 		Phone:     user.Phone,
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
+		Source:    source,
+	}
+
+	// 记录客户端对账号的访问权限，需要在保存会话前判断账号是否已登录
+	if err := s.addAccountOwner(clientID, userInfo.ID); err != nil {
+		return nil, errors.Wrap(err, "save account owner")
 	}
 
 	// 保存用户信息到存储
-	s.saveUserInfo(session.ID, userInfo)
+	s.saveUserInfo(clientID, userInfo)
 
-	// 复制会话数据到用户命名空间，确保与Chat API兼容
-	if err := s.syncSessionToUserNamespace(session.ID, userInfo.ID); err != nil {
-		logctx.From(ctx).Error("Failed to sync session to user namespace", zap.Error(err))
+	// 复制会话数据到用户命名空间，账号以此判断是否已登录
+	if err := s.syncSessionToUserNamespace(clientID, userInfo.ID); err != nil {
+		return nil, errors.Wrap(err, "sync session")
 	}
 
-	s.mu.Lock()
-	session.Status = StatusCompleted
-	session.UserInfo = userInfo
-	session.UpdatedAt = time.Now()
-	s.mu.Unlock()
-
-	return nil
+	return userInfo, nil
 }
-func (s *AuthService) verifyCode(session *LoginSession, code string) {
+
+// verifyCode 将验证码发送给等待中的client.Run()会话
+func (s *AuthService) verifyCode(session *LoginSession, code string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			s.mu.Lock()
-			session.Status = StatusFailed
-			session.Error = fmt.Sprintf("panic: %v", r)
-			session.UpdatedAt = time.Now()
-			s.mu.Unlock()
+			err = errors.New("session closed") // 会话已被清理，通道已关闭
 		}
 	}()
 
-	userInfo := &UserInfo{
-		ID:        123456789,
-		Username:  "testuser",
-		Phone:     session.Phone,
-		FirstName: "Test",
-		LastName:  "User",
+	select {
+	case session.CodeChan <- code:
+		return nil
+	default:
+		return errors.New("code already submitted")
 	}
-
-	s.saveUserInfo(session.ID, userInfo)
-
-	s.mu.Lock()
-	session.Status = StatusCompleted
-	session.UserInfo = userInfo
-	session.UpdatedAt = time.Now()
-	s.mu.Unlock()
 }
 
 // verifyPassword 验证2FA密码
@@ -491,7 +903,7 @@ func (s *AuthService) verifyPassword(session *LoginSession, password string) {
 }
 
 // saveUserInfo 保存用户信息到存储
-func (s *AuthService) saveUserInfo(sessionID string, userInfo *UserInfo) {
+func (s *AuthService) saveUserInfo(clientID string, userInfo *UserInfo) {
 	userID := fmt.Sprintf("%d", userInfo.ID)
 	ns, err := s.kvStore.Open(fmt.Sprintf("user_%s", userID))
 	if err != nil {
@@ -500,27 +912,26 @@ func (s *AuthService) saveUserInfo(sessionID string, userInfo *UserInfo) {
 
 	userInfoJSON, _ := json.Marshal(userInfo)
 	ns.Set(context.Background(), "user_info", userInfoJSON)
-	ns.Set(context.Background(), "session", []byte("established"))
 
-	// 保存客户端到Telegram ID的映射
-	s.saveClientMapping(sessionID, userInfo.ID)
+	// 保存客户端到Telegram ID的映射，新登录的账号成为当前账号，其他账号保持登录
+	_ = s.saveClientMapping(clientID, userInfo.ID)
 }
 
-// saveClientMapping 保存客户端到Telegram ID的映射
-func (s *AuthService) saveClientMapping(clientID string, telegramID int64) {
+// saveClientMapping 保存客户端到Telegram ID的映射，即客户端的当前账号
+func (s *AuthService) saveClientMapping(clientID string, telegramID int64) error {
 	mappingNS, err := s.kvStore.Open("client_mapping")
 	if err != nil {
-		return
+		return errors.Wrap(err, "open client mapping storage")
 	}
 
 	telegramIDJSON, _ := json.Marshal(telegramID)
-	mappingNS.Set(context.Background(), clientID, telegramIDJSON)
+	return mappingNS.Set(context.Background(), clientID, telegramIDJSON)
 }
 
-// syncSessionToUserNamespace 同步会话数据到用户命名空间
-func (s *AuthService) syncSessionToUserNamespace(sessionID string, telegramID int64) error {
-	// 从会话命名空间读取Telegram会话数据
-	sessionNS, err := s.kvStore.Open(fmt.Sprintf("session_%s", sessionID))
+// syncSessionToUserNamespace 同步客户端登录命名空间的会话数据到用户命名空间
+func (s *AuthService) syncSessionToUserNamespace(clientID string, telegramID int64) error {
+	// 从登录命名空间读取Telegram会话数据
+	sessionNS, err := s.kvStore.Open(fmt.Sprintf("session_%s", clientID))
 	if err != nil {
 		return errors.Wrap(err, "open session namespace")
 	}
@@ -531,9 +942,8 @@ func (s *AuthService) syncSessionToUserNamespace(sessionID string, telegramID in
 		return errors.Wrap(err, "get session data")
 	}
 
-	// 如果没有真正的会话数据，跳过同步
 	if len(sessionData) == 0 {
-		return nil
+		return errors.New("no session data")
 	}
 
 	// 复制到用户命名空间
@@ -551,13 +961,17 @@ func (s *AuthService) syncSessionToUserNamespace(sessionID string, telegramID in
 }
 
 // GetQRCode 生成QR码图像
-func (s *AuthService) GetQRCode(sessionID string, size int) ([]byte, error) {
+func (s *AuthService) GetQRCode(clientID, sessionID string, size int) ([]byte, error) {
 	s.mu.RLock()
-	session, exists := s.sessions[sessionID]
+	session, exists := s.session(clientID, sessionID)
+	var token *qrlogin.Token
+	if exists {
+		token = session.QRToken
+	}
 	s.mu.RUnlock()
 
 	if !exists {
-		return nil, errors.New("session not found")
+		return nil, ErrLoginSessionNotFound
 	}
 
 	if size <= 0 {
@@ -566,8 +980,8 @@ func (s *AuthService) GetQRCode(sessionID string, size int) ([]byte, error) {
 
 	testURL := fmt.Sprintf("tg://login?token=test_token_%s", sessionID)
 	
-	if session.QRToken != nil {
-		testURL = session.QRToken.URL()
+	if token != nil {
+		testURL = token.URL()
 	}
 
 	qr, err := qrcode.New(testURL, qrcode.Medium)
@@ -593,10 +1007,14 @@ func (s *AuthService) cleanupSessions() {
 			for sessionID, session := range s.sessions {
 				if now.Sub(session.UpdatedAt) > time.Minute*30 {
 					// 清理channel
+					if session.CodeChan != nil {
+						close(session.CodeChan)
+					}
 					if session.PasswordChan != nil {
 						close(session.PasswordChan)
 					}
-					// 简化处理：忽略客户端清理
+					// 停止仍在运行的登录流程，不等待其退出，退出前需要获取s.mu
+					session.cancel()
 					delete(s.sessions, sessionID)
 				}
 			}
@@ -620,5 +1038,9 @@ func (s *AuthService) Logout(userID string) error {
 		return errors.Wrap(err, "delete user info")
 	}
 
+	if err := ns.Delete(context.Background(), "owners"); err != nil && !kv.IsNotFound(err) {
+		return errors.Wrap(err, "delete owners")
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+func TestAccountAccess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	kvd, err := kv.New(kv.DriverBolt, map[string]any{"path": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, kvd.Close()) })
+
+	s := NewAuthService(ctx, kvd)
+
+	// login模拟saveAccount：先记录访问权限，再保存会话
+	login := func(clientID string, id int64) {
+		require.NoError(t, s.addAccountOwner(clientID, id))
+		ns, err := kvd.Open(fmt.Sprintf("user_%d", id))
+		require.NoError(t, err)
+		require.NoError(t, ns.Set(ctx, "session", []byte("data")))
+		require.NoError(t, s.saveClientMapping(clientID, id))
+	}
+	ids := func(clientID string) []int64 {
+		accounts, err := s.ListAccounts(clientID)
+		require.NoError(t, err)
+		ids := make([]int64, 0, len(accounts))
+		for _, a := range accounts {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+
+	login(StartupClient, 1) // 共享账号
+	login("a", 2)
+	login("b", 3)
+	login("b", 2)
+
+	assert.Equal(t, []int64{1, 2}, ids("a"))
+	assert.Equal(t, []int64{1, 2, 3}, ids("b"))
+	assert.True(t, s.CanAccess("c", 1))
+	assert.False(t, s.CanAccess("a", 3))
+
+	_, err = s.ResolveAccount("a", 3)
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+	assert.ErrorIs(t, s.SwitchAccount("a", 3), ErrNotAuthenticated)
+	_, err = s.RemoveAccount("a", 3)
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+
+	// 账号还属于a，b移除后只失去访问权限，当前账号切换到其他账号
+	loggedOut, err := s.RemoveAccount("b", 2)
+	require.NoError(t, err)
+	assert.False(t, loggedOut)
+	assert.Equal(t, []int64{1, 3}, ids("b"))
+	current, err := s.GetAuthenticatedTelegramID("b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), current)
+
+	loggedOut, err = s.RemoveAccount("a", 2)
+	require.NoError(t, err)
+	assert.True(t, loggedOut)
+	assert.Equal(t, []int64{1}, ids("a"))
+	assert.False(t, s.CanAccess("a", 2))
+
+	// 登出后重新登录的账号属于新的客户端
+	login("c", 2)
+	assert.Equal(t, []int64{1, 2}, ids("c"))
+	assert.Equal(t, []int64{1}, ids("a"))
+
+	// 任务属于创建它的客户端和可以使用任务账号的客户端
+	assert.True(t, s.CanAccessTask("a", "a", 2))
+	assert.True(t, s.CanAccessTask("c", "a", 2))
+	assert.False(t, s.CanAccessTask("b", "a", 2))
+	assert.True(t, s.CanAccessTask("b", "a", 1))
+	assert.False(t, s.CanAccessTask("b", "a", 0))
+	assert.False(t, s.CanAccessTask("b", "", 0))
+}
+
+func TestStartLogin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &AuthService{ctx: ctx, sessions: make(map[string]*LoginSession)}
+
+	var running atomic.Int32
+	process := func(ctx context.Context, session *LoginSession) {
+		assert.Equal(t, int32(1), running.Add(1), "previous login is still running")
+		s.setStatus(session, StatusWaitingCode, "")
+		<-ctx.Done()
+		s.setStatus(session, StatusFailed, ctx.Err().Error())
+		running.Add(-1)
+	}
+	waiting := func(id string) func() bool {
+		return func() bool {
+			session, err := s.GetSession("a", id)
+			return err == nil && session.Status == StatusWaitingCode
+		}
+	}
+
+	first := s.startLogin(&LoginSession{ID: "1", ClientID: "a", Status: StatusInitializing, CodeChan: make(chan string, 1)}, process)
+	assert.Equal(t, StatusInitializing, first.Status)
+	require.Eventually(t, waiting("1"), time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, s.VerifyCode("b", "1", "12345"), ErrLoginSessionNotFound)
+	require.NoError(t, s.VerifyCode("a", "1", "12345"))
+	assert.Error(t, s.VerifyPassword("a", "1", "password"))
+
+	// 新的登录取消并等待之前的登录流程退出
+	s.startLogin(&LoginSession{ID: "2", ClientID: "a", Status: StatusInitializing}, process)
+	_, err := s.GetSession("a", "1")
+	assert.ErrorIs(t, err, ErrLoginSessionNotFound)
+	require.Eventually(t, waiting("2"), time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), running.Load())
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/dcpool"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/middlewares/observer"
+	"github.com/iyear/tdl/core/storage"
+	tclientcore "github.com/iyear/tdl/core/tclient"
+	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/pkg/tclient"
+)
+
+// ErrClientManagerClosed 客户端管理器已关闭
+var ErrClientManagerClosed = errors.New("client manager closed")
+
+// ClientConfig 创建Telegram客户端使用的配置，变化时需要重新连接
+type ClientConfig struct {
+	Proxy            string
+	ReconnectTimeout time.Duration
+	PoolSize         int64
+}
+
+// AccountClient 账号的长连接客户端，由ClientManager持有，使用方不能关闭
+type AccountClient struct {
+	ID     int64
+	Client *telegram.Client
+	Pool   dcpool.Pool
+	KV     storage.Storage
+}
+
+// accountClient 运行中的客户端及其引用计数
+type accountClient struct {
+	AccountClient
+
+	config ClientConfig
+	pool   *trackedPool // 与Pool相同，用于导出指标
+	cancel context.CancelFunc
+	ready  chan struct{} // 客户端已连接并通过认证检查，或启动失败
+	err    error         // 启动失败的原因，ready关闭后可读
+	refs   int
+	stale  bool // 配置已变化或账号已登出，空闲后关闭
+}
+
+// ClientManager 为每个账号维护一个长期运行的Telegram客户端和dcpool.Pool
+//
+// HTTP请求和任务共享同一个账号的连接，不再为每次调用新建客户端。
+// 配置（如代理）变化或账号登出后，旧客户端在最后一个使用方结束后关闭，
+// 新的调用会使用新配置重新连接
+type ClientManager struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	kvd       kv.Storage
+	auth      *AuthService
+	config    func() ClientConfig
+	updates   func(id int64) telegram.UpdateHandler
+	observers func(id int64) observer.Observer
+	mu        sync.Mutex
+	accounts  map[int64]*accountClient
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewClientManager 创建客户端管理器，config在每次连接时读取
+//
+// updates为账号的客户端提供更新处理器，observers接收连接池中间件的重试和FLOOD_WAIT，都可以为nil
+func NewClientManager(ctx context.Context, kvd kv.Storage, auth *AuthService, config func() ClientConfig,
+	updates func(id int64) telegram.UpdateHandler, observers func(id int64) observer.Observer) *ClientManager {
+	ctx, cancel := context.WithCancel(ctx)
+	return &ClientManager{
+		ctx:       ctx,
+		cancel:    cancel,
+		kvd:       kvd,
+		auth:      auth,
+		config:    config,
+		updates:   updates,
+		observers: observers,
+		accounts:  make(map[int64]*accountClient),
+	}
+}
+
+// Run 使用账号的客户端执行f，accountID为0时使用clientID的当前账号
+func (m *ClientManager) Run(ctx context.Context, clientID string, accountID int64, f func(ctx context.Context, c *AccountClient) error) error {
+	id, err := m.auth.ResolveAccount(clientID, accountID)
+	if err != nil {
+		return errors.Wrap(err, "resolve account")
+	}
+
+	a, err := m.acquire(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer m.release(a)
+
+	return f(ctx, &a.AccountClient)
+}
+
+// acquire 获取账号的客户端，必要时启动新的客户端并等待连接完成
+func (m *ClientManager) acquire(ctx context.Context, id int64) (*accountClient, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrClientManagerClosed
+	}
+
+	a, ok := m.accounts[id]
+	if !ok {
+		var err error
+		if a, err = m.start(id); err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		m.accounts[id] = a
+	}
+	a.refs++
+	m.mu.Unlock()
+
+	select {
+	case <-a.ready:
+	case <-ctx.Done():
+		m.release(a)
+		return nil, ctx.Err()
+	}
+
+	if a.err != nil {
+		m.release(a)
+		return nil, a.err
+	}
+	return a, nil
+}
+
+// release 归还客户端，已过期的客户端在没有使用方后关闭
+func (m *ClientManager) release(a *accountClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a.refs--
+	if a.refs == 0 && a.stale {
+		a.cancel()
+	}
+}
+
+// start 创建客户端并在后台运行，调用方需持有锁
+func (m *ClientManager) start(id int64) (*accountClient, error) {
+	ns, err := m.kvd.Open(fmt.Sprintf("user_%d", id))
+	if err != nil {
+		return nil, errors.Wrap(err, "open storage")
+	}
+
+	var updates telegram.UpdateHandler
+	if m.updates != nil {
+		updates = m.updates(id)
+	}
+
+	config := m.config()
+	client, err := tclient.New(m.ctx, tclient.Options{
+		KV:               ns,
+		Proxy:            config.Proxy,
+		ReconnectTimeout: config.ReconnectTimeout,
+		UpdateHandler:    updates,
+	}, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "create telegram client")
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	a := &accountClient{
+		AccountClient: AccountClient{
+			ID:     id,
+			Client: client,
+			KV:     ns,
+		},
+		config: config,
+		cancel: cancel,
+		ready:  make(chan struct{}),
+	}
+
+	log := logctx.From(m.ctx).With(zap.Int64("account", id))
+	log.Info("Starting Telegram client",
+		zap.String("proxy", config.Proxy),
+		zap.Duration("reconnect_timeout", config.ReconnectTimeout),
+		zap.Int64("pool_size", config.PoolSize))
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		started := false
+		err := tclientcore.RunWithAuth(ctx, client, func(ctx context.Context) error {
+			middlewares := tclientcore.NewDefaultMiddlewares(ctx, config.ReconnectTimeout)
+			if m.observers != nil {
+				middlewares = tclientcore.NewObservedMiddlewares(ctx, config.ReconnectTimeout, m.observers(id))
+			}
+			a.pool = newTrackedPool(client, config.PoolSize, dcpool.NewPool(client, config.PoolSize, middlewares...))
+			a.Pool = a.pool
+			started = true
+			close(a.ready)
+
+			<-ctx.Done()
+			return a.Pool.Close()
+		})
+		if !started {
+			a.err = errors.Wrap(err, "start telegram client")
+			close(a.ready)
+		}
+
+		// 客户端退出后从管理器中移除，下次调用时重新连接
+		m.mu.Lock()
+		if m.accounts[id] == a {
+			delete(m.accounts, id)
+		}
+		m.mu.Unlock()
+		cancel()
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Warn("Telegram client stopped", zap.Error(err))
+			return
+		}
+		log.Info("Telegram client stopped")
+	}()
+
+	return a, nil
+}
+
+// Stop 关闭账号的客户端，正在使用的调用结束后生效，用于账号登出或重新登录
+func (m *ClientManager) Stop(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.accounts[id]; ok {
+		m.expire(a)
+	}
+}
+
+// Reconfigure 配置变化后关闭使用旧配置的客户端，新的调用使用新配置重新连接
+func (m *ClientManager) Reconfigure() {
+	config := m.config()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.accounts {
+		if a.config != config {
+			m.expire(a)
+		}
+	}
+}
+
+// expire 将客户端标记为过期并从管理器中移除，调用方需持有锁
+func (m *ClientManager) expire(a *accountClient) {
+	delete(m.accounts, a.ID)
+	a.stale = true
+	if a.refs == 0 {
+		a.cancel()
+	}
+}
+
+// Collect 写出运行中的客户端数和每个账号各DC的连接池大小
+func (m *ClientManager) Collect(_ context.Context, w *MetricsWriter) {
+	m.mu.Lock()
+	pools := make(map[int64]*trackedPool, len(m.accounts))
+	for id, a := range m.accounts {
+		select {
+		case <-a.ready:
+			if a.err == nil {
+				pools[id] = a.pool
+			}
+		default: // 连接中
+		}
+	}
+	m.mu.Unlock()
+
+	ids := make([]int64, 0, len(pools))
+	for id := range pools {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	w.Family("tdl_clients", "gauge", "Connected Telegram clients.")
+	w.Sample("tdl_clients", float64(len(pools)))
+	w.Family("tdl_dcpool_pool_size", "gauge", "Configured connection pool size of each DC used by an account, 0 means unlimited.")
+	for _, id := range ids {
+		sizes := pools[id].sizes()
+		dcs := make([]int, 0, len(sizes))
+		for dc := range sizes {
+			dcs = append(dcs, dc)
+		}
+		sort.Ints(dcs)
+		for _, dc := range dcs {
+			w.Sample("tdl_dcpool_pool_size", float64(sizes[dc]),
+				"account", strconv.FormatInt(id, 10), "dc", strconv.Itoa(dc))
+		}
+	}
+}
+
+// Close 关闭所有客户端并等待退出，正在执行的调用会失败
+func (m *ClientManager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	m.cancel()
+	m.wg.Wait()
+}
+
+// trackedPool 记录账号已经打开连接的DC，dcpool.Pool本身不暴露这些信息
+type trackedPool struct {
+	dcpool.Pool
+	client *telegram.Client
+	size   int64
+
+	mu  sync.Mutex
+	dcs map[int]struct{}
+}
+
+func newTrackedPool(client *telegram.Client, size int64, pool dcpool.Pool) *trackedPool {
+	return &trackedPool{
+		Pool:   pool,
+		client: client,
+		size:   size,
+		dcs:    make(map[int]struct{}),
+	}
+}
+
+func (p *trackedPool) Client(ctx context.Context, dc int) *tg.Client {
+	p.use(dc)
+	return p.Pool.Client(ctx, dc)
+}
+
+func (p *trackedPool) Takeout(ctx context.Context, dc int) *tg.Client {
+	p.use(dc)
+	return p.Pool.Takeout(ctx, dc)
+}
+
+func (p *trackedPool) Default(ctx context.Context) *tg.Client {
+	p.use(p.client.Config().ThisDC)
+	return p.Pool.Default(ctx)
+}
+
+func (p *trackedPool) use(dc int) {
+	p.mu.Lock()
+	p.dcs[dc] = struct{}{}
+	p.mu.Unlock()
+}
+
+// sizes 返回已使用的每个DC配置的连接池大小，0表示不限制，不是实际连接数
+func (p *trackedPool) sizes() map[int]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sizes := make(map[int]int64, len(p.dcs))
+	for dc := range p.dcs {
+		sizes[dc] = p.size
+	}
+	return sizes
+}
@@ -0,0 +1,413 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/app/chat"
+	"github.com/iyear/tdl/core/logctx"
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+const (
+	// DialogNamespace 对话列表缓存使用的kv命名空间
+	DialogNamespace = "dialogs"
+	// DefaultDialogTTL 对话列表缓存的有效期，客户端运行期间由更新增量维护
+	DefaultDialogTTL = 30 * time.Minute
+
+	dialogKeyPrefix = "account:"
+	// dialogFlushDelay 增量更新写回kv的延迟，合并短时间内的多条消息
+	dialogFlushDelay = 30 * time.Second
+)
+
+// 对话的peer类型，与tg.PeerClass对应
+const (
+	DialogKindUser    = "user"
+	DialogKindChat    = "chat"
+	DialogKindChannel = "channel"
+)
+
+// DialogSort 对话列表的排序字段
+type DialogSort string
+
+const (
+	DialogSortDate DialogSort = "date" // 最后一条消息的时间
+	DialogSortType DialogSort = "type"
+	DialogSortName DialogSort = "name"
+)
+
+// CachedDialog 缓存的对话，在chat.Dialog的基础上记录排序和增量更新需要的信息
+type CachedDialog struct {
+	chat.Dialog
+
+	Kind            string `json:"kind"`              // user, chat 或 channel，与ID一起唯一确定对话
+	TopMessage      int    `json:"top_message"`       // 最后一条消息的ID
+	LastMessageDate int    `json:"last_message_date"` // 最后一条消息的时间，Unix时间戳
+}
+
+// DialogList 账号的对话列表缓存
+type DialogList struct {
+	Dialogs   []*CachedDialog `json:"dialogs"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// Stale 收到了无法增量处理的更新（如新对话），下次读取时重新获取
+	Stale bool `json:"stale,omitempty"`
+}
+
+// fresh 缓存是否可以直接使用
+func (l *DialogList) fresh(ttl time.Duration) bool {
+	return !l.Stale && time.Since(l.UpdatedAt) < ttl
+}
+
+// clone 复制列表，返回给调用方后增量更新不会影响正在使用的结果
+func (l *DialogList) clone() *DialogList {
+	c := *l
+	c.Dialogs = make([]*CachedDialog, len(l.Dialogs))
+	for i, d := range l.Dialogs {
+		dd := *d
+		c.Dialogs[i] = &dd
+	}
+	return &c
+}
+
+// apply 应用一条更新，返回列表是否变化
+func (l *DialogList) apply(ev dialogEvent) bool {
+	if ev.reset {
+		l.Stale = true
+		return true
+	}
+
+	for _, d := range l.Dialogs {
+		if d.Kind != ev.kind || d.ID != ev.id {
+			continue
+		}
+		if ev.date < d.LastMessageDate || (ev.date == d.LastMessageDate && ev.msg <= d.TopMessage) {
+			return false // 重复或乱序的更新
+		}
+		d.LastMessageDate, d.TopMessage = ev.date, ev.msg
+		return true
+	}
+
+	// 新对话的名称等信息不在更新中，重新获取整个列表
+	l.Stale = true
+	return true
+}
+
+// DialogCache 按账号缓存对话列表，持久化在kv中，服务重启后仍然有效
+//
+// 缓存在TTL过期、显式刷新或收到无法增量处理的更新后重新获取，
+// 账号的长连接客户端运行期间，新消息会直接更新对应对话的最后消息时间
+type DialogCache struct {
+	ctx context.Context
+	kvd storage.Storage
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[int64]*dialogEntry
+}
+
+type dialogEntry struct {
+	load    sync.Mutex  // 串行化同一账号的获取，并发的请求复用同一次结果
+	list    *DialogList // 已从kv加载或获取的列表，nil表示尚未加载
+	pending bool        // 增量更新尚未写回kv
+}
+
+// NewDialogCache 创建对话列表缓存
+func NewDialogCache(ctx context.Context, kvd kv.Storage, ttl time.Duration) (*DialogCache, error) {
+	ns, err := kvd.Open(DialogNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "open dialogs namespace")
+	}
+
+	return &DialogCache{
+		ctx:     ctx,
+		kvd:     ns,
+		ttl:     ttl,
+		entries: make(map[int64]*dialogEntry),
+	}, nil
+}
+
+// Load 返回账号的对话列表，缓存不可用或refresh为true时调用fetch重新获取
+//
+// 同一账号的获取是串行的，等待期间其他请求已经刷新时直接使用其结果
+func (c *DialogCache) Load(ctx context.Context, accountID int64, refresh bool, fetch func(ctx context.Context) ([]*CachedDialog, error)) (*DialogList, error) {
+	requested := time.Now()
+
+	e := c.entry(accountID)
+	e.load.Lock()
+	defer e.load.Unlock()
+
+	list, err := c.get(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if list != nil {
+		c.mu.Lock()
+		usable := list.fresh(c.ttl) && (!refresh || list.UpdatedAt.After(requested))
+		if usable {
+			list = list.clone()
+		}
+		c.mu.Unlock()
+		if usable {
+			return list, nil
+		}
+	}
+
+	dialogs, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list = &DialogList{Dialogs: dialogs, UpdatedAt: time.Now()}
+	c.mu.Lock()
+	e.list = list
+	e.pending = false
+	data, err := json.Marshal(list)
+	list = list.clone()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal dialogs")
+	}
+
+	if err = c.kvd.Set(ctx, dialogKey(accountID), data); err != nil {
+		return nil, errors.Wrap(err, "save dialogs")
+	}
+	return list, nil
+}
+
+// Invalidate 使账号的缓存失效，如账号登出后
+func (c *DialogCache) Invalidate(ctx context.Context, accountID int64) error {
+	c.mu.Lock()
+	delete(c.entries, accountID)
+	c.mu.Unlock()
+
+	if err := c.kvd.Delete(ctx, dialogKey(accountID)); err != nil && !kv.IsNotFound(err) {
+		return errors.Wrap(err, "delete dialogs")
+	}
+	return nil
+}
+
+// UpdateHandler 返回账号客户端使用的更新处理器，将新消息应用到缓存
+func (c *DialogCache) UpdateHandler(accountID int64) telegram.UpdateHandler {
+	return telegram.UpdateHandlerFunc(func(ctx context.Context, u tg.UpdatesClass) error {
+		if events := dialogEvents(u); len(events) > 0 {
+			c.apply(ctx, accountID, events)
+		}
+		return nil
+	})
+}
+
+// apply 增量更新缓存，列表变化后延迟写回kv
+func (c *DialogCache) apply(ctx context.Context, accountID int64, events []dialogEvent) {
+	list, err := c.get(ctx, accountID)
+	if err != nil || list == nil {
+		return // 没有缓存时不需要更新，下次读取时获取
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entries[accountID]
+	if e == nil || e.list != list {
+		return // 更新期间缓存已被替换或删除
+	}
+
+	changed := false
+	for _, ev := range events {
+		if list.apply(ev) {
+			changed = true
+		}
+	}
+	if !changed || e.pending {
+		return
+	}
+
+	e.pending = true
+	time.AfterFunc(dialogFlushDelay, func() { c.flush(accountID, list) })
+}
+
+// flush 将增量更新后的列表写回kv
+func (c *DialogCache) flush(accountID int64, list *DialogList) {
+	c.mu.Lock()
+	e := c.entries[accountID]
+	if e == nil || e.list != list || !e.pending {
+		c.mu.Unlock()
+		return
+	}
+	e.pending = false
+	data, err := json.Marshal(list)
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err = c.kvd.Set(c.ctx, dialogKey(accountID), data); err != nil {
+		logctx.From(c.ctx).Warn("Failed to save dialogs",
+			zap.Int64("account", accountID),
+			zap.Error(err))
+	}
+}
+
+// entry 获取账号的缓存条目，不存在时创建
+func (c *DialogCache) entry(accountID int64) *dialogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[accountID]
+	if !ok {
+		e = &dialogEntry{}
+		c.entries[accountID] = e
+	}
+	return e
+}
+
+// get 返回内存中的列表，首次访问时从kv加载，不存在时返回nil
+func (c *DialogCache) get(ctx context.Context, accountID int64) (*DialogList, error) {
+	e := c.entry(accountID)
+
+	c.mu.Lock()
+	list := e.list
+	c.mu.Unlock()
+	if list != nil {
+		return list, nil
+	}
+
+	data, err := c.kvd.Get(ctx, dialogKey(accountID))
+	if err != nil {
+		if kv.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get dialogs")
+	}
+
+	var loaded DialogList
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		return nil, nil // 格式不兼容时重新获取
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.list == nil {
+		e.list = &loaded
+	}
+	return e.list, nil
+}
+
+func dialogKey(accountID int64) string {
+	return fmt.Sprintf("%s%d", dialogKeyPrefix, accountID)
+}
+
+// SortDialogs 原地排序对话列表，相同时按ID排序以保证分页稳定
+func SortDialogs(dialogs []*CachedDialog, by DialogSort, desc bool) error {
+	var cmp func(a, b *CachedDialog) int
+	switch by {
+	case DialogSortDate:
+		cmp = func(a, b *CachedDialog) int { return a.LastMessageDate - b.LastMessageDate }
+	case DialogSortType:
+		cmp = func(a, b *CachedDialog) int {
+			if r := strings.Compare(a.Type, b.Type); r != 0 {
+				return r
+			}
+			return strings.Compare(strings.ToLower(a.VisibleName), strings.ToLower(b.VisibleName))
+		}
+	case DialogSortName:
+		cmp = func(a, b *CachedDialog) int {
+			return strings.Compare(strings.ToLower(a.VisibleName), strings.ToLower(b.VisibleName))
+		}
+	default:
+		return errors.Errorf("unknown sort field %q", by)
+	}
+
+	sort.SliceStable(dialogs, func(i, j int) bool {
+		r := cmp(dialogs[i], dialogs[j])
+		if r == 0 {
+			if dialogs[i].ID == dialogs[j].ID {
+				return false
+			}
+			r = -1
+			if dialogs[i].ID > dialogs[j].ID {
+				r = 1
+			}
+		}
+		if desc {
+			return r > 0
+		}
+		return r < 0
+	})
+	return nil
+}
+
+// dialogEvent 从更新中提取的对话变化
+type dialogEvent struct {
+	kind  string
+	id    int64
+	msg   int  // 新消息的ID
+	date  int  // 新消息的时间
+	reset bool // 无法增量处理，需要重新获取列表
+}
+
+// dialogEvents 提取更新中影响对话列表的部分
+func dialogEvents(u tg.UpdatesClass) []dialogEvent {
+	switch u := u.(type) {
+	case *tg.UpdateShortMessage:
+		return []dialogEvent{{kind: DialogKindUser, id: u.UserID, msg: u.ID, date: u.Date}}
+	case *tg.UpdateShortChatMessage:
+		return []dialogEvent{{kind: DialogKindChat, id: u.ChatID, msg: u.ID, date: u.Date}}
+	case *tg.UpdateShort:
+		return dialogUpdateEvents(nil, u.Update)
+	case *tg.Updates:
+		return dialogUpdateEvents(nil, u.Updates...)
+	case *tg.UpdatesCombined:
+		return dialogUpdateEvents(nil, u.Updates...)
+	case *tg.UpdatesTooLong:
+		// 丢失了部分更新
+		return []dialogEvent{{reset: true}}
+	}
+	return nil
+}
+
+func dialogUpdateEvents(events []dialogEvent, updates ...tg.UpdateClass) []dialogEvent {
+	for _, update := range updates {
+		var msg tg.MessageClass
+		switch u := update.(type) {
+		case *tg.UpdateNewMessage:
+			msg = u.Message
+		case *tg.UpdateNewChannelMessage:
+			msg = u.Message
+		case *tg.UpdateChannel:
+			// 加入、退出频道或频道信息变化
+			events = append(events, dialogEvent{reset: true})
+			continue
+		default:
+			continue
+		}
+
+		m, ok := msg.AsNotEmpty()
+		if !ok {
+			continue
+		}
+		ev := dialogEvent{msg: m.GetID(), date: m.GetDate()}
+		switch p := m.GetPeerID().(type) {
+		case *tg.PeerUser:
+			ev.kind, ev.id = DialogKindUser, p.UserID
+		case *tg.PeerChat:
+			ev.kind, ev.id = DialogKindChat, p.ChatID
+		case *tg.PeerChannel:
+			ev.kind, ev.id = DialogKindChannel, p.ChannelID
+		default:
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/app/chat"
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+func newCachedDialog(kind string, id int64, typ, name string, date int) *CachedDialog {
+	return &CachedDialog{
+		Dialog:          chat.Dialog{ID: id, Type: typ, VisibleName: name},
+		Kind:            kind,
+		TopMessage:      date,
+		LastMessageDate: date,
+	}
+}
+
+func TestDialogCache(t *testing.T) {
+	ctx := context.Background()
+
+	kvd, err := kv.New(kv.DriverBolt, map[string]any{"path": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, kvd.Close()) })
+
+	cache, err := NewDialogCache(ctx, kvd, time.Hour)
+	require.NoError(t, err)
+
+	fetched := 0
+	fetch := func(ctx context.Context) ([]*CachedDialog, error) {
+		fetched++
+		return []*CachedDialog{
+			newCachedDialog(DialogKindUser, 1, "private", "Alice", 100),
+			newCachedDialog(DialogKindChannel, 1, "channel", "News", 200),
+		}, nil
+	}
+
+	list, err := cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	require.Len(t, list.Dialogs, 2)
+
+	_, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetched)
+
+	// new message in a known dialog, matched by kind and id
+	cache.apply(ctx, 1, []dialogEvent{{kind: DialogKindUser, id: 1, msg: 150, date: 300}})
+	list, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetched)
+	assert.Equal(t, 300, list.Dialogs[0].LastMessageDate)
+	assert.Equal(t, 150, list.Dialogs[0].TopMessage)
+	assert.Equal(t, 200, list.Dialogs[1].LastMessageDate)
+
+	// results are copies
+	list.Dialogs[0].LastMessageDate = 0
+	list, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 300, list.Dialogs[0].LastMessageDate)
+
+	// message from an unknown dialog makes the cache stale
+	cache.apply(ctx, 1, []dialogEvent{{kind: DialogKindChat, id: 2, msg: 1, date: 400}})
+	_, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetched)
+
+	// explicit refresh
+	_, err = cache.Load(ctx, 1, true, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, fetched)
+
+	// reload from kv, as after a server restart
+	cache, err = NewDialogCache(ctx, kvd, time.Hour)
+	require.NoError(t, err)
+	list, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, fetched)
+	assert.Len(t, list.Dialogs, 2)
+
+	// expired
+	cache, err = NewDialogCache(ctx, kvd, 0)
+	require.NoError(t, err)
+	_, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 4, fetched)
+
+	require.NoError(t, cache.Invalidate(ctx, 1))
+	cache, err = NewDialogCache(ctx, kvd, time.Hour)
+	require.NoError(t, err)
+	_, err = cache.Load(ctx, 1, false, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 5, fetched)
+}
+
+func TestSortDialogs(t *testing.T) {
+	dialogs := func() []*CachedDialog {
+		return []*CachedDialog{
+			newCachedDialog(DialogKindUser, 3, "private", "bob", 100),
+			newCachedDialog(DialogKindChannel, 1, "channel", "Alice", 300),
+			newCachedDialog(DialogKindChat, 2, "group", "carol", 200),
+			newCachedDialog(DialogKindUser, 4, "private", "Bob", 200),
+		}
+	}
+	ids := func(dialogs []*CachedDialog) []int64 {
+		r := make([]int64, 0, len(dialogs))
+		for _, d := range dialogs {
+			r = append(r, d.ID)
+		}
+		return r
+	}
+
+	tests := []struct {
+		by   DialogSort
+		desc bool
+		want []int64
+	}{
+		{DialogSortDate, true, []int64{1, 4, 2, 3}},
+		{DialogSortDate, false, []int64{3, 2, 4, 1}},
+		{DialogSortName, false, []int64{1, 3, 4, 2}},
+		{DialogSortType, false, []int64{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		d := dialogs()
+		require.NoError(t, SortDialogs(d, tt.by, tt.desc))
+		assert.Equal(t, tt.want, ids(d), "%s desc=%v", tt.by, tt.desc)
+	}
+
+	assert.Error(t, SortDialogs(dialogs(), "unknown", false))
+}
+
+func TestDialogEvents(t *testing.T) {
+	events := dialogEvents(&tg.Updates{Updates: []tg.UpdateClass{
+		&tg.UpdateNewMessage{Message: &tg.Message{ID: 10, Date: 100, PeerID: &tg.PeerUser{UserID: 1}}},
+		&tg.UpdateNewChannelMessage{Message: &tg.Message{ID: 20, Date: 200, PeerID: &tg.PeerChannel{ChannelID: 2}}},
+		&tg.UpdateNewMessage{Message: &tg.MessageEmpty{ID: 30}},
+		&tg.UpdateUserTyping{UserID: 1},
+	}})
+	assert.Equal(t, []dialogEvent{
+		{kind: DialogKindUser, id: 1, msg: 10, date: 100},
+		{kind: DialogKindChannel, id: 2, msg: 20, date: 200},
+	}, events)
+
+	assert.Equal(t, []dialogEvent{{kind: DialogKindChat, id: 3, msg: 5, date: 50}},
+		dialogEvents(&tg.UpdateShortChatMessage{ChatID: 3, ID: 5, Date: 50}))
+	assert.Equal(t, []dialogEvent{{reset: true}},
+		dialogEvents(&tg.UpdateShort{Update: &tg.UpdateChannel{ChannelID: 2}}))
+	assert.Equal(t, []dialogEvent{{reset: true}}, dialogEvents(&tg.UpdatesTooLong{}))
+	assert.Empty(t, dialogEvents(&tg.UpdateShortSentMessage{ID: 1}))
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/pkg/key"
+	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/pkg/tclient"
+)
+
+var (
+	// ErrNamespaceLocked 命名空间正在被其他进程（如正在运行的CLI命令）使用
+	ErrNamespaceLocked = errors.New("namespace is in use by another process")
+	// ErrNamespaceNotLoggedIn 命名空间中没有已登录的会话
+	ErrNamespaceNotLoggedIn = errors.New("namespace is not logged in")
+)
+
+// StartupClient 服务启动时导入CLI命名空间使用的客户端标识，不对应任何浏览器
+const StartupClient = "startup"
+
+// StorageOpener 按需打开存储，调用方使用后负责关闭，以便及时释放bolt的文件锁
+type StorageOpener func() (kv.Storage, error)
+
+// webNamespaces Web服务自身使用的命名空间，共享CLI存储时不作为CLI命名空间列出
+var webNamespaces = map[string]bool{
+	OperatorNamespace: true,
+	TaskNamespace:     true,
+	"settings":        true,
+	"client_mapping":  true,
+}
+
+// IsWebNamespace 判断命名空间是否由Web服务创建
+func IsWebNamespace(ns string) bool {
+	return webNamespaces[ns] || strings.HasPrefix(ns, "user_") || strings.HasPrefix(ns, "session_")
+}
+
+// ListCLINamespaces 列出CLI存储中的命名空间，shared为true时排除Web服务自身的命名空间
+//
+// 只遍历文件，不打开命名空间，不会与正在运行的CLI命令争用文件锁
+func ListCLINamespaces(open StorageOpener, shared bool) ([]string, error) {
+	src, err := open()
+	if err != nil {
+		return nil, errors.Wrap(err, "open cli storage")
+	}
+	defer src.Close()
+
+	namespaces, err := src.Namespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "list namespaces")
+	}
+
+	result := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if shared && IsWebNamespace(ns) {
+			continue
+		}
+		result = append(result, ns)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// ImportNamespace 将CLI命名空间中已登录的会话导入为Web账号，导入的账号成为客户端的当前账号
+//
+// 会话被复制到Web账号自己的命名空间，之后CLI和Web可以同时使用各自的命名空间，
+// 不会因为bolt的单进程文件锁而互相阻塞。源存储只在读取会话期间打开
+func (s *AuthService) ImportNamespace(ctx context.Context, clientID string, open StorageOpener, namespace, proxyURL string) (*UserInfo, error) {
+	if IsWebNamespace(namespace) {
+		return nil, errors.Errorf("namespace %q belongs to the web server", namespace)
+	}
+
+	s.mu.RLock()
+	pending := s.pendingLogin(clientID)
+	s.mu.RUnlock()
+	if pending != nil {
+		return nil, errors.New("another login is in progress")
+	}
+
+	sessionData, app, err := readCLISession(ctx, open, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// 复制到登录使用的临时命名空间，连接Telegram获取账号信息后再保存为账号
+	staging, err := s.kvStore.Open(fmt.Sprintf("session_%s", clientID))
+	if err != nil {
+		return nil, errors.Wrap(err, "open session storage")
+	}
+	if err = staging.Set(ctx, "session", sessionData); err != nil {
+		return nil, errors.Wrap(err, "copy session")
+	}
+	if err = staging.Set(ctx, key.App(), app); err != nil {
+		return nil, errors.Wrap(err, "copy app")
+	}
+
+	ctx = kv.With(ctx, s.kvStore)
+	client, err := tclient.New(ctx, tclient.Options{
+		KV:    staging,
+		Proxy: proxyURL,
+	}, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "create client")
+	}
+
+	var userInfo *UserInfo
+	if err = client.Run(ctx, func(ctx context.Context) error {
+		userInfo, err = s.saveAccount(ctx, clientID, client, namespace)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "import session")
+	}
+
+	return userInfo, nil
+}
+
+// readCLISession 读取CLI命名空间的会话和应用类型，读取后立即关闭源存储
+func readCLISession(ctx context.Context, open StorageOpener, namespace string) (session, app []byte, _ error) {
+	src, err := open()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open cli storage")
+	}
+	defer src.Close()
+
+	ns, err := src.Open(namespace)
+	if err != nil {
+		if kv.IsLocked(err) {
+			return nil, nil, errors.Wrapf(ErrNamespaceLocked, "namespace %q", namespace)
+		}
+		return nil, nil, errors.Wrap(err, "open namespace")
+	}
+
+	session, err = ns.Get(ctx, "session")
+	if err != nil && !kv.IsNotFound(err) {
+		return nil, nil, errors.Wrap(err, "get session")
+	}
+	if len(session) == 0 {
+		return nil, nil, errors.Wrapf(ErrNamespaceNotLoggedIn, "namespace %q", namespace)
+	}
+
+	app, err = ns.Get(ctx, key.App())
+	if err != nil {
+		if !kv.IsNotFound(err) {
+			return nil, nil, errors.Wrap(err, "get app")
+		}
+		app = []byte(tclient.AppBuiltin) // 与tclient.GetApp的默认值一致
+	}
+
+	// bolt返回的数据只在数据库打开期间有效，关闭源存储前复制
+	return append([]byte(nil), session...), append([]byte(nil), app...), nil
+}
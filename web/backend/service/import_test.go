@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/key"
+	"github.com/iyear/tdl/pkg/kv"
+	"github.com/iyear/tdl/pkg/tclient"
+)
+
+func TestReadCLISession(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	open := func() (kv.Storage, error) {
+		return kv.New(kv.DriverBolt, map[string]any{"path": dir})
+	}
+
+	cli, err := open()
+	require.NoError(t, err)
+	for _, ns := range []string{"default", "work", "operator", "user_1"} {
+		_, err := cli.Open(ns)
+		require.NoError(t, err)
+	}
+	work, err := cli.Open("work")
+	require.NoError(t, err)
+	require.NoError(t, work.Set(ctx, "session", []byte("data")))
+
+	namespaces, err := ListCLINamespaces(open, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "work"}, namespaces)
+
+	// CLI进程仍然持有命名空间
+	_, _, err = readCLISession(ctx, open, "work")
+	assert.ErrorIs(t, err, ErrNamespaceLocked)
+	require.NoError(t, cli.Close())
+
+	session, app, err := readCLISession(ctx, open, "work")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), session)
+	assert.Equal(t, []byte(tclient.AppBuiltin), app)
+
+	_, _, err = readCLISession(ctx, open, "default")
+	assert.ErrorIs(t, err, ErrNamespaceNotLoggedIn)
+
+	// 读取后源存储已关闭，CLI可以再次打开
+	cli, err = open()
+	require.NoError(t, err)
+	work, err = cli.Open("work")
+	require.NoError(t, err)
+	_, err = work.Get(ctx, key.App())
+	assert.True(t, kv.IsNotFound(err))
+	assert.NoError(t, cli.Close())
+}
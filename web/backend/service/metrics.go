@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iyear/tdl/core/middlewares/observer"
+	"github.com/iyear/tdl/pkg/ps"
+)
+
+// 传输方向，用于按账号统计传输的字节数
+const (
+	TransferDownload = "download"
+	TransferUpload   = "upload"
+)
+
+// 任务的结束状态，暂停的任务计为取消
+const (
+	TaskOutcomeCompleted = "completed"
+	TaskOutcomeFailed    = "failed"
+	TaskOutcomeCancelled = "cancelled"
+)
+
+// Collector 在抓取时写入当前值的指标，如任务队列长度和连接数
+type Collector func(ctx context.Context, w *MetricsWriter)
+
+// Metrics 服务运行期间累计的计数器，以Prometheus文本格式导出
+//
+// 传输字节数来自下载和上传的进度回调，重试和FLOOD_WAIT来自账号客户端的中间件，
+// 任务结果来自调度器。计数器不持久化，服务重启后从0开始
+type Metrics struct {
+	mu         sync.Mutex
+	transfers  map[transferKey]int64
+	outcomes   map[outcomeKey]int64
+	floodWaits map[int64]*floodWaitStat // account -> 统计
+	retries    map[int64]int64          // account -> 次数
+	collectors []Collector
+}
+
+type transferKey struct {
+	account   int64
+	direction string
+}
+
+type outcomeKey struct {
+	typ     string
+	outcome string
+}
+
+type floodWaitStat struct {
+	count   int64
+	seconds float64
+}
+
+// NewMetrics 创建指标注册表
+func NewMetrics() *Metrics {
+	return &Metrics{
+		transfers:  make(map[transferKey]int64),
+		outcomes:   make(map[outcomeKey]int64),
+		floodWaits: make(map[int64]*floodWaitStat),
+		retries:    make(map[int64]int64),
+	}
+}
+
+// Register 添加抓取时调用的采集函数，按注册顺序输出
+func (m *Metrics) Register(collectors ...Collector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, collectors...)
+}
+
+// AddTransfer 累加账号传输的字节数
+func (m *Metrics) AddTransfer(accountID int64, direction string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transfers[transferKey{account: accountID, direction: direction}] += n
+}
+
+// TaskFinished 记录任务的结束状态
+func (m *Metrics) TaskFinished(jobType, outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes[outcomeKey{typ: jobType, outcome: outcome}]++
+}
+
+// Observer 返回账号客户端中间件使用的观察者，记录重试和FLOOD_WAIT
+func (m *Metrics) Observer(accountID int64) observer.Observer {
+	return accountObserver{m: m, account: accountID}
+}
+
+type accountObserver struct {
+	m       *Metrics
+	account int64
+}
+
+func (o accountObserver) OnRetry(_ context.Context, _ error) {
+	o.m.mu.Lock()
+	defer o.m.mu.Unlock()
+	o.m.retries[o.account]++
+}
+
+func (o accountObserver) OnFloodWait(_ context.Context, d time.Duration) {
+	o.m.mu.Lock()
+	defer o.m.mu.Unlock()
+
+	stat, ok := o.m.floodWaits[o.account]
+	if !ok {
+		stat = &floodWaitStat{}
+		o.m.floodWaits[o.account] = stat
+	}
+	stat.count++
+	stat.seconds += d.Seconds()
+}
+
+// Write 以Prometheus文本格式写出所有指标
+func (m *Metrics) Write(ctx context.Context, w io.Writer) error {
+	mw := &MetricsWriter{}
+
+	m.mu.Lock()
+	mw.Family("tdl_transfer_bytes_total", "counter", "Bytes transferred by download and upload tasks.")
+	for _, k := range sortedKeys(m.transfers, func(a, b transferKey) bool {
+		if a.account != b.account {
+			return a.account < b.account
+		}
+		return a.direction < b.direction
+	}) {
+		mw.Sample("tdl_transfer_bytes_total", float64(m.transfers[k]),
+			"account", strconv.FormatInt(k.account, 10), "direction", k.direction)
+	}
+
+	mw.Family("tdl_tasks_finished_total", "counter", "Finished tasks by type and outcome.")
+	for _, k := range sortedKeys(m.outcomes, func(a, b outcomeKey) bool {
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		return a.outcome < b.outcome
+	}) {
+		mw.Sample("tdl_tasks_finished_total", float64(m.outcomes[k]), "type", k.typ, "outcome", k.outcome)
+	}
+
+	accounts := sortedKeys(m.floodWaits, func(a, b int64) bool { return a < b })
+	mw.Family("tdl_flood_waits_total", "counter", "FLOOD_WAIT errors returned by Telegram.")
+	for _, id := range accounts {
+		mw.Sample("tdl_flood_waits_total", float64(m.floodWaits[id].count), "account", strconv.FormatInt(id, 10))
+	}
+	mw.Family("tdl_flood_wait_seconds_total", "counter", "Seconds waited because of FLOOD_WAIT errors.")
+	for _, id := range accounts {
+		mw.Sample("tdl_flood_wait_seconds_total", m.floodWaits[id].seconds, "account", strconv.FormatInt(id, 10))
+	}
+
+	mw.Family("tdl_retries_total", "counter", "Requests retried by the retry middleware.")
+	for _, id := range sortedKeys(m.retries, func(a, b int64) bool { return a < b }) {
+		mw.Sample("tdl_retries_total", float64(m.retries[id]), "account", strconv.FormatInt(id, 10))
+	}
+
+	collectors := make([]Collector, len(m.collectors))
+	copy(collectors, m.collectors)
+	m.mu.Unlock()
+
+	// 采集函数可能需要获取其他组件的锁，不持有m.mu
+	for _, c := range collectors {
+		c(ctx, mw)
+	}
+
+	_, err := io.WriteString(w, mw.b.String())
+	return err
+}
+
+// ProcessStats 当前进程的资源占用
+type ProcessStats struct {
+	CPUPercent float64 `json:"cpu_percent"` // 距上次读取期间的CPU占用
+	Memory     uint64  `json:"memory"`      // 常驻内存字节数
+	Goroutines int     `json:"goroutines"`
+}
+
+// ReadProcessStats 读取进程的资源占用，读取失败的项为0
+func ReadProcessStats(ctx context.Context) ProcessStats {
+	stats := ProcessStats{Goroutines: ps.GetGoroutineNum()}
+	if cpu, err := ps.GetSelfCPU(ctx); err == nil {
+		stats.CPUPercent = cpu
+	}
+	if mem, err := ps.GetSelfMem(ctx); err == nil {
+		stats.Memory = mem.RSS
+	}
+	return stats
+}
+
+// CollectProcess 写出进程的资源占用
+func CollectProcess(ctx context.Context, w *MetricsWriter) {
+	stats := ReadProcessStats(ctx)
+
+	w.Family("tdl_process_cpu_percent", "gauge", "CPU usage of the process since the last scrape.")
+	w.Sample("tdl_process_cpu_percent", stats.CPUPercent)
+	w.Family("tdl_process_resident_memory_bytes", "gauge", "Resident memory size of the process.")
+	w.Sample("tdl_process_resident_memory_bytes", float64(stats.Memory))
+	w.Family("tdl_process_goroutines", "gauge", "Number of goroutines.")
+	w.Sample("tdl_process_goroutines", float64(stats.Goroutines))
+}
+
+// MetricsWriter 生成Prometheus文本格式，同一指标的样本需要在Family之后连续写出
+type MetricsWriter struct {
+	b strings.Builder
+}
+
+// Family 写出指标的说明和类型
+func (w *MetricsWriter) Family(name, typ, help string) {
+	w.b.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	w.b.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample 写出一个样本，labels为交替的标签名和值
+func (w *MetricsWriter) Sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			w.b.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// sortedKeys 返回排序后的map键，使输出稳定
+func sortedKeys[K comparable, V any](m map[K]V, less func(a, b K) bool) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMetrics(t *testing.T, m *Metrics) string {
+	var buf bytes.Buffer
+	require.NoError(t, m.Write(context.Background(), &buf))
+	return buf.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+
+	m.AddTransfer(2, TransferUpload, 10)
+	m.AddTransfer(1, TransferDownload, 100)
+	m.AddTransfer(1, TransferDownload, 50)
+	m.AddTransfer(1, TransferUpload, 0)
+	m.TaskFinished("download", TaskOutcomeCompleted)
+	m.TaskFinished("download", TaskOutcomeCompleted)
+	m.TaskFinished("upload", TaskOutcomeFailed)
+
+	o := m.Observer(1)
+	o.OnRetry(context.Background(), errors.New("RPC_CALL_FAIL"))
+	o.OnFloodWait(context.Background(), 3*time.Second)
+	o.OnFloodWait(context.Background(), 1500*time.Millisecond)
+
+	m.Register(func(_ context.Context, w *MetricsWriter) {
+		w.Family("tdl_test", "gauge", "Test gauge.")
+		w.Sample("tdl_test", 1, "label", "a\"b\\c\nd")
+	})
+
+	assert.Equal(t, `# HELP tdl_transfer_bytes_total Bytes transferred by download and upload tasks.
+# TYPE tdl_transfer_bytes_total counter
+tdl_transfer_bytes_total{account="1",direction="download"} 150
+tdl_transfer_bytes_total{account="2",direction="upload"} 10
+# HELP tdl_tasks_finished_total Finished tasks by type and outcome.
+# TYPE tdl_tasks_finished_total counter
+tdl_tasks_finished_total{type="download",outcome="completed"} 2
+tdl_tasks_finished_total{type="upload",outcome="failed"} 1
+# HELP tdl_flood_waits_total FLOOD_WAIT errors returned by Telegram.
+# TYPE tdl_flood_waits_total counter
+tdl_flood_waits_total{account="1"} 2
+# HELP tdl_flood_wait_seconds_total Seconds waited because of FLOOD_WAIT errors.
+# TYPE tdl_flood_wait_seconds_total counter
+tdl_flood_wait_seconds_total{account="1"} 4.5
+# HELP tdl_retries_total Requests retried by the retry middleware.
+# TYPE tdl_retries_total counter
+tdl_retries_total{account="1"} 1
+# HELP tdl_test Test gauge.
+# TYPE tdl_test gauge
+tdl_test{label="a\"b\\c\nd"} 1
+`, writeMetrics(t, m))
+}
+
+func TestSchedulerMetrics(t *testing.T) {
+	m := NewMetrics()
+	s := NewScheduler(context.Background(), func() int { return 1 })
+	s.SetMetrics(m)
+	m.Register(s.Collect)
+
+	release := make(chan struct{})
+	s.Submit(Job{ID: "a", Type: "download", Run: func(ctx context.Context) error {
+		<-release
+		return nil
+	}})
+	s.Submit(Job{ID: "b", Type: "upload", Run: func(ctx context.Context) error {
+		return errors.New("failed")
+	}})
+	s.Submit(Job{ID: "c", Type: "upload", Run: func(ctx context.Context) error { return nil }})
+
+	assert.Contains(t, writeMetrics(t, m), `tdl_tasks{type="download",state="running"} 1
+tdl_tasks{type="download",state="queued"} 0
+tdl_tasks{type="upload",state="running"} 0
+tdl_tasks{type="upload",state="queued"} 2
+# HELP tdl_tasks_limit Maximum number of concurrently running tasks.
+# TYPE tdl_tasks_limit gauge
+tdl_tasks_limit 1
+`)
+
+	queued, _ := s.Cancel("c")
+	assert.True(t, queued)
+	close(release)
+	require.Eventually(t, func() bool { return len(s.List()) == 0 }, time.Second, 10*time.Millisecond)
+
+	out := writeMetrics(t, m)
+	assert.Contains(t, out, `tdl_tasks_finished_total{type="download",outcome="completed"} 1
+tdl_tasks_finished_total{type="upload",outcome="cancelled"} 1
+tdl_tasks_finished_total{type="upload",outcome="failed"} 1
+`)
+	assert.Contains(t, out, `tdl_tasks{type="upload",state="queued"} 0`)
+}
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+const (
+	// OperatorNamespace 操作员认证使用的kv命名空间
+	OperatorNamespace = "operator"
+
+	// AdminOperator 使用管理员密码登录的操作员
+	AdminOperator = "admin"
+
+	// OperatorSessionTTL 操作员会话有效期
+	OperatorSessionTTL = 7 * 24 * time.Hour
+
+	// ClientTTL 浏览器标识的有效期，重新登录操作员时沿用未过期的标识
+	ClientTTL = 365 * 24 * time.Hour
+
+	// APITokenPrefix API令牌前缀，便于在脚本和日志中识别
+	APITokenPrefix = "tdl_"
+
+	operatorPasswordKey = "password"
+	operatorTokensKey   = "tokens"
+	operatorSessionKey  = "session:"
+)
+
+var (
+	// ErrOperatorUnauthorized 操作员凭据无效或已过期
+	ErrOperatorUnauthorized = errors.New("operator unauthorized")
+	// ErrTokenNotFound API令牌不存在
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// Identity 通过认证的请求身份
+//
+// Operator是通过认证的操作员，Client标识发起请求的浏览器。登录会话、当前账号和账号的访问权限以Client为键，
+// 同一操作员在不同浏览器中互不影响；API令牌沿用创建它的浏览器的Client
+type Identity struct {
+	Operator string
+	Client   string
+}
+
+// APIToken 操作员的个人API令牌，令牌本身只在创建时返回一次
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Operator   string     `json:"operator"`
+	Client     string     `json:"client,omitempty"`
+	Hash       string     `json:"hash"` // 令牌的SHA-256
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// operatorSession 浏览器登录后的会话
+type operatorSession struct {
+	Operator  string    `json:"operator"`
+	Client    string    `json:"client,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OperatorService 管理Web服务自身的访问认证：管理员密码、会话和API令牌
+type OperatorService struct {
+	kvd storage.Storage
+	mu  sync.Mutex // 保护令牌列表的读改写
+}
+
+// NewOperatorService 创建操作员认证服务
+//
+// password非空时更新管理员密码；未配置过密码时生成随机密码，generated返回该密码以便提示用户
+func NewOperatorService(ctx context.Context, kvd kv.Storage, password string) (_ *OperatorService, generated string, _ error) {
+	ns, err := kvd.Open(OperatorNamespace)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "open operator namespace")
+	}
+	s := &OperatorService{kvd: ns}
+
+	if password == "" {
+		if _, err := ns.Get(ctx, operatorPasswordKey); err == nil {
+			return s, "", nil
+		} else if !kv.IsNotFound(err) {
+			return nil, "", errors.Wrap(err, "get password")
+		}
+
+		if password, err = randomHex(12); err != nil {
+			return nil, "", err
+		}
+		generated = password
+	}
+
+	if err := s.SetPassword(ctx, password); err != nil {
+		return nil, "", err
+	}
+	return s, generated, nil
+}
+
+// SetPassword 设置管理员密码
+func (s *OperatorService) SetPassword(ctx context.Context, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "hash password")
+	}
+
+	return s.kvd.Set(ctx, operatorPasswordKey, hash)
+}
+
+// Login 校验管理员密码并创建会话，返回会话ID和浏览器标识
+//
+// client为浏览器已有的标识，无效时生成新的标识
+func (s *OperatorService) Login(ctx context.Context, password, client string) (sessionID, _ string, _ error) {
+	hash, err := s.kvd.Get(ctx, operatorPasswordKey)
+	if err != nil {
+		return "", "", errors.Wrap(err, "get password")
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return "", "", ErrOperatorUnauthorized
+	}
+
+	if sessionID, err = randomHex(32); err != nil {
+		return "", "", err
+	}
+	if !validClient(client) {
+		if client, err = randomHex(16); err != nil {
+			return "", "", err
+		}
+	}
+
+	now := time.Now()
+	data, err := json.Marshal(operatorSession{
+		Operator:  AdminOperator,
+		Client:    client,
+		CreatedAt: now,
+		ExpiresAt: now.Add(OperatorSessionTTL),
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "marshal session")
+	}
+
+	if err := s.kvd.Set(ctx, operatorSessionKey+hashToken(sessionID), data); err != nil {
+		return "", "", errors.Wrap(err, "save session")
+	}
+	return sessionID, client, nil
+}
+
+// Logout 删除会话
+func (s *OperatorService) Logout(ctx context.Context, sessionID string) error {
+	if err := s.kvd.Delete(ctx, operatorSessionKey+hashToken(sessionID)); err != nil && !kv.IsNotFound(err) {
+		return errors.Wrap(err, "delete session")
+	}
+	return nil
+}
+
+// Session 校验会话ID，返回会话的身份
+func (s *OperatorService) Session(ctx context.Context, sessionID string) (Identity, error) {
+	if sessionID == "" {
+		return Identity{}, ErrOperatorUnauthorized
+	}
+
+	key := operatorSessionKey + hashToken(sessionID)
+	data, err := s.kvd.Get(ctx, key)
+	if err != nil {
+		if kv.IsNotFound(err) {
+			return Identity{}, ErrOperatorUnauthorized
+		}
+		return Identity{}, errors.Wrap(err, "get session")
+	}
+
+	var session operatorSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Identity{}, errors.Wrap(err, "unmarshal session")
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		_ = s.kvd.Delete(ctx, key)
+		return Identity{}, ErrOperatorUnauthorized
+	}
+
+	// 旧版本创建的会话没有浏览器标识，每个会话单独作为一个客户端
+	client := session.Client
+	if client == "" {
+		client = "session-" + hashToken(sessionID)[:16]
+	}
+	return Identity{Operator: session.Operator, Client: client}, nil
+}
+
+// Token 校验API令牌，返回令牌的身份
+func (s *OperatorService) Token(ctx context.Context, token string) (Identity, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return Identity{}, ErrOperatorUnauthorized
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.tokens(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	hash := hashToken(token)
+	for i, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+			continue
+		}
+
+		// 最近使用时间只需要分钟级精度，避免每个请求都写存储
+		now := time.Now()
+		if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > time.Minute {
+			tokens[i].LastUsedAt = &now
+			if err := s.saveTokens(ctx, tokens); err != nil {
+				return Identity{}, err
+			}
+		}
+
+		// 旧版本创建的令牌没有浏览器标识，每个令牌单独作为一个客户端
+		client := t.Client
+		if client == "" {
+			client = "token-" + t.ID
+		}
+		return Identity{Operator: t.Operator, Client: client}, nil
+	}
+
+	return Identity{}, ErrOperatorUnauthorized
+}
+
+// CreateToken 为操作员创建API令牌，返回令牌记录和明文令牌
+//
+// 令牌沿用创建者的浏览器标识，脚本可以使用该浏览器登录的账号
+func (s *OperatorService) CreateToken(ctx context.Context, creator Identity, name string) (*APIToken, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token := APITokenPrefix + secret
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.tokens(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := APIToken{
+		ID:        id,
+		Name:      name,
+		Operator:  creator.Operator,
+		Client:    creator.Client,
+		Hash:      hashToken(token),
+		CreatedAt: time.Now(),
+	}
+	if err := s.saveTokens(ctx, append(tokens, t)); err != nil {
+		return nil, "", err
+	}
+
+	return &t, token, nil
+}
+
+// ListTokens 列出操作员的API令牌，按创建时间排序
+func (s *OperatorService) ListTokens(ctx context.Context, operator string) ([]APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.tokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]APIToken, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Operator == operator {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+// RevokeToken 删除操作员的API令牌
+func (s *OperatorService) RevokeToken(ctx context.Context, operator, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.tokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, t := range tokens {
+		if t.ID == id && t.Operator == operator {
+			return s.saveTokens(ctx, append(tokens[:i], tokens[i+1:]...))
+		}
+	}
+
+	return ErrTokenNotFound
+}
+
+func (s *OperatorService) tokens(ctx context.Context) ([]APIToken, error) {
+	data, err := s.kvd.Get(ctx, operatorTokensKey)
+	if err != nil {
+		if kv.IsNotFound(err) {
+			return []APIToken{}, nil
+		}
+		return nil, errors.Wrap(err, "get tokens")
+	}
+
+	var tokens []APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tokens")
+	}
+	return tokens, nil
+}
+
+func (s *OperatorService) saveTokens(ctx context.Context, tokens []APIToken) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return errors.Wrap(err, "marshal tokens")
+	}
+
+	if err := s.kvd.Set(ctx, operatorTokensKey, data); err != nil {
+		return errors.Wrap(err, "save tokens")
+	}
+	return nil
+}
+
+// hashToken 令牌和会话ID只保存哈希，泄露kv存储不会泄露凭据
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validClient 检查浏览器标识是否由Login生成，标识会用作kv命名空间的一部分
+func validClient(client string) bool {
+	if len(client) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(client)
+	return err == nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate random bytes")
+	}
+	return hex.EncodeToString(b), nil
+}
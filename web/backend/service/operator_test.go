@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+func TestOperatorService(t *testing.T) {
+	ctx := context.Background()
+
+	kvd, err := kv.New(kv.DriverBolt, map[string]any{"path": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, kvd.Close()) })
+
+	s, generated, err := NewOperatorService(ctx, kvd, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, generated)
+
+	// password is kept on restart
+	s, again, err := NewOperatorService(ctx, kvd, "")
+	require.NoError(t, err)
+	assert.Empty(t, again)
+
+	_, _, err = s.Login(ctx, "wrong", "")
+	assert.ErrorIs(t, err, ErrOperatorUnauthorized)
+
+	sessionID, client, err := s.Login(ctx, generated, "invalid")
+	require.NoError(t, err)
+	assert.True(t, validClient(client))
+	identity, err := s.Session(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Operator: AdminOperator, Client: client}, identity)
+
+	// another browser gets its own client, the same browser keeps it
+	_, other, err := s.Login(ctx, generated, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, client, other)
+	_, same, err := s.Login(ctx, generated, client)
+	require.NoError(t, err)
+	assert.Equal(t, client, same)
+
+	require.NoError(t, s.Logout(ctx, sessionID))
+	_, err = s.Session(ctx, sessionID)
+	assert.ErrorIs(t, err, ErrOperatorUnauthorized)
+
+	info, token, err := s.CreateToken(ctx, identity, "script")
+	require.NoError(t, err)
+	tokenIdentity, err := s.Token(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, identity, tokenIdentity)
+
+	tokens, err := s.ListTokens(ctx, AdminOperator)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.NotNil(t, tokens[0].LastUsedAt)
+
+	require.NoError(t, s.RevokeToken(ctx, AdminOperator, info.ID))
+	_, err = s.Token(ctx, token)
+	assert.ErrorIs(t, err, ErrOperatorUnauthorized)
+	assert.ErrorIs(t, s.RevokeToken(ctx, AdminOperator, info.ID), ErrTokenNotFound)
+}
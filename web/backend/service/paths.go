@@ -0,0 +1,254 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/pkg/consts"
+)
+
+// ErrPathForbidden 路径不在允许的根目录中
+var ErrPathForbidden = errors.New("path is outside of allowed roots")
+
+// protectedDirs 无论根目录如何配置都不允许访问的目录，数据目录中保存了账号的会话
+var protectedDirs = []string{consts.DataDir}
+
+// Sandbox 将客户端传入的路径限制在允许的根目录中
+//
+// 根目录和路径都会解析符号链接，路径中的..在解析后检查，
+// 所以无法通过符号链接或..访问根目录以外的文件
+type Sandbox struct {
+	roots     []string // 已解析符号链接的绝对路径
+	protected []string // 根目录中不允许访问的目录，已解析符号链接
+}
+
+// NewSandbox 创建沙箱，不存在的根目录会被创建，第一个根目录为默认目录
+func NewSandbox(roots []string) (*Sandbox, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no allowed roots")
+	}
+
+	s := &Sandbox{roots: make([]string, 0, len(roots))}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve root %q", root)
+		}
+		if err = os.MkdirAll(abs, 0755); err != nil {
+			return nil, errors.Wrapf(err, "create root %q", root)
+		}
+		if abs, err = filepath.EvalSymlinks(abs); err != nil {
+			return nil, errors.Wrapf(err, "resolve root %q", root)
+		}
+		s.roots = append(s.roots, abs)
+	}
+
+	for _, dir := range protectedDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve protected dir %q", dir)
+		}
+		if abs, err = resolveSymlinks(abs, 0); err != nil {
+			return nil, errors.Wrapf(err, "resolve protected dir %q", dir)
+		}
+		s.protected = append(s.protected, abs)
+	}
+
+	return s, nil
+}
+
+// Roots 返回允许的根目录
+func (s *Sandbox) Roots() []string {
+	return append([]string(nil), s.roots...)
+}
+
+// Default 返回默认目录，即第一个根目录
+func (s *Sandbox) Default() string {
+	return s.roots[0]
+}
+
+// IsRoot 判断已解析的路径是否为根目录本身
+func (s *Sandbox) IsRoot(path string) bool {
+	for _, root := range s.roots {
+		if root == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 将路径解析为根目录中的绝对路径，相对路径相对于默认目录
+//
+// 路径可以不存在，此时解析最近的已存在的上级目录的符号链接。
+// 不在任何根目录中或在数据目录中时返回ErrPathForbidden
+func (s *Sandbox) Resolve(path string) (string, error) {
+	if path == "" {
+		return s.Default(), nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.Default(), path)
+	}
+
+	resolved, err := resolveSymlinks(filepath.Clean(path), 0)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve path %q", path)
+	}
+
+	for _, dir := range s.protected {
+		if within(dir, resolved) {
+			return "", errors.Wrapf(ErrPathForbidden, "path %q", path)
+		}
+	}
+	for _, root := range s.roots {
+		if within(root, resolved) {
+			return resolved, nil
+		}
+	}
+
+	return "", errors.Wrapf(ErrPathForbidden, "path %q", path)
+}
+
+// Join 将客户端传入的文件名清理后放入目录dir，dir应为Resolve的结果
+func (s *Sandbox) Join(dir, name string) (string, error) {
+	return s.Resolve(filepath.Join(dir, SanitizeFilename(name)))
+}
+
+// maxSymlinks 解析路径时最多跟随的符号链接数量
+const maxSymlinks = 255
+
+// resolveSymlinks 解析路径中的符号链接，路径可以不存在
+//
+// 不存在的部分原样拼接，但指向不存在目标的符号链接仍会被跟随，
+// 否则之后在其中创建文件时会写到链接的目标
+func resolveSymlinks(path string, links int) (string, error) {
+	if links > maxSymlinks {
+		return "", errors.New("too many levels of symbolic links")
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return "", err
+	}
+	dir, err := resolveSymlinks(parent, links)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, filepath.Base(path))
+	if target, err := os.Readlink(name); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		return resolveSymlinks(target, links+1)
+	}
+	return name, nil
+}
+
+// within 判断path是否为root或root中的路径，两者都应为已清理的绝对路径
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// SanitizeFilename 清理客户端传入的文件名，只保留最后一级名称并替换不安全的字符
+func SanitizeFilename(name string) string {
+	// 客户端可能使用任意一种路径分隔符
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimRight(name, ". ")
+	}
+
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// DefaultDownloadDir 默认下载目录，使用用户Downloads目录中单独的tdl目录，不存在时由NewSandbox创建
+//
+// 不使用Downloads或用户目录本身，否则导出和上传的根目录也会包含用户的其他文件
+func DefaultDownloadDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return filepath.Join(os.TempDir(), "tdl")
+	}
+	return filepath.Join(home, "Downloads", "tdl")
+}
+
+// PathPolicy Web文件操作允许访问的根目录
+type PathPolicy struct {
+	Download *Sandbox // 下载任务的保存目录
+	Export   *Sandbox // 聊天和成员导出的输出目录
+	Upload   *Sandbox // 可以上传的服务器本地文件
+	Staging  *Sandbox // 浏览器上传文件的暂存目录
+}
+
+// PathRoots 各类文件操作的根目录配置，为空时使用默认目录
+type PathRoots struct {
+	Download []string
+	Export   []string
+	Upload   []string
+}
+
+// NewPathPolicy 根据配置创建路径策略，导出和上传默认使用下载的根目录
+func NewPathPolicy(roots PathRoots) (*PathPolicy, error) {
+	if len(roots.Download) == 0 {
+		roots.Download = []string{DefaultDownloadDir()}
+	}
+	if len(roots.Export) == 0 {
+		roots.Export = roots.Download
+	}
+	if len(roots.Upload) == 0 {
+		roots.Upload = roots.Download
+	}
+
+	var (
+		p   PathPolicy
+		err error
+	)
+	for _, s := range []struct {
+		name  string
+		roots []string
+		dst   **Sandbox
+	}{
+		{"download", roots.Download, &p.Download},
+		{"export", roots.Export, &p.Export},
+		{"upload", roots.Upload, &p.Upload},
+		{"staging", []string{filepath.Join(os.TempDir(), "tdl_upload")}, &p.Staging},
+	} {
+		if *s.dst, err = NewSandbox(s.roots); err != nil {
+			return nil, errors.Wrapf(err, "%s roots", s.name)
+		}
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandbox(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	data := filepath.Join(root, ".tdl")
+	require.NoError(t, os.MkdirAll(outside, 0755))
+	require.NoError(t, os.MkdirAll(data, 0755))
+
+	protected := protectedDirs
+	protectedDirs = []string{data}
+	defer func() { protectedDirs = protected }()
+
+	s, err := NewSandbox([]string{root})
+	require.NoError(t, err)
+	assert.Equal(t, root, s.Default())
+
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "sub"), filepath.Join(outside, "back")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "new"), filepath.Join(root, "dangling")))
+
+	tests := []struct {
+		path string
+		want string // 为空表示应被拒绝
+	}{
+		{"", root},
+		{root, root},
+		{"sub/new", filepath.Join(root, "sub", "new")},
+		{filepath.Join(root, "a", "..", "b"), filepath.Join(root, "b")},
+		{filepath.Join(root, ".."), ""},
+		{"../outside", ""},
+		{"/", ""},
+		{filepath.Join(root, "escape"), ""},
+		{filepath.Join(root, "escape", "not", "exist"), ""},
+		{filepath.Join(outside, "back", "file"), filepath.Join(root, "sub", "file")},
+		{filepath.Join(root, "dangling", "file"), ""},
+		{root + "2", ""},
+		{data, ""},
+		{filepath.Join(data, "user_1"), ""},
+		{filepath.Join(root, "sub", "..", ".tdl", "web_data"), ""},
+		{filepath.Join(root, ".tdl2"), filepath.Join(root, ".tdl2")},
+	}
+	for _, tt := range tests {
+		got, err := s.Resolve(tt.path)
+		if tt.want == "" {
+			assert.ErrorIs(t, err, ErrPathForbidden, tt.path)
+			continue
+		}
+		require.NoError(t, err, tt.path)
+		assert.Equal(t, tt.want, got, tt.path)
+	}
+
+	joined, err := s.Join(root, "../../etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "passwd"), joined)
+
+	require.NoError(t, os.Symlink(data, filepath.Join(root, "data")))
+	_, err = s.Resolve(filepath.Join(root, "data", "user_1"))
+	assert.ErrorIs(t, err, ErrPathForbidden)
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":           "photo.jpg",
+		"../../etc/passwd":    "passwd",
+		`..\..\windows\a.txt`: "a.txt",
+		"a:b*c?.txt":          "a_b_c_.txt",
+		"bad\x00name\n":       "badname",
+		"..":                  "file",
+		"dir/":                "file",
+		"  spaced name.mp4  ": "spaced name.mp4",
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, SanitizeFilename(name), name)
+	}
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go.uber.org/zap"
+
+	"github.com/iyear/tdl/core/logctx"
+)
+
+// ErrJobNotQueued 任务不在等待队列中
+var ErrJobNotQueued = errors.New("job is not queued")
+
+// ErrJobExists 同ID的任务已在等待或运行中
+var ErrJobExists = errors.New("job already exists")
+
+// Job 由调度器执行的任务
+type Job struct {
+	ID        string
+	Type      string
+	Priority  int    // 数值越大越先执行，相同优先级按提交顺序
+	ClientID  string // 创建任务的客户端
+	AccountID int64  // 执行任务的Telegram账号
+	Run       func(ctx context.Context) error
+}
+
+// JobInfo 队列中任务的快照
+type JobInfo struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Priority   int        `json:"priority"`
+	ClientID   string     `json:"-"`
+	AccountID  int64      `json:"account_id,omitempty"`
+	Position   int        `json:"position"` // 在等待队列中的位置，运行中为-1
+	Running    bool       `json:"running"`
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+}
+
+type queuedJob struct {
+	Job
+	enqueuedAt time.Time
+}
+
+// Scheduler 全局任务调度器
+//
+// 所有Web任务（下载、转发、上传、导出）都通过调度器执行，
+// 同时运行的任务数由limit决定，其余任务按优先级和FIFO顺序排队
+type Scheduler struct {
+	ctx     context.Context
+	limit   func() int
+	mu      sync.Mutex
+	queue   []*queuedJob // 按优先级从高到低，相同优先级按提交顺序
+	running map[string]*runningJob
+	types   map[string]struct{} // 提交过的任务类型，没有任务时指标仍输出0
+	metrics *Metrics
+}
+
+type runningJob struct {
+	job       *queuedJob
+	cancel    context.CancelFunc
+	startedAt time.Time
+}
+
+// NewScheduler 创建调度器，limit在每次调度时读取，以便设置修改后立即生效
+func NewScheduler(ctx context.Context, limit func() int) *Scheduler {
+	return &Scheduler{
+		ctx:     ctx,
+		limit:   limit,
+		running: make(map[string]*runningJob),
+		types:   make(map[string]struct{}),
+	}
+}
+
+// SetMetrics 记录任务的结束状态，需在提交任务前设置
+func (s *Scheduler) SetMetrics(m *Metrics) {
+	s.metrics = m
+}
+
+// Submit 提交任务，返回任务在等待队列中的位置（0表示队首）
+//
+// 同ID的任务已在等待或运行中时返回ErrJobExists，否则会丢失原任务的取消函数
+func (s *Scheduler) Submit(job Job) (int, error) {
+	s.mu.Lock()
+	if _, ok := s.running[job.ID]; ok || s.indexOf(job.ID) >= 0 {
+		s.mu.Unlock()
+		return 0, errors.Wrapf(ErrJobExists, "job %s", job.ID)
+	}
+	qj := &queuedJob{Job: job, enqueuedAt: time.Now()}
+	pos := s.insert(qj)
+	s.types[job.Type] = struct{}{}
+	s.mu.Unlock()
+
+	logctx.From(s.ctx).Info("Job queued",
+		zap.String("id", job.ID),
+		zap.String("type", job.Type),
+		zap.Int("priority", job.Priority),
+		zap.Int("position", pos))
+
+	s.Dispatch()
+	return pos, nil
+}
+
+// Dispatch 在并发数允许的情况下启动队首的任务
+func (s *Scheduler) Dispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := s.limit()
+	if limit < 1 {
+		limit = 1
+	}
+
+	for len(s.running) < limit && len(s.queue) > 0 {
+		qj := s.queue[0]
+		s.queue = s.queue[1:]
+		s.start(qj)
+	}
+}
+
+// start 启动任务，调用方需持有锁
+func (s *Scheduler) start(qj *queuedJob) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.running[qj.ID] = &runningJob{job: qj, cancel: cancel, startedAt: time.Now()}
+
+	go func() {
+		defer func() {
+			cancel()
+
+			s.mu.Lock()
+			// 同ID的任务可能已在取消后重新提交
+			if r, ok := s.running[qj.ID]; ok && r.job == qj {
+				delete(s.running, qj.ID)
+			}
+			s.mu.Unlock()
+
+			s.Dispatch()
+		}()
+
+		err := qj.Run(ctx)
+		if err != nil {
+			logctx.From(s.ctx).Debug("Job finished with error",
+				zap.String("id", qj.ID),
+				zap.Error(err))
+		}
+
+		outcome := TaskOutcomeCompleted
+		switch {
+		case ctx.Err() != nil || errors.Is(err, context.Canceled):
+			outcome = TaskOutcomeCancelled
+		case err != nil:
+			outcome = TaskOutcomeFailed
+		}
+		s.metrics.TaskFinished(qj.Type, outcome)
+	}()
+}
+
+// Cancel 取消任务：等待中的任务直接移出队列，运行中的任务取消其上下文
+//
+// 返回值表示任务是否处于等待队列中（此时Run不会被调用）
+func (s *Scheduler) Cancel(id string) (queued bool, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.indexOf(id); i >= 0 {
+		s.metrics.TaskFinished(s.queue[i].Type, TaskOutcomeCancelled)
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		return true, true
+	}
+
+	if r, ok := s.running[id]; ok {
+		r.cancel()
+		return false, true
+	}
+
+	return false, false
+}
+
+// Active 任务是否在等待或运行中
+func (s *Scheduler) Active(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.running[id]
+	return ok || s.indexOf(id) >= 0
+}
+
+// Position 返回任务在等待队列中的位置
+func (s *Scheduler) Position(id string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	return i, i >= 0
+}
+
+// List 返回运行中和等待中的任务快照
+func (s *Scheduler) List() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(s.running)+len(s.queue))
+	for _, r := range s.running {
+		startedAt := r.startedAt
+		jobs = append(jobs, JobInfo{
+			ID:         r.job.ID,
+			Type:       r.job.Type,
+			Priority:   r.job.Priority,
+			ClientID:   r.job.ClientID,
+			AccountID:  r.job.AccountID,
+			Position:   -1,
+			Running:    true,
+			EnqueuedAt: r.job.enqueuedAt,
+			StartedAt:  &startedAt,
+		})
+	}
+	for i, qj := range s.queue {
+		jobs = append(jobs, JobInfo{
+			ID:         qj.ID,
+			Type:       qj.Type,
+			Priority:   qj.Priority,
+			ClientID:   qj.ClientID,
+			AccountID:  qj.AccountID,
+			Position:   i,
+			EnqueuedAt: qj.enqueuedAt,
+		})
+	}
+
+	return jobs
+}
+
+// Get 返回运行中或等待中的任务快照
+func (s *Scheduler) Get(id string) (JobInfo, bool) {
+	for _, job := range s.List() {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return JobInfo{}, false
+}
+
+// Collect 写出各类型运行中和等待中的任务数
+func (s *Scheduler) Collect(_ context.Context, w *MetricsWriter) {
+	s.mu.Lock()
+	running := make(map[string]int, len(s.types))
+	queued := make(map[string]int, len(s.types))
+	for _, r := range s.running {
+		running[r.job.Type]++
+	}
+	for _, qj := range s.queue {
+		queued[qj.Type]++
+	}
+	types := make([]string, 0, len(s.types))
+	for t := range s.types {
+		types = append(types, t)
+	}
+	limit := s.limit()
+	s.mu.Unlock()
+	sort.Strings(types)
+	if limit < 1 {
+		limit = 1
+	}
+
+	w.Family("tdl_tasks", "gauge", "Running and queued tasks by type.")
+	for _, t := range types {
+		w.Sample("tdl_tasks", float64(running[t]), "type", t, "state", "running")
+		w.Sample("tdl_tasks", float64(queued[t]), "type", t, "state", "queued")
+	}
+	w.Family("tdl_tasks_limit", "gauge", "Maximum number of concurrently running tasks.")
+	w.Sample("tdl_tasks_limit", float64(limit))
+}
+
+// SetPriority 修改等待中任务的优先级，并按新的优先级重新排队
+func (s *Scheduler) SetPriority(id string, priority int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return 0, ErrJobNotQueued
+	}
+
+	qj := s.queue[i]
+	s.queue = append(s.queue[:i], s.queue[i+1:]...)
+	qj.Priority = priority
+
+	return s.insert(qj), nil
+}
+
+// Bump 将等待中的任务移到队首
+func (s *Scheduler) Bump(id string) error {
+	_, err := s.Move(id, 0)
+	return err
+}
+
+// Move 将等待中的任务移动到指定位置
+//
+// 为保持队列按优先级有序，任务会采用新位置上相邻任务的优先级
+func (s *Scheduler) Move(id string, position int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return 0, ErrJobNotQueued
+	}
+
+	qj := s.queue[i]
+	s.queue = append(s.queue[:i], s.queue[i+1:]...)
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(s.queue) {
+		position = len(s.queue)
+	}
+
+	// 优先级限制在相邻两个任务之间
+	if position < len(s.queue) && qj.Priority < s.queue[position].Priority {
+		qj.Priority = s.queue[position].Priority
+	}
+	if position > 0 && qj.Priority > s.queue[position-1].Priority {
+		qj.Priority = s.queue[position-1].Priority
+	}
+
+	s.queue = append(s.queue, nil)
+	copy(s.queue[position+1:], s.queue[position:])
+	s.queue[position] = qj
+
+	return position, nil
+}
+
+// insert 按优先级插入队列，返回位置，调用方需持有锁
+func (s *Scheduler) insert(qj *queuedJob) int {
+	pos := len(s.queue)
+	for i, existing := range s.queue {
+		if qj.Priority > existing.Priority {
+			pos = i
+			break
+		}
+	}
+
+	s.queue = append(s.queue, nil)
+	copy(s.queue[pos+1:], s.queue[pos:])
+	s.queue[pos] = qj
+
+	return pos
+}
+
+// indexOf 返回任务在等待队列中的下标，调用方需持有锁
+func (s *Scheduler) indexOf(id string) int {
+	for i, qj := range s.queue {
+		if qj.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queuedIDs(s *Scheduler) []string {
+	ids := make([]string, 0)
+	for _, job := range s.List() {
+		if !job.Running {
+			ids = append(ids, job.ID)
+		}
+	}
+	return ids
+}
+
+func TestSchedulerLimitAndOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewScheduler(ctx, func() int { return 1 })
+
+	release := make(chan struct{})
+	started := make(chan string, 8)
+	job := func(id string, priority int) Job {
+		return Job{ID: id, Type: "test", Priority: priority, Run: func(ctx context.Context) error {
+			started <- id
+			<-release
+			return nil
+		}}
+	}
+
+	submit := func(j Job) int {
+		pos, err := s.Submit(j)
+		require.NoError(t, err)
+		return pos
+	}
+
+	submit(job("a", 0))
+	require.Equal(t, "a", <-started)
+
+	assert.Equal(t, 0, submit(job("b", 0)))
+	assert.Equal(t, 1, submit(job("c", 0)))
+	assert.Equal(t, 0, submit(job("d", 5)))
+	assert.Equal(t, []string{"d", "b", "c"}, queuedIDs(s))
+
+	// 同ID的任务在等待或运行中时不能再提交
+	_, err := s.Submit(job("a", 0))
+	assert.ErrorIs(t, err, ErrJobExists)
+	_, err = s.Submit(job("b", 9))
+	assert.ErrorIs(t, err, ErrJobExists)
+	assert.Equal(t, []string{"d", "b", "c"}, queuedIDs(s))
+
+	pos, err := s.Move("c", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pos)
+	assert.Equal(t, []string{"c", "d", "b"}, queuedIDs(s))
+
+	pos, err = s.SetPriority("b", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pos)
+	assert.Equal(t, []string{"b", "c", "d"}, queuedIDs(s))
+
+	require.NoError(t, s.Bump("d"))
+	assert.Equal(t, []string{"d", "b", "c"}, queuedIDs(s))
+
+	queued, found := s.Cancel("b")
+	assert.True(t, queued)
+	assert.True(t, found)
+	assert.False(t, s.Active("b"))
+
+	_, err = s.Move("a", 0)
+	assert.ErrorIs(t, err, ErrJobNotQueued)
+
+	close(release)
+	for _, want := range []string{"d", "c"} {
+		select {
+		case id := <-started:
+			assert.Equal(t, want, id)
+		case <-time.After(time.Second):
+			t.Fatalf("job %s not started", want)
+		}
+	}
+}
+
+func TestSchedulerCancelRunning(t *testing.T) {
+	s := NewScheduler(context.Background(), func() int { return 2 })
+
+	done := make(chan error, 1)
+	s.Submit(Job{ID: "a", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		done <- ctx.Err()
+		return ctx.Err()
+	}})
+
+	require.Eventually(t, func() bool { return len(s.List()) == 1 && s.List()[0].Running }, time.Second, 10*time.Millisecond)
+
+	queued, found := s.Cancel("a")
+	assert.False(t, queued)
+	assert.True(t, found)
+	assert.ErrorIs(t, <-done, context.Canceled)
+	require.Eventually(t, func() bool { return !s.Active("a") }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+const (
+	// TaskNamespace 任务持久化使用的kv命名空间
+	TaskNamespace = "tasks"
+
+	taskIndexKey  = "index"
+	taskKeyPrefix = "task:"
+)
+
+// ErrTaskExists 新任务的ID已被其他任务使用
+var ErrTaskExists = errors.New("task already exists")
+
+// TaskRecord 持久化的任务记录，Data为各处理器自己的任务结构
+type TaskRecord struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Status    string          `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// TaskRepository 基于kv存储的任务仓库，服务重启后任务仍然可见
+type TaskRepository struct {
+	kvd storage.Storage
+	mu  sync.Mutex
+}
+
+// NewTaskRepository 创建任务仓库
+func NewTaskRepository(kvd kv.Storage) (*TaskRepository, error) {
+	ns, err := kvd.Open(TaskNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "open tasks namespace")
+	}
+
+	return &TaskRepository{kvd: ns}, nil
+}
+
+// Save 保存或更新任务，data会被序列化为JSON
+func (r *TaskRepository) Save(ctx context.Context, id, taskType, status string, createdAt time.Time, data interface{}) error {
+	return r.save(ctx, id, taskType, status, createdAt, data, false)
+}
+
+// Create 保存新任务，ID已被使用时返回ErrTaskExists
+func (r *TaskRepository) Create(ctx context.Context, id, taskType, status string, createdAt time.Time, data interface{}) error {
+	return r.save(ctx, id, taskType, status, createdAt, data, true)
+}
+
+func (r *TaskRepository) save(ctx context.Context, id, taskType, status string, createdAt time.Time, data interface{}, create bool) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "marshal task data")
+	}
+
+	record, err := json.Marshal(TaskRecord{
+		ID:        id,
+		Type:      taskType,
+		Status:    status,
+		CreatedAt: createdAt,
+		UpdatedAt: time.Now(),
+		Data:      raw,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal task record")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if create {
+		_, err = r.kvd.Get(ctx, taskKeyPrefix+id)
+		switch {
+		case err == nil:
+			return errors.Wrapf(ErrTaskExists, "task %s", id)
+		case !kv.IsNotFound(err):
+			return errors.Wrap(err, "get task")
+		}
+	}
+
+	if err = r.kvd.Set(ctx, taskKeyPrefix+id, record); err != nil {
+		return errors.Wrap(err, "save task")
+	}
+
+	ids, err := r.index(ctx)
+	if err != nil {
+		return err
+	}
+	for _, existing := range ids {
+		if existing == id {
+			return nil
+		}
+	}
+
+	return r.setIndex(ctx, append(ids, id))
+}
+
+// Get 获取单个任务记录
+func (r *TaskRepository) Get(ctx context.Context, id string) (*TaskRecord, error) {
+	data, err := r.kvd.Get(ctx, taskKeyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	var record TaskRecord
+	if err = json.Unmarshal(data, &record); err != nil {
+		return nil, errors.Wrap(err, "unmarshal task record")
+	}
+
+	return &record, nil
+}
+
+// List 按创建顺序列出指定类型的任务，taskType为空时返回全部
+func (r *TaskRepository) List(ctx context.Context, taskType string) ([]*TaskRecord, error) {
+	r.mu.Lock()
+	ids, err := r.index(ctx)
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*TaskRecord, 0, len(ids))
+	for _, id := range ids {
+		record, err := r.Get(ctx, id)
+		if err != nil {
+			if kv.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "get task %s", id)
+		}
+
+		if taskType != "" && record.Type != taskType {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Delete 删除任务记录
+func (r *TaskRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.kvd.Delete(ctx, taskKeyPrefix+id); err != nil && !kv.IsNotFound(err) {
+		return errors.Wrap(err, "delete task")
+	}
+
+	ids, err := r.index(ctx)
+	if err != nil {
+		return err
+	}
+
+	filtered := ids[:0]
+	for _, existing := range ids {
+		if existing != id {
+			filtered = append(filtered, existing)
+		}
+	}
+
+	return r.setIndex(ctx, filtered)
+}
+
+// index 读取任务ID索引，kv存储本身不支持遍历
+func (r *TaskRepository) index(ctx context.Context) ([]string, error) {
+	data, err := r.kvd.Get(ctx, taskIndexKey)
+	if err != nil {
+		if kv.IsNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, errors.Wrap(err, "get task index")
+	}
+
+	var ids []string
+	if err = json.Unmarshal(data, &ids); err != nil {
+		return nil, errors.Wrap(err, "unmarshal task index")
+	}
+
+	return ids, nil
+}
+
+func (r *TaskRepository) setIndex(ctx context.Context, ids []string) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return errors.Wrap(err, "marshal task index")
+	}
+
+	if err = r.kvd.Set(ctx, taskIndexKey, data); err != nil {
+		return errors.Wrap(err, "set task index")
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+func TestTaskRepository(t *testing.T) {
+	ctx := context.Background()
+
+	kvd, err := kv.New(kv.DriverBolt, map[string]any{"path": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, kvd.Close()) })
+
+	repo, err := NewTaskRepository(kvd)
+	require.NoError(t, err)
+
+	type data struct {
+		Name string `json:"name"`
+	}
+
+	now := time.Now()
+	require.NoError(t, repo.Save(ctx, "a", "download", "running", now, data{Name: "a"}))
+	require.NoError(t, repo.Save(ctx, "b", "upload", "pending", now, data{Name: "b"}))
+	require.NoError(t, repo.Save(ctx, "c", "download", "completed", now, data{Name: "c"}))
+	// update keeps original order
+	require.NoError(t, repo.Save(ctx, "a", "download", "completed", now, data{Name: "a2"}))
+	// create never overwrites an existing task
+	assert.ErrorIs(t, repo.Create(ctx, "a", "upload", "queued", now, data{Name: "a3"}), ErrTaskExists)
+
+	records, err := repo.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "a", records[0].ID)
+	assert.Equal(t, "completed", records[0].Status)
+	assert.JSONEq(t, `{"name":"a2"}`, string(records[0].Data))
+
+	records, err = repo.List(ctx, "download")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "c", records[1].ID)
+
+	require.NoError(t, repo.Delete(ctx, "a"))
+	_, err = repo.Get(ctx, "a")
+	assert.True(t, kv.IsNotFound(err))
+
+	records, err = repo.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "b", records[0].ID)
+
+	// reopen the same storage, as after a server restart
+	repo, err = NewTaskRepository(kvd)
+	require.NoError(t, err)
+	records, err = repo.List(ctx, "upload")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "pending", records[0].Status)
+
+	// a deleted ID can be used again
+	require.NoError(t, repo.Create(ctx, "a", "upload", "queued", now, data{Name: "a3"}))
+	records, err = repo.List(ctx, "upload")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// ThumbCache 缩略图的磁盘缓存，总大小超过上限时删除最久未使用的文件
+//
+// 缩略图按照片或文件的ID缓存，不区分账号，服务重启后仍然有效
+type ThumbCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*thumbEntry // key -> 缓存文件
+}
+
+// thumbTempPrefix 写入中的临时文件前缀，加载缓存目录时删除
+const thumbTempPrefix = ".tmp-"
+
+type thumbEntry struct {
+	size int64
+	used time.Time
+}
+
+// DefaultThumbCacheDir 默认的缩略图缓存目录，优先使用用户缓存目录
+func DefaultThumbCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil || dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tdl", "thumbs")
+}
+
+// NewThumbCache 创建缓存并加载目录中已有的文件，maxBytes为缓存总大小的上限
+func NewThumbCache(dir string, maxBytes int64) (*ThumbCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create thumb cache dir")
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read thumb cache dir")
+	}
+
+	c := &ThumbCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*thumbEntry, len(des)),
+	}
+	for _, de := range des {
+		if strings.HasPrefix(de.Name(), thumbTempPrefix) {
+			_ = os.Remove(filepath.Join(dir, de.Name())) // 上次退出时未完成的写入
+			continue
+		}
+		info, err := de.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// 使用修改时间作为最后使用时间，Get会更新它
+		c.entries[de.Name()] = &thumbEntry{size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// Get 读取缓存的缩略图
+func (c *ThumbCache) Get(key string) ([]byte, bool) {
+	key = SanitizeFilename(key)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		entry.used = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.mu.Lock()
+		c.remove(key)
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now) // 重启后仍能按使用时间淘汰
+	return data, true
+}
+
+// Put 写入缩略图，先写临时文件再重命名，避免读到不完整的文件
+func (c *ThumbCache) Put(key string, data []byte) error {
+	key = SanitizeFilename(key)
+	if int64(len(data)) > c.maxBytes {
+		return nil // 单个文件超过上限时不缓存
+	}
+
+	tmp, err := os.CreateTemp(c.dir, thumbTempPrefix+"*")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write thumb")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	c.entries[key] = &thumbEntry{size: int64(len(data)), used: time.Now()}
+	c.size += int64(len(data))
+	c.evict()
+
+	return nil
+}
+
+// Size 缓存文件的总大小
+func (c *ThumbCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// evict 删除最久未使用的文件直到不超过上限，调用方需持有锁
+func (c *ThumbCache) evict() {
+	if c.size <= c.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].used.Before(c.entries[keys[j]].used)
+	})
+
+	for _, key := range keys {
+		if c.size <= c.maxBytes {
+			break
+		}
+		_ = os.Remove(filepath.Join(c.dir, key))
+		c.remove(key)
+	}
+}
+
+// remove 从索引中移除，调用方需持有锁
+func (c *ThumbCache) remove(key string) {
+	if entry, ok := c.entries[key]; ok {
+		c.size -= entry.size
+		delete(c.entries, key)
+	}
+}
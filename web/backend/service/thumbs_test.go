@@ -0,0 +1,56 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThumbCache(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewThumbCache(dir, 10)
+	require.NoError(t, err)
+
+	_, ok := c.Get("photo_1_m")
+	assert.False(t, ok)
+
+	require.NoError(t, c.Put("photo_1_m", []byte("1234")))
+	require.NoError(t, c.Put("photo_2_m", []byte("5678")))
+
+	data, ok := c.Get("photo_1_m")
+	require.True(t, ok)
+	assert.Equal(t, "1234", string(data))
+
+	// photo_2_m is the least recently used one
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, c.Put("doc_3_s", []byte("abcd")))
+	assert.Equal(t, int64(8), c.Size())
+
+	_, ok = c.Get("photo_2_m")
+	assert.False(t, ok)
+	_, err = os.Stat(filepath.Join(dir, "photo_2_m"))
+	assert.True(t, os.IsNotExist(err))
+
+	// too large to be cached
+	require.NoError(t, c.Put("doc_4_s", make([]byte, 11)))
+	_, ok = c.Get("doc_4_s")
+	assert.False(t, ok)
+
+	// keys can't escape the cache dir
+	require.NoError(t, c.Put("../escape", []byte("x")))
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape"))
+	assert.True(t, os.IsNotExist(err))
+
+	// reload from disk, as after a server restart
+	c, err = NewThumbCache(dir, 10)
+	require.NoError(t, err)
+	data, ok = c.Get("doc_3_s")
+	require.True(t, ok)
+	assert.Equal(t, "abcd", string(data))
+	assert.LessOrEqual(t, c.Size(), int64(10))
+}
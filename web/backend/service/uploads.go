@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/iyear/tdl/core/storage"
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+const (
+	// UploadSessionNamespace 分块上传会话使用的kv命名空间
+	UploadSessionNamespace = "upload_sessions"
+
+	uploadIndexKey  = "index"
+	uploadKeyPrefix = "session:"
+)
+
+// UploadSession 可续传的分块上传会话，记录浏览器已经写入暂存文件的字节数
+//
+// 客户端中断后通过会话查询Offset，从该位置继续发送剩余的数据
+type UploadSession struct {
+	ID        string          `json:"id"`
+	Filename  string          `json:"filename"`
+	Path      string          `json:"path"` // 暂存文件的绝对路径
+	Size      int64           `json:"size"`
+	Offset    int64           `json:"offset"`
+	Options   json.RawMessage `json:"options,omitempty"` // 文件完成后创建上传任务使用的选项
+	ClientID  string          `json:"client_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Completed 所有分块是否都已写入
+func (s *UploadSession) Completed() bool {
+	return s.Offset >= s.Size
+}
+
+// UploadSessionStore 基于kv存储的上传会话，服务重启后仍可继续上传
+type UploadSessionStore struct {
+	kvd storage.Storage
+	mu  sync.Mutex
+}
+
+// NewUploadSessionStore 创建上传会话存储
+func NewUploadSessionStore(kvd kv.Storage) (*UploadSessionStore, error) {
+	ns, err := kvd.Open(UploadSessionNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "open upload sessions namespace")
+	}
+
+	return &UploadSessionStore{kvd: ns}, nil
+}
+
+// Save 保存或更新会话
+func (s *UploadSessionStore) Save(ctx context.Context, session *UploadSession) error {
+	session.UpdatedAt = time.Now()
+	data, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrap(err, "marshal upload session")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = s.kvd.Set(ctx, uploadKeyPrefix+session.ID, data); err != nil {
+		return errors.Wrap(err, "save upload session")
+	}
+
+	ids, err := s.index(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == session.ID {
+			return nil
+		}
+	}
+
+	return s.setIndex(ctx, append(ids, session.ID))
+}
+
+// Get 获取会话，不存在时返回storage.ErrNotFound
+func (s *UploadSessionStore) Get(ctx context.Context, id string) (*UploadSession, error) {
+	data, err := s.kvd.Get(ctx, uploadKeyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	var session UploadSession
+	if err = json.Unmarshal(data, &session); err != nil {
+		return nil, errors.Wrap(err, "unmarshal upload session")
+	}
+
+	return &session, nil
+}
+
+// List 按创建顺序列出所有会话
+func (s *UploadSessionStore) List(ctx context.Context) ([]*UploadSession, error) {
+	s.mu.Lock()
+	ids, err := s.index(ctx)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*UploadSession, 0, len(ids))
+	for _, id := range ids {
+		session, err := s.Get(ctx, id)
+		if err != nil {
+			if kv.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "get upload session %s", id)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// Delete 删除会话记录，暂存文件由调用方清理
+func (s *UploadSessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.kvd.Delete(ctx, uploadKeyPrefix+id); err != nil && !kv.IsNotFound(err) {
+		return errors.Wrap(err, "delete upload session")
+	}
+
+	ids, err := s.index(ctx)
+	if err != nil {
+		return err
+	}
+
+	filtered := ids[:0]
+	for _, existing := range ids {
+		if existing != id {
+			filtered = append(filtered, existing)
+		}
+	}
+
+	return s.setIndex(ctx, filtered)
+}
+
+// index 读取会话ID索引，kv存储本身不支持遍历
+func (s *UploadSessionStore) index(ctx context.Context) ([]string, error) {
+	data, err := s.kvd.Get(ctx, uploadIndexKey)
+	if err != nil {
+		if kv.IsNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, errors.Wrap(err, "get upload session index")
+	}
+
+	var ids []string
+	if err = json.Unmarshal(data, &ids); err != nil {
+		return nil, errors.Wrap(err, "unmarshal upload session index")
+	}
+
+	return ids, nil
+}
+
+func (s *UploadSessionStore) setIndex(ctx context.Context, ids []string) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return errors.Wrap(err, "marshal upload session index")
+	}
+
+	if err = s.kvd.Set(ctx, uploadIndexKey, data); err != nil {
+		return errors.Wrap(err, "set upload session index")
+	}
+
+	return nil
+}
+
+// StagingPolicy 暂存目录的生命周期策略
+type StagingPolicy struct {
+	SessionTTL time.Duration // 未完成的上传会话在没有新分块后保留的时间
+	Retention  time.Duration // 失败、取消或中断的任务保留暂存文件以便重试的时间
+}
+
+// DefaultStagingPolicy 默认的暂存目录生命周期
+var DefaultStagingPolicy = StagingPolicy{
+	SessionTTL: 24 * time.Hour,
+	Retention:  7 * 24 * time.Hour,
+}
+
+// StagingOwner 暂存目录的使用方
+type StagingOwner int
+
+const (
+	StagingOrphan   StagingOwner = iota // 没有对应的会话或任务，例如服务在创建任务前退出
+	StagingSession                      // 仍在接收分块的上传会话
+	StagingActive                       // 排队或运行中的任务
+	StagingFinished                     // 全部文件上传成功的任务
+	StagingFailed                       // 有文件失败、被取消或中断的任务，可以重试
+)
+
+// Expired 判断暂存目录是否可以删除，idle为目录或会话最后一次更新后经过的时间
+func (p StagingPolicy) Expired(owner StagingOwner, idle time.Duration) bool {
+	switch owner {
+	case StagingActive:
+		return false
+	case StagingFinished:
+		return true
+	case StagingFailed:
+		return idle > p.Retention
+	default:
+		return idle > p.SessionTTL
+	}
+}
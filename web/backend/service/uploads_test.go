@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iyear/tdl/pkg/kv"
+)
+
+func TestUploadSessionStore(t *testing.T) {
+	ctx := context.Background()
+
+	kvd, err := kv.New(kv.DriverBolt, map[string]any{"path": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, kvd.Close()) })
+
+	store, err := NewUploadSessionStore(kvd)
+	require.NoError(t, err)
+
+	a := &UploadSession{ID: "a", Filename: "a.mp4", Size: 10, Options: []byte(`{"to_chat":"me"}`)}
+	require.NoError(t, store.Save(ctx, a))
+	require.NoError(t, store.Save(ctx, &UploadSession{ID: "b", Size: 5}))
+
+	a.Offset = 4
+	require.NoError(t, store.Save(ctx, a))
+
+	got, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), got.Offset)
+	assert.False(t, got.Completed())
+	assert.JSONEq(t, `{"to_chat":"me"}`, string(got.Options))
+
+	// reopen the same storage, as after a server restart
+	store, err = NewUploadSessionStore(kvd)
+	require.NoError(t, err)
+	sessions, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "a", sessions[0].ID)
+
+	require.NoError(t, store.Delete(ctx, "a"))
+	_, err = store.Get(ctx, "a")
+	assert.True(t, kv.IsNotFound(err))
+
+	sessions, err = store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "b", sessions[0].ID)
+}
+
+func TestStagingPolicy(t *testing.T) {
+	p := StagingPolicy{SessionTTL: time.Hour, Retention: 24 * time.Hour}
+
+	tests := []struct {
+		owner   StagingOwner
+		idle    time.Duration
+		expired bool
+	}{
+		{StagingActive, 48 * time.Hour, false},
+		{StagingFinished, 0, true},
+		{StagingFailed, 2 * time.Hour, false},
+		{StagingFailed, 25 * time.Hour, true},
+		{StagingSession, 30 * time.Minute, false},
+		{StagingSession, 2 * time.Hour, true},
+		{StagingOrphan, 30 * time.Minute, false},
+		{StagingOrphan, 2 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expired, p.Expired(tt.owner, tt.idle), "owner %d idle %s", tt.owner, tt.idle)
+	}
+}
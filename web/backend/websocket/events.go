@@ -0,0 +1,130 @@
+package websocket
+
+import "sort"
+
+// 客户端发送的操作
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+	ActionResume      = "resume"
+)
+
+// eventLogSize 每个账号和浏览器保留的最近事件数量
+const eventLogSize = 1000
+
+// ClientMessage 客户端发送给服务端的消息
+//
+//	{"action":"subscribe","task_ids":["..."],"task_types":["download"]}
+//	{"action":"unsubscribe","task_ids":["..."]}
+//	{"action":"resume","seq":42}
+type ClientMessage struct {
+	Action    string   `json:"action"`
+	TaskIDs   []string `json:"task_ids,omitempty"`
+	TaskTypes []string `json:"task_types,omitempty"`
+	Seq       uint64   `json:"seq,omitempty"` // resume: 已收到的最后一个事件序号
+}
+
+// SubscriptionData 当前订阅，均为空时接收全部事件
+type SubscriptionData struct {
+	TaskIDs   []string `json:"task_ids"`
+	TaskTypes []string `json:"task_types"`
+}
+
+// ReplayData 断线期间错过的事件
+type ReplayData struct {
+	Events []Message `json:"events"`
+	Latest uint64    `json:"latest"` // 当前最新的事件序号
+	// Complete 为false表示部分事件已超出保留范围，需要重新拉取任务列表
+	Complete bool `json:"complete"`
+}
+
+// event 带有路由信息的已编号事件
+type event struct {
+	msg      Message
+	taskID   string // 为空表示与任务无关，如通知
+	taskType string
+}
+
+// eventLog 单个账号或浏览器的有界事件日志
+//
+// 序号由Hub统一分配，多个日志共用同一序列，因此单个日志中的序号不连续
+type eventLog struct {
+	dropped uint64  // 最后一个因超出保留数量被移除的事件序号
+	events  []event // 按序号升序，最多eventLogSize个
+}
+
+// append 写入已编号的事件
+func (l *eventLog) append(ev event) {
+	if len(l.events) == eventLogSize {
+		l.dropped = l.events[0].msg.Seq
+		copy(l.events, l.events[1:])
+		l.events = l.events[:len(l.events)-1]
+	}
+	l.events = append(l.events, ev)
+}
+
+// since 返回序号大于seq且匹配订阅的事件，complete表示没有事件因超出保留范围而丢失
+func (l *eventLog) since(seq uint64, sub *subscription) (events []event, complete bool) {
+	for _, ev := range l.events {
+		if ev.msg.Seq > seq && sub.match(ev) {
+			events = append(events, ev)
+		}
+	}
+
+	return events, l.dropped <= seq
+}
+
+// subscription 连接的事件过滤条件
+type subscription struct {
+	taskIDs   map[string]bool
+	taskTypes map[string]bool
+}
+
+func newSubscription() *subscription {
+	return &subscription{
+		taskIDs:   make(map[string]bool),
+		taskTypes: make(map[string]bool),
+	}
+}
+
+// match 未订阅任何任务时接收全部事件，与任务无关的事件总是接收
+func (s *subscription) match(ev event) bool {
+	if ev.taskID == "" || (len(s.taskIDs) == 0 && len(s.taskTypes) == 0) {
+		return true
+	}
+	return s.taskIDs[ev.taskID] || s.taskTypes[ev.taskType]
+}
+
+func (s *subscription) add(msg ClientMessage) {
+	for _, id := range msg.TaskIDs {
+		s.taskIDs[id] = true
+	}
+	for _, t := range msg.TaskTypes {
+		s.taskTypes[t] = true
+	}
+}
+
+func (s *subscription) remove(msg ClientMessage) {
+	for _, id := range msg.TaskIDs {
+		delete(s.taskIDs, id)
+	}
+	for _, t := range msg.TaskTypes {
+		delete(s.taskTypes, t)
+	}
+}
+
+func (s *subscription) data() SubscriptionData {
+	data := SubscriptionData{
+		TaskIDs:   make([]string, 0, len(s.taskIDs)),
+		TaskTypes: make([]string, 0, len(s.taskTypes)),
+	}
+	for id := range s.taskIDs {
+		data.TaskIDs = append(data.TaskIDs, id)
+	}
+	for t := range s.taskTypes {
+		data.TaskTypes = append(data.TaskTypes, t)
+	}
+	sort.Strings(data.TaskIDs)
+	sort.Strings(data.TaskTypes)
+	return data
+}
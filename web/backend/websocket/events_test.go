@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func seqs(events []event) []uint64 {
+	s := make([]uint64, 0, len(events))
+	for _, ev := range events {
+		s = append(s, ev.msg.Seq)
+	}
+	return s
+}
+
+func numbered(seq uint64, ev event) event {
+	ev.msg.Seq = seq
+	return ev
+}
+
+func TestEventLogSince(t *testing.T) {
+	l := &eventLog{}
+	l.append(numbered(1, event{taskID: "a", taskType: "download"}))
+	l.append(numbered(3, event{taskID: "b", taskType: "upload"}))
+	l.append(numbered(4, event{})) // 通知
+
+	all := newSubscription()
+	events, complete := l.since(1, all)
+	assert.True(t, complete)
+	assert.Equal(t, []uint64{3, 4}, seqs(events))
+
+	sub := newSubscription()
+	sub.add(ClientMessage{TaskTypes: []string{"download"}})
+	events, complete = l.since(0, sub)
+	assert.True(t, complete)
+	assert.Equal(t, []uint64{1, 4}, seqs(events))
+}
+
+func TestEventLogBounded(t *testing.T) {
+	l := &eventLog{}
+	for i := 1; i <= eventLogSize+10; i++ {
+		l.append(numbered(uint64(i), event{taskID: "a"}))
+	}
+
+	assert.Len(t, l.events, eventLogSize)
+	assert.Equal(t, uint64(10), l.dropped)
+
+	events, complete := l.since(5, newSubscription())
+	assert.False(t, complete)
+	assert.Len(t, events, eventLogSize)
+	assert.Equal(t, uint64(11), events[0].msg.Seq)
+
+	_, complete = l.since(10, newSubscription())
+	assert.True(t, complete)
+}
@@ -3,6 +3,9 @@ package websocket
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,13 +15,28 @@ import (
 	"github.com/iyear/tdl/core/logctx"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// 在生产环境中应该检查Origin
-		return true
-	},
+// checkOrigin 只接受同源或允许跨域的页面发起的连接，防止其他网站借用浏览器的会话Cookie连接（跨站WebSocket劫持）
+//
+// 没有Origin头的连接不是浏览器发起的，如脚本使用API令牌连接
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		for _, allowed := range origins {
+			if strings.EqualFold(origin, allowed) {
+				return true
+			}
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
 }
 
 // 消息类型
@@ -28,6 +46,9 @@ const (
 	MessageTypeTaskEnd     = "task_end"
 	MessageTypeTaskError   = "task_error"
 	MessageTypeNotification = "notification"
+	MessageTypeSubscribed   = "subscribed"
+	MessageTypeReplay       = "replay"
+	MessageTypeError        = "error"
 )
 
 // WebSocket消息格式
@@ -35,11 +56,13 @@ type Message struct {
 	Type      string      `json:"type"`
 	Data      interface{} `json:"data"`
 	Timestamp int64       `json:"timestamp"`
+	Seq       uint64      `json:"seq,omitempty"` // 事件序号，仅推送的事件带有
 }
 
 // 进度数据
 type ProgressData struct {
 	TaskID      string  `json:"task_id"`
+	TaskType    string  `json:"task_type"`
 	Progress    float64 `json:"progress"`
 	Speed       string  `json:"speed"`
 	ETA         string  `json:"eta"`
@@ -57,26 +80,58 @@ type TaskData struct {
 
 // Client 表示一个WebSocket客户端
 type Client struct {
-	hub    *Hub
-	conn   *websocket.Conn
-	send   chan []byte
-	userID string
+	hub      *Hub
+	conn     *websocket.Conn
+	send     chan []byte
+	clientID string        // 连接所属的浏览器
+	sub      *subscription // 仅在Hub.Run中访问
+}
+
+// Authenticator 识别WebSocket连接所属的浏览器，返回错误表示连接未认证
+type Authenticator func(c *gin.Context) (clientID string, err error)
+
+// Access 判断浏览器是否可以访问Telegram账号，只有可以访问的连接才会收到账号的任务事件
+type Access func(clientID string, accountID int64) bool
+
+// envelope 发送给浏览器或账号的事件，clientID为空时发送给所有可以访问accountID的连接
+type envelope struct {
+	clientID  string
+	accountID int64
+	event     event
+}
+
+// request 客户端发来的消息
+type request struct {
+	client *Client
+	msg    ClientMessage
 }
 
 // Hub 管理所有WebSocket连接
+//
+// 任务事件按任务使用的Telegram账号路由，只发送给可以访问该账号的连接；登录通知只发送给发起登录的浏览器。
+// 所有事件使用同一个递增的序号，并按账号和浏览器写入有界日志，重连的客户端可以从指定序号补发错过的事件
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
+	access      Access
+	seq         uint64
+	clients     map[string]map[*Client]bool // clientID -> 连接
+	accountLogs map[int64]*eventLog         // accountID -> 事件日志
+	clientLogs  map[string]*eventLog        // clientID -> 事件日志
+	broadcast   chan envelope
+	requests    chan request
+	register    chan *Client
+	unregister  chan *Client
 }
 
-func NewHub() *Hub {
+func NewHub(access Access) *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		access:      access,
+		clients:     make(map[string]map[*Client]bool),
+		accountLogs: make(map[int64]*eventLog),
+		clientLogs:  make(map[string]*eventLog),
+		broadcast:   make(chan envelope),
+		requests:    make(chan request),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
 	}
 }
 
@@ -84,49 +139,181 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
+			if h.clients[client.clientID] == nil {
+				h.clients[client.clientID] = make(map[*Client]bool)
+			}
+			h.clients[client.clientID][client] = true
 			
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
+			h.remove(client)
 			
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
+		case env := <-h.broadcast:
+			h.dispatch(env)
+
+		case req := <-h.requests:
+			h.handleRequest(req.client, req.msg)
 		}
 	}
 }
 
-// BroadcastProgress 广播进度更新
-func (h *Hub) BroadcastProgress(data ProgressData) {
+// dispatch 为事件编号，写入日志并发送给可以接收的连接
+func (h *Hub) dispatch(env envelope) {
+	h.seq++
+	ev := env.event
+	ev.msg.Seq = h.seq
+
+	data, err := json.Marshal(ev.msg)
+	if err != nil {
+		return
+	}
+
+	if env.clientID != "" {
+		eventLogOf(h.clientLogs, env.clientID).append(ev)
+		h.send(h.clients[env.clientID], ev, data)
+		return
+	}
+
+	eventLogOf(h.accountLogs, env.accountID).append(ev)
+	for clientID, clients := range h.clients {
+		if h.access(clientID, env.accountID) {
+			h.send(clients, ev, data)
+		}
+	}
+}
+
+// send 将事件发送给订阅匹配的连接
+func (h *Hub) send(clients map[*Client]bool, ev event, data []byte) {
+	for client := range clients {
+		if client.sub.match(ev) {
+			h.deliver(client, data)
+		}
+	}
+}
+
+// eventLogOf 返回键对应的事件日志，不存在时创建
+func eventLogOf[K comparable](logs map[K]*eventLog, key K) *eventLog {
+	log := logs[key]
+	if log == nil {
+		log = &eventLog{}
+		logs[key] = log
+	}
+	return log
+}
+
+// replay 合并连接可以接收的日志中序号大于seq的事件
+func (h *Hub) replay(client *Client, seq uint64) ReplayData {
+	replay := ReplayData{Latest: h.seq, Complete: true}
+	if seq > h.seq {
+		// 服务重启过，序号从头开始
+		seq = 0
+		replay.Complete = false
+	}
+
+	logs := make([]*eventLog, 0, len(h.accountLogs)+1)
+	if log := h.clientLogs[client.clientID]; log != nil {
+		logs = append(logs, log)
+	}
+	for accountID, log := range h.accountLogs {
+		if h.access(client.clientID, accountID) {
+			logs = append(logs, log)
+		}
+	}
+
+	var events []event
+	for _, log := range logs {
+		matched, complete := log.since(seq, client.sub)
+		events = append(events, matched...)
+		replay.Complete = replay.Complete && complete
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].msg.Seq < events[j].msg.Seq })
+
+	replay.Events = make([]Message, 0, len(events))
+	for _, ev := range events {
+		replay.Events = append(replay.Events, ev.msg)
+	}
+	return replay
+}
+
+// handleRequest 处理客户端的订阅和补发请求
+func (h *Hub) handleRequest(client *Client, msg ClientMessage) {
+	if !h.clients[client.clientID][client] {
+		return // 连接已移除
+	}
+
+	switch msg.Action {
+	case ActionSubscribe:
+		client.sub.add(msg)
+		h.reply(client, MessageTypeSubscribed, client.sub.data())
+	case ActionUnsubscribe:
+		client.sub.remove(msg)
+		h.reply(client, MessageTypeSubscribed, client.sub.data())
+	case ActionResume:
+		h.reply(client, MessageTypeReplay, h.replay(client, msg.Seq))
+	default:
+		h.reply(client, MessageTypeError, map[string]string{
+			"message": "unknown action: " + msg.Action,
+		})
+	}
+}
+
+// reply 向单个连接发送不编号的应答消息
+func (h *Hub) reply(client *Client, msgType string, data interface{}) {
+	msg, err := json.Marshal(Message{
+		Type:      msgType,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+	h.deliver(client, msg)
+}
+
+// deliver 发送消息，连接发送缓冲区已满时断开连接，客户端重连后可补发
+func (h *Hub) deliver(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		h.remove(client)
+	}
+}
+
+// remove 移除连接并关闭其发送通道
+func (h *Hub) remove(client *Client) {
+	clients, ok := h.clients[client.clientID]
+	if !ok || !clients[client] {
+		return
+	}
+
+	delete(clients, client)
+	close(client.send)
+	if len(clients) == 0 {
+		delete(h.clients, client.clientID)
+	}
+}
+
+// BroadcastProgress 向可以访问账号的连接推送任务进度
+func (h *Hub) BroadcastProgress(accountID int64, data ProgressData) {
 	msg := Message{
 		Type:      MessageTypeProgress,
 		Data:      data,
 		Timestamp: time.Now().Unix(),
 	}
-	h.broadcastMessage(msg)
+	h.broadcastMessage(envelope{accountID: accountID, event: event{msg: msg, taskID: data.TaskID, taskType: data.TaskType}})
 }
 
-// BroadcastTaskStatus 广播任务状态
-func (h *Hub) BroadcastTaskStatus(msgType string, data TaskData) {
+// BroadcastTaskStatus 向可以访问账号的连接推送任务状态
+func (h *Hub) BroadcastTaskStatus(accountID int64, msgType string, data TaskData) {
 	msg := Message{
 		Type:      msgType,
 		Data:      data,
 		Timestamp: time.Now().Unix(),
 	}
-	h.broadcastMessage(msg)
+	h.broadcastMessage(envelope{accountID: accountID, event: event{msg: msg, taskID: data.TaskID, taskType: data.TaskType}})
 }
 
-// BroadcastNotification 广播通知
-func (h *Hub) BroadcastNotification(message string, level string) {
+// BroadcastNotification 向浏览器的所有连接推送通知
+func (h *Hub) BroadcastNotification(clientID string, message string, level string) {
 	msg := Message{
 		Type: MessageTypeNotification,
 		Data: map[string]string{
@@ -135,19 +322,41 @@ func (h *Hub) BroadcastNotification(message string, level string) {
 		},
 		Timestamp: time.Now().Unix(),
 	}
-	h.broadcastMessage(msg)
+	if clientID == "" {
+		return
+	}
+	h.broadcastMessage(envelope{clientID: clientID, event: event{msg: msg}})
 }
 
-func (h *Hub) broadcastMessage(msg Message) {
-	data, err := json.Marshal(msg)
-	if err != nil {
+// broadcastMessage 将事件交给Hub.Run分发，没有所属账号的任务事件直接丢弃
+func (h *Hub) broadcastMessage(env envelope) {
+	if env.clientID == "" && env.accountID == 0 {
 		return
 	}
-	h.broadcast <- data
+	h.broadcast <- env
 }
 
-func HandleWebSocket(hub *Hub) gin.HandlerFunc {
+// HandleWebSocket 处理WebSocket连接，未认证的连接在升级前被拒绝
+//
+// origins为允许跨域连接的来源，与CORS配置一致，同源的连接总是允许
+func HandleWebSocket(hub *Hub, authenticate Authenticator, origins []string) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     checkOrigin(origins),
+	}
+
 	return func(c *gin.Context) {
+		clientID, err := authenticate(c)
+		if err != nil || clientID == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "Unauthorized",
+				"code":    "unauthorized", // api.CodeUnauthorized
+			})
+			return
+		}
+
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			logctx.From(c.Request.Context()).Error("WebSocket upgrade failed", zap.Error(err))
@@ -155,10 +364,11 @@ func HandleWebSocket(hub *Hub) gin.HandlerFunc {
 		}
 
 		client := &Client{
-			hub:    hub,
-			conn:   conn,
-			send:   make(chan []byte, 256),
-			userID: c.GetString("user_id"), // 从中间件获取用户ID
+			hub:      hub,
+			conn:     conn,
+			send:     make(chan []byte, 256),
+			clientID: clientID,
+			sub:      newSubscription(),
 		}
 
 		client.hub.register <- client
@@ -175,7 +385,7 @@ func (c *Client) readPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(4096)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -183,10 +393,16 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
+
+		var msg ClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			msg = ClientMessage{Action: "invalid"}
+		}
+		c.hub.requests <- request{client: c, msg: msg}
 	}
 }
 
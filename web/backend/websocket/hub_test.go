@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	check := checkOrigin([]string{"http://localhost:5173"})
+
+	for origin, ok := range map[string]bool{
+		"":                         true, // not a browser
+		"http://example.com:8080":  true, // same origin
+		"https://EXAMPLE.com:8080": true,
+		"http://localhost:5173":    true,
+		"http://localhost:3000":    false,
+		"http://evil.com":          false,
+		"http://example.com":       false,
+		"://bad":                   false,
+	} {
+		r := httptest.NewRequest("GET", "http://example.com:8080/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		assert.Equal(t, ok, check(r), origin)
+	}
+}
+
+func newTestClient(h *Hub, clientID string) *Client {
+	c := &Client{hub: h, send: make(chan []byte, 16), clientID: clientID, sub: newSubscription()}
+	h.register <- c
+	return c
+}
+
+func received(t *testing.T, c *Client) []Message {
+	msgs := make([]Message, 0)
+	for {
+		select {
+		case data := <-c.send:
+			var msg Message
+			require.NoError(t, json.Unmarshal(data, &msg))
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestHubRoutesByAccount(t *testing.T) {
+	owners := map[int64]string{1: "a", 2: "b"} // 未列出的账号所有浏览器都可以访问
+	h := NewHub(func(clientID string, accountID int64) bool {
+		owner, ok := owners[accountID]
+		return !ok || owner == clientID
+	})
+	go h.Run()
+
+	a, b := newTestClient(h, "a"), newTestClient(h, "b")
+
+	h.BroadcastTaskStatus(1, MessageTypeTaskStart, TaskData{TaskID: "t1"})
+	h.BroadcastTaskStatus(2, MessageTypeTaskStart, TaskData{TaskID: "t2"})
+	h.BroadcastProgress(3, ProgressData{TaskID: "t3"})
+	h.BroadcastNotification("b", "login", "info")
+	h.BroadcastTaskStatus(0, MessageTypeTaskStart, TaskData{TaskID: "t0"}) // 没有账号，丢弃
+
+	// 请求经过Hub.Run处理，之前的事件都已发送
+	h.requests <- request{client: a, msg: ClientMessage{Action: ActionResume, Seq: 1}}
+	h.requests <- request{client: b, msg: ClientMessage{Action: ActionResume, Seq: 0}}
+	h.requests <- request{client: b, msg: ClientMessage{Action: ActionSubscribe}} // 等待上一个请求处理完成
+
+	msgs := received(t, a)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, []uint64{1, 3}, []uint64{msgs[0].Seq, msgs[1].Seq})
+
+	var replay struct {
+		Events   []Message `json:"events"`
+		Latest   uint64    `json:"latest"`
+		Complete bool      `json:"complete"`
+	}
+	data, _ := json.Marshal(msgs[2].Data)
+	require.NoError(t, json.Unmarshal(data, &replay))
+	assert.Equal(t, uint64(4), replay.Latest)
+	assert.True(t, replay.Complete)
+	require.Len(t, replay.Events, 1)
+	assert.Equal(t, uint64(3), replay.Events[0].Seq)
+
+	msgs = received(t, b)
+	require.Len(t, msgs, 5)
+	assert.Equal(t, []uint64{2, 3, 4}, []uint64{msgs[0].Seq, msgs[1].Seq, msgs[2].Seq})
+	data, _ = json.Marshal(msgs[3].Data)
+	require.NoError(t, json.Unmarshal(data, &replay))
+	require.Len(t, replay.Events, 3)
+
+	// 来自服务重启前的序号
+	h.requests <- request{client: a, msg: ClientMessage{Action: ActionResume, Seq: 100}}
+	h.requests <- request{client: a, msg: ClientMessage{Action: ActionSubscribe}} // 等待上一个请求处理完成
+	msgs = received(t, a)
+	require.Len(t, msgs, 2)
+	data, _ = json.Marshal(msgs[0].Data)
+	require.NoError(t, json.Unmarshal(data, &replay))
+	assert.False(t, replay.Complete)
+	assert.Len(t, replay.Events, 2)
+}
@@ -7,10 +7,37 @@ import DownloadPage from './pages/DownloadPage'
 import ForwardPage from './pages/ForwardPage'
 import UploadPage from './pages/UploadPage'
 import SettingsPage from './pages/SettingsPage'
+import OperatorLoginPage from './pages/OperatorLoginPage'
 import { useAuthGuard } from './hooks/useAuth'
+import { useOperator } from './hooks/useOperator'
 import { useWebSocket } from './hooks/useWebSocket'
 
 function App() {
+  const operator = useOperator()
+
+  // 等待操作员认证状态初始化完成
+  if (!operator.isInitialized) {
+    return (
+      <div className="min-h-screen bg-background flex items-center justify-center">
+        <div className="animate-spin rounded-full h-8 w-8 border-b-2 border-primary"></div>
+      </div>
+    )
+  }
+
+  // 先登录Web服务本身，再登录Telegram
+  if (!operator.isAuthenticated) {
+    return (
+      <div className="min-h-screen bg-background">
+        <OperatorLoginPage onLogin={operator.login} />
+        <Toaster />
+      </div>
+    )
+  }
+
+  return <TelegramApp />
+}
+
+function TelegramApp() {
   const { isAuthenticated, shouldShowLoader } = useAuthGuard()
 
   // 建立WebSocket连接（仅在已认证时）
@@ -310,7 +310,8 @@ export function ImportChatDialog({ trigger }: ImportChatDialogProps) {
         template: template.trim() || settings.defaultTemplate,
         jsonData,
         selectedMessageIds: selectedMessages.map(msg => msg.id),
-        taskId: task.id
+        taskId: task.id,
+        restart: !downloadConfig.continue
       })
 
       if (response.data.success) {
@@ -0,0 +1,48 @@
+import { useCallback, useEffect, useState } from 'react'
+import { ApiService, OPERATOR_UNAUTHORIZED_EVENT } from '@/utils/api'
+
+/**
+ * Web服务操作员认证状态
+ * 会话失效时由API拦截器触发事件，回到操作员登录页
+ */
+export function useOperator() {
+  const [isInitialized, setIsInitialized] = useState(false)
+  const [isAuthenticated, setIsAuthenticated] = useState(false)
+
+  const checkStatus = useCallback(async () => {
+    try {
+      const response = await ApiService.getOperatorStatus()
+      setIsAuthenticated(!!response.data.data?.authenticated)
+    } catch (error) {
+      console.error('Failed to check operator status:', error)
+      setIsAuthenticated(false)
+    } finally {
+      setIsInitialized(true)
+    }
+  }, [])
+
+  const login = useCallback(async (password: string) => {
+    await ApiService.operatorLogin(password)
+    setIsAuthenticated(true)
+  }, [])
+
+  const logout = useCallback(async () => {
+    await ApiService.operatorLogout()
+    setIsAuthenticated(false)
+  }, [])
+
+  useEffect(() => {
+    checkStatus()
+
+    const onUnauthorized = () => setIsAuthenticated(false)
+    window.addEventListener(OPERATOR_UNAUTHORIZED_EVENT, onUnauthorized)
+    return () => window.removeEventListener(OPERATOR_UNAUTHORIZED_EVENT, onUnauthorized)
+  }, [checkStatus])
+
+  return {
+    isInitialized,
+    isAuthenticated,
+    login,
+    logout,
+  }
+}
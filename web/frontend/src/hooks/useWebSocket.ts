@@ -6,11 +6,14 @@ interface WebSocketMessage {
   type: string
   data: any
   timestamp: number
+  seq?: number
 }
 
 export function useWebSocket(enabled: boolean) {
   const wsRef = useRef<WebSocket | null>(null)
   const reconnectTimeoutRef = useRef<NodeJS.Timeout | null>(null)
+  // 最后收到的事件序号，重连后从这里补发错过的事件
+  const lastSeqRef = useRef(0)
   const { updateTask } = useTaskStore()
 
   const connect = useCallback(() => {
@@ -28,6 +31,7 @@ export function useWebSocket(enabled: boolean) {
 
     ws.onopen = () => {
       console.log('WebSocket connected')
+      ws.send(JSON.stringify({ action: 'resume', seq: lastSeqRef.current }))
     }
 
     ws.onmessage = (event) => {
@@ -56,6 +60,11 @@ export function useWebSocket(enabled: boolean) {
   }, [enabled])
 
   const handleMessage = useCallback((message: WebSocketMessage) => {
+    if (message.seq) {
+      if (message.seq <= lastSeqRef.current) return
+      lastSeqRef.current = message.seq
+    }
+
     switch (message.type) {
       case 'progress':
         const progressData = message.data
@@ -124,6 +133,25 @@ export function useWebSocket(enabled: boolean) {
         })
         break
 
+      case 'replay':
+        // 断线期间错过的事件，服务重启后序号重新开始
+        if (message.data.latest < lastSeqRef.current) {
+          lastSeqRef.current = 0
+        }
+        message.data.events.forEach((event: WebSocketMessage) => handleMessage(event))
+        lastSeqRef.current = Math.max(lastSeqRef.current, message.data.latest)
+        if (!message.data.complete) {
+          console.warn('Some WebSocket events were lost while disconnected')
+        }
+        break
+
+      case 'subscribed':
+        break
+
+      case 'error':
+        console.error('WebSocket error:', message.data.message)
+        break
+
       default:
         console.log('Unknown message type:', message.type)
     }
@@ -0,0 +1,71 @@
+import { useState } from 'react'
+import { Card, CardHeader, CardTitle, CardContent } from '@/components/ui/card'
+import { Button } from '@/components/ui/button'
+import { Input } from '@/components/ui/input'
+import { Label } from '@/components/ui/label'
+import { toast } from '@/components/ui/use-toast'
+import { Lock } from 'lucide-react'
+
+interface OperatorLoginPageProps {
+  onLogin: (password: string) => Promise<void>
+}
+
+const OperatorLoginPage = ({ onLogin }: OperatorLoginPageProps) => {
+  const [password, setPassword] = useState('')
+  const [isLoading, setIsLoading] = useState(false)
+
+  const handleLogin = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!password) return
+
+    setIsLoading(true)
+    try {
+      await onLogin(password)
+      setPassword('')
+    } catch (error: any) {
+      toast({
+        title: "登录失败",
+        description: error.response?.data?.error || "密码错误",
+        variant: "destructive",
+      })
+    } finally {
+      setIsLoading(false)
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center p-4">
+      <Card className="w-full max-w-md">
+        <CardHeader className="text-center">
+          <Lock className="h-12 w-12 mx-auto text-primary mb-2" />
+          <CardTitle className="text-2xl font-bold">tdl Web</CardTitle>
+          <p className="text-muted-foreground">
+            请输入管理员密码
+          </p>
+        </CardHeader>
+        <CardContent>
+          <form onSubmit={handleLogin} className="space-y-4">
+            <div className="space-y-2">
+              <Label htmlFor="operator-password">管理员密码</Label>
+              <Input
+                id="operator-password"
+                type="password"
+                value={password}
+                onChange={(e: React.ChangeEvent<HTMLInputElement>) => setPassword(e.target.value)}
+                placeholder="启动时通过 --password 设置或在日志中查看"
+                disabled={isLoading}
+                autoFocus
+              />
+            </div>
+
+            <Button type="submit" disabled={isLoading || !password} className="w-full">
+              {isLoading ? '登录中...' : '登录'}
+            </Button>
+          </form>
+        </CardContent>
+      </Card>
+    </div>
+  )
+}
+
+export default OperatorLoginPage
@@ -12,6 +12,9 @@ interface Settings {
   maxThreads: number
   maxTasks: number
   partSize: number
+  limit: number
+  poolSize: number
+  delay: number
 }
 
 const SettingsPage = () => {
@@ -20,7 +23,10 @@ const SettingsPage = () => {
     reconnectTimeout: 300,
     maxThreads: 4,
     maxTasks: 2,
-    partSize: 512
+    partSize: 512,
+    limit: 2,
+    poolSize: 8,
+    delay: 0
   })
   const [loading, setLoading] = useState(false)
   const [saving, setSaving] = useState(false)
@@ -81,7 +87,10 @@ const SettingsPage = () => {
       reconnectTimeout: 300,
       maxThreads: 4,
       maxTasks: 2,
-      partSize: 512
+      partSize: 512,
+      limit: 2,
+      poolSize: 8,
+      delay: 0
     })
     toast({
       title: '设置已重置',
@@ -193,6 +202,59 @@ const SettingsPage = () => {
               文件传输的分块大小，范围64-2048KB，推荐512KB
             </p>
           </div>
+
+          <div className="grid grid-cols-1 md:grid-cols-3 gap-4">
+            <div>
+              <Label htmlFor="limit">任务内并发文件数</Label>
+              <Input
+                id="limit"
+                type="number"
+                value={settings.limit}
+                onChange={(e) => setSettings({ ...settings, limit: parseInt(e.target.value) || 2 })}
+                placeholder="2"
+                disabled={loading}
+                min="1"
+                max="16"
+              />
+              <p className="text-sm text-muted-foreground mt-1">
+                单个任务同时传输的文件数 (1-16)
+              </p>
+            </div>
+
+            <div>
+              <Label htmlFor="poolSize">连接池大小</Label>
+              <Input
+                id="poolSize"
+                type="number"
+                value={settings.poolSize}
+                onChange={(e) => setSettings({ ...settings, poolSize: parseInt(e.target.value) || 0 })}
+                placeholder="8"
+                disabled={loading}
+                min="0"
+                max="64"
+              />
+              <p className="text-sm text-muted-foreground mt-1">
+                每个DC的连接数，0表示不限制 (0-64)
+              </p>
+            </div>
+
+            <div>
+              <Label htmlFor="delay">文件间隔 (毫秒)</Label>
+              <Input
+                id="delay"
+                type="number"
+                value={settings.delay}
+                onChange={(e) => setSettings({ ...settings, delay: parseInt(e.target.value) || 0 })}
+                placeholder="0"
+                disabled={loading}
+                min="0"
+                max="60000"
+              />
+              <p className="text-sm text-muted-foreground mt-1">
+                每个文件之间的等待时间，0表示不等待
+              </p>
+            </div>
+          </div>
         </CardContent>
       </Card>
 
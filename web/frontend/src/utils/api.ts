@@ -2,52 +2,46 @@ import axios from 'axios'
 
 const API_BASE_URL = import.meta.env.VITE_API_URL || '/api/v1'
 
+// 操作员未认证时触发的全局事件
+export const OPERATOR_UNAUTHORIZED_EVENT = 'tdl:operator-unauthorized'
+
+// 错误响应中的code字段，完整说明见 /api/v1/openapi.json
+export type ApiErrorCode =
+  | 'invalid_request'
+  | 'invalid_expression'
+  | 'operator_unauthorized'
+  | 'unauthorized'
+  | 'forbidden'
+  | 'path_forbidden'
+  | 'chat_forbidden'
+  | 'not_found'
+  | 'peer_not_found'
+  | 'message_not_found'
+  | 'conflict'
+  | 'flood_wait'
+  | 'telegram_error'
+  | 'unavailable'
+  | 'timeout'
+  | 'internal'
+
 export const api = axios.create({
   baseURL: API_BASE_URL,
   timeout: 10000,
+  withCredentials: true,
   headers: {
     'Content-Type': 'application/json',
   },
 })
 
-// 生成或获取客户端ID
-function getOrCreateClientID(): string {
-  const STORAGE_KEY = 'tdl_client_id'
-  let clientID = localStorage.getItem(STORAGE_KEY)
-  
-  if (!clientID) {
-    // 生成新的客户端ID
-    clientID = 'client_' + Array.from(crypto.getRandomValues(new Uint8Array(16)))
-      .map(b => b.toString(16).padStart(2, '0'))
-      .join('')
-    localStorage.setItem(STORAGE_KEY, clientID)
-  }
-  
-  return clientID
-}
-
-// 请求拦截器
-api.interceptors.request.use(
-  (config) => {
-    // 添加客户端ID到请求头
-    const clientID = getOrCreateClientID()
-    config.headers['X-TDL-Client-ID'] = clientID
-    return config
-  },
-  (error) => {
-    return Promise.reject(error)
-  }
-)
-
 // 响应拦截器
 api.interceptors.response.use(
   (response) => {
     return response
   },
   (error) => {
-    if (error.response?.status === 401) {
-      // 处理认证失效
-      window.location.href = '/login'
+    // 未登录Telegram同样返回401，只有操作员会话失效时才回到Web服务登录页
+    if (error.response?.status === 401 && error.response?.data?.code === 'operator_unauthorized') {
+      window.dispatchEvent(new Event(OPERATOR_UNAUTHORIZED_EVENT))
     }
     return Promise.reject(error)
   }
@@ -55,6 +49,19 @@ api.interceptors.response.use(
 
 // API服务类
 export class ApiService {
+  // Web服务操作员认证
+  static async getOperatorStatus() {
+    return api.get('/operator/status')
+  }
+
+  static async operatorLogin(password: string) {
+    return api.post('/operator/login', { password })
+  }
+
+  static async operatorLogout() {
+    return api.post('/operator/logout')
+  }
+
   // 认证相关
   static async checkAuthStatus() {
     return api.get('/auth/status')
@@ -84,6 +91,27 @@ export class ApiService {
     return api.post('/auth/logout')
   }
 
+  // 多账号管理
+  static async getAccounts() {
+    return api.get('/auth/accounts')
+  }
+
+  static async switchAccount(accountId: number) {
+    return api.post(`/auth/accounts/${accountId}/switch`)
+  }
+
+  static async removeAccount(accountId: number) {
+    return api.delete(`/auth/accounts/${accountId}`)
+  }
+
+  static async getCLINamespaces() {
+    return api.get('/auth/cli/namespaces')
+  }
+
+  static async importAccount(namespace: string, proxy?: string) {
+    return api.post('/auth/accounts/import', proxy ? { namespace, proxy } : { namespace })
+  }
+
   // 下载相关
   static async getChats() {
     return api.get('/download/chats')
@@ -123,8 +151,8 @@ export class ApiService {
     return api.post(`/download/tasks/${taskId}/resume`)
   }
 
-  static async retryDownloadTask(taskId: string) {
-    return api.post(`/download/tasks/${taskId}/retry`)
+  static async retryDownloadTask(taskId: string, failedOnly = false) {
+    return api.post(`/download/tasks/${taskId}/retry`, { failed_only: failedOnly })
   }
 
   static async getDownloadTaskDetails(taskId: string) {
@@ -138,6 +166,7 @@ export class ApiService {
     jsonData: any
     selectedMessageIds: number[]
     taskId: string
+    restart?: boolean
   }) {
     // Convert camelCase to snake_case for backend API
     const requestData = {
@@ -146,7 +175,8 @@ export class ApiService {
       template: data.template,
       json_data: data.jsonData,
       selected_message_ids: data.selectedMessageIds,
-      task_id: data.taskId
+      task_id: data.taskId,
+      restart: data.restart
     }
     return api.post('/download/import', requestData)
   }
@@ -159,6 +189,9 @@ export class ApiService {
     page?: number
     limit?: number
     search?: string
+    sort?: 'date' | 'type' | 'name'
+    order?: 'asc' | 'desc'
+    refresh?: boolean
   }) {
     const params: any = {
       filter: options?.filter || 'true',
@@ -174,6 +207,15 @@ export class ApiService {
     if (options?.search) {
       params.search = options.search
     }
+    if (options?.sort) {
+      params.sort = options.sort
+    }
+    if (options?.order) {
+      params.order = options.order
+    }
+    if (options?.refresh) {
+      params.refresh = true
+    }
     
     return api.get('/chat/list', { params })
   }
@@ -205,6 +247,73 @@ export class ApiService {
     return api.post('/chat/users', data)
   }
 
+  // 分页浏览聊天消息，peer为me时浏览收藏夹，使用返回的next_offset_id获取下一页
+  static async getChatMessages(peer: string | number, params: {
+    offset_id?: number
+    offset_date?: number
+    limit?: number
+    thread?: number
+    filter?: string
+    only_media?: boolean
+    account_id?: number
+  } = {}) {
+    return api.get(`/chat/${encodeURIComponent(String(peer))}/messages`, { params })
+  }
+
+  static async getExportTasks() {
+    return api.get('/chat/tasks')
+  }
+
+  static async getExportTaskDetails(taskId: string) {
+    return api.get(`/chat/tasks/${taskId}`)
+  }
+
+  static async cancelExportTask(taskId: string) {
+    return api.delete(`/chat/tasks/${taskId}`)
+  }
+
+  // 导出结果为tdl JSON，可以直接作为importFromJson的json_data
+  static async getExportResult(taskId: string) {
+    return api.get(`/chat/tasks/${taskId}/result`)
+  }
+
+  // 文件管理相关，path为空时列出下载根目录
+  static async listFiles(path = '', scope: 'download' | 'upload' = 'download') {
+    return api.get('/files', { params: { path, scope } })
+  }
+
+  static async mkdir(path: string) {
+    return api.post('/files/mkdir', { path })
+  }
+
+  static async renameFile(path: string, name: string) {
+    return api.post('/files/rename', { path, name })
+  }
+
+  static async deleteFile(path: string, recursive = false) {
+    return api.delete('/files', { params: { path, recursive } })
+  }
+
+  // 浏览器直接访问的下载链接，不经过axios
+  static fileDownloadUrl(path: string) {
+    return `${API_BASE_URL}/files/download?path=${encodeURIComponent(path)}`
+  }
+
+  static taskFilesUrl(taskId: string) {
+    return `${API_BASE_URL}/download/tasks/${taskId}/files`
+  }
+
+  // 媒体预览链接，可直接用于<video>和<img>，download为true时作为附件下载
+  static mediaUrl(peer: string | number, msgId: number, download = false) {
+    const query = download ? '?download=true' : ''
+    return `${API_BASE_URL}/media/${encodeURIComponent(String(peer))}/${msgId}${query}`
+  }
+
+  // 缩略图链接，size为期望的最短长边
+  static thumbnailUrl(peer: string | number, msgId: number, size = 320) {
+    return `${API_BASE_URL}/media/${encodeURIComponent(String(peer))}/${msgId}/thumb?size=${size}`
+  }
+
   // 设置相关
   static async getSettings() {
     return api.get('/settings')
@@ -241,6 +350,10 @@ export class ApiService {
     return api.delete(`/forward/tasks/${taskId}`)
   }
 
+  static async retryForwardTask(taskId: string, failedOnly = false) {
+    return api.post(`/forward/tasks/${taskId}/retry`, { failed_only: failedOnly })
+  }
+
   // 上传相关
   static async startUpload(formData: FormData) {
     return api.post('/upload/start', formData, {
@@ -250,6 +363,50 @@ export class ApiService {
     })
   }
 
+  // 分块上传：创建会话后按offset依次发送分块，中断后查询offset继续，最后一个分块写入后自动开始上传任务
+  static async createUploadSession(data: {
+    filename: string
+    size: number
+    to_chat?: string
+    remove?: boolean
+    photo?: boolean
+    priority?: number
+    account_id?: number
+  }) {
+    return api.post('/upload/sessions', data)
+  }
+
+  static async getUploadSession(sessionId: string) {
+    return api.get(`/upload/sessions/${sessionId}`)
+  }
+
+  static async uploadChunk(sessionId: string, offset: number, chunk: Blob) {
+    return api.patch(`/upload/sessions/${sessionId}`, chunk, {
+      timeout: 0,
+      headers: {
+        'Content-Type': 'application/offset+octet-stream',
+        'Upload-Offset': String(offset),
+      },
+    })
+  }
+
+  static async deleteUploadSession(sessionId: string) {
+    return api.delete(`/upload/sessions/${sessionId}`)
+  }
+
+  // 上传服务器本地的文件或目录，paths需位于上传根目录中
+  static async startLocalUpload(data: {
+    paths: string[]
+    to_chat?: string
+    excludes?: string[]
+    remove?: boolean
+    photo?: boolean
+    priority?: number
+    account_id?: number
+  }) {
+    return api.post('/upload/local', data)
+  }
+
   static async getUploadTasks() {
     return api.get('/upload/tasks')
   }
@@ -261,4 +418,8 @@ export class ApiService {
   static async cancelUploadTask(taskId: string) {
     return api.delete(`/upload/tasks/${taskId}`)
   }
+
+  static async retryUploadTask(taskId: string, failedOnly = false) {
+    return api.post(`/upload/tasks/${taskId}/retry`, { failed_only: failedOnly })
+  }
 }
\ No newline at end of file